@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// agentMeta identifies this tailer, in heartbeats and, unless
+// -no-agent-meta is given, in every event.
+type agentMeta struct {
+	host       string
+	version    string
+	instanceID string
+}
+
+// loadAgentMeta collects the metadata. The instance ID is random per
+// process unless -instance-id-file names a file to keep it in, which is
+// created on first use.
+func loadAgentMeta(cfg Config) (*agentMeta, error) {
+	host, _ := os.Hostname()
+	meta := &agentMeta{host: host, version: version}
+	if cfg.InstanceIDFile == "" {
+		meta.instanceID = newUUID()
+		return meta, nil
+	}
+
+	data, err := os.ReadFile(cfg.InstanceIDFile)
+	switch {
+	case err == nil:
+		meta.instanceID = strings.TrimSpace(string(data))
+		if meta.instanceID == "" {
+			return nil, fmt.Errorf("-instance-id-file %s is empty", cfg.InstanceIDFile)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		meta.instanceID = newUUID()
+		if err := os.WriteFile(cfg.InstanceIDFile, []byte(meta.instanceID+"\n"), 0o644); err != nil {
+			return nil, fmt.Errorf("write instance ID file: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("read instance ID file: %w", err)
+	}
+	return meta, nil
+}
+
+// stamp sets the agent fields of event.
+func (m *agentMeta) stamp(event *CrawlEvent) {
+	event.AgentHost = m.host
+	event.AgentVersion = m.version
+	event.InstanceID = m.instanceID
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
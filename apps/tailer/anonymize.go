@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// validatePrefixLengths checks the -ipv4-prefix and -ipv6-prefix values.
+// Zero is always allowed and means the address is dropped entirely.
+func validatePrefixLengths(v4Bits, v6Bits int) error {
+	if v4Bits != 0 && (v4Bits < 8 || v4Bits > 32) {
+		return fmt.Errorf("-ipv4-prefix must be 0 or between 8 and 32, got %d", v4Bits)
+	}
+	if v6Bits != 0 && (v6Bits < 16 || v6Bits > 64) {
+		return fmt.Errorf("-ipv6-prefix must be 0 or between 16 and 64, got %d", v6Bits)
+	}
+	return nil
+}
+
+// toPrefix truncates ip to its network prefix (v4Bits for IPv4, v6Bits for
+// IPv6) so that no individual address leaves the host. IPv4-mapped IPv6
+// addresses are treated as IPv4. A prefix length of 0, or anything that
+// doesn't parse as an address, yields "" — never the raw input.
+func toPrefix(ip string, v4Bits, v6Bits int) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap().WithZone("")
+
+	bits := v6Bits
+	if addr.Is4() {
+		bits = v4Bits
+	}
+	if bits == 0 {
+		return ""
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
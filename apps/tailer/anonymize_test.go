@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestToPrefix(t *testing.T) {
+	tests := []struct {
+		ip     string
+		v4, v6 int
+		want   string
+	}{
+		{"203.0.113.77", 24, 48, "203.0.113.0/24"},
+		{"203.0.113.77", 16, 48, "203.0.0.0/16"},
+		{"203.0.113.77", 8, 48, "203.0.0.0/8"},
+		{"203.0.113.77", 32, 48, "203.0.113.77/32"},
+		{"203.0.113.77", 0, 48, ""},
+		{"2001:db8:abcd:1234::1", 24, 48, "2001:db8:abcd::/48"},
+		{"2001:db8:abcd:1234::1", 24, 56, "2001:db8:abcd:1200::/56"},
+		{"2001:db8:abcd:1234::1", 24, 16, "2001::/16"},
+		{"2001:db8:abcd:1234::1", 24, 64, "2001:db8:abcd:1234::/64"},
+		{"2001:db8:abcd:1234::1", 24, 0, ""},
+		{"2a03::5", 24, 48, "2a03::/48"},
+		{"::ffff:198.51.100.9", 24, 48, "198.51.100.0/24"},
+		{"fe80::1%eth0", 24, 48, "fe80::/48"},
+		{"not-an-ip", 24, 48, ""},
+		{"-", 24, 48, ""},
+		{"", 24, 48, ""},
+	}
+
+	for _, tt := range tests {
+		if got := toPrefix(tt.ip, tt.v4, tt.v6); got != tt.want {
+			t.Errorf("toPrefix(%q, %d, %d) = %q, want %q", tt.ip, tt.v4, tt.v6, got, tt.want)
+		}
+	}
+}
+
+func TestToPrefixHasNoHostBits(t *testing.T) {
+	addrs := []string{"255.255.255.255", "10.1.2.3", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "2001:db8::dead:beef"}
+
+	for _, ip := range addrs {
+		for v4 := 8; v4 <= 32; v4++ {
+			for v6 := 16; v6 <= 64; v6++ {
+				got := toPrefix(ip, v4, v6)
+				p, err := netip.ParsePrefix(got)
+				if err != nil {
+					t.Fatalf("toPrefix(%q, %d, %d) = %q: %v", ip, v4, v6, got, err)
+				}
+				if p != p.Masked() {
+					t.Errorf("toPrefix(%q, %d, %d) = %q has host bits set", ip, v4, v6, got)
+				}
+			}
+		}
+	}
+}
+
+func TestValidatePrefixLengths(t *testing.T) {
+	valid := [][2]int{{0, 0}, {8, 16}, {32, 64}, {24, 48}}
+	for _, v := range valid {
+		if err := validatePrefixLengths(v[0], v[1]); err != nil {
+			t.Errorf("validatePrefixLengths(%d, %d) = %v, want nil", v[0], v[1], err)
+		}
+	}
+
+	invalid := [][2]int{{7, 48}, {33, 48}, {24, 15}, {24, 65}, {-1, 48}}
+	for _, v := range invalid {
+		if err := validatePrefixLengths(v[0], v[1]); err == nil {
+			t.Errorf("validatePrefixLengths(%d, %d) = nil, want error", v[0], v[1])
+		}
+	}
+}
+
+// FuzzToPrefix checks that for any input toPrefix returns nothing or a
+// network of at most the configured length, with no host bits set.
+func FuzzToPrefix(f *testing.F) {
+	for _, ip := range []string{
+		"203.0.113.77", "2001:db8:abcd:1234::1", "::ffff:198.51.100.9", "fe80::1%eth0", "::", "0.0.0.0",
+		"255.255.255.255/32", "1.2.3.4:8080", "[2001:db8::1]", "01.02.03.004", "2001:db8::1%25eth0", "1.2.3.4\x00",
+	} {
+		f.Add(ip, 24, 48)
+	}
+	f.Fuzz(func(t *testing.T, ip string, v4, v6 int) {
+		if validatePrefixLengths(v4, v6) != nil {
+			return
+		}
+		got := toPrefix(ip, v4, v6)
+		if got == "" {
+			return
+		}
+		p, err := netip.ParsePrefix(got)
+		if err != nil {
+			t.Fatalf("toPrefix(%q, %d, %d) = %q: %v", ip, v4, v6, got, err)
+		}
+		bits := v6
+		if p.Addr().Is4() {
+			bits = v4
+		}
+		if p.Bits() != bits || p != p.Masked() || p.Addr().Zone() != "" {
+			t.Errorf("toPrefix(%q, %d, %d) = %q, more than a /%d network", ip, v4, v6, got, bits)
+		}
+	})
+}
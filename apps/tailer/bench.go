@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// benchSamples is how many line latencies bench keeps for percentiles.
+const benchSamples = 1 << 20
+
+// benchAgents are the user agents of the lines bench makes up, with the
+// crawler family nginx would have logged for each.
+var benchAgents = []struct{ ua, family string }{
+	{"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)", "gptbot"},
+	{"Mozilla/5.0 (compatible; ClaudeBot/1.0; +claudebot@anthropic.com)", "claudebot"},
+	{"Mozilla/5.0 (compatible; PerplexityBot/1.0; +https://perplexity.ai/perplexitybot)", "perplexitybot"},
+	{"CCBot/2.0 (https://commoncrawl.org/faq/)", "ccbot"},
+}
+
+// runBench is the bench subcommand. It feeds made-up lines in the default
+// nginx format through the pipeline at -rate lines a second for -duration,
+// delivering the events to nowhere, and reports to out the rate it kept
+// up, how long a line took and what it allocated. It returns the exit
+// status.
+func runBench(args []string, out io.Writer) int {
+	var rate int
+	var duration time.Duration
+	cfg, _, err := parseConfigWith("trace-tailer bench", args, func(fs *flag.FlagSet) {
+		fs.IntVar(&rate, "rate", 0, "Lines a second to generate (0 for as many as the pipeline takes)")
+		fs.DurationVar(&duration, "duration", 10*time.Second, "How long to run")
+	})
+	if errors.Is(err, flag.ErrHelp) || errors.Is(err, errVersion) {
+		return 0
+	}
+	switch {
+	case err != nil:
+	case rate < 0:
+		err = fmt.Errorf("-rate must not be negative, got %d", rate)
+	case duration <= 0:
+		err = fmt.Errorf("-duration must be positive, got %s", duration)
+	case cfg.Format != "nginx" || cfg.LineFormat != "":
+		err = errors.New("bench generates lines in the nginx format; drop -format and -line-format")
+	}
+	if err != nil {
+		slog.Error("Invalid configuration", "err", err)
+		return 1
+	}
+	setupLogging(cfg)
+	// Dropping events when the queue is full would count lines that were
+	// never delivered.
+	cfg.Backpressure = "block"
+
+	ctx := context.Background()
+	sender := NewFanout(NewSender(ctx, newPrintSender(io.Discard), cfg, nil), nil, 0)
+	p, err := NewPipeline(cfg, sender, nil, nil, nil)
+	if err != nil {
+		slog.Error("Invalid configuration", "err", err)
+		return 1
+	}
+	r := benchmark(p, rate, duration)
+	sender.Close(cfg.ShutdownWait)
+	r.report(out, rate)
+	return 0
+}
+
+// benchResult is what a bench run measured.
+type benchResult struct {
+	lines     int
+	elapsed   time.Duration
+	latencies []time.Duration // a sample of them
+	mallocs   uint64
+	bytes     uint64
+}
+
+// benchmark processes lines through p at rate lines a second, or as fast as
+// it can if rate is 0, until duration has passed.
+func benchmark(p *Pipeline, rate int, duration time.Duration) benchResult {
+	var r benchResult
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	// Checking the time only every few lines keeps it out of the way, and
+	// paces them in bursts of at most a millisecond.
+	step := 64
+	if rate > 0 {
+		step = min(max(rate/1000, 1), step)
+	}
+	var line []byte
+	start := time.Now()
+	for r.lines = 0; ; r.lines++ {
+		if r.lines%step == 0 {
+			if rate > 0 {
+				due := time.Duration(r.lines) * time.Second / time.Duration(rate)
+				if due >= duration {
+					break
+				}
+				if wait := time.Until(start.Add(due)); wait > 0 {
+					time.Sleep(wait)
+				}
+			}
+			if time.Since(start) >= duration {
+				break
+			}
+		}
+		line = appendBenchLine(line[:0], r.lines, time.Now())
+
+		t := time.Now()
+		p.Process("bench", string(line))
+		took := time.Since(t)
+		if len(r.latencies) < benchSamples {
+			r.latencies = append(r.latencies, took)
+		} else if i := rand.IntN(r.lines + 1); i < benchSamples {
+			r.latencies[i] = took
+		}
+	}
+	r.elapsed = time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	r.mallocs = after.Mallocs - before.Mallocs
+	r.bytes = after.TotalAlloc - before.TotalAlloc
+	return r
+}
+
+// appendBenchLine appends the nth made-up line, logged at now, to b.
+func appendBenchLine(b []byte, n int, now time.Time) []byte {
+	agent := benchAgents[n%len(benchAgents)]
+	b = strconv.AppendFloat(b, float64(now.UnixMilli())/1000, 'f', 3, 64)
+	b = append(b, ` "GET /docs/page-`...)
+	b = strconv.AppendInt(b, int64(n%10000), 10)
+	b = append(b, `?ref=bench HTTP/1.1" 200 `...)
+	b = strconv.AppendInt(b, int64(512+n%4096), 10)
+	b = append(b, ` "`...)
+	b = append(b, agent.ua...)
+	b = append(b, `" 203.0.113.`...)
+	b = strconv.AppendInt(b, int64(n%250+1), 10)
+	b = append(b, ` en-US 0.012 example.com `...)
+	return append(b, agent.family...)
+}
+
+// report prints r; rate is the rate asked for, 0 if none.
+func (r benchResult) report(out io.Writer, rate int) {
+	if r.lines == 0 {
+		fmt.Fprintln(out, "No lines processed")
+		return
+	}
+	achieved := float64(r.lines) / r.elapsed.Seconds()
+	fmt.Fprintf(out, "Processed %d lines in %s: %.0f lines/s", r.lines, r.elapsed.Round(time.Millisecond), achieved)
+	if rate > 0 {
+		fmt.Fprintf(out, " (%.1f%% of -rate %d)", 100*achieved/float64(rate), rate)
+	}
+	fmt.Fprintln(out)
+
+	slices.Sort(r.latencies)
+	fmt.Fprintf(out, "Latency: p50 %s, p99 %s, max %s\n",
+		percentile(r.latencies, 0.50), percentile(r.latencies, 0.99), r.latencies[len(r.latencies)-1])
+	fmt.Fprintf(out, "Allocations: %.1f per line, %.0f B per line\n",
+		float64(r.mallocs)/float64(r.lines), float64(r.bytes)/float64(r.lines))
+	if errs := metrics.ParseErrors.Load(); errs > 0 {
+		fmt.Fprintf(out, "Parse errors: %d\n", errs)
+	}
+}
+
+// percentile returns the q quantile of sorted, which must not be empty.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	return sorted[min(int(q*float64(len(sorted))), len(sorted)-1)]
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBenchmark(t *testing.T) {
+	cfg := testConfig()
+	cfg.Format = "nginx"
+	cfg.Backpressure = "block"
+	sender := NewFanout(NewSender(context.Background(), newPrintSender(io.Discard), cfg, nil), nil, 0)
+	defer sender.Close(5 * time.Second)
+	p, err := NewPipeline(cfg, sender, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parseErrors := metrics.ParseErrors.Load()
+	r := benchmark(p, 2000, 100*time.Millisecond)
+	if r.lines < 150 || r.lines > 250 {
+		t.Errorf("processed %d lines in 100ms at 2000 a second", r.lines)
+	}
+	if got := metrics.ParseErrors.Load() - parseErrors; got != 0 {
+		t.Errorf("%d of the generated lines did not parse", got)
+	}
+
+	var out strings.Builder
+	r.report(&out, 2000)
+	for _, want := range []string{"lines/s (", "% of -rate 2000)", "Latency: p50 ", "Allocations: "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned for sends not attempted because the circuit
+// breaker is open.
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// Circuit breaker states, as exported in trace_tailer_circuit_state.
+const (
+	circuitClosed   = 0
+	circuitOpen     = 1
+	circuitHalfOpen = 2
+)
+
+// Breaker stops requests to an API that is down, so that sends fail at
+// once instead of each waiting out -http-timeout. After threshold
+// consecutive failed requests (network errors or 5xx) the circuit opens;
+// once cooldown has passed it is half-open and a single probe request is
+// let through, which closes it again or reopens it. Retries happen within
+// a closed circuit. A threshold of 0 disables the breaker.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time     // when the circuit last opened
+	since    time.Time     // start of the current outage
+	changed  chan struct{} // closed and replaced on every state change
+
+	opened *Counter
+	gauge  *Gauge
+	log    *slog.Logger
+}
+
+// NewBreaker returns a closed breaker recording into m and logging to log.
+func NewBreaker(threshold int, cooldown time.Duration, m *Metrics, log *slog.Logger) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		changed:   make(chan struct{}),
+		opened:    &m.CircuitOpened,
+		gauge:     &m.CircuitState,
+		log:       log,
+	}
+}
+
+// Allow returns errCircuitOpen if a request may not be made now. When it
+// lets the probe of a half-open circuit through, the outcome must be
+// passed to Record before another request is allowed.
+func (b *Breaker) Allow() error {
+	if b.threshold == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitClosed:
+		return nil
+	case circuitOpen:
+		if time.Since(b.openedAt) >= b.cooldown {
+			b.log.Info("Circuit half-open, probing API")
+			b.set(circuitHalfOpen)
+			return nil
+		}
+	}
+	return errCircuitOpen
+}
+
+// Record counts the outcome of a request Allow let through. Any answer
+// other than a 5xx shows the API is up; requests cut short by shutdown
+// show nothing, and a probe among them is retried after the cooldown.
+func (b *Breaker) Record(err error, cancelled bool) {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cancelled {
+		if b.state == circuitHalfOpen {
+			b.set(circuitOpen)
+		}
+		return
+	}
+	if class := errorClass(err); err == nil || (class != "network" && class != "5xx") {
+		if b.state != circuitClosed {
+			b.log.Info("API recovered, circuit closed", "after", time.Since(b.since).Round(time.Second))
+			b.set(circuitClosed)
+		}
+		b.failures = 0
+		return
+	}
+	switch b.state {
+	case circuitClosed:
+		b.failures++
+		if b.failures < b.threshold {
+			return
+		}
+		b.log.Warn("API failing, opening circuit", "failures", b.failures, "cooldown", b.cooldown, "err", err)
+		b.opened.Inc()
+		b.since = time.Now()
+	case circuitHalfOpen:
+		b.log.Info("Probe failed, circuit open again", "cooldown", b.cooldown, "err", err)
+	}
+	// A request made before the circuit opened may fail after; that
+	// doesn't restart the cooldown.
+	if b.state != circuitOpen {
+		b.openedAt = time.Now()
+		b.set(circuitOpen)
+	}
+}
+
+// set changes the state and wakes Wait. b.mu must be held.
+func (b *Breaker) set(state int) {
+	b.state = state
+	b.gauge.Set(int64(state))
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Wait blocks until a probe is due or the circuit closes, or cancel is
+// closed, and reports whether sending may be tried again.
+func (b *Breaker) Wait(cancel <-chan struct{}) bool {
+	b.mu.Lock()
+	state, changed := b.state, b.changed
+	d := time.Until(b.openedAt.Add(b.cooldown))
+	b.mu.Unlock()
+	if state == circuitClosed || (state == circuitOpen && d <= 0) {
+		return true
+	}
+
+	var due <-chan time.Time
+	if state == circuitOpen {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		due = t.C
+	}
+	select {
+	case <-changed:
+		return true
+	case <-due:
+		return true
+	case <-cancel:
+		return false
+	}
+}
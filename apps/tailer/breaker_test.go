@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+func TestBreaker(t *testing.T) {
+	m := NewMetrics()
+	b := NewBreaker(2, 20*time.Millisecond, m, slog.Default())
+	unavailable := &client.StatusError{StatusCode: 503}
+
+	// A 400 in between resets the count.
+	for _, err := range []error{unavailable, &client.StatusError{StatusCode: 400}, unavailable} {
+		if b.Allow() != nil {
+			t.Fatal("closed circuit refused a request")
+		}
+		b.Record(err, false)
+	}
+	if b.Allow() != nil {
+		t.Fatal("circuit opened below the threshold")
+	}
+	b.Record(unavailable, false)
+	if err := b.Allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("Allow after 2 failures = %v, want errCircuitOpen", err)
+	}
+	if m.CircuitOpened.Load() != 1 || m.CircuitState.Load() != circuitOpen {
+		t.Errorf("opened %d, state %d; want 1, open", m.CircuitOpened.Load(), m.CircuitState.Load())
+	}
+
+	// After the cooldown one probe goes through; it fails and reopens.
+	time.Sleep(30 * time.Millisecond)
+	if b.Allow() != nil {
+		t.Fatal("no probe after the cooldown")
+	}
+	if b.Allow() == nil {
+		t.Fatal("second request allowed while probing")
+	}
+	b.Record(unavailable, false)
+	if b.Allow() == nil {
+		t.Fatal("failed probe didn't reopen the circuit")
+	}
+
+	// The next probe succeeds and closes it.
+	if !b.Wait(nil) {
+		t.Fatal("Wait = false")
+	}
+	if b.Allow() != nil {
+		t.Fatal("no probe after the cooldown")
+	}
+	b.Record(nil, false)
+	if b.Allow() != nil || m.CircuitState.Load() != circuitClosed {
+		t.Errorf("circuit not closed after a successful probe")
+	}
+}
+
+func TestSenderCircuitOpen(t *testing.T) {
+	unavailable := &client.StatusError{StatusCode: 503}
+	api := &fakeAPI{errs: []error{unavailable, unavailable, unavailable}}
+	cfg := testConfig()
+	cfg.BatchSize = 1
+	cfg.MaxRetries = 5
+	cfg.BreakerAfter = 2
+	cfg.BreakerCooldown = 20 * time.Millisecond
+	s := NewSender(context.Background(), api, cfg, nil)
+	s.Enqueue(&CrawlEvent{Path: "/"})
+	if !s.Close(5 * time.Second) {
+		t.Fatal("Close timed out")
+	}
+
+	// Two failures open the circuit, the first probe fails and the second
+	// delivers; waiting out the cooldown uses up no retries.
+	if api.calls != 4 || len(api.received) != 1 {
+		t.Errorf("got %d calls, %d events sent; want 4, 1", api.calls, len(api.received))
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"log/slog"
+	"math"
+	"math/bits"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+const (
+	// burstBuckets is how many slices -burst-window is counted in; it
+	// slides by one at a time.
+	burstBuckets = 10
+
+	// burstMaxFamilies bounds the families tracked. With each bucket's
+	// path sketch at 1 KiB, that is about 2.5 MiB at most.
+	burstMaxFamilies = 256
+
+	// burstCheckEvery is how often a family's window is checked against
+	// its thresholds, at most.
+	burstCheckEvery = 5 * time.Second
+
+	burstDefaultMinRequests = 100
+	burstAlertTimeout       = 10 * time.Second
+)
+
+// BurstRule is one entry of the config file's bursts list: thresholds on
+// what one crawler family does within -burst-window, any of which being
+// reached is a burst. Family "*" applies to known families without a rule
+// of their own.
+type BurstRule struct {
+	Family      string  `yaml:"family"`
+	Requests    int64   `yaml:"requests"`
+	UniquePaths int64   `yaml:"unique_paths"`
+	ErrorRatio  float64 `yaml:"error_ratio"` // of responses with status 400 and up
+
+	// MinRequests is how many requests there must be before ErrorRatio
+	// counts, burstDefaultMinRequests if zero.
+	MinRequests int64 `yaml:"min_requests"`
+}
+
+func (r BurstRule) validate() error {
+	if r.Family == "" {
+		return errors.New("family is not set")
+	}
+	if r.Requests < 0 || r.UniquePaths < 0 || r.MinRequests < 0 || r.ErrorRatio < 0 || r.ErrorRatio > 1 {
+		return errors.New("thresholds must not be negative, and error_ratio at most 1")
+	}
+	if r.Requests == 0 && r.UniquePaths == 0 && r.ErrorRatio == 0 {
+		return errors.New("none of requests, unique_paths and error_ratio is set")
+	}
+	return nil
+}
+
+// Bursts tracks, for each crawler family with a rule, the requests it
+// made, the distinct paths among them and the share that failed over a
+// sliding window, and raises an alert when a threshold is reached: a
+// warning in the log and, with -burst-alerts api, an alert sent to the
+// API. A family alerts at most once a window.
+type Bursts struct {
+	rules  map[string]BurstRule
+	window time.Duration
+	width  int64 // of a bucket, in nanoseconds
+	seed   maphash.Seed
+
+	mu       sync.Mutex
+	families map[string]*burstFamily
+	fullLog  throttledLog
+}
+
+type burstFamily struct {
+	rule    BurstRule
+	buckets [burstBuckets]burstBucket
+	checked time.Time
+	alerted time.Time
+}
+
+type burstBucket struct {
+	n        int64 // the bucket's number since the epoch
+	requests int64
+	errors   int64
+	paths    pathSketch
+}
+
+// NewBursts returns the detector for cfg, or nil if there are no rules.
+func NewBursts(cfg Config) *Bursts {
+	if len(cfg.Bursts) == 0 {
+		return nil
+	}
+	b := &Bursts{
+		rules:    map[string]BurstRule{},
+		window:   cfg.BurstWindow,
+		width:    int64(cfg.BurstWindow) / burstBuckets,
+		seed:     maphash.MakeSeed(),
+		families: map[string]*burstFamily{},
+		fullLog:  throttledLog{interval: time.Hour},
+	}
+	for _, r := range cfg.Bursts {
+		if r.MinRequests == 0 {
+			r.MinRequests = burstDefaultMinRequests
+		}
+		family := strings.ToLower(r.Family)
+		if _, dup := b.rules[family]; !dup {
+			b.rules[family] = r
+		}
+	}
+	return b
+}
+
+// Observe counts a request of family for path with status, made at now,
+// the time it was logged. Requests logged before the window of the latest
+// are left out.
+func (b *Bursts) Observe(family, path string, status int, now time.Time) {
+	rule, ok := b.rules[family]
+	if !ok {
+		if rule, ok = b.rules["*"]; !ok || family == "unknown" {
+			return
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f := b.families[family]
+	if f == nil {
+		if len(b.families) >= burstMaxFamilies {
+			b.fullLog.Log(slog.LevelWarn, "Too many crawler families to track for bursts", "max", burstMaxFamilies, "family", family)
+			return
+		}
+		f = &burstFamily{rule: rule}
+		b.families[family] = f
+	}
+	n := now.UnixNano() / b.width
+	bucket := &f.buckets[n%burstBuckets]
+	switch {
+	case bucket.n > n:
+		return
+	case bucket.n < n:
+		*bucket = burstBucket{n: n}
+	}
+	bucket.requests++
+	if status >= 400 {
+		bucket.errors++
+	}
+	bucket.paths.add(maphash.String(b.seed, path))
+
+	if now.Sub(f.checked) < burstCheckEvery || now.Sub(f.alerted) < b.window {
+		return
+	}
+	f.checked = now
+	if alert := f.check(n, b.window); alert != nil {
+		f.alerted = now
+		alert.Timestamp = now.UnixMilli()
+		alert.CrawlerFamily = family
+		raise(alert)
+	}
+}
+
+// check returns an alert if the window ending with bucket n reaches a
+// threshold.
+func (f *burstFamily) check(n int64, window time.Duration) *client.Alert {
+	var requests, errs int64
+	var paths pathSketch
+	for i := range f.buckets {
+		if b := &f.buckets[i]; b.n > n-burstBuckets {
+			requests += b.requests
+			errs += b.errors
+			paths.merge(&b.paths)
+		}
+	}
+	unique := paths.count()
+	ratio := float64(errs) / float64(requests)
+
+	var reasons []string
+	r := f.rule
+	if r.Requests > 0 && requests >= r.Requests {
+		reasons = append(reasons, "requests")
+	}
+	if r.UniquePaths > 0 && unique >= r.UniquePaths {
+		reasons = append(reasons, "unique_paths")
+	}
+	if r.ErrorRatio > 0 && requests >= r.MinRequests && ratio >= r.ErrorRatio {
+		reasons = append(reasons, "error_ratio")
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	return &client.Alert{
+		Type:        "burst",
+		Reasons:     reasons,
+		WindowMs:    window.Milliseconds(),
+		Requests:    requests,
+		UniquePaths: unique,
+		Errors:      errs,
+		ErrorRatio:  math.Round(ratio*1000) / 1000,
+	}
+}
+
+// raise logs alert and hands it to alerts.
+func raise(alert *client.Alert) {
+	slog.Warn("Burst of requests from a crawler", "crawler_family", alert.CrawlerFamily,
+		"requests", alert.Requests, "unique_paths", alert.UniquePaths, "errors", alert.Errors, "error_ratio", alert.ErrorRatio,
+		"window", time.Duration(alert.WindowMs)*time.Millisecond, "reasons", strings.Join(alert.Reasons, ","))
+	alerts.Send(alert)
+}
+
+// pathSketch is a HyperLogLog counter of distinct paths, good to about 3%.
+type pathSketch [1 << pathSketchBits]uint8
+
+const pathSketchBits = 10
+
+func (s *pathSketch) add(h uint64) {
+	i := h >> (64 - pathSketchBits)
+	rank := uint8(bits.LeadingZeros64(h<<pathSketchBits|1<<(pathSketchBits-1))) + 1
+	if rank > s[i] {
+		s[i] = rank
+	}
+}
+
+func (s *pathSketch) merge(o *pathSketch) {
+	for i, r := range o {
+		if r > s[i] {
+			s[i] = r
+		}
+	}
+}
+
+func (s *pathSketch) count() int64 {
+	const m = float64(len(pathSketch{}))
+	var sum float64
+	zeros := 0
+	for _, r := range s {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// Small counts are better estimated from the empty registers.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+// alerts sends alerts to the API with -burst-alerts api; nil otherwise.
+// It is set before any log line is read.
+var alerts *AlertSender
+
+// AlertSender posts alerts to /v1/alerts in the background, so that a
+// slow API never holds up reading.
+type AlertSender struct {
+	api  *client.Client
+	meta *agentMeta
+	sem  chan struct{}
+	log  throttledLog
+}
+
+func NewAlertSender(api *client.Client, meta *agentMeta) *AlertSender {
+	return &AlertSender{api: api, meta: meta, sem: make(chan struct{}, 4), log: throttledLog{interval: time.Minute}}
+}
+
+// Send posts alert. It does nothing on a nil sender, and drops the alert
+// if too many are still being sent.
+func (s *AlertSender) Send(alert *client.Alert) {
+	if s == nil {
+		return
+	}
+	alert.AgentHost, alert.InstanceID = s.meta.host, s.meta.instanceID
+	select {
+	case s.sem <- struct{}{}:
+	default:
+		s.log.Log(slog.LevelWarn, "Dropped an alert, others are still being sent", "crawler_family", alert.CrawlerFamily)
+		return
+	}
+	go func() {
+		defer func() { <-s.sem }()
+		ctx, cancel := context.WithTimeout(context.Background(), burstAlertTimeout)
+		defer cancel()
+		if err := s.api.SendAlert(ctx, alert); err != nil {
+			s.log.Log(slog.LevelWarn, "Failed to send alert", "crawler_family", alert.CrawlerFamily, "err", err)
+		}
+	}()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+func TestBursts(t *testing.T) {
+	check := func(b *Bursts, family string) *client.Alert {
+		f := b.families[family]
+		if f == nil {
+			return nil
+		}
+		n := f.buckets[0].n
+		for _, bucket := range f.buckets {
+			n = max(n, bucket.n)
+		}
+		return f.check(n, b.window)
+	}
+
+	rules := []BurstRule{
+		{Family: "GPTBot", Requests: 100},
+		{Family: "claudebot", UniquePaths: 50},
+		{Family: "*", ErrorRatio: 0.5, MinRequests: 20},
+	}
+	start := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name    string
+		family  string
+		n       int
+		path    func(i int) string
+		status  func(i int) int
+		reasons []string
+	}{
+		{name: "below requests", family: "gptbot", n: 99, path: func(int) string { return "/" }, status: ok},
+		{name: "requests", family: "gptbot", n: 100, path: func(int) string { return "/" }, status: ok, reasons: []string{"requests"}},
+		{name: "one path", family: "claudebot", n: 500, path: func(int) string { return "/" }, status: ok},
+		{name: "unique paths", family: "claudebot", n: 60, path: func(i int) string { return fmt.Sprint("/", i) }, status: ok, reasons: []string{"unique_paths"}},
+		{name: "errors below min requests", family: "ccbot", n: 19, path: func(int) string { return "/" }, status: func(int) int { return 404 }},
+		{name: "error ratio", family: "ccbot", n: 40, path: func(int) string { return "/" }, status: func(i int) int { return 200 + 300*(i%2) }, reasons: []string{"error_ratio"}},
+		{name: "unknown is not a family", family: "unknown", n: 40, path: func(int) string { return "/" }, status: func(int) int { return 404 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBursts(Config{Bursts: rules, BurstWindow: time.Minute})
+			for i := 0; i < tt.n; i++ {
+				b.Observe(tt.family, tt.path(i), tt.status(i), start.Add(time.Duration(i)*time.Millisecond))
+			}
+			alert := check(b, tt.family)
+			if (alert == nil) != (tt.reasons == nil) || alert != nil && fmt.Sprint(alert.Reasons) != fmt.Sprint(tt.reasons) {
+				t.Errorf("alert = %+v, want reasons %v", alert, tt.reasons)
+			}
+		})
+	}
+
+	// Requests slide out of the window; a family alerts once a window.
+	b := NewBursts(Config{Bursts: rules, BurstWindow: time.Minute})
+	for i := 0; i < 60; i++ {
+		b.Observe("gptbot", "/", 200, start.Add(time.Duration(i)*time.Second/2))
+	}
+	for i := 0; i < 60; i++ {
+		b.Observe("gptbot", "/", 200, start.Add(time.Minute+time.Duration(i)*time.Second/2))
+	}
+	if alert := check(b, "gptbot"); alert != nil {
+		t.Errorf("alert for requests over two windows: %+v", alert)
+	}
+	f := b.families["gptbot"]
+	for i := 0; i < 99; i++ {
+		b.Observe("gptbot", "/", 200, start.Add(3*time.Minute))
+	}
+	// Windows are checked every burstCheckEvery at most.
+	b.Observe("gptbot", "/", 200, start.Add(3*time.Minute+burstCheckEvery))
+	first := f.alerted
+	if first.IsZero() {
+		t.Fatal("no alert at the threshold")
+	}
+	if got := check(b, "gptbot").Requests; got != 100 {
+		t.Errorf("requests = %d, want 100", got)
+	}
+	for i := 0; i < 200; i++ {
+		b.Observe("gptbot", "/", 200, start.Add(3*time.Minute+10*time.Second))
+	}
+	if f.alerted != first {
+		t.Error("alerted twice in a window")
+	}
+	// Requests logged before the window of the latest are left out.
+	b.Observe("gptbot", "/", 200, start)
+	if got := check(b, "gptbot").Requests; got != 300 {
+		t.Errorf("requests = %d, want 300", got)
+	}
+
+	b = NewBursts(Config{Bursts: rules, BurstWindow: time.Minute})
+	for i := 0; i < burstMaxFamilies+10; i++ {
+		b.Observe(fmt.Sprint("bot", i), "/", 200, start)
+	}
+	if len(b.families) != burstMaxFamilies {
+		t.Errorf("tracked %d families, want %d", len(b.families), burstMaxFamilies)
+	}
+}
+
+func ok(int) int { return 200 }
+
+func TestPathSketch(t *testing.T) {
+	seed := maphash.MakeSeed()
+	for _, n := range []int{0, 1, 10, 100, 1000, 5000, 50000} {
+		var s pathSketch
+		for i := 0; i < n; i++ {
+			s.add(maphash.String(seed, fmt.Sprint("/page/", i)))
+			s.add(maphash.String(seed, fmt.Sprint("/page/", i/2)))
+		}
+		got := s.count()
+		if d := float64(got-int64(n)) / float64(max(n, 1)); d < -0.1 || d > 0.1 {
+			t.Errorf("count of %d paths = %d", n, got)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rotationSuffixRe matches what logrotate appends to a rotated log's name:
+// a number (access.log.1, access.log.2.gz) or, with dateext, a date
+// (access.log-20240115, access.log-2024-01-15, access.log-20240115-1705276800.gz).
+var rotationSuffixRe = regexp.MustCompile(`^[.-]([0-9][0-9-]*)(\.gz)?$`)
+
+// rotation is a rotated log file and where it sorts among its siblings.
+type rotation struct {
+	path string
+	date string // the digits of the date, for dateext
+	n    int    // otherwise, the number
+}
+
+// rotatedFiles returns the rotations of path, oldest first: dated ones by
+// date, then numbered ones from the highest number down. Names that
+// don't look like rotations (access.log.bak, access.log.2.bz2) are left
+// out.
+func rotatedFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(globEscape(path) + "?*")
+	if err != nil {
+		return nil, fmt.Errorf("list rotations of %s: %w", path, err)
+	}
+	var rots []rotation
+	for _, m := range matches {
+		sm := rotationSuffixRe.FindStringSubmatch(m[len(path):])
+		if sm == nil {
+			continue
+		}
+		if fi, err := os.Stat(m); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if digits := strings.ReplaceAll(sm[1], "-", ""); len(digits) >= 8 {
+			rots = append(rots, rotation{path: m, date: digits})
+		} else {
+			n, _ := strconv.Atoi(digits)
+			rots = append(rots, rotation{path: m, n: n})
+		}
+	}
+	sort.Slice(rots, func(i, j int) bool {
+		a, b := rots[i], rots[j]
+		if (a.date != "") != (b.date != "") {
+			return a.date != ""
+		}
+		if a.date != "" {
+			return a.date < b.date
+		}
+		return a.n > b.n
+	})
+	files := make([]string, len(rots))
+	for i, r := range rots {
+		files[i] = r.path
+	}
+	return files, nil
+}
+
+// globEscape quotes the glob metacharacters in a literal path.
+func globEscape(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// catchUp reads the lines written to rotations of path since the tailer
+// last read it, for -catch-up, before path itself is tailed. If the file
+// it stopped in is still there uncompressed (found by its inode), reading
+// resumes at the stored offset and every newer rotation is read whole.
+// Otherwise, as when that file has since been compressed, rotations last
+// modified after the stored position are read and lines timestamped at or
+// before it are skipped. Nothing is read when there is no stored position,
+// or when path is still the file the position is for.
+//
+// process is Pipeline.ProcessAfter. Reading ends early, without an
+// error, when stop is closed.
+func catchUp(path string, positions *PositionStore, process func(source, line string, after int64) error, stop <-chan struct{}) (lines, skipped int, err error) {
+	pos, ok := positions.Get(path)
+	if !ok {
+		return 0, 0, nil
+	}
+	if statInode(path) == pos.Inode {
+		return 0, 0, nil
+	}
+
+	files, err := rotatedFiles(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	start, offset, after := -1, int64(0), pos.Time
+	for i, f := range files {
+		if !strings.HasSuffix(f, ".gz") && statInode(f) == pos.Inode {
+			start, offset, after = i, pos.Offset, 0
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+		slog.Info("Catching up by timestamp; the file last read is gone or compressed", "file", path)
+	}
+
+	for i, f := range files[start:] {
+		if i > 0 {
+			offset = 0
+		}
+		if after > 0 {
+			if fi, err := os.Stat(f); err == nil && fi.ModTime().UnixMilli() < after {
+				continue
+			}
+		}
+		n, s, err := catchUpFile(f, offset, after, process, stop)
+		lines += n
+		skipped += s
+		if err != nil {
+			return lines, skipped, fmt.Errorf("read %s: %w", f, err)
+		}
+		slog.Info("Caught up on rotated file", "file", f, "lines", n, "already_read", s)
+		select {
+		case <-stop:
+			return lines, skipped, nil
+		default:
+		}
+	}
+	return lines, skipped, nil
+}
+
+func catchUpFile(file string, offset, after int64, process func(source, line string, after int64) error, stop <-chan struct{}) (lines, skipped int, err error) {
+	r, err := openLog(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+			return 0, 0, fmt.Errorf("skip to offset %d: %w", offset, err)
+		}
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			lines++
+			if errors.Is(process(file, line, after), errAlreadyRead) {
+				skipped++
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return lines, skipped, nil
+		}
+		if err != nil {
+			return lines, skipped, err
+		}
+		select {
+		case <-stop:
+			return lines, skipped, nil
+		default:
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "access.log")
+	for _, name := range []string{"access.log", "access.log.1", "access.log.2.gz", "access.log.10.gz", "access.log-20240102", "access.log-20240101.gz", "access.log.bak", "access.log.3.bz2", "access.logs"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := rotatedFiles(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		got[i] = filepath.Base(got[i])
+	}
+	want := []string{"access.log-20240101.gz", "access.log-20240102", "access.log.10.gz", "access.log.2.gz", "access.log.1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// catchUpLines runs catchUp with lines of the form "<unix ms> <text>",
+// returning the texts it hands on.
+func catchUpLines(t *testing.T, path string, positions *PositionStore) []string {
+	t.Helper()
+	var got []string
+	process := func(source, line string, after int64) error {
+		ts, text, _ := strings.Cut(line, " ")
+		if n, _ := strconv.ParseInt(ts, 10, 64); n <= after {
+			return errAlreadyRead
+		}
+		got = append(got, text)
+		return nil
+	}
+	if _, _, err := catchUp(path, positions, process, make(chan struct{})); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func writeGzip(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte(content))
+	zw.Close()
+	f.Close()
+}
+
+func TestCatchUpAfterRename(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "access.log")
+	positions, err := LoadPositions(filepath.Join(dir, "positions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An old rotation, read long ago, then the file being read when the
+	// tailer stopped: it got as far as "a", then logrotate renamed it and
+	// nginx wrote "b" and "c" there, then "d" after a second rotation.
+	writeGzip(t, live+".3.gz", "1 old\n")
+	if err := os.WriteFile(live, []byte("10 a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	positions.Update(live, statInode(live), int64(len("10 a\n")))
+	f, _ := os.OpenFile(live, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("11 b\n12 c\n")
+	f.Close()
+	if err := os.Rename(live, live+".2"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(live+".1", []byte("13 d\n"), 0o644)
+	os.WriteFile(live, []byte("14 live\n"), 0o644)
+
+	got := catchUpLines(t, live, positions)
+	if want := []string{"b", "c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCatchUpByTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "access.log")
+	positions, err := LoadPositions(filepath.Join(dir, "positions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file last read has been compressed, so only the time it was
+	// read up to is left to go by. .2.gz was last written before that
+	// time, so it isn't opened at all; its line would be sent if it were.
+	read := time.Now().Add(-time.Hour)
+	ms := read.UnixMilli()
+	writeGzip(t, live+".2.gz", fmt.Sprintf("%d unread\n", ms+1000))
+	old := read.Add(-time.Hour)
+	os.Chtimes(live+".2.gz", old, old)
+	writeGzip(t, live+".1.gz", fmt.Sprintf("%d a\n%d b\n%d c\n", ms-1, ms, ms+1))
+	os.WriteFile(live, []byte("0 live\n"), 0o644)
+	positions.positions[live] = Position{Inode: 1, Offset: 5, Time: ms}
+
+	if got, want := catchUpLines(t, live, positions), []string{"c"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCatchUpNothingRotated(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "access.log")
+	positions, err := LoadPositions(filepath.Join(dir, "positions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(live+".1", []byte("1 old\n"), 0o644)
+	os.WriteFile(live, []byte("2 live\n"), 0o644)
+	if got := catchUpLines(t, live, positions); len(got) != 0 {
+		t.Errorf("no position: got %q", got)
+	}
+	positions.Update(live, statInode(live), 0)
+	if got := catchUpLines(t, live, positions); len(got) != 0 {
+		t.Errorf("live file not rotated: got %q", got)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/originaryx/trace/tailer/pkg/parser"
+)
+
+const (
+	// checkShowFailures is how many failing lines check prints.
+	checkShowFailures = 5
+
+	// checkContext is how much of a failing line check prints on either
+	// side of where it failed.
+	checkContext = 60
+)
+
+// runCheck is the check subcommand. It parses up to -n lines of the -file
+// logs with the configured format, sending nothing anywhere, and reports
+// to out how many parsed, what failed most often and the first lines that
+// failed. It returns the exit status: 1 if fewer than -min-ok of the lines
+// parsed.
+func runCheck(args []string, out io.Writer) int {
+	var n int
+	var minOK float64
+	cfg, _, err := parseConfigWith("trace-tailer check", args, func(fs *flag.FlagSet) {
+		fs.IntVar(&n, "n", 1000, "Check at most this many lines")
+		fs.Float64Var(&minOK, "min-ok", 0.99, "Exit non-zero if a smaller share of the lines parse")
+	})
+	if errors.Is(err, flag.ErrHelp) || errors.Is(err, errVersion) {
+		return 0
+	}
+	if err == nil && n < 1 {
+		err = errors.New("-n must be at least 1")
+	}
+	if err == nil && (minOK < 0 || minOK > 1) {
+		err = fmt.Errorf("-min-ok must be between 0 and 1, got %g", minOK)
+	}
+	if err != nil {
+		slog.Error("Invalid configuration", "err", err)
+		return 1
+	}
+	setupLogging(cfg)
+	p, err := newParser(cfg)
+	if err != nil {
+		slog.Error("Invalid configuration", "err", err)
+		return 1
+	}
+
+	c := &checker{parse: p, maxLineBytes: cfg.MaxLineBytes, reasons: map[string]int{}}
+	for _, pattern := range cfg.LogFiles {
+		if err := c.checkPattern(pattern, n); err != nil {
+			slog.Error("Failed to read log", "file", pattern, "err", err)
+			return 1
+		}
+	}
+	format := "-format " + cfg.Format
+	if cfg.LineFormat != "" {
+		format = "-line-format"
+	}
+	return c.report(out, format, minOK)
+}
+
+// checker tallies what check finds.
+type checker struct {
+	parse        parser.LineParser
+	maxLineBytes int
+
+	lines, parsed, skipped, failed int
+	reasons                        map[string]int
+	failures                       []checkFailure
+}
+
+type checkFailure struct {
+	source string
+	number int
+	line   string
+	reason string
+	offset int // where in line it failed, -1 if unknown
+}
+
+// checkPattern checks the lines of the files pattern matches, oldest
+// first, or of standard input for "-", until n lines were checked in all.
+func (c *checker) checkPattern(pattern string, n int) error {
+	if pattern == "-" {
+		return c.checkLines(os.Stdin, "stdin", n)
+	}
+	files, err := expandOldestFirst(pattern)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if c.lines >= n {
+			return nil
+		}
+		r, err := openLog(file)
+		if err != nil {
+			return err
+		}
+		err = c.checkLines(r, file, n)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (c *checker) checkLines(r io.Reader, source string, n int) error {
+	br := bufio.NewReader(r)
+	for number := 1; c.lines < n; number++ {
+		line, err := br.ReadString('\n')
+		if line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"); line != "" {
+			c.check(source, number, line)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check parses line, number number of source, as the tailer would.
+func (c *checker) check(source string, number int, line string) {
+	c.lines++
+	var reason string
+	offset := -1
+	if c.maxLineBytes > 0 && len(line) > c.maxLineBytes {
+		reason = "longer than -max-line-bytes"
+	} else {
+		_, err := c.parse.Parse(line)
+		switch {
+		case err == nil:
+			c.parsed++
+			return
+		case errors.Is(err, parser.ErrSkip):
+			c.skipped++
+			return
+		}
+		reason = withoutValues(err.Error(), line)
+		if e, ok := c.parse.(parser.Explainer); ok {
+			if part, at := e.Explain(line); part != "" {
+				reason, offset = part, at
+			}
+		}
+	}
+	c.failed++
+	c.reasons[reason]++
+	if len(c.failures) < checkShowFailures {
+		c.failures = append(c.failures, checkFailure{source: source, number: number, line: line, reason: reason, offset: offset})
+	}
+}
+
+// withoutValues replaces the quoted values in a parse error that were
+// taken from line, so that errors about different lines count as one.
+func withoutValues(msg, line string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(msg, '"')
+		if i < 0 {
+			break
+		}
+		quoted, err := strconv.QuotedPrefix(msg[i:])
+		if err != nil {
+			break
+		}
+		b.WriteString(msg[:i])
+		if v, err := strconv.Unquote(quoted); err == nil && v != "" && strings.Contains(line, v) {
+			b.WriteString(`"…"`)
+		} else {
+			b.WriteString(quoted)
+		}
+		msg = msg[i+len(quoted):]
+	}
+	b.WriteString(msg)
+	return b.String()
+}
+
+// report prints what was found and returns the exit status.
+func (c *checker) report(out io.Writer, format string, minOK float64) int {
+	checked := c.parsed + c.failed
+	fmt.Fprintf(out, "Checked %d lines with %s\n", c.lines, format)
+	if c.skipped > 0 {
+		fmt.Fprintf(out, "Skipped: %d, not requests\n", c.skipped)
+	}
+	if checked == 0 {
+		fmt.Fprintln(out, "No requests to check")
+		return 1
+	}
+	rate := float64(c.parsed) / float64(checked)
+	fmt.Fprintf(out, "Parsed:  %d of %d (%.2f%%)\n", c.parsed, checked, 100*rate)
+
+	if len(c.reasons) > 0 {
+		reasons := make([]string, 0, len(c.reasons))
+		for r := range c.reasons {
+			reasons = append(reasons, r)
+		}
+		sort.Slice(reasons, func(i, j int) bool {
+			if a, b := c.reasons[reasons[i]], c.reasons[reasons[j]]; a != b {
+				return a > b
+			}
+			return reasons[i] < reasons[j]
+		})
+		fmt.Fprintln(out, "\nFailures by cause:")
+		for _, r := range reasons {
+			fmt.Fprintf(out, "%8d  %s\n", c.reasons[r], r)
+		}
+		fmt.Fprintln(out, "\nFirst failing lines:")
+		for _, f := range c.failures {
+			fmt.Fprintf(out, "%s:%d: %s\n", f.source, f.number, f.reason)
+			excerpt, column := excerptAt(f.line, f.offset)
+			fmt.Fprintf(out, "    %s\n", excerpt)
+			if column >= 0 {
+				fmt.Fprintf(out, "    %s^\n", strings.Repeat(" ", column))
+			}
+		}
+	}
+
+	if rate < minOK {
+		fmt.Fprintf(out, "\n%.2f%% of the lines parsed, below -min-ok %.2f%%\n", 100*rate, 100*minOK)
+		return 1
+	}
+	return 0
+}
+
+// excerptAt returns the part of line around offset that fits a terminal
+// line, with tabs and other control characters shown as spaces, and the
+// column offset is at in it, or -1 if offset is.
+func excerptAt(line string, offset int) (excerpt string, column int) {
+	start, end := 0, len(line)
+	if offset > checkContext {
+		start = offset - checkContext
+	}
+	limit := offset + checkContext
+	if offset < 0 {
+		limit = 2 * checkContext
+	}
+	end = min(end, limit)
+	// Cut at the start of a character.
+	for start > 0 && !utf8.RuneStart(line[start]) {
+		start--
+	}
+	for end < len(line) && !utf8.RuneStart(line[end]) {
+		end++
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	column = -1
+	for i, r := range line[start:end] {
+		if start+i == offset {
+			column = utf8.RuneCountInString(b.String())
+		}
+		if r < ' ' || r == 0x7f {
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+	if offset == end {
+		column = utf8.RuneCountInString(b.String())
+	}
+	if end < len(line) {
+		b.WriteString("…")
+	}
+	return b.String(), column
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCheck(t *testing.T) {
+	const good = `1700000000.123 "GET /a HTTP/1.1" 200 512 "GPTBot/1.0"` + "\n"
+	sample := filepath.Join(t.TempDir(), "sample.log")
+	lines := strings.Repeat(good, 8) + `1700000000.123 "GET /a HTTP/1.1" OK 512 "GPTBot/1.0"` + "\n" + "junk\n" + good
+	if err := os.WriteFile(sample, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	format := `-line-format=$msec "$request" $status $body_bytes_sent "$http_user_agent"`
+
+	var out strings.Builder
+	if code := runCheck([]string{format, "-file", sample, "-n", "10"}, &out); code != 1 {
+		t.Errorf("exit status %d, want 1", code)
+	}
+	for _, want := range []string{
+		"Checked 10 lines",
+		"Parsed:  8 of 10 (80.00%)",
+		"       1  $request\n       1  $status\n",
+		sample + ":9: $status\n    1700000000.123 \"GET /a HTTP/1.1\" OK 512 \"GPTBot/1.0\"\n" + strings.Repeat(" ", 4+33) + "^\n",
+		"below -min-ok 99.00%",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if code := runCheck([]string{format, "-file", sample, "-min-ok", "0.8"}, &out); code != 0 {
+		t.Errorf("with -min-ok 0.8, exit status %d, want 0:\n%s", code, out.String())
+	}
+}
+
+func TestWithoutValues(t *testing.T) {
+	const line = `ts=abc code=OK`
+	tests := []struct{ msg, want string }{
+		{`invalid status "OK"`, `invalid status "…"`},
+		{`parse timestamp "abc": bad`, `parse timestamp "…": bad`},
+		{`missing key "status"`, `missing key "status"`},
+		{`line did not match expected format`, `line did not match expected format`},
+	}
+	for _, tt := range tests {
+		if got := withoutValues(tt.msg, line); got != tt.want {
+			t.Errorf("withoutValues(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
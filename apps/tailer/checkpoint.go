@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Checkpoint is what -checkpoint-file holds: how far a -once replay got.
+type Checkpoint struct {
+	Done   []string `json:"done"`             // files read to the end
+	File   string   `json:"file,omitempty"`   // the file being read
+	Size   int64    `json:"size,omitempty"`   // of File, to tell if it changed
+	Offset int64    `json:"offset,omitempty"` // bytes of File read, after any decompression
+
+	// Lines and EventsSent add up every run of the replay.
+	Lines      int64 `json:"lines"`
+	EventsSent int64 `json:"events_sent"`
+	Time       int64 `json:"time"` // when it was saved, in Unix ms
+}
+
+// Checkpoints keeps the checkpoint of a replay and saves it to the
+// checkpoint file. Like positions, it records how far lines have been
+// read: events still held in memory are lost in a crash unless the queue
+// is a bolt file, which Sync flushes before saving.
+type Checkpoints struct {
+	path string
+
+	mu     sync.Mutex
+	cp     Checkpoint
+	offset atomic.Int64 // into cp.File
+	resume Checkpoint   // where to resume, with the counts of earlier runs
+	saved  Checkpoint   // as last saved, without the time
+	flush  func() error
+}
+
+// LoadCheckpoints returns the checkpoints saved to path. With resume, the
+// replay continues from the checkpoint already there, if any; otherwise
+// it starts over, replacing it.
+func LoadCheckpoints(path string, resume bool) (*Checkpoints, error) {
+	c := &Checkpoints{path: path}
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if resume {
+			slog.Info("No checkpoint to resume from, replaying from the start", "checkpoint_file", path)
+		}
+		return c, nil
+	case err != nil:
+		return nil, fmt.Errorf("read checkpoint file: %w", err)
+	case !resume:
+		slog.Info("Replaying from the start, replacing the checkpoint; -resume continues from it", "checkpoint_file", path)
+		return c, nil
+	}
+	if err := json.Unmarshal(data, &c.resume); err != nil {
+		return nil, fmt.Errorf("parse checkpoint file %s: %w", path, err)
+	}
+	c.cp.Done = slices.Clone(c.resume.Done)
+	slog.Info("Resuming replay from checkpoint", "checkpoint_file", path, "files_done", len(c.resume.Done),
+		"file", c.resume.File, "offset", c.resume.Offset, "events_sent", c.resume.EventsSent,
+		"saved", time.UnixMilli(c.resume.Time).UTC().Format(time.RFC3339))
+	return c, nil
+}
+
+// Start records that file, with info fi (nil if it can't be had), is
+// about to be read. It reports whether the checkpoint resumed from has it
+// read already and, if not, how many of its bytes to skip.
+func (c *Checkpoints) Start(file string, fi os.FileInfo) (done bool, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.Contains(c.cp.Done, file) {
+		return true, 0
+	}
+	c.cp.File, c.cp.Size = file, 0
+	if fi != nil {
+		c.cp.Size = fi.Size()
+	}
+	if file == c.resume.File {
+		if c.resume.Size == c.cp.Size {
+			offset = c.resume.Offset
+		} else {
+			slog.Warn("File changed since the checkpoint, replaying it from the start", "file", file, "size", c.cp.Size, "checkpoint_size", c.resume.Size)
+		}
+		c.resume.File = ""
+	}
+	c.offset.Store(offset)
+	return false, offset
+}
+
+// Advance records that n more bytes of the file being read were handled.
+func (c *Checkpoints) Advance(n int) {
+	c.offset.Add(int64(n))
+}
+
+// Done records that file was read to the end.
+func (c *Checkpoints) Done(file string) {
+	c.mu.Lock()
+	c.cp.Done = append(c.cp.Done, file)
+	c.cp.File, c.cp.Size = "", 0
+	c.offset.Store(0)
+	c.mu.Unlock()
+}
+
+// SetFlush makes Sync call flush after taking the checkpoint and before
+// saving it, as PositionStore.SetFlush does.
+func (c *Checkpoints) SetFlush(flush func() error) {
+	c.mu.Lock()
+	c.flush = flush
+	c.mu.Unlock()
+}
+
+// Sync saves the checkpoint if it changed since the last call. The file is
+// replaced atomically, so a crash leaves the previous checkpoint whole.
+func (c *Checkpoints) Sync() error {
+	c.mu.Lock()
+	cp := c.cp
+	cp.Done = slices.Clone(c.cp.Done)
+	cp.Offset = 0
+	if cp.File != "" {
+		cp.Offset = c.offset.Load()
+	}
+	cp.Lines = c.resume.Lines + metrics.LinesRead.Load()
+	cp.EventsSent = c.resume.EventsSent + metrics.EventsSent.Load()
+	unchanged := cp.File == c.saved.File && cp.Offset == c.saved.Offset && len(cp.Done) == len(c.saved.Done) &&
+		cp.EventsSent == c.saved.EventsSent && cp.Lines == c.saved.Lines
+	flush := c.flush
+	c.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	cp.Time = time.Now().UnixMilli()
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("marshal checkpoint: %w", err)
+	}
+	if flush != nil {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	if err := writeFileSync(c.path, data); err != nil {
+		return err
+	}
+	cp.Time = 0
+	c.mu.Lock()
+	c.saved = cp
+	c.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	line := func(i int) string {
+		return fmt.Sprintf("%d.000 \"GET /%d HTTP/1.1\" 200 512 \"GPTBot/1.0\" 203.0.113.7 en 0.010 example.com gptbot\n", 1_700_000_000+i, i)
+	}
+	rotated, live := filepath.Join(dir, "access.log.1"), filepath.Join(dir, "access.log")
+	f, err := os.Create(rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte(line(0) + line(1) + line(2)))
+	zw.Close()
+	f.Close()
+	if err := os.WriteFile(live, []byte(line(3)+line(4)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(rotated, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.Format = "nginx"
+	cfg.LogFiles = []string{filepath.Join(dir, "access.log*")}
+	cfg.CheckpointFile = filepath.Join(dir, "replay.json")
+	cfg.Resume = true
+	replay := func() (lines int, paths []string) {
+		t.Helper()
+		api := &fakeAPI{}
+		sender := NewSender(context.Background(), api, cfg, nil)
+		p, err := NewPipeline(cfg, NewFanout(sender, nil, 0), nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReplay(cfg, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines, _, err = r.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sender.Close(5 * time.Second)
+		if err := r.checkpoints.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range api.received {
+			paths = append(paths, e.Path)
+		}
+		slices.Sort(paths)
+		return lines, paths
+	}
+
+	// A crash after the first line of the rotated file.
+	data, _ := json.Marshal(Checkpoint{File: rotated, Size: fileSize(t, rotated), Offset: int64(len(line(0)))})
+	if err := os.WriteFile(cfg.CheckpointFile, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lines, paths := replay()
+	if want := []string{"/1", "/2", "/3", "/4"}; lines != 4 || !slices.Equal(paths, want) {
+		t.Errorf("resumed replay read %d lines and sent %v, want %v", lines, paths, want)
+	}
+	var cp Checkpoint
+	data, _ = os.ReadFile(cfg.CheckpointFile)
+	if err := json.Unmarshal(data, &cp); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cp.Done, []string{rotated, live}) || cp.File != "" || cp.Time == 0 {
+		t.Errorf("checkpoint after the replay = %+v, want both files done", cp)
+	}
+
+	// Resuming a finished replay reads nothing; starting over reads it all.
+	if lines, _ := replay(); lines != 0 {
+		t.Errorf("resuming a finished replay read %d lines", lines)
+	}
+	cfg.Resume = false
+	if lines, paths := replay(); lines != 5 || len(paths) != 5 {
+		t.Errorf("replay without -resume read %d lines and sent %v, want all 5", lines, paths)
+	}
+
+	// A file that changed since is read from the start.
+	cfg.Resume = true
+	data, _ = json.Marshal(Checkpoint{Done: []string{rotated}, File: live, Size: 1, Offset: int64(len(line(3)))})
+	if err := os.WriteFile(cfg.CheckpointFile, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, paths := replay(); strings.Join(paths, ",") != "/3,/4" {
+		t.Errorf("after a change, sent %v, want /3 and /4", paths)
+	}
+}
+
+func fileSize(t *testing.T, file string) int64 {
+	t.Helper()
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
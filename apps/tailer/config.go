@@ -0,0 +1,843 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// iisLogFiles is where IIS writes the W3C logs of its sites by default,
+// a file a day.
+const iisLogFiles = `C:\inetpub\logs\LogFiles\W3SVC*\u_ex*.log`
+
+// Config holds every setting, from flags and the -config file.
+type Config struct {
+	LogFiles         []string
+	SyslogAddrs      []string
+	Input            string
+	Units            []string
+	Format           string
+	MaxLineBytes     int
+	JSONMap          string
+	LineFormat       string
+	LTSVMap          string
+	TSVColumns       string
+	CrawlersFile     string
+	BuiltinCrawlers  bool
+	SignaturesEvery  time.Duration
+	SignaturesCache  string
+	VerifyBots       bool
+	RobotsFile       string
+	RobotsFetch      bool
+	RobotsTTL        time.Duration
+	IncludePaths     []string
+	ExcludePaths     []string
+	ExcludeExts      string
+	Statuses         string
+	OnlyCrawlers     bool
+	Families         string
+	NormalizeHosts   bool
+	DefaultHost      string
+	Hosts            string
+	ExcludeHosts     string
+	EmptyHost        string
+	DebugFilters     bool
+	Sample           string
+	Endpoint         string
+	Transport        string
+	GRPCAuth         string
+	Sinks            []string
+	KafkaBrokers     string
+	KafkaTopic       string
+	KafkaAcks        string
+	KafkaCompress    string
+	KafkaTimeout     time.Duration
+	OutDir           string
+	OutFileBytes     int64
+	OutFileAge       time.Duration
+	OutMaxBytes      int64
+	APIKey           string
+	Secret           string
+	KeyFile          string
+	SecretFile       string
+	BatchSize        int
+	BatchInterval    time.Duration
+	MaxRetries       int
+	RetryBase        time.Duration
+	BreakerAfter     int
+	BreakerCooldown  time.Duration
+	MaxRPS           float64
+	Burst            int
+	QueueSize        int
+	Workers          int
+	Compress         string
+	SignUncompressed bool
+	SigVersion       int
+	SignAlg          string
+	PrivateKeyFile   string
+	Properties       []Property
+	Bursts           []BurstRule
+	BurstWindow      time.Duration
+	BurstAlerts      string
+	Mirrors          []Mirror
+	MirrorSample     float64
+	Fallbacks        []string
+	FailoverAfter    int
+	FailoverProbe    time.Duration
+	UnmatchedHost    string
+	TLSCert          string
+	TLSKey           string
+	TLSCA            string
+	TLSInsecure      bool
+	Proxy            string
+	Headers          []string
+	HTTPTimeout      time.Duration
+	MaxIdleConns     int
+	IdleConnTimeout  time.Duration
+	DisableKeepAlive bool
+	Stream           bool
+	StreamDuration   time.Duration
+	StreamMaxBytes   int
+	StreamTimeout    time.Duration
+	Backpressure     string
+	PositionFile     string
+	FromBeginning    bool
+	CatchUp          bool
+	StallTimeout     time.Duration
+	WatchMode        string
+	PollInterval     time.Duration
+	Once             bool
+	DryRun           bool
+	RejectsFile      string
+	RejectsMaxBytes  int64
+	ProgressEvery    int
+	ProgressInterval time.Duration
+	CheckpointFile   string
+	Resume           bool
+	MaxFailureRate   float64
+	IPv4Prefix       int
+	IPv6Prefix       int
+	GeoIPDB          string
+	ASNDB            string
+	BotRangesDir     string
+	TrustProxy       bool
+	TrustedProxies   string
+	SpoolDir         string
+	SpoolMaxBytes    int64
+	QueueBackend     string
+	QueuePath        string
+	QueueMaxBytes    int64
+	ConfigFile       string
+	MetricsAddr      string
+	HealthAddr       string
+	DebugAddr        string
+	OTelEndpoint     string
+	OTelProtocol     string
+	OTelInterval     time.Duration
+	StatsdAddr       string
+	StatsdInterval   time.Duration
+	ReadyMaxOutage   time.Duration
+	ReadyQueueHigh   float64
+	NoReadyCheck     bool
+	StatsInterval    time.Duration
+	HeartbeatEvery   time.Duration
+	NoAgentMeta      bool
+	InstanceIDFile   string
+	EventIDMode      string
+	UAMode           string
+	UASalt           string
+	UASaltFile       string
+	NormalizePaths   bool
+	PathNFC          bool
+	PathMode         string
+	PathHashDepth    int
+	PathSalt         string
+	PathSaltFile     string
+	KeepParams       string
+	ScrubParams      string
+	DedupWindow      time.Duration
+	DedupMaxKeys     int
+	MaxEventAge      time.Duration
+	Since            time.Time
+	Until            time.Time
+	Mode             string
+	AggWindow        time.Duration
+	AggGrace         time.Duration
+	AggPathDepth     int
+	ShutdownWait     time.Duration
+	LogLevel         string
+	LogFormat        string
+	LogFile          string
+}
+
+// stringList is a flag.Value collecting every occurrence of a flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// envRefRe matches ${NAME} references expanded in config file values.
+var envRefRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// configOnlyFlags are flags that make no sense inside a config file.
+var configOnlyFlags = []string{"config", "check-config", "version"}
+
+// errVersion is returned by parseConfig after -version printed the
+// version, like flag.ErrHelp after -help printed the usage.
+var errVersion = errors.New("version requested")
+
+// parseConfig builds the configuration from command line arguments and
+// the -config file they name, and validates it. It is called again on
+// SIGHUP to pick up changes to the file. checkConfig reports whether
+// -check-config was given.
+func parseConfig(args []string) (cfg Config, checkConfig bool, err error) {
+	return parseConfigWith("trace-tailer", args, nil)
+}
+
+// parseConfigWith is parseConfig for a subcommand: the flag set is named
+// name, and define, unless nil, adds the subcommand's own flags to it.
+func parseConfigWith(name string, args []string, define func(fs *flag.FlagSet)) (cfg Config, checkConfig bool, err error) {
+	var stdin, showVersion bool
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.ConfigFile, "config", "", "YAML file setting any of these flags by name; flags given on the command line take precedence")
+	fs.BoolVar(&checkConfig, "check-config", false, "Validate the configuration and exit without tailing")
+	fs.BoolVar(&showVersion, "version", false, "Print the version and exit")
+	fs.Var((*stringList)(&cfg.LogFiles), "file", "Log file to tail; may be repeated and may be a glob, or - for stdin (default /var/log/nginx/peac.log, or the IIS logs with -format iis)")
+	fs.Var((*stringList)(&cfg.SyslogAddrs), "listen-syslog", "Receive log lines as syslog messages on this udp:// or tcp:// address, e.g. udp://0.0.0.0:5514; may be repeated")
+	fs.StringVar(&cfg.Input, "input", "file", "Where log lines come from: file, or journald for the systemd journal of the -unit units")
+	fs.Var((*stringList)(&cfg.Units), "unit", "Systemd unit whose journal -input journald reads, e.g. nginx.service; may be repeated")
+	fs.BoolVar(&stdin, "stdin", false, "Read log lines from standard input until EOF (same as -file -)")
+	fs.StringVar(&cfg.Format, "format", "nginx", "Log format: nginx, apache-combined, json, ltsv, tsv, caddy, traefik, alb, cloudfront, haproxy, envoy, iis (W3C), or ndjson (files of -sink file)")
+	fs.IntVar(&cfg.MaxLineBytes, "max-line-bytes", 16<<10, "Skip log lines longer than this, unparsed; 0 for no limit")
+	fs.StringVar(&cfg.LTSVMap, "ltsv-map", "", "Overrides for -format ltsv labels, e.g. host=domain,ua=agent")
+	fs.StringVar(&cfg.TSVColumns, "tsv-columns", "", "The event field of each -format tsv column, e.g. time,method,path,status,ua,ip,host")
+	fs.StringVar(&cfg.LineFormat, "line-format", "", "The nginx log_format string the logs are written with, parsed instead of a -format")
+	fs.StringVar(&cfg.JSONMap, "json-map", "", "Overrides for -format json key names, e.g. status=status,ua=http_user_agent")
+	fs.StringVar(&cfg.CrawlersFile, "crawlers-file", "", "JSON file of crawler rules (family plus match or regex), checked before the built-in table")
+	fs.BoolVar(&cfg.BuiltinCrawlers, "builtin-crawlers-only", false, "Classify with the built-in table and -crawlers-file only, never with signatures downloaded from the API")
+	fs.DurationVar(&cfg.SignaturesEvery, "signatures-refresh", time.Hour, "How often to download the crawler signature list from the API")
+	fs.StringVar(&cfg.SignaturesCache, "signatures-cache", "", "File keeping the last downloaded signature list, used at startup until the API answers, e.g. /var/lib/trace-tailer/signatures.json")
+	fs.BoolVar(&cfg.VerifyBots, "verify-bots", false, "Confirm known crawlers by reverse and forward DNS of the client address")
+	fs.StringVar(&cfg.RobotsFile, "robots-file", "", "robots.txt to check requests against, for every host, marking those it disallows")
+	fs.BoolVar(&cfg.RobotsFetch, "robots-fetch", false, "Check requests against the robots.txt of their host, fetched over HTTPS for the hosts of -hosts and the properties, marking those it disallows")
+	fs.DurationVar(&cfg.RobotsTTL, "robots-ttl", time.Hour, "How long a robots.txt fetched with -robots-fetch is used before fetching it again")
+	fs.Var((*stringList)(&cfg.IncludePaths), "include-path", "Only send events whose path matches this regex; may be repeated")
+	fs.Var((*stringList)(&cfg.ExcludePaths), "exclude-path", "Never send events whose path matches this regex; may be repeated, and wins over -include-path")
+	fs.StringVar(&cfg.ExcludeExts, "exclude-extensions", defaultExcludeExts, "Never send events for paths whose last segment has one of these extensions, in any case (\"\" sends them all)")
+	fs.StringVar(&cfg.Statuses, "statuses", "", "Only send events with these status codes or classes, e.g. 2xx,3xx,429 (default all)")
+	fs.BoolVar(&cfg.OnlyCrawlers, "only-crawlers", false, "Only send events from a known crawler family")
+	fs.BoolVar(&cfg.NormalizeHosts, "normalize-hosts", true, "Lower-case hosts, strip ports and trailing dots, convert IDNs to punycode, and treat IP addresses and malformed names as invalid")
+	fs.StringVar(&cfg.DefaultHost, "default-host", "", "Host to send events with an invalid host under (default drop them)")
+	fs.StringVar(&cfg.Hosts, "hosts", "", "Only send events for these hosts, e.g. example.com,*.example.org (ports and case are ignored)")
+	fs.StringVar(&cfg.ExcludeHosts, "exclude-hosts", "", "Never send events for these hosts; wins over -hosts")
+	fs.StringVar(&cfg.EmptyHost, "empty-host", "send", "Events without a host, from formats that don't log one: send or drop")
+	fs.BoolVar(&cfg.DebugFilters, "debug-filters", false, "Append the lines of events a filter dropped, with the reason, to -rejects-file")
+	fs.StringVar(&cfg.Families, "families", "", "Only send events from these crawler families, e.g. gptbot,claudebot (implies -only-crawlers)")
+	fs.StringVar(&cfg.Sample, "sample", "", "Fraction of events to send per crawler family, e.g. bytespider=0.1,default=1 (default all)")
+	cfg.Endpoint = "http://localhost:8787"
+	fs.Var(&endpointFlag{cfg: &cfg}, "endpoint", "Originary Trace API endpoint, or unix:///path/to.sock for a local socket; repeat to mirror events to further endpoints")
+	fs.StringVar(&cfg.Transport, "transport", "http", "How events reach -endpoint: http (the JSON API) or grpc (the Ingest RPC, with -endpoint a gRPC target such as dns:///ingest.internal:443)")
+	fs.StringVar(&cfg.GRPCAuth, "grpc-auth", "hmac", "How -transport grpc calls authenticate: hmac (signed with the secret) or bearer (the secret as a bearer token)")
+	fs.Var((*stringList)(&cfg.Sinks), "sink", "Where events go: http (the ingest API), kafka or file; repeat to send every event to several, the first taking -spool-dir and -backpressure (default http)")
+	fs.StringVar(&cfg.KafkaBrokers, "kafka-brokers", "", "Comma-separated Kafka brokers for -sink kafka, e.g. kafka1:9092,kafka2:9092")
+	fs.StringVar(&cfg.KafkaTopic, "kafka-topic", "crawl-events", "Kafka topic for -sink kafka; messages are the event JSON keyed by host")
+	fs.StringVar(&cfg.KafkaAcks, "kafka-acks", "all", "Acknowledgements -sink kafka waits for: all (in-sync replicas), leader or none")
+	fs.StringVar(&cfg.KafkaCompress, "kafka-compression", "none", "Compression of -sink kafka batches: none, gzip, snappy, lz4 or zstd")
+	fs.DurationVar(&cfg.KafkaTimeout, "kafka-timeout", 10*time.Second, "Time limit for producing one batch to -sink kafka")
+	fs.StringVar(&cfg.OutDir, "out-dir", "", "Directory -sink file writes gzipped NDJSON files to, for replay elsewhere with -once -format ndjson")
+	fs.Int64Var(&cfg.OutFileBytes, "out-file-bytes", 16<<20, "Compressed size at which -sink file starts a new file")
+	fs.DurationVar(&cfg.OutFileAge, "out-file-age", 10*time.Minute, "Longest -sink file keeps a file open before starting a new one")
+	fs.Int64Var(&cfg.OutMaxBytes, "out-max-bytes", 1<<30, "Maximum size of the files in -out-dir; the oldest are deleted beyond it")
+	fs.Var((*stringList)(&cfg.Fallbacks), "failover-endpoint", "Endpoint to fail over to when the primary keeps failing; may be repeated, tried in order, with the same credentials")
+	fs.IntVar(&cfg.FailoverAfter, "failover-threshold", 3, "Consecutive failed requests (network errors or 5xx) before failing over to the next endpoint")
+	fs.DurationVar(&cfg.FailoverProbe, "failover-probe-interval", time.Minute, "After failing over, how often to retry the primary endpoint to fail back")
+	fs.Float64Var(&cfg.MirrorSample, "mirror-sample", 1, "Fraction of events also sent to mirror endpoints")
+	fs.StringVar(&cfg.APIKey, "key", "", "Originary Trace API key ID")
+	fs.StringVar(&cfg.Secret, "secret", "", "Originary Trace HMAC secret (prefer -secret-file or TRACE_HMAC_SECRET)")
+	fs.StringVar(&cfg.KeyFile, "key-file", "", "File containing the API key ID")
+	fs.StringVar(&cfg.SecretFile, "secret-file", "", "File containing the HMAC secret, re-read on SIGHUP")
+	fs.IntVar(&cfg.BatchSize, "batch-size", 100, "Maximum events per request (1 disables batching)")
+	fs.DurationVar(&cfg.BatchInterval, "batch-interval", 2*time.Second, "Maximum time an event waits in a partial batch")
+	fs.IntVar(&cfg.MaxRetries, "max-retries", 5, "Retries after a failed send before events are dropped")
+	fs.DurationVar(&cfg.RetryBase, "retry-base", 500*time.Millisecond, "Initial retry delay, doubled on each attempt")
+	fs.IntVar(&cfg.BreakerAfter, "breaker-threshold", 5, "Consecutive failed requests (network errors or 5xx) that open the circuit breaker (0 disables it)")
+	fs.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", 30*time.Second, "How long an open circuit fails sends at once before a probe request")
+	fs.Float64Var(&cfg.MaxRPS, "max-rps", 0, "Maximum requests per second to the endpoint (0 means unlimited)")
+	fs.IntVar(&cfg.Burst, "burst", 10, "Requests allowed in a burst above -max-rps")
+	fs.IntVar(&cfg.QueueSize, "queue-size", 10000, "Events buffered in memory while the endpoint is slow or throttling")
+	fs.IntVar(&cfg.Workers, "workers", 4, "Concurrent requests to the endpoint; above 1, events may arrive out of order")
+	fs.StringVar(&cfg.Backpressure, "backpressure", "drop-newest", "What gives way when the queue is full: drop-newest, drop-oldest or block (overflowing events go to -spool-dir if set)")
+	fs.StringVar(&cfg.Compress, "compress", "", "Compress request bodies: gzip, or empty for none (small bodies are always sent as is)")
+	fs.BoolVar(&cfg.SignUncompressed, "sign-uncompressed", false, "Sign the body before compression rather than the bytes sent; must match the API")
+	fs.StringVar(&cfg.SignAlg, "sign-alg", "hmac", "Request signing algorithm: hmac (with the secret) or ed25519 (with -private-key-file)")
+	fs.StringVar(&cfg.PrivateKeyFile, "private-key-file", "", "Ed25519 private key for -sign-alg ed25519: PEM (PKCS #8) or a base64 seed")
+	fs.StringVar(&cfg.UnmatchedHost, "unmatched-host", "default", "Events whose host matches none of the config file's properties: default (send with -key) or drop")
+	fs.IntVar(&cfg.SigVersion, "sig-version", 1, "Request signing scheme: 1 signs the body, 2 also covers the method, path and timestamp; must match the API")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "PEM client certificate for endpoints requiring mutual TLS, reloaded when it changes (needs -tls-key)")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "PEM private key for -tls-cert")
+	fs.StringVar(&cfg.TLSCA, "tls-ca", "", "PEM CA certificates to trust for the endpoint, in addition to the system roots")
+	fs.BoolVar(&cfg.TLSInsecure, "tls-insecure-skip-verify", false, "Don't verify the endpoint's certificate; for lab setups only")
+	fs.Var((*stringList)(&cfg.Headers), "header", "Extra header for every request to the endpoint, as \"Name: value\" with ${NAME} expanded from the environment; may be repeated")
+	fs.StringVar(&cfg.Proxy, "proxy", "", "Proxy for requests to the endpoint, e.g. socks5://host:1080 or http://host:3128 (default from HTTPS_PROXY/HTTP_PROXY)")
+	fs.DurationVar(&cfg.HTTPTimeout, "http-timeout", client.DefaultTimeout, "Time limit for one request to the endpoint, including reading the response")
+	fs.IntVar(&cfg.MaxIdleConns, "max-idle-conns", http.DefaultMaxIdleConnsPerHost, "Idle connections kept open to the endpoint; raise towards -workers for high throughput")
+	fs.DurationVar(&cfg.IdleConnTimeout, "idle-conn-timeout", 90*time.Second, "How long an idle connection to the endpoint is kept open")
+	fs.BoolVar(&cfg.DisableKeepAlive, "disable-keepalive", false, "Open a new connection for every request")
+	fs.BoolVar(&cfg.Stream, "stream", false, "Stream events as NDJSON over one long-lived request to /v1/events/stream, falling back to batches when that fails")
+	fs.DurationVar(&cfg.StreamDuration, "stream-max-duration", client.DefaultStreamMaxDuration, "How long one -stream request stays open before a new one is started")
+	fs.IntVar(&cfg.StreamMaxBytes, "stream-max-bytes", client.DefaultStreamMaxBytes, "Bytes of events one -stream request carries before a new one is started; also what is held in memory to resend")
+	fs.DurationVar(&cfg.StreamTimeout, "stream-write-timeout", client.DefaultStreamWriteTimeout, "How long a -stream write may wait for the endpoint to read before falling back to batches")
+	fs.StringVar(&cfg.PositionFile, "position-file", "", "File recording read positions so restarts resume where they stopped")
+	fs.BoolVar(&cfg.FromBeginning, "from-beginning", false, "Read the log file from the start, ignoring the position file")
+	fs.BoolVar(&cfg.CatchUp, "catch-up", false, "Before tailing, read what rotated copies of each -file (.1, .2.gz, dateext) hold beyond the saved position")
+	fs.StringVar(&cfg.WatchMode, "watch-mode", "poll", "How to notice log file changes: poll, which works on any filesystem, or inotify, which is cheaper but misses changes on NFS")
+	fs.DurationVar(&cfg.PollInterval, "poll-interval", 250*time.Millisecond, "How often -watch-mode poll checks log files for changes")
+	fs.DurationVar(&cfg.StallTimeout, "stall-timeout", 5*time.Minute, "Reopen a log file when no line has been read from it for this long although it was written to, replaced or truncated; 0 disables")
+	fs.BoolVar(&cfg.Once, "once", false, "Replay the log files (gzipped or not) from the start to EOF, then exit; for backfills")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "Write events to standard output as NDJSON instead of sending them; no key or secret needed")
+	fs.StringVar(&cfg.RejectsFile, "rejects-file", "", "Append lines that fail to parse, with the reason, to this file")
+	fs.Int64Var(&cfg.RejectsMaxBytes, "rejects-max-bytes", 10<<20, "Size at which -rejects-file is rotated to a single .1 backup")
+	fs.IntVar(&cfg.ProgressEvery, "progress-every", 100000, "With -once or standard input, log progress every this many lines (0 disables)")
+	fs.DurationVar(&cfg.ProgressInterval, "progress-interval", 10*time.Second, "With -once, log the share of bytes read, the event rate and the time left this often (0 disables)")
+	fs.StringVar(&cfg.CheckpointFile, "checkpoint-file", "", "With -once, save how far the replay got to this file every few seconds, for -resume")
+	fs.BoolVar(&cfg.Resume, "resume", false, "With -once and -checkpoint-file, continue the replay from the saved checkpoint instead of starting over")
+	fs.Float64Var(&cfg.MaxFailureRate, "max-failure-rate", 0.01, "With -once, exit non-zero if more than this fraction of lines fail to parse or send")
+	fs.IntVar(&cfg.IPv4Prefix, "ipv4-prefix", 24, "Prefix length IPv4 client addresses are truncated to (8-32, 0 drops the address)")
+	fs.IntVar(&cfg.IPv6Prefix, "ipv6-prefix", 48, "Prefix length IPv6 client addresses are truncated to (16-64, 0 drops the address)")
+	fs.StringVar(&cfg.GeoIPDB, "geoip-db", "", "MaxMind country database, e.g. GeoLite2-Country.mmdb, to add the client's country to events; reloaded when it changes")
+	fs.StringVar(&cfg.ASNDB, "asn-db", "", "MaxMind ASN database, e.g. GeoLite2-ASN.mmdb, to add the client's autonomous system to events; reloaded when it changes")
+	fs.StringVar(&cfg.BotRangesDir, "bot-ranges-dir", "", "Directory of published crawler address ranges, one <family>.json each, to check client addresses against; reloaded when they change")
+	fs.BoolVar(&cfg.TrustProxy, "trust-proxy", false, "Take the client address from the logged X-Forwarded-For header, for nginx behind a load balancer")
+	fs.StringVar(&cfg.TrustedProxies, "trusted-proxies", "", "With -trust-proxy, CIDRs of further proxies to skip in X-Forwarded-For, e.g. 10.0.0.0/8,172.16.0.0/12")
+	fs.StringVar(&cfg.SpoolDir, "spool-dir", "", "Directory for spooling events while the endpoint is unreachable")
+	fs.Int64Var(&cfg.SpoolMaxBytes, "spool-max-bytes", 100<<20, "Maximum size of the spool; oldest events are dropped beyond it")
+	fs.StringVar(&cfg.QueueBackend, "queue-backend", "memory", "Where events wait for delivery: memory, or bolt to keep them in -queue-path until the API accepts them, across crashes")
+	fs.StringVar(&cfg.QueuePath, "queue-path", "", "BoltDB file for -queue-backend bolt, e.g. /var/lib/trace-tailer/queue.db")
+	fs.Int64Var(&cfg.QueueMaxBytes, "queue-max-bytes", 256<<20, "Maximum size of the events in -queue-path; oldest events are dropped beyond it")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address (e.g. :9464); disabled if empty")
+	fs.StringVar(&cfg.HealthAddr, "health-addr", "", "Serve /healthz and /readyz on this address (e.g. :8080); disabled if empty")
+	fs.StringVar(&cfg.DebugAddr, "debug-addr", "", "Serve pprof profiles and /debug/vars on this address, localhost unless it names a host (e.g. 127.0.0.1:6060); disabled if empty")
+	fs.StringVar(&cfg.OTelEndpoint, "otel-endpoint", "", "Export the tailer's own metrics and send spans over OTLP to this collector URL (e.g. http://localhost:4317); disabled if empty")
+	fs.StringVar(&cfg.OTelProtocol, "otel-protocol", "grpc", "OTLP protocol for -otel-endpoint: grpc or http")
+	fs.DurationVar(&cfg.OTelInterval, "otel-interval", time.Minute, "How often to export metrics to -otel-endpoint")
+	fs.StringVar(&cfg.StatsdAddr, "statsd-addr", "", "Send metrics in DogStatsD format over UDP to this address (e.g. 127.0.0.1:8125); disabled if empty")
+	fs.DurationVar(&cfg.StatsdInterval, "statsd-interval", 10*time.Second, "How often to send metrics to -statsd-addr")
+	fs.DurationVar(&cfg.ReadyMaxOutage, "ready-max-outage", 0, "Report not ready once the API has been failing for this long (0: API outages never affect readiness)")
+	fs.Float64Var(&cfg.ReadyQueueHigh, "ready-queue-high", 0.9, "Report not ready while the queue is at least this fraction of -queue-size full")
+	fs.BoolVar(&cfg.NoReadyCheck, "no-ready-check", false, "Under systemd with Type=notify, report ready once the tails are open, without first checking that -endpoint answers")
+	fs.DurationVar(&cfg.StatsInterval, "stats-interval", time.Minute, "How often to log a summary of activity since the previous one, and at shutdown (0 disables)")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "Log level: debug, info, warn or error (debug shows the contents of failing lines)")
+	fs.StringVar(&cfg.LogFormat, "log-format", "text", "Log format: text or json")
+	fs.StringVar(&cfg.LogFile, "log-file", "", "Append logs to this file instead of writing them to standard error")
+	fs.DurationVar(&cfg.HeartbeatEvery, "heartbeat-interval", time.Minute, "How often to tell the API the tailer is alive, with recent counters (0 disables)")
+	fs.BoolVar(&cfg.NoAgentMeta, "no-agent-meta", false, "Don't add agent_host, agent_version and instance_id to events")
+	fs.StringVar(&cfg.InstanceIDFile, "instance-id-file", "", "File holding this tailer's instance ID, created if missing (default a new ID per run)")
+	fs.StringVar(&cfg.EventIDMode, "event-id-mode", "uuid", "How events get the event_id the API deduplicates on: uuid (random, time-ordered), hash (of ts, host, path, ip_prefix and ua) or off")
+	fs.StringVar(&cfg.UAMode, "ua-mode", "raw", "What events carry of the User-Agent: raw, hash (a salted SHA-256, by -ua-salt) or family-only (none, only the crawler family)")
+	fs.StringVar(&cfg.UASalt, "ua-salt", "", "Salt for -ua-mode hash (prefer -ua-salt-file or TRACE_UA_SALT)")
+	fs.StringVar(&cfg.UASaltFile, "ua-salt-file", "", "File containing the salt for -ua-mode hash")
+	fs.BoolVar(&cfg.NormalizePaths, "normalize-paths", false, "Put paths in one canonical form: decode escapes of unreserved characters, upper-case the others, escape non-ASCII bytes, and resolve // and . and .. segments")
+	fs.BoolVar(&cfg.PathNFC, "path-nfc", false, "With -normalize-paths, also put non-ASCII characters in Unicode NFC form")
+	fs.StringVar(&cfg.PathMode, "path-mode", "raw", "How paths are sent: raw, truncate:N (the first N segments) or hash-segments (segments that look like IDs replaced by a salted hash)")
+	fs.IntVar(&cfg.PathHashDepth, "path-hash-depth", 1, "With -path-mode hash-segments, how many leading segments are never hashed")
+	fs.StringVar(&cfg.PathSalt, "path-salt", "", "Salt for -path-mode hash-segments (prefer -path-salt-file or TRACE_PATH_SALT)")
+	fs.StringVar(&cfg.PathSaltFile, "path-salt-file", "", "File containing the salt for -path-mode hash-segments")
+	fs.StringVar(&cfg.KeepParams, "keep-params", "", "Query parameters to keep in paths, e.g. page,lang or *; the query string is dropped otherwise")
+	fs.StringVar(&cfg.ScrubParams, "scrub-params", "", "Query parameters to drop even when -keep-params matches them, e.g. utm_*,token,email")
+	fs.DurationVar(&cfg.BurstWindow, "burst-window", 10*time.Minute, "Window over which the config file's bursts thresholds are counted")
+	fs.StringVar(&cfg.BurstAlerts, "burst-alerts", "log", "Where bursts are reported: log (a warning) or api (also POST /v1/alerts)")
+	fs.DurationVar(&cfg.DedupWindow, "dedup-window", 0, "Drop events repeating one seen within this time, e.g. 5s for mirrored logs (0 disables)")
+	fs.IntVar(&cfg.DedupMaxKeys, "dedup-max-keys", 100000, "Recent events remembered for -dedup-window")
+	fs.DurationVar(&cfg.MaxEventAge, "max-event-age", 48*time.Hour, "Skip events whose line is older than this, e.g. after a long outage (0 disables; default none with -once and -stdin)")
+	fs.Var(timeFlag{&cfg.Since}, "since", "With -once or -stdin, skip lines logged before this time: RFC 3339, a date such as 2024-06-01 (UTC), or relative such as -72h")
+	fs.Var(timeFlag{&cfg.Until}, "until", "With -once or -stdin, skip lines logged at or after this time, in the same forms as -since")
+	fs.StringVar(&cfg.Mode, "mode", "raw", "What is sent: raw (every event) or aggregate (per-window counts by host, crawler family, status class and path prefix, to /v1/rollups)")
+	fs.DurationVar(&cfg.AggWindow, "agg-window", time.Minute, "With -mode aggregate, the window counts are sent for, aligned to the clock")
+	fs.DurationVar(&cfg.AggGrace, "agg-grace", 15*time.Second, "With -mode aggregate, how long after a window ends lines for it are still counted")
+	fs.IntVar(&cfg.AggPathDepth, "agg-path-depth", 2, "With -mode aggregate, the leading path segments counts are kept by")
+	fs.DurationVar(&cfg.ShutdownWait, "shutdown-timeout", 10*time.Second, "How long to wait for queued events to be delivered on shutdown")
+	if define != nil {
+		define(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+	if showVersion {
+		fmt.Printf("trace-tailer %s\n", version)
+		return Config{}, false, errVersion
+	}
+
+	if cfg.ConfigFile != "" {
+		set := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if err := loadConfigFile(cfg.ConfigFile, fs, set); err != nil {
+			return Config{}, false, err
+		}
+		lists, err := loadConfigLists(cfg.ConfigFile)
+		if err != nil {
+			return Config{}, false, err
+		}
+		cfg.Properties = lists.Properties
+		cfg.Bursts = lists.Bursts
+		cfg.addMirrors(lists.Mirrors)
+	}
+	if len(cfg.Sinks) == 0 {
+		cfg.Sinks = []string{"http"}
+	}
+	if stdin {
+		cfg.LogFiles = append(cfg.LogFiles, "-")
+	}
+	if len(cfg.LogFiles) == 0 && len(cfg.SyslogAddrs) == 0 && cfg.Input == "file" {
+		cfg.LogFiles = []string{"/var/log/nginx/peac.log"}
+		if cfg.Format == "iis" {
+			cfg.LogFiles = []string{iisLogFiles}
+		}
+	}
+	if cfg.Once {
+		// A replay reads much faster than it can send; dropping events
+		// because the queue is full would defeat the point.
+		cfg.Backpressure = "block"
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	// Backfilling old lines is what -once and -stdin (or -file -) are for.
+	if (cfg.Once || slices.Contains(cfg.LogFiles, "-")) && !set["max-event-age"] {
+		cfg.MaxEventAge = 0
+	}
+	// Lines read again after resuming must get the IDs they were sent
+	// with, for the API to drop them.
+	if cfg.CheckpointFile != "" && !set["event-id-mode"] {
+		cfg.EventIDMode = "hash"
+	}
+	if cfg.DryRun {
+		// One worker keeps the output in log order.
+		cfg.Workers = 1
+	}
+	if err := cfg.validate(); err != nil {
+		return Config{}, false, err
+	}
+	return cfg, checkConfig, nil
+}
+
+// validate checks settings that don't need any files opened.
+func (cfg Config) validate() error {
+	if slices.Contains(cfg.LogFiles, "-") && len(cfg.LogFiles) > 1 {
+		return errors.New("standard input cannot be combined with other -file values")
+	}
+	switch cfg.Input {
+	case "file":
+		if len(cfg.Units) > 0 {
+			return errors.New("-unit needs -input journald")
+		}
+	case "journald":
+		if len(cfg.Units) == 0 {
+			return errors.New("-input journald needs at least one -unit")
+		}
+		if len(cfg.LogFiles) > 0 {
+			return errors.New("-input journald cannot be combined with -file")
+		}
+		if cfg.Once {
+			return errors.New("-input journald cannot be combined with -once")
+		}
+	default:
+		return fmt.Errorf("unknown -input %q (want file or journald)", cfg.Input)
+	}
+	if cfg.CatchUp {
+		switch {
+		case cfg.PositionFile == "":
+			return errors.New("-catch-up needs -position-file")
+		case cfg.Once || cfg.FromBeginning:
+			return errors.New("-catch-up cannot be combined with -once or -from-beginning")
+		case slices.Contains(cfg.LogFiles, "-"):
+			return errors.New("-catch-up cannot be used with standard input")
+		}
+	}
+	if len(cfg.SyslogAddrs) > 0 && cfg.Once {
+		return errors.New("-listen-syslog cannot be combined with -once")
+	}
+	for _, addr := range cfg.SyslogAddrs {
+		if _, _, err := parseSyslogAddr(addr); err != nil {
+			return err
+		}
+	}
+	if err := validatePrefixLengths(cfg.IPv4Prefix, cfg.IPv6Prefix); err != nil {
+		return err
+	}
+	if _, err := NewTrustedProxies(cfg.TrustedProxies); err != nil {
+		return err
+	}
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	if cfg.LineFormat != "" && cfg.Format != "nginx" {
+		return fmt.Errorf("-line-format replaces -format; drop -format %s", cfg.Format)
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		return fmt.Errorf("unknown -log-format %q (want text or json)", cfg.LogFormat)
+	}
+	if cfg.DedupWindow < 0 || (cfg.DedupWindow > 0 && cfg.DedupMaxKeys < 1) {
+		return errors.New("-dedup-window must not be negative and -dedup-max-keys at least 1")
+	}
+	if cfg.BatchSize < 1 {
+		return errors.New("-batch-size must be at least 1")
+	}
+	if cfg.QueueSize < 1 {
+		return errors.New("-queue-size must be at least 1")
+	}
+	if cfg.DebugAddr != "" {
+		if _, err := debugListenAddr(cfg.DebugAddr); err != nil {
+			return err
+		}
+	}
+	if cfg.OTelEndpoint != "" {
+		if _, err := otelEndpointURL(cfg.OTelEndpoint); err != nil {
+			return err
+		}
+		if cfg.OTelProtocol != "grpc" && cfg.OTelProtocol != "http" {
+			return fmt.Errorf("unknown -otel-protocol %q (want grpc or http)", cfg.OTelProtocol)
+		}
+		if cfg.OTelInterval <= 0 {
+			return errors.New("-otel-interval must be positive")
+		}
+	}
+	if cfg.StatsdAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.StatsdAddr); err != nil {
+			return fmt.Errorf("invalid -statsd-addr %q: %w", cfg.StatsdAddr, err)
+		}
+		if cfg.StatsdInterval <= 0 {
+			return errors.New("-statsd-interval must be positive")
+		}
+	}
+	if cfg.BurstWindow < burstBuckets*time.Second {
+		return fmt.Errorf("-burst-window must be at least %ds", burstBuckets)
+	}
+	switch cfg.BurstAlerts {
+	case "log":
+	case "api":
+		if cfg.Transport != "http" {
+			return errors.New("-burst-alerts api needs -transport http")
+		}
+	default:
+		return fmt.Errorf("unknown -burst-alerts %q (want log or api)", cfg.BurstAlerts)
+	}
+	switch cfg.Mode {
+	case "raw":
+	case "aggregate":
+		if cfg.AggWindow < 10*time.Second || (24*time.Hour)%cfg.AggWindow != 0 {
+			return errors.New("-agg-window must be at least 10s and divide a day evenly")
+		}
+		if cfg.AggGrace < 0 || cfg.AggGrace >= cfg.AggWindow {
+			return errors.New("-agg-grace must not be negative and be shorter than -agg-window")
+		}
+		if cfg.AggPathDepth < 1 {
+			return errors.New("-agg-path-depth must be at least 1")
+		}
+		if cfg.Transport != "http" || len(cfg.Properties) > 0 || !slices.Equal(cfg.Sinks, []string{"http"}) {
+			return errors.New("-mode aggregate needs -transport http and -sink http, without properties")
+		}
+		if cfg.Sample != "" {
+			return errors.New("-sample can't be combined with -mode aggregate, which counts every event")
+		}
+	default:
+		return fmt.Errorf("unknown -mode %q (want raw or aggregate)", cfg.Mode)
+	}
+	if cfg.RobotsFile != "" && cfg.RobotsFetch {
+		return errors.New("-robots-file and -robots-fetch can't be combined")
+	}
+	if cfg.RobotsFetch && cfg.Hosts == "" && len(cfg.Properties) == 0 {
+		return errors.New("-robots-fetch needs -hosts or properties, naming the sites whose robots.txt may be fetched")
+	}
+	if cfg.RobotsFetch && cfg.RobotsTTL < time.Minute {
+		return errors.New("-robots-ttl must be at least 1m")
+	}
+	if !cfg.BuiltinCrawlers && cfg.SignaturesEvery < time.Minute {
+		return errors.New("-signatures-refresh must be at least 1m")
+	}
+	if cfg.ReadyMaxOutage < 0 {
+		return errors.New("-ready-max-outage must not be negative")
+	}
+	if cfg.ReadyQueueHigh <= 0 || cfg.ReadyQueueHigh > 1 {
+		return errors.New("-ready-queue-high must be more than 0 and at most 1")
+	}
+	if cfg.Compress != "" && cfg.Compress != "gzip" {
+		return fmt.Errorf("unknown -compress %q (want gzip)", cfg.Compress)
+	}
+	switch cfg.SignAlg {
+	case "hmac":
+		if cfg.PrivateKeyFile != "" {
+			return errors.New("-private-key-file needs -sign-alg ed25519")
+		}
+	case "ed25519":
+		if cfg.PrivateKeyFile == "" {
+			return errors.New("-sign-alg ed25519 needs -private-key-file")
+		}
+	default:
+		return fmt.Errorf("unknown -sign-alg %q (want hmac or ed25519)", cfg.SignAlg)
+	}
+	if len(cfg.Fallbacks) > 0 && (cfg.FailoverAfter < 1 || cfg.FailoverProbe <= 0) {
+		return errors.New("-failover-threshold must be at least 1 and -failover-probe-interval positive")
+	}
+	if cfg.MirrorSample < 0 || cfg.MirrorSample > 1 {
+		return fmt.Errorf("-mirror-sample must be between 0 and 1, got %g", cfg.MirrorSample)
+	}
+	if cfg.MaxEventAge < 0 {
+		return errors.New("-max-event-age must not be negative")
+	}
+	switch {
+	case cfg.CheckpointFile != "" && !cfg.Once:
+		return errors.New("-checkpoint-file needs -once")
+	case cfg.Resume && cfg.CheckpointFile == "":
+		return errors.New("-resume needs -checkpoint-file")
+	case cfg.ProgressInterval < 0:
+		return errors.New("-progress-interval must not be negative")
+	}
+	if !cfg.Since.IsZero() || !cfg.Until.IsZero() {
+		switch {
+		case !cfg.Once && !slices.Contains(cfg.LogFiles, "-"):
+			return errors.New("-since and -until need -once or -stdin")
+		case !cfg.Since.IsZero() && !cfg.Until.IsZero() && !cfg.Until.After(cfg.Since):
+			return errors.New("-until must be after -since")
+		}
+	}
+	if cfg.PathNFC && !cfg.NormalizePaths {
+		return errors.New("-path-nfc needs -normalize-paths")
+	}
+	if cfg.DefaultHost != "" {
+		if host, ok := normalizeHost(cfg.DefaultHost); !ok || host != cfg.DefaultHost {
+			return fmt.Errorf("-default-host %q is not a lower-case DNS name", cfg.DefaultHost)
+		}
+	}
+	if cfg.EmptyHost != "send" && cfg.EmptyHost != "drop" {
+		return fmt.Errorf("unknown -empty-host %q (want send or drop)", cfg.EmptyHost)
+	}
+	if cfg.DebugFilters && cfg.RejectsFile == "" {
+		return errors.New("-debug-filters needs -rejects-file")
+	}
+	switch cfg.UnmatchedHost {
+	case "default":
+	case "drop":
+		if len(cfg.Properties) == 0 {
+			return errors.New("-unmatched-host drop needs properties in the -config file")
+		}
+	default:
+		return fmt.Errorf("unknown -unmatched-host %q (want default or drop)", cfg.UnmatchedHost)
+	}
+	if cfg.SigVersion != 1 && cfg.SigVersion != 2 {
+		return fmt.Errorf("unknown -sig-version %d (want 1 or 2)", cfg.SigVersion)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if cfg.HTTPTimeout <= 0 {
+		return errors.New("-http-timeout must be positive")
+	}
+	if cfg.MaxIdleConns < 0 || cfg.IdleConnTimeout < 0 {
+		return errors.New("-max-idle-conns and -idle-conn-timeout must not be negative")
+	}
+	if err := validateSinks(cfg); err != nil {
+		return err
+	}
+	if _, err := parseHeaders(cfg.Headers); err != nil {
+		return err
+	}
+	switch cfg.Transport {
+	case "http":
+	case "grpc":
+		if len(cfg.Properties) > 0 || len(cfg.Fallbacks) > 0 || len(cfg.Mirrors) > 0 || cfg.Stream {
+			return errors.New("-transport grpc can't be combined with properties, mirrors, -failover-endpoint or -stream")
+		}
+		if cfg.SignAlg != "hmac" || cfg.SignUncompressed || cfg.Proxy != "" || len(cfg.Headers) > 0 {
+			return errors.New("-transport grpc doesn't support -sign-alg ed25519, -sign-uncompressed, -proxy or -header")
+		}
+		if cfg.GRPCAuth != "hmac" && cfg.GRPCAuth != "bearer" {
+			return fmt.Errorf("unknown -grpc-auth %q (want hmac or bearer)", cfg.GRPCAuth)
+		}
+	default:
+		return fmt.Errorf("unknown -transport %q (want http or grpc)", cfg.Transport)
+	}
+	if cfg.Stream {
+		if len(cfg.Properties) > 0 || len(cfg.Fallbacks) > 0 {
+			return errors.New("-stream can't be combined with properties or -failover-endpoint")
+		}
+		if cfg.StreamDuration <= 0 || cfg.StreamMaxBytes < 1 || cfg.StreamTimeout <= 0 {
+			return errors.New("-stream-max-duration, -stream-max-bytes and -stream-write-timeout must be positive")
+		}
+	}
+	if cfg.Workers < 1 {
+		return errors.New("-workers must be at least 1")
+	}
+	if cfg.MaxLineBytes < 0 {
+		return errors.New("-max-line-bytes must not be negative")
+	}
+	if cfg.WatchMode != "poll" && cfg.WatchMode != "inotify" {
+		return fmt.Errorf("unknown -watch-mode %q (want poll or inotify)", cfg.WatchMode)
+	}
+	if cfg.WatchMode == "inotify" && runtime.GOOS == "windows" {
+		return errors.New("-watch-mode inotify is Linux only; use poll")
+	}
+	if cfg.PollInterval <= 0 {
+		return errors.New("-poll-interval must be positive")
+	}
+	if cfg.StallTimeout != 0 && cfg.StallTimeout < time.Second {
+		return errors.New("-stall-timeout must be 0 or at least 1s")
+	}
+	switch cfg.Backpressure {
+	case "drop-newest", "drop-oldest", "block":
+	default:
+		return fmt.Errorf("unknown -backpressure %q (want drop-newest, drop-oldest or block)", cfg.Backpressure)
+	}
+	if _, _, err := parsePathMode(cfg.PathMode); err != nil {
+		return err
+	}
+	if cfg.PathHashDepth < 0 {
+		return errors.New("-path-hash-depth must not be negative")
+	}
+	if _, err := parseParamPatterns("-keep-params", cfg.KeepParams); err != nil {
+		return err
+	}
+	if _, err := parseParamPatterns("-scrub-params", cfg.ScrubParams); err != nil {
+		return err
+	}
+	if cfg.ScrubParams != "" && cfg.KeepParams == "" {
+		return errors.New("-scrub-params only narrows -keep-params, which is not set")
+	}
+	switch cfg.UAMode {
+	case "raw", "hash", "family-only":
+	default:
+		return fmt.Errorf("unknown -ua-mode %q (want raw, hash or family-only)", cfg.UAMode)
+	}
+	if _, err := eventIDFunc(cfg.EventIDMode); err != nil {
+		return err
+	}
+	if cfg.BatchInterval <= 0 {
+		return errors.New("-batch-interval must be positive")
+	}
+	if cfg.RejectsFile != "" && cfg.RejectsMaxBytes <= 0 {
+		return errors.New("-rejects-max-bytes must be positive")
+	}
+	if cfg.SpoolDir != "" && cfg.SpoolMaxBytes <= 0 {
+		return errors.New("-spool-max-bytes must be positive")
+	}
+	switch cfg.QueueBackend {
+	case "", "memory":
+	case "bolt":
+		if cfg.QueuePath == "" {
+			return errors.New("-queue-backend bolt needs -queue-path")
+		}
+		if cfg.SpoolDir != "" {
+			return errors.New("-spool-dir can't be used with -queue-backend bolt, which keeps undelivered events itself")
+		}
+		if cfg.QueueMaxBytes <= 0 {
+			return errors.New("-queue-max-bytes must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown -queue-backend %q (want memory or bolt)", cfg.QueueBackend)
+	}
+	if cfg.MaxRPS < 0 || (cfg.MaxRPS > 0 && cfg.Burst < 1) {
+		return errors.New("-max-rps must be non-negative and -burst at least 1")
+	}
+	if cfg.MaxRetries < 0 || cfg.RetryBase <= 0 {
+		return errors.New("-max-retries must be non-negative and -retry-base positive")
+	}
+	if cfg.BreakerAfter < 0 || cfg.BreakerCooldown <= 0 {
+		return errors.New("-breaker-threshold must be non-negative and -breaker-cooldown positive")
+	}
+	return nil
+}
+
+// loadConfigFile applies the YAML file at path to the flags in fs. Keys
+// are flag names (underscores are accepted for dashes) and values are
+// what would be given on the command line; a list sets a repeatable flag
+// once per element. Flags in set, i.e. given on the command line, are
+// left alone so they override the file. Unknown keys are logged and
+// ignored.
+func loadConfigFile(path string, fs *flag.FlagSet, set map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	var unknown []string
+	for key, value := range values {
+		if key == "properties" || key == "mirrors" || key == "bursts" {
+			continue // see loadConfigLists
+		}
+		name := strings.ReplaceAll(key, "_", "-")
+		if fs.Lookup(name) == nil || slices.Contains(configOnlyFlags, name) {
+			unknown = append(unknown, key)
+			continue
+		}
+		if set[name] {
+			continue
+		}
+
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		for _, item := range items {
+			s, err := configString(item)
+			if err != nil {
+				return fmt.Errorf("config file %s: %s: %w", path, key, err)
+			}
+			if err := fs.Set(name, s); err != nil {
+				return fmt.Errorf("config file %s: %s: %w", path, key, err)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		slog.Warn("Ignoring unknown keys in config file", "file", path, "keys", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// expandEnv replaces the ${NAME} references in s with the values of the
+// environment variables. Every one must be set.
+func expandEnv(s string) (string, error) {
+	var missing []string
+	s = envRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRefRe.FindStringSubmatch(ref)[1]
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return val
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return s, nil
+}
+
+// configString turns a scalar YAML value into flag syntax, expanding
+// ${NAME} environment references in strings.
+func configString(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return expandEnv(v)
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("want a scalar or a list of scalars, got %T", v)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// CrawlerRule maps user agents to a crawler family. Match is a
+// case-insensitive substring; Regex, if set instead, is matched
+// case-insensitively against the whole user agent. Domains lists the
+// hostname suffixes the operator's crawler IPs reverse-resolve to, for
+// -verify-bots.
+type CrawlerRule struct {
+	Family  string   `json:"family"`
+	Match   string   `json:"match,omitempty"`
+	Regex   string   `json:"regex,omitempty"`
+	Domains []string `json:"domains,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// builtinCrawlers covers the AI and search crawlers seen most often.
+// Order matters: the first matching rule wins.
+var builtinCrawlers = []CrawlerRule{
+	{Family: "gptbot", Match: "gptbot", Domains: []string{"openai.com"}},
+	{Family: "chatgpt-user", Match: "chatgpt-user", Domains: []string{"openai.com"}},
+	{Family: "oai-searchbot", Match: "oai-searchbot", Domains: []string{"openai.com"}},
+	{Family: "claudebot", Match: "claudebot", Domains: []string{"anthropic.com"}},
+	{Family: "claude-user", Match: "claude-user", Domains: []string{"anthropic.com"}},
+	{Family: "claude-searchbot", Match: "claude-searchbot", Domains: []string{"anthropic.com"}},
+	{Family: "anthropic-ai", Match: "anthropic-ai", Domains: []string{"anthropic.com"}},
+	{Family: "perplexitybot", Match: "perplexitybot"},
+	{Family: "perplexity-user", Match: "perplexity-user"},
+	{Family: "google-extended", Match: "google-extended", Domains: []string{"googlebot.com", "google.com"}},
+	{Family: "googlebot", Match: "googlebot", Domains: []string{"googlebot.com", "google.com", "googleusercontent.com"}},
+	{Family: "bingbot", Match: "bingbot", Domains: []string{"search.msn.com"}},
+	{Family: "ccbot", Match: "ccbot"},
+	{Family: "amazonbot", Match: "amazonbot", Domains: []string{"crawl.amazonbot.amazon"}},
+	{Family: "bytespider", Match: "bytespider"},
+	{Family: "applebot", Match: "applebot", Domains: []string{"applebot.apple.com"}},
+	{Family: "meta-externalagent", Match: "meta-externalagent"},
+	{Family: "facebookbot", Match: "facebookexternalhit"},
+	{Family: "duckduckbot", Match: "duckduckbot"},
+	{Family: "yandexbot", Match: "yandexbot", Domains: []string{"yandex.ru", "yandex.net", "yandex.com"}},
+	{Family: "baiduspider", Match: "baiduspider", Domains: []string{"baidu.com", "baidu.jp"}},
+	{Family: "cohere-ai", Match: "cohere-ai"},
+	{Family: "diffbot", Match: "diffbot"},
+	{Family: "youbot", Match: "youbot"},
+}
+
+// Classifier assigns a crawler family to a user agent.
+type Classifier struct {
+	rules   []CrawlerRule
+	domains map[string][]string // family -> verification domains
+}
+
+// NewClassifier builds a classifier from the built-in table, preceded by
+// signatures, those downloaded from the API, and before them the rules in
+// file (a JSON array of CrawlerRule) if file is non-empty, so local rules
+// can both add bots and override built-in or downloaded ones.
+func NewClassifier(file string, signatures []CrawlerRule) (*Classifier, error) {
+	var rules []CrawlerRule
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read crawler table: %w", err)
+		}
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("parse crawler table %s: %w", file, err)
+		}
+	}
+	rules = append(rules, signatures...)
+	rules = append(rules, builtinCrawlers...)
+
+	domains := map[string][]string{}
+	for i := range rules {
+		r := &rules[i]
+		if _, seen := domains[r.Family]; !seen && len(r.Domains) > 0 {
+			domains[r.Family] = r.Domains
+		}
+		if r.Family == "" || (r.Match == "") == (r.Regex == "") {
+			return nil, fmt.Errorf("crawler rule %d: need a family and exactly one of match or regex", i)
+		}
+		r.Match = strings.ToLower(r.Match)
+		if r.Regex != "" {
+			re, err := regexp.Compile("(?i)" + r.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("crawler rule %d (%s): %w", i, r.Family, err)
+			}
+			r.re = re
+		}
+	}
+	return &Classifier{rules: rules, domains: domains}, nil
+}
+
+// Domains returns the verification domains of family, if it has any.
+func (c *Classifier) Domains(family string) []string {
+	return c.domains[family]
+}
+
+// Classify returns the family of the first rule matching ua, or "unknown".
+func (c *Classifier) Classify(ua string) string {
+	lower := strings.ToLower(ua)
+	for _, r := range c.rules {
+		if r.re != nil {
+			if r.re.MatchString(ua) {
+				return r.Family
+			}
+		} else if strings.Contains(lower, r.Match) {
+			return r.Family
+		}
+	}
+	return "unknown"
+}
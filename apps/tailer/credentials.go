@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// resolveCredential picks a credential value from, in order of precedence,
+// the flag value, the named file, or the environment variable. The error
+// names the source that was used (or tried) when the result is empty.
+func resolveCredential(what, flagName, flagVal, file, env string) (string, error) {
+	if flagVal != "" {
+		return flagVal, nil
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("read %s from %s: %w", what, file, err)
+		}
+		v := strings.TrimRight(string(data), "\r\n")
+		if v == "" {
+			return "", fmt.Errorf("%s file %s is empty", what, file)
+		}
+		return v, nil
+	}
+	if v := os.Getenv(env); v != "" {
+		return v, nil
+	}
+	return "", fmt.Errorf("%s is not set: use -%s, -%s-file, or %s", what, flagName, flagName, env)
+}
+
+// loadCredentials resolves the API key and secret from cfg. With
+// -sign-alg ed25519 there is no secret.
+func loadCredentials(cfg Config) (key, secret string, err error) {
+	if cfg.DryRun || !slices.Contains(cfg.Sinks, "http") {
+		// Nothing is sent to the API, so nothing needs signing.
+		return "", "", nil
+	}
+	key, err = resolveCredential("API key", "key", cfg.APIKey, cfg.KeyFile, "TRACE_API_KEY")
+	if err != nil {
+		return "", "", err
+	}
+	if cfg.SignAlg == "ed25519" {
+		return key, "", nil
+	}
+	secret, err = resolveCredential("HMAC secret", "secret", cfg.Secret, cfg.SecretFile, "TRACE_HMAC_SECRET")
+	if err != nil {
+		return "", "", err
+	}
+	return key, secret, nil
+}
+
+// loadPrivateKey reads the -private-key-file Ed25519 key.
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read private key: %w", err)
+	}
+	key, err := client.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("-private-key-file %s: %w", path, err)
+	}
+	return key, nil
+}
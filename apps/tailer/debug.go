@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+)
+
+// DebugVars is the /debug/vars report: what the process holds in memory
+// and where it is spending it.
+type DebugVars struct {
+	Goroutines int            `json:"goroutines"`
+	Memory     debugMemory    `json:"memory"`
+	Queue      debugQueue     `json:"queue"`
+	Parser     debugParser    `json:"parser"`
+	Events     map[string]any `json:"events"`
+}
+
+type debugMemory struct {
+	HeapAlloc   uint64 `json:"heap_alloc_bytes"`
+	HeapInuse   uint64 `json:"heap_inuse_bytes"`
+	HeapObjects uint64 `json:"heap_objects"`
+	Sys         uint64 `json:"sys_bytes"`
+	NumGC       uint32 `json:"num_gc"`
+}
+
+type debugQueue struct {
+	Depth   int64            `json:"depth"`
+	Stored  int64            `json:"stored"`
+	Mirrors map[string]int64 `json:"mirrors,omitempty"` // depth by endpoint
+}
+
+type debugParser struct {
+	LinesRead    int64 `json:"lines_read"`
+	ParseErrors  int64 `json:"parse_errors"`
+	LinesTooLong int64 `json:"lines_too_long"`
+}
+
+// Vars reports the state of the process and of m.
+func (m *Metrics) Vars() DebugVars {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	vars := DebugVars{
+		Goroutines: runtime.NumGoroutine(),
+		Memory:     debugMemory{mem.HeapAlloc, mem.HeapInuse, mem.HeapObjects, mem.Sys, mem.NumGC},
+		Queue:      debugQueue{Depth: m.QueueDepth.Load(), Stored: m.QueueStored.Load()},
+		Parser:     debugParser{m.LinesRead.Load(), m.ParseErrors.Load(), m.LinesTooLong.Load()},
+		Events: map[string]any{
+			"sent": m.EventsSent.Load(), "retried": m.EventsRetried.Load(), "dropped": m.EventsDropped.Load(),
+			"filtered": m.Filtered(), "deduplicated": m.EventsDeduped.Load(), "queue_overflow": m.QueueOverflow.Load(),
+		},
+	}
+	for _, mirror := range m.mirrorList() {
+		if vars.Queue.Mirrors == nil {
+			vars.Queue.Mirrors = map[string]int64{}
+		}
+		vars.Queue.Mirrors[mirror.endpoint] = mirror.m.QueueDepth.Load()
+	}
+	return vars
+}
+
+// LogDiagnostics logs m's debug vars and every goroutine's stack, for
+// SIGUSR1 where -debug-addr can't be reached.
+func (m *Metrics) LogDiagnostics() {
+	vars := m.Vars()
+	slog.Info("Diagnostics", "goroutines", vars.Goroutines,
+		slog.Group("memory", "heap_alloc_bytes", vars.Memory.HeapAlloc, "heap_inuse_bytes", vars.Memory.HeapInuse,
+			"heap_objects", vars.Memory.HeapObjects, "sys_bytes", vars.Memory.Sys, "num_gc", vars.Memory.NumGC),
+		slog.Group("queue", "depth", vars.Queue.Depth, "stored", vars.Queue.Stored),
+		slog.Group("parser", "lines_read", vars.Parser.LinesRead, "parse_errors", vars.Parser.ParseErrors,
+			"lines_too_long", vars.Parser.LinesTooLong))
+	var stacks bytes.Buffer
+	runtimepprof.Lookup("goroutine").WriteTo(&stacks, 2)
+	slog.Info("Goroutine stacks", "stacks", stacks.String())
+}
+
+// debugListenAddr is addr, on localhost if it names no host: the
+// profiles show what the tailer holds, so they are only offered to other
+// machines when asked for by address, as with 0.0.0.0:6060.
+func debugListenAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid -debug-addr %q: %w", addr, err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// DebugServer exposes pprof profiles and debug vars under /debug/.
+type DebugServer struct {
+	srv *http.Server
+}
+
+// StartDebugServer listens on addr and serves the profiles in the
+// background.
+func StartDebugServer(addr string, m *Metrics) (*DebugServer, error) {
+	addr, err := debugListenAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+
+	// net/http/pprof registers itself on the default mux, which isn't
+	// served; these are its handlers on ours.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(m.Vars())
+	})
+
+	ds := &DebugServer{srv: &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}}
+	go func() {
+		if err := ds.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Debug server failed", "err", err)
+		}
+	}()
+	slog.Info("Serving debug profiles", "url", "http://"+ln.Addr().String()+"/debug/pprof/")
+	return ds, nil
+}
+
+func (ds *DebugServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ds.srv.Shutdown(ctx)
+}
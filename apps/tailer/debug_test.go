@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestDebugListenAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    string
+		wantErr bool
+	}{
+		{addr: ":6060", want: "127.0.0.1:6060"},
+		{addr: "127.0.0.1:6060", want: "127.0.0.1:6060"},
+		{addr: "0.0.0.0:6060", want: "0.0.0.0:6060"},
+		{addr: "[::]:6060", want: "[::]:6060"},
+		{addr: "6060", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := debugListenAddr(tt.addr)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("debugListenAddr(%q) = %q, %v; want %q, error %v", tt.addr, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
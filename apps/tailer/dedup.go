@@ -0,0 +1,84 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// Dedup suppresses repeats of an event, for upstreams that log the same
+// request twice (mirrored logging). Two events are the same if host,
+// path, method, IP prefix and user agent match and their timestamps are
+// at most window apart; timestamps rather than the clock are compared so
+// that -once replays behave like live tailing.
+//
+// Keys are kept in an LRU of at most maxKeys entries, so memory stays
+// bounded however many distinct requests there are.
+type Dedup struct {
+	window  int64 // ms
+	maxKeys int
+
+	mu    sync.Mutex
+	order *list.List // of *dedupEntry, most recent first
+	keys  map[dedupKey]*list.Element
+}
+
+type dedupKey [16]byte
+
+type dedupEntry struct {
+	key dedupKey
+	ts  int64
+}
+
+func NewDedup(window time.Duration, maxKeys int) *Dedup {
+	return &Dedup{
+		window:  window.Milliseconds(),
+		maxKeys: maxKeys,
+		order:   list.New(),
+		keys:    map[dedupKey]*list.Element{},
+	}
+}
+
+// Seen records event and reports whether it repeats one within the
+// window.
+func (d *Dedup) Seen(event *CrawlEvent) bool {
+	key := keyOf(event)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.keys[key]; ok {
+		entry := el.Value.(*dedupEntry)
+		delta := event.Timestamp - entry.ts
+		if delta < 0 {
+			delta = -delta
+		}
+		d.order.MoveToFront(el)
+		if delta <= d.window {
+			return true
+		}
+		entry.ts = event.Timestamp
+		return false
+	}
+
+	d.keys[key] = d.order.PushFront(&dedupEntry{key: key, ts: event.Timestamp})
+	if d.order.Len() > d.maxKeys {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.keys, oldest.Value.(*dedupEntry).key)
+	}
+	return false
+}
+
+// keyOf hashes the fields that identify a request, so that a key costs the
+// same whatever the length of the path or user agent.
+func keyOf(event *CrawlEvent) dedupKey {
+	h := sha256.New()
+	for _, field := range []string{event.Host, event.Path, event.Method, event.IPPrefix, event.UserAgent} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	var key dedupKey
+	copy(key[:], h.Sum(nil))
+	return key
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	ev := func(ts int64, path string) *CrawlEvent {
+		return &CrawlEvent{Timestamp: ts, Host: "example.com", Path: path, Method: "GET", IPPrefix: "1.2.3.0/24", UserAgent: "GPTBot/1.0"}
+	}
+	tests := []struct {
+		name   string
+		events []*CrawlEvent
+		want   []bool
+	}{
+		{"mirrored line", []*CrawlEvent{ev(1000, "/a"), ev(1000, "/a")}, []bool{false, true}},
+		{"within window", []*CrawlEvent{ev(1000, "/a"), ev(5999, "/a")}, []bool{false, true}},
+		{"out of order", []*CrawlEvent{ev(5000, "/a"), ev(1000, "/a")}, []bool{false, true}},
+		{"after window", []*CrawlEvent{ev(1000, "/a"), ev(7000, "/a"), ev(8000, "/a")}, []bool{false, false, true}},
+		{"different path", []*CrawlEvent{ev(1000, "/a"), ev(1000, "/b")}, []bool{false, false}},
+		{"evicted", []*CrawlEvent{ev(1000, "/a"), ev(1000, "/b"), ev(1000, "/c"), ev(1000, "/a")}, []bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDedup(5*time.Second, 2)
+			for i, event := range tt.events {
+				if got := d.Seen(event); got != tt.want[i] {
+					t.Errorf("event %d: Seen = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
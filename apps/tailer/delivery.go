@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+	"github.com/originaryx/trace/tailer/pkg/grpcclient"
+)
+
+// Delivery is everything between the pipeline and where events end up:
+// the transport cfg picks over api, with any failover, the mirrors and the
+// sinks, behind one sender.
+type Delivery struct {
+	Sender *Fanout
+	Router *Router // nil without properties
+
+	creds   credentialStore
+	stream  *client.Stream
+	grpcAPI *grpcclient.Client
+	sinks   []sink
+}
+
+// NewDelivery sets up delivery for cfg over api, spooling to spool unless it
+// is nil. Cancelling ctx aborts delivery as NewSender describes.
+func NewDelivery(ctx context.Context, cfg Config, api *client.Client, key, secret string, spool Backlog) (*Delivery, error) {
+	d := &Delivery{creds: api}
+	var transport client.Sender = api
+	if cfg.Stream && !cfg.DryRun {
+		d.stream = newStream(ctx, cfg, api)
+		transport = d.stream
+	}
+	if cfg.Transport == "grpc" {
+		var err error
+		if d.grpcAPI, err = newGRPCClient(cfg, key, secret); err != nil {
+			return nil, fmt.Errorf("set up the gRPC client: %w", err)
+		}
+		transport, d.creds = d.grpcAPI, d.grpcAPI
+	}
+	if len(cfg.Properties) > 0 {
+		router, err := newRouter(cfg, api)
+		if err != nil {
+			return nil, fmt.Errorf("load property credentials: %w", err)
+		}
+		transport, d.Router = router, router
+	}
+	if len(cfg.Fallbacks) > 0 {
+		failover, err := newFailover(cfg, api, transport)
+		if err != nil {
+			return nil, fmt.Errorf("set up failover endpoints: %w", err)
+		}
+		transport, d.creds = failover, failover
+	}
+
+	var mirrors []*Sender
+	d.sinks = []sink{{name: "dry-run", send: transport}}
+	if cfg.DryRun {
+		slog.Info("Dry run: writing events to standard output instead of sending them")
+		d.sinks[0].send = newPrintSender(os.Stdout)
+	} else {
+		var err error
+		if mirrors, err = newMirrorSenders(ctx, cfg, key, secret); err != nil {
+			return nil, fmt.Errorf("set up mirrors: %w", err)
+		}
+		if d.sinks, err = newSinks(cfg, transport); err != nil {
+			return nil, fmt.Errorf("set up sinks: %w", err)
+		}
+	}
+	d.Sender = NewFanout(NewSender(ctx, d.sinks[0].send, cfg, spool), mirrors, cfg.MirrorSample)
+	for _, s := range d.sinks[1:] {
+		d.Sender.AddSink(newSender(ctx, s.send, cfg, nil, metrics.AddSink(s.name), slog.Default().With("sink", s.name)))
+	}
+	return d, nil
+}
+
+// Close closes the transports and sinks, once Sender has been closed.
+func (d *Delivery) Close() {
+	if d.stream != nil {
+		d.stream.Close()
+	}
+	if d.grpcAPI != nil {
+		d.grpcAPI.Close()
+	}
+	closeSinks(d.sinks)
+}
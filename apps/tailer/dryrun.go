@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// maxQuotedLine is how much of an unparseable line -dry-run shows.
+const maxQuotedLine = 200
+
+// printSender is the client.Sender behind -dry-run: it writes events to w
+// as NDJSON, exactly as they would have been sent.
+type printSender struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newPrintSender(w io.Writer) *printSender {
+	return &printSender{enc: json.NewEncoder(w)}
+}
+
+func (p *printSender) Send(ctx context.Context, events []*CrawlEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range events {
+		if err := p.enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newRollupPrinter returns what -dry-run sends rollups with: it writes
+// them to w as NDJSON.
+func newRollupPrinter(w io.Writer) func(context.Context, *client.Rollup) error {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(_ context.Context, r *client.Rollup) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return enc.Encode(r)
+	}
+}
+
+// truncateLine shortens line for quoting in a log message.
+func truncateLine(line string) string {
+	if len(line) <= maxQuotedLine {
+		return line
+	}
+	return line[:maxQuotedLine] + "..."
+}
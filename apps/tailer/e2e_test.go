@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// ingestServer is a fake ingest API: it checks the signature of every
+// request to /v1/events and keeps the events of those it accepts. The
+// first failures requests are answered with a 500.
+type ingestServer struct {
+	t        *testing.T
+	key      string
+	secret   string
+	failures int
+
+	mu       sync.Mutex
+	requests int
+	events   []CrawlEvent
+}
+
+func (s *ingestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ts, _ := strconv.ParseInt(r.Header.Get("X-Peac-Timestamp"), 10, 64)
+	switch {
+	case r.Method != "POST" || r.URL.Path != "/v1/events":
+		s.t.Errorf("request to %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	case r.Header.Get("X-Peac-Key") != s.key || r.Header.Get("X-Peac-Sig-Version") != "2":
+		s.t.Errorf("X-Peac-Key %q, X-Peac-Sig-Version %q", r.Header.Get("X-Peac-Key"), r.Header.Get("X-Peac-Sig-Version"))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	case r.Header.Get("X-Peac-Signature") != client.SignV2([]byte(s.secret), r.Method, r.URL.EscapedPath(), ts, body):
+		s.t.Errorf("bad signature on a request of %d bytes", len(body))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	case time.Since(time.UnixMilli(ts)).Abs() > time.Minute:
+		s.t.Errorf("X-Peac-Timestamp %d is not now", ts)
+	}
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			s.t.Errorf("gunzip body: %v", err)
+			return
+		}
+		body, _ = io.ReadAll(zr)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.requests <= s.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var e CrawlEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			s.t.Errorf("decode event %s: %v", scanner.Bytes(), err)
+			continue
+		}
+		s.events = append(s.events, e)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *ingestServer) received() (requests int, events []CrawlEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, slices.Clone(s.events)
+}
+
+// TestEndToEnd tails a log file through rotation, with a line that doesn't
+// parse and an API that fails at first, and checks what the API receives.
+func TestEndToEnd(t *testing.T) {
+	api := &ingestServer{t: t, key: "pk_test", secret: "sk_test", failures: 2}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "access.log")
+	line := func(i int) string {
+		return fmt.Sprintf("%d.250 \"GET /page/%d?utm=x HTTP/1.1\" 200 %d \"Mozilla/5.0 (compatible; GPTBot/1.2)\" 203.0.113.%d en 0.010 example.com gptbot\n",
+			1_700_000_000+i, i, 100*i, i)
+	}
+	if err := os.WriteFile(file, []byte(line(1)+line(2)+"not an access log line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, _, err := parseConfig([]string{
+		"-endpoint", srv.URL, "-file", file, "-format", "nginx", "-from-beginning",
+		"-sig-version", "2", "-compress", "gzip", "-batch-interval", "20ms",
+		"-poll-interval", "10ms", "-retry-base", "10ms", "-workers", "1", "-max-event-age", "0",
+		"-no-agent-meta",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newClient(cfg, api.key, api.secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	delivery, err := NewDelivery(ctx, cfg, c, api.key, api.secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPipeline(cfg, delivery.Sender, nil, nil, delivery.Router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parseErrors := metrics.ParseErrors.Load()
+	w := NewWatcher(ctx, cfg, p, nil)
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor := func(n int) []CrawlEvent {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, events := api.received()
+			if len(events) >= n || time.Now().After(deadline) {
+				return events
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if events := waitFor(2); len(events) != 2 {
+		t.Fatalf("received %d events before rotation, want 2", len(events))
+	}
+
+	// Rotate as logrotate does: rename, then a new file in its place.
+	if err := os.Rename(file, file+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(line(3)+line(4)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	events := waitFor(4)
+
+	w.Stop()
+	if !delivery.Sender.Close(5 * time.Second) {
+		t.Error("sender did not drain")
+	}
+	delivery.Close()
+
+	slices.SortFunc(events, func(a, b CrawlEvent) int { return int(a.Timestamp - b.Timestamp) })
+	var want []CrawlEvent
+	for i := 1; i <= 4; i++ {
+		want = append(want, CrawlEvent{
+			Timestamp: int64(1_700_000_000+i)*1000 + 250, Host: "example.com", Path: fmt.Sprintf("/page/%d", i),
+			Method: "GET", Status: 200, Bytes: int64(100 * i), UserAgent: "Mozilla/5.0 (compatible; GPTBot/1.2)",
+			AcceptLang: "en", CrawlerFamily: "gptbot", IPPrefix: "203.0.113.0/24", RequestTimeMs: 10,
+			Source: "nginx", FetchClass: "full",
+		})
+	}
+	if len(events) != len(want) {
+		t.Fatalf("received %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, got := range events {
+		// Set by the tailer for each event, so checked apart.
+		if got.EventID == "" {
+			t.Errorf("event %d has no event_id", i)
+		}
+		got.EventID = ""
+		if got != want[i] {
+			t.Errorf("event %d:\n got  %+v\n want %+v", i, got, want[i])
+		}
+	}
+	if requests, _ := api.received(); requests < api.failures+2 {
+		t.Errorf("%d requests, want the failed ones retried", requests)
+	}
+	if got := metrics.ParseErrors.Load() - parseErrors; got != 1 {
+		t.Errorf("%d parse errors, want 1", got)
+	}
+}
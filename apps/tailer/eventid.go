@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// eventIDFunc returns the function giving events their event_id for an
+// -event-id-mode, or nil for off (or none given).
+func eventIDFunc(mode string) (func(*CrawlEvent) string, error) {
+	switch mode {
+	case "uuid":
+		return func(*CrawlEvent) string { return newUUIDv7(time.Now()) }, nil
+	case "hash":
+		return hashEventID, nil
+	case "off", "":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown -event-id-mode %q (want uuid, hash or off)", mode)
+}
+
+// newUUIDv7 returns a time-ordered (version 7) UUID made at t.
+func newUUIDv7(t time.Time) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(t.UnixMilli())<<16)
+	rand.Read(b[6:])
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// hashEventID derives an ID from the fields that identify a request, so
+// that an event read again from the log, as after a restart that lost
+// its position, gets the same ID. Two requests alike in all of them
+// within the same millisecond get the same ID too.
+func hashEventID(event *CrawlEvent) string {
+	h := sha256.New()
+	for _, field := range []string{strconv.FormatInt(event.Timestamp, 10), event.Host, event.Path, event.IPPrefix, event.UserAgent} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
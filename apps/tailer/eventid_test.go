@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestEventID(t *testing.T) {
+	const nginxLine = `1700000000.123 "GET /a HTTP/1.1" 200 512 "GPTBot/1.0" 203.0.113.7 en 0.010 example.com gptbot`
+	uuidv7 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	hash := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	tests := []struct {
+		name     string
+		mode     string
+		line     string
+		want     *regexp.Regexp // nil for no ID
+		wantSame bool           // the same line gets the same ID
+	}{
+		{name: "uuid", mode: "uuid", line: nginxLine, want: uuidv7},
+		{name: "hash", mode: "hash", line: nginxLine, want: hash, wantSame: true},
+		{name: "off", mode: "off", line: nginxLine},
+		{name: "replayed", mode: "uuid", line: `{"ts":1700000000123,"host":"example.com","path":"/a","method":"GET","status":200,"event_id":"replayed"}`, want: regexp.MustCompile(`^replayed$`), wantSame: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Format = "nginx"
+			if tt.line[0] == '{' {
+				cfg.Format = "ndjson"
+			}
+			cfg.EventIDMode = tt.mode
+			api := &fakeAPI{}
+			sender := NewSender(context.Background(), api, cfg, nil)
+			p, err := NewPipeline(cfg, NewFanout(sender, nil, 0), nil, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				if err := p.Process("test", tt.line); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !sender.Close(5 * time.Second) {
+				t.Fatal("sender did not drain")
+			}
+
+			if len(api.received) != 2 {
+				t.Fatalf("sent %d events, want 2", len(api.received))
+			}
+			a, b := api.received[0].EventID, api.received[1].EventID
+			switch {
+			case tt.want == nil:
+				if a != "" {
+					t.Errorf("event_id = %q, want none", a)
+				}
+			case !tt.want.MatchString(a):
+				t.Errorf("event_id = %q, want a match for %s", a, tt.want)
+			case (a == b) != tt.wantSame:
+				t.Errorf("event_ids %q and %q, want same %v", a, b, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestHashEventID(t *testing.T) {
+	base := CrawlEvent{Timestamp: 1700000000123, Host: "example.com", Path: "/a", IPPrefix: "203.0.113.0/24", UserAgent: "GPTBot/1.0", Status: 200}
+	id := hashEventID(&base)
+	for name, change := range map[string]func(*CrawlEvent){
+		"ts":        func(e *CrawlEvent) { e.Timestamp++ },
+		"host":      func(e *CrawlEvent) { e.Host = "example.org" },
+		"path":      func(e *CrawlEvent) { e.Path = "/b" },
+		"ip_prefix": func(e *CrawlEvent) { e.IPPrefix = "198.51.100.0/24" },
+		"ua":        func(e *CrawlEvent) { e.UserAgent = "ClaudeBot/1.0" },
+		"split":     func(e *CrawlEvent) { e.Host, e.Path = "example.com/a", "" },
+	} {
+		e := base
+		change(&e)
+		if hashEventID(&e) == id {
+			t.Errorf("changing %s kept the ID", name)
+		}
+	}
+	e := base
+	e.Status = 404
+	if hashEventID(&e) != id {
+		t.Error("changing the status changed the ID")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// Failover is a client.Sender that sends to one of several endpoints,
+// preferring them in order. After FailoverAfter consecutive failed
+// requests (network errors or 5xx) it moves to the next one; while not on
+// the first, a request every FailoverProbe is tried on the first endpoint
+// again, and success there fails back. The endpoints share credentials;
+// only the base URL differs.
+type Failover struct {
+	endpoints []failoverEndpoint
+	threshold int
+	probe     time.Duration
+
+	mu        sync.Mutex
+	current   int
+	failures  int
+	lastProbe time.Time
+}
+
+type failoverEndpoint struct {
+	url  string
+	api  *client.Client // for credential changes
+	send client.Sender  // api, or a Router around it
+}
+
+// newFailover returns a failover from the primary endpoint, reached
+// through api and transport, to cfg.Fallbacks. Each fallback gets a client
+// like api (and a router, with properties).
+func newFailover(cfg Config, api *client.Client, transport client.Sender) (*Failover, error) {
+	f := &Failover{
+		endpoints: []failoverEndpoint{{url: api.Endpoint, api: api, send: transport}},
+		threshold: cfg.FailoverAfter,
+		probe:     cfg.FailoverProbe,
+	}
+	key, secret := api.Credentials()
+	for _, url := range cfg.Fallbacks {
+		fb, err := newClient(cfg, key, secret)
+		if err != nil {
+			return nil, err
+		}
+		fb.Endpoint = url
+		var send client.Sender = fb
+		if len(cfg.Properties) > 0 {
+			if send, err = newRouter(cfg, fb); err != nil {
+				return nil, err
+			}
+		}
+		f.endpoints = append(f.endpoints, failoverEndpoint{url: url, api: fb, send: send})
+	}
+	return f, nil
+}
+
+// Send delivers events to the current endpoint, first probing the
+// primary if it is due.
+func (f *Failover) Send(ctx context.Context, events []*event.CrawlEvent) error {
+	if f.probeDue() {
+		err := f.endpoints[0].send.Send(ctx, events)
+		if err == nil {
+			f.failBack()
+			return nil
+		}
+		slog.Debug("Primary endpoint still failing", "endpoint", f.endpoints[0].url, "err", err)
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	i := f.current
+	f.mu.Unlock()
+	err := f.endpoints[i].send.Send(ctx, events)
+	f.record(i, err, ctx.Err() != nil)
+	return err
+}
+
+// probeDue reports whether this request should try the primary endpoint
+// again. Only one request per interval does.
+func (f *Failover) probeDue() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.current == 0 || time.Since(f.lastProbe) < f.probe {
+		return false
+	}
+	f.lastProbe = time.Now()
+	return true
+}
+
+func (f *Failover) failBack() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.current == 0 {
+		return
+	}
+	slog.Info("Primary endpoint recovered, failing back", "from", f.endpoints[f.current].url, "to", f.endpoints[0].url)
+	f.current, f.failures = 0, 0
+}
+
+// record counts the outcome of a request to endpoint i, failing over once
+// the current endpoint reaches the threshold. Errors other than network
+// errors and 5xx, such as a 400 for a bad batch or a 429, say nothing
+// about the endpoint's health, and neither do requests cut short by the
+// sender's shutdown.
+func (f *Failover) record(i int, err error, cancelled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i != f.current || cancelled {
+		return
+	}
+	if err == nil {
+		f.failures = 0
+		return
+	}
+	if class := errorClass(err); class != "network" && class != "5xx" {
+		return
+	}
+	f.failures++
+	if f.failures < f.threshold {
+		return
+	}
+	next := (f.current + 1) % len(f.endpoints)
+	slog.Info("Endpoint failing, failing over", "from", f.endpoints[f.current].url, "to", f.endpoints[next].url, "failures", f.failures, "err", err)
+	metrics.Failovers.Inc()
+	f.current, f.failures = next, 0
+	f.lastProbe = time.Now()
+}
+
+// Credentials returns the key ID and secret in use.
+func (f *Failover) Credentials() (key, secret string) {
+	return f.endpoints[0].api.Credentials()
+}
+
+// SetCredentials replaces the credentials for every endpoint.
+func (f *Failover) SetCredentials(key, secret string) {
+	for _, ep := range f.endpoints {
+		ep.api.SetCredentials(key, secret)
+	}
+}
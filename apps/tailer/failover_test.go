@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+func TestFailover(t *testing.T) {
+	unavailable := &client.StatusError{StatusCode: 503}
+	primary := &fakeAPI{errs: []error{unavailable, &client.StatusError{StatusCode: 400}, unavailable}}
+	fallback := &fakeAPI{}
+	f := &Failover{
+		endpoints: []failoverEndpoint{{url: "primary", send: primary}, {url: "fallback", send: fallback}},
+		threshold: 2,
+		probe:     20 * time.Millisecond,
+	}
+	send := func() error { return f.Send(context.Background(), []*CrawlEvent{{Path: "/"}}) }
+
+	// The 400 in between doesn't count towards the threshold.
+	for i := range 3 {
+		if err := send(); err == nil {
+			t.Fatalf("send %d succeeded, want the scripted error", i)
+		}
+	}
+	if f.current != 1 {
+		t.Fatalf("current = %d after failures, want the fallback", f.current)
+	}
+	if err := send(); err != nil || len(fallback.received) != 1 {
+		t.Fatalf("send after failover = %v, fallback has %d events; want delivered", err, len(fallback.received))
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+	if f.current != 0 || len(primary.received) != 1 || len(fallback.received) != 1 {
+		t.Errorf("after probe: current %d, primary %d, fallback %d events; want failed back with the event on the primary",
+			f.current, len(primary.received), len(fallback.received))
+	}
+}
+
+func TestFailoverProbeFailure(t *testing.T) {
+	unavailable := &client.StatusError{StatusCode: 503}
+	primary := &fakeAPI{errs: []error{unavailable, unavailable}}
+	fallback := &fakeAPI{}
+	f := &Failover{
+		endpoints: []failoverEndpoint{{url: "primary", send: primary}, {url: "fallback", send: fallback}},
+		threshold: 1,
+		probe:     time.Millisecond,
+	}
+	f.Send(context.Background(), []*CrawlEvent{{Path: "/"}})
+	time.Sleep(5 * time.Millisecond)
+
+	// The probe fails, and the batch still goes to the fallback.
+	if err := f.Send(context.Background(), []*CrawlEvent{{Path: "/"}}); err != nil {
+		t.Fatal(err)
+	}
+	if f.current != 1 || primary.calls != 2 || len(fallback.received) != 1 {
+		t.Errorf("current %d, primary calls %d, fallback %d events; want still on the fallback", f.current, primary.calls, len(fallback.received))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fifoBackoff bounds the wait between reopening a named pipe that had no
+// writer; it starts at fifoBackoffMin and doubles while nothing arrives.
+const (
+	fifoBackoffMin = 100 * time.Millisecond
+	fifoBackoffMax = 2 * time.Second
+)
+
+// fifoTail reads lines from a named pipe, such as one nginx logs to so
+// the lines never touch disk. When the writer closes its end (nginx
+// reopening its logs, say), the pipe is reopened rather than the reading
+// stopped. Pipes have no offsets, so nothing is recorded in the position
+// file and lines written while the tailer isn't reading are lost.
+type fifoTail struct {
+	path string
+
+	mu   sync.Mutex
+	f    *os.File
+	stop chan struct{}
+}
+
+func newFIFOTail(path string) *fifoTail {
+	return &fifoTail{path: path, stop: make(chan struct{})}
+}
+
+// run calls handle with each line until Stop.
+func (ft *fifoTail) run(handle func(line string)) {
+	backoff := fifoBackoffMin
+	for {
+		lines, err := ft.readOnce(handle)
+		if err != nil && !ft.stopped() {
+			slog.Warn("Error reading named pipe", "file", ft.path, "err", err)
+		}
+		if lines > 0 {
+			backoff = fifoBackoffMin
+		}
+		select {
+		case <-ft.stop:
+			return
+		case <-time.After(backoff):
+		}
+		if lines == 0 {
+			backoff = min(2*backoff, fifoBackoffMax)
+		}
+	}
+}
+
+// readOnce opens the pipe and reads it until the writer goes away.
+func (ft *fifoTail) readOnce(handle func(line string)) (lines int, err error) {
+	// O_NONBLOCK keeps the open from blocking until there is a writer and
+	// lets Stop interrupt a read by closing the file.
+	f, err := os.OpenFile(ft.path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return 0, err
+	}
+	ft.mu.Lock()
+	if ft.stopped() {
+		ft.mu.Unlock()
+		f.Close()
+		return 0, nil
+	}
+	ft.f = f
+	ft.mu.Unlock()
+	defer func() {
+		ft.mu.Lock()
+		ft.f = nil
+		ft.mu.Unlock()
+		f.Close()
+	}()
+
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadString('\n')
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			lines++
+			handle(line)
+		}
+		if errors.Is(err, io.EOF) {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+	}
+}
+
+func (ft *fifoTail) stopped() bool {
+	select {
+	case <-ft.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop interrupts the current read and stops reopening the pipe.
+func (ft *fifoTail) Stop() {
+	ft.mu.Lock()
+	close(ft.stop)
+	if ft.f != nil {
+		ft.f.Close()
+	}
+	ft.mu.Unlock()
+}
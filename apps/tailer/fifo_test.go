@@ -0,0 +1,62 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFIFOTailReopens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.fifo")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	var mu sync.Mutex
+	var got []string
+	ft := newFIFOTail(path)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ft.run(func(line string) {
+			mu.Lock()
+			got = append(got, line)
+			mu.Unlock()
+		})
+	}()
+
+	// Two writers one after the other, as when nginx reopens its logs;
+	// the second closes mid-line.
+	for _, data := range []string{"one\ntwo\n", "three\nfour"} {
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteString(data)
+		w.Close()
+	}
+
+	want := []string{"one", "two", "three", "four"}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n >= len(want) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ft.Stop()
+	<-done
+
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
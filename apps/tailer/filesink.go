@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileSinkSuffix     = ".ndjson.gz"
+	fileSinkTmpSuffix  = ".tmp"
+	fileSinkTimeFormat = "20060102T150405.000Z"
+)
+
+// FileSink writes events to gzipped NDJSON files in a directory, for hosts
+// with no way to reach the API: the files are carried off and replayed
+// elsewhere with -once -format ndjson. A file is written under a .tmp name
+// and renamed, after an fsync, once it holds maxFileBytes or has been open
+// maxAge. Finished files beyond maxBytes in total are then pruned, oldest
+// first.
+//
+// Each batch is a gzip member of its own, so a file left open by a crash
+// can still be read up to its last batch; it is finished at the next
+// start.
+type FileSink struct {
+	dir          string
+	host         string
+	maxFileBytes int64
+	maxBytes     int64
+	maxAge       time.Duration
+
+	mu     sync.Mutex
+	cur    *os.File // nil until the next Send
+	name   string   // final path of cur
+	size   int64    // bytes written to cur
+	timer  *time.Timer
+	opened time.Time // when the newest file was opened, to keep names unique
+	buf    bytes.Buffer
+	gz     *gzip.Writer
+}
+
+// NewFileSink returns the sink for the -out-* flags of cfg, finishing
+// any files a previous run left open in -out-dir.
+func NewFileSink(cfg Config) (*FileSink, error) {
+	if err := validateFileSink(cfg); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.OutDir, 0o700); err != nil {
+		return nil, fmt.Errorf("create output directory: %w", err)
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "unknown"
+	}
+	s := &FileSink{
+		dir:          cfg.OutDir,
+		host:         host,
+		maxFileBytes: cfg.OutFileBytes,
+		maxBytes:     cfg.OutMaxBytes,
+		maxAge:       cfg.OutFileAge,
+		gz:           gzip.NewWriter(nil),
+	}
+	tmps, err := filepath.Glob(filepath.Join(s.dir, "*"+fileSinkSuffix+fileSinkTmpSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("read output directory: %w", err)
+	}
+	for _, tmp := range tmps {
+		if err := os.Rename(tmp, strings.TrimSuffix(tmp, fileSinkTmpSuffix)); err != nil {
+			return nil, fmt.Errorf("finish %s: %w", tmp, err)
+		}
+		slog.Info("Finished output file left by a previous run", "file", tmp)
+	}
+	if len(tmps) > 0 {
+		syncDir(s.dir)
+	}
+	s.prune()
+	return s, nil
+}
+
+// Send appends events to the open file, opening one if needed. They are
+// written, but not synced, when it returns.
+func (s *FileSink) Send(ctx context.Context, events []*CrawlEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buf.Reset()
+	s.gz.Reset(&s.buf)
+	enc := json.NewEncoder(s.gz)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("marshal event: %w", err)
+		}
+	}
+	if err := s.gz.Close(); err != nil {
+		return fmt.Errorf("compress events: %w", err)
+	}
+
+	if s.cur == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.cur.Write(s.buf.Bytes()); err != nil {
+		// Cut off what was written of the batch, so that the file stays
+		// readable for the batches before it.
+		s.cur.Truncate(s.size)
+		s.cur.Seek(s.size, io.SeekStart)
+		return fmt.Errorf("write %s: %w", s.cur.Name(), err)
+	}
+	s.size += int64(s.buf.Len())
+	if s.size >= s.maxFileBytes {
+		if err := s.finish(); err != nil {
+			slog.Error("Failed to finish output file", "file", s.name, "err", err)
+		}
+	}
+	return nil
+}
+
+// Close finishes the open file, if any.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur == nil {
+		return nil
+	}
+	return s.finish()
+}
+
+// open starts a new file named after the host and the time. s.mu is held.
+func (s *FileSink) open() error {
+	now := time.Now().Truncate(time.Millisecond)
+	if !now.After(s.opened) {
+		now = s.opened.Add(time.Millisecond)
+	}
+	name := filepath.Join(s.dir, s.host+"-"+now.UTC().Format(fileSinkTimeFormat)+fileSinkSuffix)
+	f, err := os.OpenFile(name+fileSinkTmpSuffix, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("create output file: %w", err)
+	}
+	s.cur, s.name, s.size, s.opened = f, name, 0, now
+	s.timer = time.AfterFunc(s.maxAge, func() { s.expire(f) })
+	return nil
+}
+
+// expire finishes f once it has been open maxAge, unless it has been
+// finished already.
+func (s *FileSink) expire(f *os.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur != f {
+		return
+	}
+	if err := s.finish(); err != nil {
+		slog.Error("Failed to finish output file", "file", s.name, "err", err)
+	}
+}
+
+// finish syncs and closes the open file, gives it its final name and
+// prunes the directory. s.mu is held.
+func (s *FileSink) finish() error {
+	f := s.cur
+	s.cur = nil
+	s.timer.Stop()
+	err := f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("sync %s: %w", f.Name(), err)
+	}
+	if err := os.Rename(f.Name(), s.name); err != nil {
+		return fmt.Errorf("rename %s: %w", f.Name(), err)
+	}
+	syncDir(s.dir)
+	s.prune()
+	return nil
+}
+
+// prune deletes the oldest finished files until what is left fits in
+// maxBytes, keeping at least the newest.
+func (s *FileSink) prune() {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+fileSinkSuffix))
+	if err != nil {
+		return
+	}
+	var total int64
+	infos := make(map[string]os.FileInfo, len(files))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			infos[f] = fi
+			total += fi.Size()
+		}
+	}
+	files = slices.DeleteFunc(files, func(f string) bool { return infos[f] == nil })
+	sort.SliceStable(files, func(i, j int) bool { return infos[files[i]].ModTime().Before(infos[files[j]].ModTime()) })
+	for len(files) > 1 && total > s.maxBytes {
+		size := infos[files[0]].Size()
+		if err := os.Remove(files[0]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Failed to prune output file", "file", files[0], "err", err)
+			return
+		}
+		metrics.FilesPruned.Inc()
+		slog.Warn("Pruned oldest output file to stay within -out-max-bytes", "file", files[0], "bytes", size)
+		total -= size
+		files = files[1:]
+	}
+}
+
+// syncDir fsyncs a directory so that renames in it survive a crash.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+func validateFileSink(cfg Config) error {
+	if cfg.OutDir == "" {
+		return errors.New("-sink file needs -out-dir")
+	}
+	if cfg.OutFileBytes <= 0 || cfg.OutFileAge <= 0 {
+		return errors.New("-out-file-bytes and -out-file-age must be positive")
+	}
+	if cfg.OutMaxBytes < cfg.OutFileBytes {
+		return errors.New("-out-max-bytes must be at least -out-file-bytes")
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fileSinkConfig(dir string) Config {
+	return Config{OutDir: dir, OutFileBytes: 16 << 20, OutFileAge: time.Hour, OutMaxBytes: 1 << 30}
+}
+
+func outputFiles(t *testing.T, dir string) (finished, open []string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		switch {
+		case strings.HasSuffix(e.Name(), fileSinkSuffix):
+			finished = append(finished, filepath.Join(dir, e.Name()))
+		case strings.HasSuffix(e.Name(), fileSinkSuffix+fileSinkTmpSuffix):
+			open = append(open, e.Name())
+		}
+	}
+	return finished, open
+}
+
+// TestFileSinkReplays writes batches across several files and reads them
+// back through a pipeline, as -once -format ndjson would.
+func TestFileSinkReplays(t *testing.T) {
+	dir := t.TempDir()
+	cfg := fileSinkConfig(dir)
+	cfg.OutFileBytes = 1 // a file per batch
+	s, err := NewFileSink(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		events := []*CrawlEvent{
+			{Timestamp: 1700000000000 + int64(i), Host: "example.com", Path: "/a", Method: "GET", Status: 200,
+				UserAgent: "GPTBot/1.0", IPPrefix: "203.0.113.0/24", CrawlerFamily: "gptbot", Source: "nginx", AgentHost: "edge-1"},
+			{Timestamp: 1700000000000 + int64(i), Host: "example.com", Path: "/b", Method: "GET", Status: 404,
+				IPPrefix: "203.0.113.0/24", CrawlerFamily: "unknown", Source: "nginx", AgentHost: "edge-1"},
+		}
+		if err := s.Send(context.Background(), events); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	finished, open := outputFiles(t, dir)
+	if len(finished) != 3 || len(open) != 0 {
+		t.Fatalf("finished %v and open %v, want 3 finished files", finished, open)
+	}
+	host, _ := os.Hostname()
+	if !strings.HasPrefix(filepath.Base(finished[0]), host+"-") {
+		t.Errorf("file %s isn't named after host %s", finished[0], host)
+	}
+
+	replay := testConfig()
+	replay.Format = "ndjson"
+	replay.IPv4Prefix = 24
+	api := &fakeAPI{}
+	sender := NewSender(context.Background(), api, replay, nil)
+	p, err := NewPipeline(replay, NewFanout(sender, nil, 0), nil, &agentMeta{host: "collector"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.LogFiles = []string{filepath.Join(dir, "*"+fileSinkSuffix)}
+	r, err := NewReplay(replay, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, perrs, err := r.Run(context.Background())
+	if err != nil || lines != 6 || perrs != 0 {
+		t.Fatalf("replayed %d lines with %d parse errors: %v", lines, perrs, err)
+	}
+	sender.Close(time.Second)
+	if len(api.received) != 6 {
+		t.Fatalf("sent %d events, want 6", len(api.received))
+	}
+	for _, e := range api.received {
+		if e.IPPrefix != "203.0.113.0/24" || e.AgentHost != "edge-1" {
+			t.Errorf("replayed event %+v lost what the first tailer set", e)
+		}
+	}
+}
+
+func TestFileSinkRotatesByAge(t *testing.T) {
+	dir := t.TempDir()
+	cfg := fileSinkConfig(dir)
+	cfg.OutFileAge = 50 * time.Millisecond
+	s, err := NewFileSink(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Send(context.Background(), []*CrawlEvent{{Path: "/a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if finished, open := outputFiles(t, dir); len(finished) != 0 || len(open) != 1 {
+		t.Fatalf("finished %v and open %v, want one open file", finished, open)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		finished, open := outputFiles(t, dir)
+		if len(finished) == 1 && len(open) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("finished %v and open %v, want the file finished after -out-file-age", finished, open)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFileSinkPrunesOldest(t *testing.T) {
+	dir := t.TempDir()
+	// Left by a previous run: two finished files and one still open.
+	now := time.Now()
+	for i, name := range []string{"h-1" + fileSinkSuffix, "h-2" + fileSinkSuffix, "h-3" + fileSinkSuffix + fileSinkTmpSuffix} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(path, mtime, mtime)
+	}
+	cfg := fileSinkConfig(dir)
+	cfg.OutFileBytes = 100
+	cfg.OutMaxBytes = 250
+	before := metrics.FilesPruned.Load()
+	s, err := NewFileSink(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	finished, open := outputFiles(t, dir)
+	want := []string{filepath.Join(dir, "h-2"+fileSinkSuffix), filepath.Join(dir, "h-3"+fileSinkSuffix)}
+	if len(open) != 0 || strings.Join(finished, ",") != strings.Join(want, ",") {
+		t.Errorf("finished %v and open %v, want %v", finished, open, want)
+	}
+	if n := metrics.FilesPruned.Load() - before; n != 1 {
+		t.Errorf("%d files pruned, want 1", n)
+	}
+}
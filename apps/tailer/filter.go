@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// filterReasons are the label values of events_filtered_total.
+// Events sampled out by -sample count as "sample", those dropped by
+// -unmatched-host as "host", those for static assets as "extension" and
+// those -normalize-hosts finds no valid host in as "invalid_host", those
+// older than -max-event-age as "stale" and those outside -since and -until
+// as "time_range".
+var filterReasons = []string{"path", "status", "family", "sample", "host", "extension", "invalid_host", "stale", "time_range"}
+
+// defaultExcludeExts are the static assets -exclude-extensions drops
+// unless told otherwise.
+const defaultExcludeExts = "css,js,png,jpg,gif,svg,woff,woff2,ico,map"
+
+// Filter decides which parsed events are worth sending. A nil *Filter
+// passes everything.
+type Filter struct {
+	include  []*regexp.Regexp
+	exclude  []*regexp.Regexp
+	statuses *statusSet
+
+	extensions map[string]bool // lower case, without the dot
+
+	hosts        []string // patterns, lower case
+	excludeHosts []string
+	dropEmpty    bool
+
+	onlyCrawlers bool
+	families     map[string]bool // nil means any known family
+}
+
+// NewFilter compiles the path, status and crawler family settings. It
+// returns nil if no filtering is configured.
+func NewFilter(cfg Config) (*Filter, error) {
+	extensions, err := parseExtensions(cfg.ExcludeExts)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := parseHostPatterns("-hosts", cfg.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	excludeHosts, err := parseHostPatterns("-exclude-hosts", cfg.ExcludeHosts)
+	if err != nil {
+		return nil, err
+	}
+	dropEmpty := cfg.EmptyHost == "drop"
+	if len(cfg.IncludePaths) == 0 && len(cfg.ExcludePaths) == 0 && extensions == nil && hosts == nil && excludeHosts == nil && !dropEmpty &&
+		cfg.Statuses == "" && !cfg.OnlyCrawlers && cfg.Families == "" {
+		return nil, nil
+	}
+	f := &Filter{extensions: extensions, hosts: hosts, excludeHosts: excludeHosts, dropEmpty: dropEmpty, onlyCrawlers: cfg.OnlyCrawlers}
+	if f.include, err = compilePatterns("-include-path", cfg.IncludePaths); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compilePatterns("-exclude-path", cfg.ExcludePaths); err != nil {
+		return nil, err
+	}
+	if f.statuses, err = parseStatuses(cfg.Statuses); err != nil {
+		return nil, err
+	}
+	if cfg.Families != "" {
+		f.onlyCrawlers = true
+		f.families = map[string]bool{}
+		for _, family := range strings.Split(cfg.Families, ",") {
+			if family = strings.ToLower(strings.TrimSpace(family)); family != "" {
+				f.families[family] = true
+			}
+		}
+	}
+	return f, nil
+}
+
+// parseExtensions parses -exclude-extensions, a comma-separated list with
+// or without dots. An empty list yields nil.
+func parseExtensions(list string) (map[string]bool, error) {
+	var exts map[string]bool
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext == "" {
+			continue
+		}
+		if strings.ContainsAny(ext, "./?") {
+			return nil, fmt.Errorf("invalid -exclude-extensions entry %q", ext)
+		}
+		if exts == nil {
+			exts = map[string]bool{}
+		}
+		exts[ext] = true
+	}
+	return exts, nil
+}
+
+// parseHostPatterns parses a comma-separated list of host patterns, where
+// "*" matches any run of characters. An empty list yields nil.
+func parseHostPatterns(flagName, list string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %w", flagName, p, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func compilePatterns(flagName string, patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %w", flagName, p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// Reject returns why event should not be sent, or "" if it should: its
+// status, host or path. The path has already had its query string
+// stripped by the parser. The
+// crawler family is checked separately by RejectFamily, once it is known.
+func (f *Filter) Reject(event *CrawlEvent) string {
+	if f == nil {
+		return ""
+	}
+	if f.statuses != nil && !f.statuses.Contains(event.Status) {
+		return "status"
+	}
+	if !f.acceptsHost(event.Host) {
+		return "host"
+	}
+	if matchAny(f.exclude, event.Path) {
+		return "path"
+	}
+	if len(f.include) > 0 && !matchAny(f.include, event.Path) {
+		return "path"
+	}
+	return ""
+}
+
+// RejectExtension reports whether path, as requested, is for a static
+// asset under -exclude-extensions. Only the last segment's extension
+// counts, so "/docs/js-frameworks" isn't one.
+func (f *Filter) RejectExtension(path string) bool {
+	if f == nil || f.extensions == nil {
+		return false
+	}
+	ext := pathExtension(path)
+	return ext != "" && f.extensions[strings.ToLower(ext)]
+}
+
+// pathExtension returns the extension of the last segment of path,
+// without the dot, or "" if it has none. A leading dot, as in
+// "/.well-known", doesn't start one.
+func pathExtension(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	segment := path[strings.LastIndexByte(path, '/')+1:]
+	if i := strings.LastIndexByte(segment, '.'); i > 0 {
+		return segment[i+1:]
+	}
+	return ""
+}
+
+// RejectFamily reports whether event should be dropped for its crawler
+// family under -only-crawlers or -families.
+func (f *Filter) RejectFamily(event *CrawlEvent) bool {
+	if f == nil || !f.onlyCrawlers {
+		return false
+	}
+	family := strings.ToLower(event.CrawlerFamily)
+	if family == "" || family == "-" || family == "unknown" {
+		return true
+	}
+	return f.families != nil && !f.families[family]
+}
+
+// acceptsHost reports whether host, with any port, passes -hosts,
+// -exclude-hosts and -empty-host.
+func (f *Filter) acceptsHost(host string) bool {
+	if host == "" {
+		return !f.dropEmpty
+	}
+	if f.hosts == nil && f.excludeHosts == nil {
+		return true
+	}
+	host = strings.ToLower(stripPort(host))
+	if matchHostPattern(f.excludeHosts, host) {
+		return false
+	}
+	return f.hosts == nil || matchHostPattern(f.hosts, host)
+}
+
+func matchHostPattern(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// stripPort returns host without a port, and an IPv6 address without its
+// brackets.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusSet is a set of HTTP status codes given as individual codes and
+// whole classes, e.g. "2xx,3xx,429".
+type statusSet struct {
+	classes [10]bool
+	codes   map[int]bool
+}
+
+// parseStatuses parses a -statuses value. An empty spec means no status
+// filtering and yields nil.
+func parseStatuses(spec string) (*statusSet, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	set := &statusSet{codes: map[int]bool{}}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if len(item) == 3 && item[1:] == "xx" && item[0] >= '1' && item[0] <= '5' {
+			set.classes[item[0]-'0'] = true
+			continue
+		}
+		code, err := strconv.Atoi(item)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid -statuses entry %q: want a status code like 404 or a class like 2xx", item)
+		}
+		set.codes[code] = true
+	}
+	if len(set.codes) == 0 && set.classes == [10]bool{} {
+		return nil, fmt.Errorf("invalid -statuses %q: no status codes given", spec)
+	}
+	return set, nil
+}
+
+func (s *statusSet) Contains(status int) bool {
+	if s.codes[status] {
+		return true
+	}
+	class := status / 100
+	return class >= 0 && class < len(s.classes) && s.classes[class]
+}
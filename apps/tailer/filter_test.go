@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRejectExtension(t *testing.T) {
+	f, err := NewFilter(Config{ExcludeExts: defaultExcludeExts + ",.PDF"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/static/app.css", true},
+		{"/img/Logo.PNG", true},
+		{"/fonts/inter.woff2", true},
+		{"/docs/report.pdf", true},
+		{"/app.js?v=3", true},
+		{"/docs/js-frameworks", false},
+		{"/css/", false},
+		{"/assets.css/index", false},
+		{"/docs/guide.html", false},
+		{"/.well-known/security.txt", false},
+		{"/.js", false},
+		{"/", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := f.RejectExtension(tt.path); got != tt.want {
+			t.Errorf("RejectExtension(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if f, err := NewFilter(Config{ExcludeExts: ""}); err != nil || f != nil {
+		t.Errorf(`NewFilter with -exclude-extensions "" = %v, %v; want no filter`, f, err)
+	}
+	if _, err := NewFilter(Config{ExcludeExts: "css,a/b"}); err == nil {
+		t.Error("NewFilter accepted an extension with a slash")
+	}
+}
+
+func TestFilterHosts(t *testing.T) {
+	tests := []struct {
+		name                  string
+		hosts, exclude, empty string
+		host                  string
+		want                  string
+	}{
+		{name: "listed", hosts: "example.com,*.example.org", host: "example.com", want: ""},
+		{name: "case and port", hosts: "example.com", host: "Example.COM:8443", want: ""},
+		{name: "wildcard", hosts: "example.com,*.example.org", host: "www.example.org", want: ""},
+		{name: "wildcard needs a subdomain", hosts: "*.example.org", host: "example.org", want: "host"},
+		{name: "not listed", hosts: "example.com", host: "other.net", want: "host"},
+		{name: "excluded", hosts: "*.example.org", exclude: "staging.example.org", host: "staging.example.org:80", want: "host"},
+		{name: "exclude only", exclude: "localhost,127.0.0.1", host: "127.0.0.1:8080", want: "host"},
+		{name: "ipv6 with port", hosts: "::1", host: "[::1]:8080", want: ""},
+		{name: "empty sent", hosts: "example.com", empty: "send", host: "", want: ""},
+		{name: "empty dropped", empty: "drop", host: "", want: "host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.empty == "" {
+				tt.empty = "send"
+			}
+			f, err := NewFilter(Config{Hosts: tt.hosts, ExcludeHosts: tt.exclude, EmptyHost: tt.empty})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Reject(&CrawlEvent{Host: tt.host, Path: "/"}); got != tt.want {
+				t.Errorf("Reject(host %q) = %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+	if _, err := NewFilter(Config{Hosts: "[example.com"}); err == nil {
+		t.Error("NewFilter accepted a malformed -hosts pattern")
+	}
+}
+
+func TestDebugFilters(t *testing.T) {
+	cfg := testConfig()
+	cfg.Format = "nginx"
+	cfg.Hosts, cfg.EmptyHost, cfg.DebugFilters = "example.com", "send", true
+	path := filepath.Join(t.TempDir(), "rejects.log")
+	rejects, err := OpenRejects(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejects.Close()
+	p, err := NewPipeline(cfg, NewFanout(NewSender(context.Background(), &fakeAPI{}, cfg, nil), nil, 0), rejects, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `1700000000.123 "GET /a HTTP/1.1" 200 512 "GPTBot/1.0" 203.0.113.7 en 0.010 other.net gptbot`
+	if err := p.Process("access.log", line); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "access.log: filtered: host\n"+line+"\n") {
+		t.Errorf("rejects file = %q, want the filtered line", data)
+	}
+}
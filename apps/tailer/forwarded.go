@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies picks the client address out of an X-Forwarded-For chain
+// for -trust-proxy. The logged remote address is the proxy nginx sits
+// behind; each hop in the header that is in one of the prefixes is
+// another proxy and is skipped, right to left.
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+}
+
+// NewTrustedProxies parses spec, a comma separated list of CIDRs or bare
+// addresses such as "10.0.0.0/8,192.0.2.1". An empty spec trusts no hop of
+// the header, so the rightmost one is taken as the client.
+func NewTrustedProxies(spec string) (*TrustedProxies, error) {
+	t := &TrustedProxies{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return nil, fmt.Errorf("-trusted-proxies: invalid CIDR %q", s)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		t.prefixes = append(t.prefixes, prefix.Masked())
+	}
+	return t, nil
+}
+
+func (t *TrustedProxies) trusted(addr netip.Addr) bool {
+	for _, prefix := range t.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the rightmost hop of xff, the logged X-Forwarded-For
+// value, that isn't a trusted proxy. Empty entries are ignored. A hop that
+// isn't an address (an obfuscated "unknown", say) ends the search, since
+// nothing to its left can be believed, as does running out of hops; the
+// last address seen is returned then. With no usable header that is
+// remote, the address nginx logged.
+func (t *TrustedProxies) ClientIP(remote, xff string) string {
+	client := remote
+	hops := strings.Split(xff, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" || hop == "-" {
+			continue
+		}
+		addr, ok := parseHop(hop)
+		if !ok {
+			break
+		}
+		client = addr.String()
+		if !t.trusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// parseHop parses one X-Forwarded-For entry, which may carry a port:
+// "192.0.2.1", "192.0.2.1:443", "2001:db8::1" or "[2001:db8::1]:443".
+func parseHop(hop string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(strings.Trim(hop, "[]")); err == nil {
+		return addr.Unmap().WithZone(""), true
+	}
+	if ap, err := netip.ParseAddrPort(hop); err == nil {
+		return ap.Addr().Unmap().WithZone(""), true
+	}
+	return netip.Addr{}, false
+}
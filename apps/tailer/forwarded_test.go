@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := NewTrustedProxies("10.0.0.0/8, 2001:db8:ffff::/48,192.0.2.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const lb = "10.1.2.3"
+	tests := []struct {
+		name, xff, want string
+	}{
+		{"no header", "", lb},
+		{"dash", "-", lb},
+		{"single hop", "203.0.113.7", "203.0.113.7"},
+		{"rightmost untrusted", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"trusted hops skipped", "203.0.113.7, 192.0.2.9, 10.0.0.5", "203.0.113.7"},
+		{"empty entries", "203.0.113.7,, ,10.0.0.5,", "203.0.113.7"},
+		{"ipv4 with port", "203.0.113.7:51234", "203.0.113.7"},
+		{"ipv6", "2001:db8::1", "2001:db8::1"},
+		{"ipv6 with port", "[2001:db8::1]:443, 10.0.0.5", "2001:db8::1"},
+		{"bracketed ipv6", "[2001:db8::1]", "2001:db8::1"},
+		{"trusted ipv6 proxy", "2001:db8::1, 2001:db8:ffff::2", "2001:db8::1"},
+		{"ipv4-mapped", "::ffff:203.0.113.7", "203.0.113.7"},
+		{"all trusted", "10.0.0.6, 10.0.0.5", "10.0.0.6"},
+		{"garbage", "not-an-ip", lb},
+		{"garbage behind trusted", "unknown, 10.0.0.5", "10.0.0.5"},
+		{"spoofed left of client", "1.1.1.1, 203.0.113.7", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		if got := proxies.ClientIP(lb, tt.xff); got != tt.want {
+			t.Errorf("%s: ClientIP(%q) = %q, want %q", tt.name, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestNewTrustedProxiesInvalid(t *testing.T) {
+	for _, spec := range []string{"10.0.0.0/33", "example.com", "10.0.0.0/8,nope"} {
+		if _, err := NewTrustedProxies(spec); err == nil {
+			t.Errorf("NewTrustedProxies(%q) succeeded, want an error", spec)
+		}
+	}
+}
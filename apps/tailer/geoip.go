@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// enrichCheckInterval is how often -geoip-db, -asn-db and -bot-ranges-dir
+// are checked for changes.
+const enrichCheckInterval = time.Minute
+
+// GeoIP adds the country and network of the client to events, from
+// MaxMind databases: -geoip-db for the country and -asn-db for the
+// autonomous system. Lookups use the full client address, which is never
+// sent.
+type GeoIP struct {
+	country *mmdbFile // nil without -geoip-db
+	asn     *mmdbFile // nil without -asn-db
+}
+
+// geoIPRecord and asnRecord are the parts of database records lookups
+// decode.
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+type asnRecord struct {
+	Number uint32 `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+// NewGeoIP opens the databases of cfg, or returns nil if there are none.
+func NewGeoIP(cfg Config) *GeoIP {
+	if cfg.GeoIPDB == "" && cfg.ASNDB == "" {
+		return nil
+	}
+	g := &GeoIP{}
+	if cfg.GeoIPDB != "" {
+		g.country = newMMDBFile("-geoip-db", cfg.GeoIPDB)
+	}
+	if cfg.ASNDB != "" {
+		g.asn = newMMDBFile("-asn-db", cfg.ASNDB)
+	}
+	return g
+}
+
+// Enrich sets event's country and network, those of them not already set
+// and that the databases know, from its client address.
+func (g *GeoIP) Enrich(event *CrawlEvent) {
+	ip := net.ParseIP(event.ClientIP)
+	if ip == nil {
+		return
+	}
+	if g.country != nil && event.Country == "" {
+		var r geoIPRecord
+		if g.country.lookup(ip, &r) {
+			event.Country = r.Country.ISOCode
+		}
+	}
+	if g.asn != nil && event.ASN == "" {
+		var r asnRecord
+		if g.asn.lookup(ip, &r) && r.Number != 0 {
+			event.ASN, event.ASNOrg = strconv.FormatUint(uint64(r.Number), 10), r.Org
+		}
+	}
+}
+
+// mmdbFile is a MaxMind database that is checked for changes as lookups
+// are made, at most every enrichCheckInterval, and read again when it has
+// changed, so geoipupdate can replace it without a restart. A database
+// that can't be read is logged and lookups find nothing until a good one
+// is in place; the previous one, if there was one, stays in use.
+type mmdbFile struct {
+	flag, path string
+	check      fileCheck
+
+	db atomic.Pointer[maxminddb.Reader]
+
+	mu      sync.Mutex // held while reading the file
+	modTime time.Time
+	size    int64 // -1 while the file is missing
+}
+
+func newMMDBFile(flag, path string) *mmdbFile {
+	d := &mmdbFile{flag: flag, path: path}
+	d.check.start(enrichCheckInterval)
+	d.load()
+	return d
+}
+
+// load reads the database if it changed since it was last read.
+func (d *mmdbFile) load() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		// A missing file is logged once, not at every check.
+		switch {
+		case d.size < 0:
+		case d.db.Load() == nil:
+			slog.Warn("MaxMind database unavailable, events are sent without its fields", "flag", d.flag, "file", d.path, "err", err)
+		default:
+			slog.Error("Keeping the current MaxMind database", "flag", d.flag, "err", err)
+		}
+		d.modTime, d.size = time.Time{}, -1
+		return
+	}
+	if fi.ModTime().Equal(d.modTime) && fi.Size() == d.size {
+		return
+	}
+	// Whatever the outcome, the file isn't read again until it changes.
+	d.modTime, d.size = fi.ModTime(), fi.Size()
+	db, err := openMMDB(d.flag, d.path)
+	if err != nil {
+		if d.db.Load() == nil {
+			slog.Warn("MaxMind database unusable, events are sent without its fields", "err", err)
+		} else {
+			slog.Error("Keeping the current MaxMind database", "flag", d.flag, "err", err)
+		}
+		return
+	}
+	// The database is read into memory rather than mapped, so lookups
+	// still using the previous one are safe.
+	msg := "Loaded MaxMind database"
+	if d.db.Swap(db) != nil {
+		msg = "Reloaded MaxMind database"
+	}
+	slog.Info(msg, "file", d.path, "type", db.Metadata.DatabaseType,
+		"built", time.Unix(int64(db.Metadata.BuildEpoch), 0).UTC().Format(time.DateOnly))
+}
+
+func openMMDB(flag, path string) (*maxminddb.Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", flag, err)
+	}
+	db, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("open %s %s: %w", flag, path, err)
+	}
+	return db, nil
+}
+
+// lookup decodes the record for ip into record, reporting whether there
+// is one.
+func (d *mmdbFile) lookup(ip net.IP, record any) bool {
+	if d.check.due() {
+		d.load()
+	}
+	db := d.db.Load()
+	if db == nil {
+		return false
+	}
+	_, ok, err := db.LookupNetwork(ip, record)
+	return ok && err == nil
+}
+
+// fileCheck spaces out checks of a file for changes, made on the way by
+// lookups rather than by a goroutine of their own.
+type fileCheck struct {
+	every time.Duration
+	last  atomic.Int64 // UnixNano
+}
+
+func (c *fileCheck) start(every time.Duration) {
+	c.every = every
+	c.last.Store(time.Now().UnixNano())
+}
+
+// due reports whether it is time to check again. Of the callers that find
+// it is at the same time, only one is told so; the others go on with what
+// they have.
+func (c *fileCheck) due() bool {
+	now := time.Now().UnixNano()
+	last := c.last.Load()
+	return now-last >= int64(c.every) && c.last.CompareAndSwap(last, now)
+}
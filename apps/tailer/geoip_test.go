@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeMMDB writes a MaxMind DB of IPv4 networks to their records: a
+// 24-bit search tree, the records in the data section, and the metadata.
+func writeMMDB(t testing.TB, path string, records map[string]map[string]any) {
+	type node struct {
+		next [2]*node
+		data [2]int // data section offset + 1, 0 for none
+	}
+	root := &node{}
+	var data []byte
+	for network, record := range records {
+		prefix := netip.MustParsePrefix(network)
+		offset := len(data)
+		data = mmdbValue(data, record)
+
+		ip, n := prefix.Addr().As4(), root
+		for i := 0; i < prefix.Bits(); i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if i == prefix.Bits()-1 {
+				n.data[bit] = offset + 1
+				break
+			}
+			if n.next[bit] == nil {
+				n.next[bit] = &node{}
+			}
+			n = n.next[bit]
+		}
+	}
+
+	nodes, index := []*node{root}, map[*node]int{root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, next := range nodes[i].next {
+			if next != nil {
+				index[next] = len(nodes)
+				nodes = append(nodes, next)
+			}
+		}
+	}
+	var db []byte
+	for _, n := range nodes {
+		for bit := range 2 {
+			record := len(nodes) // no data
+			switch {
+			case n.next[bit] != nil:
+				record = index[n.next[bit]]
+			case n.data[bit] > 0:
+				record = len(nodes) + 16 + n.data[bit] - 1
+			}
+			db = append(db, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	db = append(db, make([]byte, 16)...)
+	db = append(db, data...)
+	db = append(db, "\xab\xcd\xefMaxMind.com"...)
+	db = mmdbValue(db, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               "Test",
+		"description":                 map[string]any{},
+		"ip_version":                  uint16(4),
+		"languages":                   []any{},
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(24),
+	})
+	if err := os.WriteFile(path, db, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mmdbValue appends v in the MaxMind DB data format. Maps and arrays must
+// be shorter than 29, and strings than 285.
+func mmdbValue(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case string:
+		if len(v) < 29 {
+			return append(append(b, 0x40|byte(len(v))), v...)
+		}
+		return append(append(b, 0x40|29, byte(len(v)-29)), v...)
+	case uint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xa2), v)
+	case uint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xc4), v)
+	case uint64:
+		return binary.BigEndian.AppendUint64(append(b, 0x08, 9-7), v)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = append(b, 0xe0|byte(len(v)))
+		for _, k := range keys {
+			b = mmdbValue(mmdbValue(b, k), v[k])
+		}
+		return b
+	case []any:
+		b = append(b, byte(len(v)), 11-7)
+		for _, e := range v {
+			b = mmdbValue(b, e)
+		}
+		return b
+	}
+	panic("unsupported type")
+}
+
+func countries(byNetwork map[string]string) map[string]map[string]any {
+	records := map[string]map[string]any{}
+	for network, country := range byNetwork {
+		records[network] = map[string]any{"country": map[string]any{"iso_code": country}}
+	}
+	return records
+}
+
+func TestGeoIP(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{GeoIPDB: filepath.Join(dir, "country.mmdb"), ASNDB: filepath.Join(dir, "asn.mmdb")}
+	writeMMDB(t, cfg.GeoIPDB, countries(map[string]string{"203.0.113.0/24": "US", "198.51.100.0/25": "DE"}))
+	writeMMDB(t, cfg.ASNDB, map[string]map[string]any{
+		"203.0.113.0/24": {"autonomous_system_number": uint32(15169), "autonomous_system_organization": "GOOGLE"},
+	})
+
+	g := NewGeoIP(cfg)
+	for _, tt := range []struct {
+		ip           string
+		country, asn string
+	}{
+		{ip: "203.0.113.7", country: "US", asn: "15169"},
+		{ip: "198.51.100.1", country: "DE"},
+		{ip: "198.51.100.200"},
+		{ip: "192.0.2.1"},
+		{ip: "2001:db8::1"},
+		{ip: ""},
+		{ip: "not an address"},
+	} {
+		e := &CrawlEvent{ClientIP: tt.ip}
+		g.Enrich(e)
+		if e.Country != tt.country || e.ASN != tt.asn {
+			t.Errorf("%q: country %q, asn %q; want %q, %q", tt.ip, e.Country, e.ASN, tt.country, tt.asn)
+		}
+		if tt.asn != "" && e.ASNOrg != "GOOGLE" {
+			t.Errorf("%q: asn_org %q, want GOOGLE", tt.ip, e.ASNOrg)
+		}
+	}
+
+	country := func(g *GeoIP) string {
+		e := &CrawlEvent{ClientIP: "203.0.113.7"}
+		g.Enrich(e)
+		return e.Country
+	}
+	// A new database is picked up at the next check; a corrupt one, or
+	// none, keeps the one in use.
+	g.country.check.every = 0
+	writeMMDB(t, cfg.GeoIPDB, countries(map[string]string{"203.0.113.0/24": "FR"}))
+	os.Chtimes(cfg.GeoIPDB, time.Now(), time.Now().Add(time.Minute))
+	if got := country(g); got != "FR" {
+		t.Errorf("after reload country = %q, want FR", got)
+	}
+	os.WriteFile(cfg.GeoIPDB, []byte("not a database"), 0o644)
+	if got := country(g); got != "FR" {
+		t.Errorf("after corrupt reload country = %q, want FR", got)
+	}
+	os.Remove(cfg.GeoIPDB)
+	if got := country(g); got != "FR" {
+		t.Errorf("after removal country = %q, want FR", got)
+	}
+
+	// Without a usable database there is no country, until there is one.
+	missing := NewGeoIP(Config{GeoIPDB: filepath.Join(dir, "missing.mmdb")})
+	if got := country(missing); got != "" {
+		t.Errorf("missing database: country = %q, want none", got)
+	}
+	corrupt := filepath.Join(dir, "corrupt.mmdb")
+	os.WriteFile(corrupt, []byte("not a database"), 0o644)
+	if got := country(NewGeoIP(Config{GeoIPDB: corrupt})); got != "" {
+		t.Errorf("corrupt database: country = %q, want none", got)
+	}
+	missing.country.check.every = 0
+	writeMMDB(t, missing.country.path, countries(map[string]string{"203.0.113.0/24": "US"}))
+	if got := country(missing); got != "US" {
+		t.Errorf("database added later: country = %q, want US", got)
+	}
+}
+
+func BenchmarkGeoIPLookup(b *testing.B) {
+	db := filepath.Join(b.TempDir(), "country.mmdb")
+	writeMMDB(b, db, countries(map[string]string{"203.0.113.0/24": "US", "198.51.100.0/24": "DE", "192.0.2.0/24": "JP"}))
+	g := NewGeoIP(Config{GeoIPDB: db})
+	b.ReportAllocs()
+	for range b.N {
+		e := CrawlEvent{ClientIP: "203.0.113.7"}
+		if g.Enrich(&e); e.Country != "US" {
+			b.Fatal("wrong country")
+		}
+	}
+}
@@ -2,10 +2,26 @@ module github.com/originaryx/trace/tailer
 
 go 1.22
 
-require github.com/nxadm/tail v1.4.11
+require (
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/nxadm/tail v1.4.11
+	github.com/oschwald/maxminddb-golang v1.13.1
+	github.com/segmentio/kafka-go v0.4.48
+	go.etcd.io/bbolt v1.3.11
+	go.opentelemetry.io/proto/otlp v1.3.1
+	golang.org/x/net v0.32.0
+	golang.org/x/sys v0.28.0
+	golang.org/x/text v0.21.0
+	google.golang.org/grpc v1.70.0
+	google.golang.org/protobuf v1.36.5
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/fsnotify/fsnotify v1.6.0 // indirect
-	golang.org/x/sys v0.5.0 // indirect
+	github.com/grpc-ecosystem/grpc-gateway/v2 v2.20.0 // indirect
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20241202173237-19429a94021a // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20241202173237-19429a94021a // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
 )
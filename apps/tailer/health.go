@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health answers the -health-addr probes: /healthz while the process is
+// alive and its tails are reading, /readyz while it is also keeping up
+// with delivery. Each responds with the checks it made, as JSON, and with
+// 503 if any failed.
+type Health struct {
+	cfg       Config
+	m         *Metrics
+	watcher   *Watcher       // nil unless tailing files
+	positions *PositionStore // nil without -position-file
+}
+
+// healthCheck is the outcome of one check, as reported in the response.
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// healthReport is the response to a probe.
+type healthReport struct {
+	Status string                 `json:"status"` // ok or fail
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+// Handler serves /healthz and /readyz.
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, h.live(time.Now()))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		checks := h.live(time.Now())
+		for name, c := range h.ready(time.Now()) {
+			checks[name] = c
+		}
+		writeHealth(w, checks)
+	})
+	return mux
+}
+
+// live checks that every tail is still reading.
+func (h *Health) live(now time.Time) map[string]healthCheck {
+	checks := map[string]healthCheck{}
+	if h.watcher != nil {
+		if ended := h.watcher.Ended(); len(ended) > 0 {
+			checks["tails"] = healthCheck{Detail: "stopped reading " + strings.Join(ended, ", ")}
+		} else {
+			checks["tails"] = healthCheck{OK: true, Detail: fmt.Sprintf("reading %d files", len(h.watcher.Files()))}
+		}
+	}
+	return checks
+}
+
+// alive returns why /healthz would fail, or nil if it wouldn't.
+func (h *Health) alive(now time.Time) error {
+	for name, c := range h.live(now) {
+		if !c.OK {
+			return fmt.Errorf("%s: %s", name, c.Detail)
+		}
+	}
+	return nil
+}
+
+// ready checks that the API hasn't been failing for longer than
+// -ready-max-outage, if set, that the queue is below -ready-queue-high,
+// and that positions can be saved.
+func (h *Health) ready(now time.Time) map[string]healthCheck {
+	checks := map[string]healthCheck{}
+
+	api := healthCheck{OK: true}
+	if since := h.m.FailingSince.Load(); since != 0 {
+		failing := now.Sub(time.UnixMilli(since)).Round(time.Second)
+		api.Detail = fmt.Sprintf("failing for %s", failing)
+		if h.cfg.ReadyMaxOutage > 0 && failing > h.cfg.ReadyMaxOutage {
+			api.OK = false
+			api.Detail += fmt.Sprintf(", more than -ready-max-outage %s", h.cfg.ReadyMaxOutage)
+		}
+	}
+	checks["api"] = api
+
+	depth, high := h.m.QueueDepth.Load(), int64(h.cfg.ReadyQueueHigh*float64(h.cfg.QueueSize))
+	queue := healthCheck{OK: depth < high, Detail: fmt.Sprintf("%d of %d events queued", depth, h.cfg.QueueSize)}
+	if !queue.OK {
+		queue.Detail += fmt.Sprintf(", at least -ready-queue-high %g", h.cfg.ReadyQueueHigh)
+	}
+	checks["queue"] = queue
+
+	if h.positions != nil {
+		if err := h.positions.Writable(); err != nil {
+			checks["positions"] = healthCheck{Detail: err.Error()}
+		} else {
+			checks["positions"] = healthCheck{OK: true}
+		}
+	}
+	return checks
+}
+
+func writeHealth(w http.ResponseWriter, checks map[string]healthCheck) {
+	report := healthReport{Status: "ok", Checks: checks}
+	code := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			report.Status, code = "fail", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+// HealthServer serves the probes of a Health.
+type HealthServer struct {
+	srv *http.Server
+}
+
+// StartHealthServer listens on addr and serves h in the background.
+func StartHealthServer(addr string, h *Health) (*HealthServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	hs := &HealthServer{srv: &http.Server{Handler: h.Handler(), ReadHeaderTimeout: 5 * time.Second}}
+	go func() {
+		if err := hs.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Health server failed", "err", err)
+		}
+	}()
+	slog.Info("Serving health checks", "url", "http://"+ln.Addr().String()+"/healthz")
+	return hs, nil
+}
+
+func (hs *HealthServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	hs.srv.Shutdown(ctx)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "file")
+	os.WriteFile(blocker, nil, 0o644)
+	tests := []struct {
+		name         string
+		path         string
+		maxOutage    time.Duration
+		failingFor   time.Duration // 0 for not failing
+		queued       int64
+		positionFile string
+		wantCode     int
+		wantFailed   string // the check that failed, if any
+	}{
+		{name: "ready", path: "/readyz", positionFile: filepath.Join(dir, "new", "positions.json"), wantCode: 200},
+		{name: "outage ignored", path: "/readyz", failingFor: time.Hour, wantCode: 200},
+		{name: "short outage", path: "/readyz", maxOutage: 5 * time.Minute, failingFor: time.Minute, wantCode: 200},
+		{name: "long outage", path: "/readyz", maxOutage: 5 * time.Minute, failingFor: 10 * time.Minute, wantCode: 503, wantFailed: "api"},
+		{name: "queue high", path: "/readyz", queued: 90, wantCode: 503, wantFailed: "queue"},
+		{name: "positions unwritable", path: "/readyz", positionFile: filepath.Join(blocker, "positions.json"), wantCode: 503, wantFailed: "positions"},
+		{name: "alive regardless", path: "/healthz", maxOutage: time.Minute, failingFor: time.Hour, queued: 100, wantCode: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.ReadyMaxOutage = tt.maxOutage
+			cfg.ReadyQueueHigh = 0.9
+			m := NewMetrics()
+			if tt.failingFor > 0 {
+				m.FailingSince.Set(time.Now().Add(-tt.failingFor).UnixMilli())
+			}
+			m.QueueDepth.Set(tt.queued)
+			h := &Health{cfg: cfg, m: m}
+			if tt.positionFile != "" {
+				h.positions = &PositionStore{path: tt.positionFile}
+			}
+
+			rec := httptest.NewRecorder()
+			h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			var report healthReport
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			for name, c := range report.Checks {
+				if !c.OK && name != tt.wantFailed {
+					t.Errorf("check %s failed: %s", name, c.Detail)
+				}
+			}
+			if tt.wantFailed != "" && report.Checks[tt.wantFailed].OK {
+				t.Errorf("check %s passed, want it failed", tt.wantFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// runHeartbeats sends a heartbeat every interval until ctx is done.
+// files returns the log files being read. Heartbeats are best effort: a
+// failed one is logged at debug level and its counters roll into the
+// next.
+func runHeartbeats(ctx context.Context, api *client.Client, meta *agentMeta, interval time.Duration, files func() []string) {
+	prev := metrics.snapshot()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cur := metrics.snapshot()
+		hb := &client.Heartbeat{
+			AgentVersion:  meta.version,
+			Hostname:      meta.host,
+			InstanceID:    meta.instanceID,
+			Files:         files(),
+			PeriodMs:      cur.at.Sub(prev.at).Milliseconds(),
+			LinesRead:     cur.lines - prev.lines,
+			ParseErrors:   cur.parseErrors - prev.parseErrors,
+			EventsSent:    cur.sent - prev.sent,
+			EventsDropped: cur.dropped - prev.dropped,
+			QueueDepth:    metrics.QueueDepth.Load(),
+		}
+		if err := api.SendHeartbeat(ctx, hb); err != nil {
+			slog.Debug("Heartbeat failed", "err", err)
+			continue
+		}
+		prev = cur
+	}
+}
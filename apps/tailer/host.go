@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// normalizeHost returns host in the form events carry it: lower case,
+// without a port or a trailing dot, and with internationalised names in
+// their ASCII (punycode) form, so that one property's requests are counted
+// under one name. It reports false for what isn't a DNS name of RFC 1123
+// shape: IP addresses, "_" and other junk.
+func normalizeHost(host string) (string, bool) {
+	host = strings.TrimSuffix(stripPort(host), ".")
+	if _, err := netip.ParseAddr(host); err == nil {
+		return host, false
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil || !isDNSName(ascii) {
+		return host, false
+	}
+	return ascii, true
+}
+
+// isDNSName reports whether name, in lower case, is made of labels of
+// letters, digits and inner hyphens, at most 63 bytes each and 253 in all.
+func isDNSName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			if c := label[i]; !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
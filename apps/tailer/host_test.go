@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+		ok   bool
+	}{
+		{"example.com", "example.com", true},
+		{"WWW.Example.COM", "www.example.com", true},
+		{"example.com:8443", "example.com", true},
+		{"example.com.", "example.com", true},
+		{"Example.com.:443", "example.com", true},
+		{"localhost", "localhost", true},
+		{"Bücher.example", "xn--bcher-kva.example", true},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example", true},
+		{"a-1.b2.example", "a-1.b2.example", true},
+		{"203.0.113.7", "", false},
+		{"203.0.113.7:80", "", false},
+		{"[2001:db8::1]:443", "", false},
+		{"_", "", false},
+		{"example.com%00", "", false},
+		{"ex ample.com", "", false},
+		{"a_b.example", "", false},
+		{"-bad.example", "", false},
+		{"bad-.example", "", false},
+		{"a..example", "", false},
+		{strings.Repeat("a", 64) + ".example", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeHost(tt.host)
+		if ok != tt.ok || ok && got != tt.want {
+			t.Errorf("normalizeHost(%q) = %q, %v; want %q, %v", tt.host, got, ok, tt.want, tt.ok)
+		}
+	}
+}
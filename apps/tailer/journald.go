@@ -0,0 +1,189 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// journalRestartDelay is how long to wait before restarting journalctl
+// after it exits unexpectedly.
+const journalRestartDelay = 5 * time.Second
+
+// Journal follows the systemd journal of one or more units through
+// `journalctl --follow --output=json` and feeds the MESSAGE of each entry
+// to the pipeline. The cursor of the last entry read is kept in the
+// position file, so a restart resumes after it.
+type Journal struct {
+	units     []string
+	key       string // position file key
+	process   func(source, line string) error
+	positions *PositionStore
+	fromStart bool
+	path      string
+
+	ctx    context.Context // cancelled to stop, which kills journalctl
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartJournal starts following the journal of units, until Stop or until
+// ctx is cancelled.
+func StartJournal(ctx context.Context, units []string, process func(source, line string) error, positions *PositionStore, fromBeginning bool) (*Journal, error) {
+	path, err := exec.LookPath("journalctl")
+	if err != nil {
+		return nil, fmt.Errorf("find journalctl: %w", err)
+	}
+	j := &Journal{
+		units:     units,
+		key:       "journald:" + strings.Join(units, ","),
+		process:   process,
+		positions: positions,
+		fromStart: fromBeginning,
+		path:      path,
+		done:      make(chan struct{}),
+	}
+	j.ctx, j.cancel = context.WithCancel(ctx)
+	slog.Info("Reading the journal", "units", strings.Join(units, ", "))
+	go j.run()
+	return j, nil
+}
+
+// Stop stops journalctl and waits for the entries read so far to reach
+// the pipeline.
+func (j *Journal) Stop() {
+	j.cancel()
+	<-j.done
+}
+
+func (j *Journal) run() {
+	defer close(j.done)
+	for {
+		err := j.follow()
+		if j.ctx.Err() != nil {
+			return
+		}
+		slog.Error("journalctl exited, restarting", "err", err, "delay", journalRestartDelay)
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-time.After(journalRestartDelay):
+		}
+	}
+}
+
+// follow runs journalctl until it exits or is killed by Stop or ctx.
+func (j *Journal) follow() error {
+	cursor := ""
+	if j.positions != nil {
+		cursor = j.positions.ResumeCursor(j.key)
+	}
+	cmd := exec.CommandContext(j.ctx, j.path, journalArgs(j.units, cursor, j.fromStart)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("pipe journalctl output: %w", err)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start journalctl: %w", err)
+	}
+	if cursor != "" {
+		slog.Info("Resuming the journal", "units", strings.Join(j.units, ", "), "cursor", cursor)
+	}
+	// Only the first run may start from the beginning; a restart without
+	// a position file picks up new entries only.
+	j.fromStart = false
+
+	br := bufio.NewReader(stdout)
+	for {
+		data, err := br.ReadBytes('\n')
+		if len(data) > 0 {
+			j.handle(data)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			cmd.Process.Kill()
+			break
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return errors.New("journalctl ended its output")
+}
+
+// journalArgs returns the journalctl arguments to follow units after
+// cursor, or when there is no cursor from the start of the journal or
+// from now on.
+func journalArgs(units []string, cursor string, fromStart bool) []string {
+	args := []string{"--follow", "--output=json", "--no-pager", "--all"}
+	for _, unit := range units {
+		args = append(args, "--unit="+unit)
+	}
+	switch {
+	case cursor != "":
+		args = append(args, "--after-cursor="+cursor)
+	case fromStart:
+		args = append(args, "--lines=all")
+	default:
+		args = append(args, "--lines=0")
+	}
+	return args
+}
+
+// journalEntry holds the fields of a journalctl JSON entry the tailer
+// uses. MESSAGE is a string, or an array of bytes when it isn't valid
+// UTF-8.
+type journalEntry struct {
+	Cursor  string          `json:"__CURSOR"`
+	Unit    string          `json:"_SYSTEMD_UNIT"`
+	Message json.RawMessage `json:"MESSAGE"`
+}
+
+func (j *Journal) handle(data []byte) {
+	var e journalEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		slog.Warn("Skipped unreadable journal entry", "err", err)
+		return
+	}
+	if msg, ok := journalMessage(e.Message); ok && msg != "" {
+		j.process("journald:"+e.Unit, msg)
+	}
+	// After the entry's event is queued, as for files.
+	if j.positions != nil && e.Cursor != "" {
+		j.positions.UpdateCursor(j.key, e.Cursor)
+	}
+}
+
+// journalMessage decodes a MESSAGE field.
+func journalMessage(raw json.RawMessage) (string, bool) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strings.TrimRight(s, "\n"), true
+	}
+	var b []byte
+	var bs []int
+	if err := json.Unmarshal(raw, &bs); err != nil {
+		return "", false
+	}
+	for _, c := range bs {
+		b = append(b, byte(c))
+	}
+	return strings.TrimRight(string(b), "\n"), true
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Journal is only available on Linux builds.
+type Journal struct{}
+
+// StartJournal reports that -input journald isn't supported here.
+func StartJournal(ctx context.Context, units []string, process func(source, line string) error, positions *PositionStore, fromBeginning bool) (*Journal, error) {
+	return nil, errors.New("-input journald is only supported on Linux")
+}
+
+func (j *Journal) Stop() {}
@@ -0,0 +1,87 @@
+//go:build linux
+
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestJournalArgs(t *testing.T) {
+	base := []string{"--follow", "--output=json", "--no-pager", "--all", "--unit=nginx.service"}
+	tests := []struct {
+		cursor    string
+		fromStart bool
+		want      string
+	}{
+		{"s=abc;i=1", true, "--after-cursor=s=abc;i=1"},
+		{"", true, "--lines=all"},
+		{"", false, "--lines=0"},
+	}
+	for _, tt := range tests {
+		got := journalArgs([]string{"nginx.service"}, tt.cursor, tt.fromStart)
+		if want := append(slices.Clone(base), tt.want); !slices.Equal(got, want) {
+			t.Errorf("journalArgs(%q, %v) = %q, want %q", tt.cursor, tt.fromStart, got, want)
+		}
+	}
+}
+
+func TestJournalHandle(t *testing.T) {
+	positions, err := LoadPositions(filepath.Join(t.TempDir(), "positions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	j := &Journal{
+		key:       "journald:nginx.service",
+		positions: positions,
+		process: func(source, line string) error {
+			got = append(got, source+" "+line)
+			return nil
+		},
+	}
+
+	j.handle([]byte(`{"__CURSOR":"s=a;i=1","_SYSTEMD_UNIT":"nginx.service","MESSAGE":"GET /a\n"}` + "\n"))
+	// "GET /b" with an invalid UTF-8 byte, which journalctl writes as an array.
+	j.handle([]byte(`{"__CURSOR":"s=a;i=2","_SYSTEMD_UNIT":"nginx.service","MESSAGE":[71,69,84,32,47,98,255]}`))
+	j.handle([]byte(`{"__CURSOR":"s=a;i=3","_SYSTEMD_UNIT":"nginx.service","MESSAGE":null}`))
+	j.handle([]byte(`not json`))
+
+	want := []string{"journald:nginx.service GET /a", "journald:nginx.service GET /b\xff"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if c := positions.ResumeCursor(j.key); c != "s=a;i=3" {
+		t.Errorf("cursor %q, want s=a;i=3", c)
+	}
+}
+
+// TestJournalCursorAfterProcess saves positions while an entry is being
+// processed, as the periodic sync may, and checks that the cursor saved
+// isn't yet that entry's.
+func TestJournalCursorAfterProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "positions.json")
+	positions, err := LoadPositions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []string
+	j := &Journal{key: "journald:nginx.service", positions: positions}
+	j.process = func(source, line string) error {
+		if err := positions.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		onDisk, err := LoadPositions(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, onDisk.ResumeCursor(j.key))
+		return nil
+	}
+	j.handle([]byte(`{"__CURSOR":"s=a;i=1","_SYSTEMD_UNIT":"nginx.service","MESSAGE":"GET /a"}`))
+	j.handle([]byte(`{"__CURSOR":"s=a;i=2","_SYSTEMD_UNIT":"nginx.service","MESSAGE":"GET /b"}`))
+	if want := []string{"", "s=a;i=1"}; !slices.Equal(saved, want) {
+		t.Errorf("cursors saved while processing %q, want %q", saved, want)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaBatchTimeout is how long the producer waits for more messages for
+// a partition before writing it. The sender batches already, so there is
+// never more to wait for.
+const kafkaBatchTimeout = 5 * time.Millisecond
+
+var kafkaAcks = map[string]kafka.RequiredAcks{
+	"all":    kafka.RequireAll,
+	"leader": kafka.RequireOne,
+	"none":   kafka.RequireNone,
+}
+
+var kafkaCodecs = map[string]kafka.Compression{
+	"none":   0,
+	"gzip":   kafka.Gzip,
+	"snappy": kafka.Snappy,
+	"lz4":    kafka.Lz4,
+	"zstd":   kafka.Zstd,
+}
+
+// kafkaWriter is the part of *kafka.Writer the sink uses.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaSink produces events to a Kafka topic as the JSON the API takes,
+// keyed by host so that each host's events stay in order on a partition.
+// The producer makes a single attempt per batch: retries are the
+// sender's, as for the API.
+type KafkaSink struct {
+	w       kafkaWriter
+	timeout time.Duration
+}
+
+// NewKafkaSink returns the sink for the -kafka-* flags of cfg. It
+// connects to the brokers when first used.
+func NewKafkaSink(cfg Config) (*KafkaSink, error) {
+	if err := validateKafka(cfg); err != nil {
+		return nil, err
+	}
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(kafkaBrokers(cfg)...),
+		Topic:        cfg.KafkaTopic,
+		Balancer:     &kafka.Hash{},
+		MaxAttempts:  1,
+		BatchSize:    cfg.BatchSize,
+		BatchTimeout: kafkaBatchTimeout,
+		RequiredAcks: kafkaAcks[cfg.KafkaAcks],
+		Compression:  kafkaCodecs[cfg.KafkaCompress],
+	}
+	return &KafkaSink{w: w, timeout: cfg.KafkaTimeout}, nil
+}
+
+// Send produces events and returns once the brokers have acknowledged
+// them as -kafka-acks requires. If some fail, the whole batch is retried,
+// so those that went through are produced again.
+func (k *KafkaSink) Send(ctx context.Context, events []*CrawlEvent) error {
+	msgs := make([]kafka.Message, len(events))
+	for i, e := range events {
+		value, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("marshal event: %w", err)
+		}
+		msgs[i] = kafka.Message{Key: []byte(e.Host), Value: value}
+	}
+	ctx, cancel := context.WithTimeout(ctx, k.timeout)
+	defer cancel()
+	err := k.w.WriteMessages(ctx, msgs...)
+	var werrs kafka.WriteErrors
+	if errors.As(err, &werrs) {
+		for _, werr := range werrs {
+			if werr != nil {
+				return fmt.Errorf("produce %d of %d events to kafka: %w", werrs.Count(), len(msgs), werr)
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("produce to kafka: %w", err)
+	}
+	return nil
+}
+
+// Close flushes anything the producer still holds and closes its
+// connections.
+func (k *KafkaSink) Close() error {
+	return k.w.Close()
+}
+
+func kafkaBrokers(cfg Config) []string {
+	var brokers []string
+	for _, b := range strings.Split(cfg.KafkaBrokers, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	return brokers
+}
+
+func validateKafka(cfg Config) error {
+	if len(kafkaBrokers(cfg)) == 0 || cfg.KafkaTopic == "" {
+		return errors.New("-sink kafka needs -kafka-brokers and -kafka-topic")
+	}
+	if _, ok := kafkaAcks[cfg.KafkaAcks]; !ok {
+		return fmt.Errorf("unknown -kafka-acks %q (want all, leader or none)", cfg.KafkaAcks)
+	}
+	if _, ok := kafkaCodecs[cfg.KafkaCompress]; !ok {
+		return fmt.Errorf("unknown -kafka-compression %q (want none, gzip, snappy, lz4 or zstd)", cfg.KafkaCompress)
+	}
+	if cfg.KafkaTimeout <= 0 {
+		return errors.New("-kafka-timeout must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeKafka records the messages written and fails them with err.
+type fakeKafka struct {
+	msgs []kafka.Message
+	err  error
+}
+
+func (f *fakeKafka) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.msgs = append(f.msgs, msgs...)
+	return f.err
+}
+
+func (f *fakeKafka) Close() error { return nil }
+
+func TestKafkaSink(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+		class     string
+	}{
+		{name: "delivered"},
+		{name: "partly failed", err: kafka.WriteErrors{nil, kafka.LeaderNotAvailable}, retryable: true, class: "other"},
+		{name: "all delivered", err: kafka.WriteErrors{nil, nil}},
+		{name: "too large", err: kafka.MessageSizeTooLarge, class: "other"},
+		{name: "broker down", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, retryable: true, class: "network"},
+		{name: "timed out", err: context.DeadlineExceeded, retryable: true, class: "network"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &fakeKafka{err: tt.err}
+			k := &KafkaSink{w: w, timeout: time.Second}
+			events := []*CrawlEvent{{Host: "a.example", Path: "/1"}, {Host: "b.example", Path: "/2"}}
+			err := k.Send(context.Background(), events)
+			if len(w.msgs) != 2 || string(w.msgs[1].Key) != "b.example" {
+				t.Fatalf("produced %+v, want 2 messages keyed by host", w.msgs)
+			}
+			want, _ := json.Marshal(events[1])
+			if string(w.msgs[1].Value) != string(want) {
+				t.Errorf("value = %s, want %s", w.msgs[1].Value, want)
+			}
+			if tt.class == "" {
+				if err != nil {
+					t.Errorf("Send = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Send = nil, want an error")
+			}
+			if retryable(err) != tt.retryable || errorClass(err) != tt.class {
+				t.Errorf("%v: retryable %v, class %s; want %v, %s", err, retryable(err), errorClass(err), tt.retryable, tt.class)
+			}
+		})
+	}
+}
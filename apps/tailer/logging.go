@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// logLevel is the -log-level in effect; SIGHUP may change it.
+var logLevel = new(slog.LevelVar)
+
+// serviceLog is set when the tailer runs as a Windows service, which has
+// no standard error: it returns the handler that logs instead, given how
+// to make a -log-format handler writing to w.
+var serviceLog func(handler func(w io.Writer) slog.Handler) slog.Handler
+
+// setupLogging makes a -log-format handler writing to -log-file, or else
+// to standard error, the default logger. The standard log package, which
+// dependencies may use, writes through it at info level.
+func setupLogging(cfg Config) {
+	level, _ := parseLogLevel(cfg.LogLevel) // checked by validate
+	logLevel.Set(level)
+	opts := &slog.HandlerOptions{Level: logLevel}
+	handler := func(w io.Writer) slog.Handler {
+		if cfg.LogFormat == "json" {
+			return slog.NewJSONHandler(w, opts)
+		}
+		return slog.NewTextHandler(w, opts)
+	}
+
+	var h slog.Handler
+	var err error
+	if cfg.LogFile != "" {
+		var f *os.File
+		if f, err = os.OpenFile(cfg.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640); err == nil {
+			h = handler(f)
+		}
+	}
+	switch {
+	case h != nil:
+	case serviceLog != nil:
+		h = serviceLog(handler)
+	default:
+		h = handler(os.Stderr)
+	}
+	slog.SetDefault(slog.New(h))
+	if err != nil {
+		slog.Error("Failed to open -log-file; logging here instead", "err", err)
+	}
+}
+
+// parseLogLevel parses a -log-level value.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown -log-level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
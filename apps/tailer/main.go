@@ -1,169 +1,537 @@
 package main
 
 import (
-	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"net/http"
-	"regexp"
-	"strconv"
+	"log/slog"
+	"math"
+	"net/url"
+	"os"
+	"slices"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/nxadm/tail"
+	"github.com/originaryx/trace/tailer/pkg/client"
+	"github.com/originaryx/trace/tailer/pkg/event"
+	"github.com/originaryx/trace/tailer/pkg/parser"
 )
 
-var lineRe = regexp.MustCompile(`^(\d+\.\d+)\s+"(\w+)\s+([^\s]+)\s+HTTP/[\d.]+"\s+(\d+)\s+(\d+)\s+"([^"]*)"\s+([^\s]+)\s+([^\s]*)\s+([\d.]+)\s+([^\s]+)\s+([^\s]+)`)
+// positionSyncInterval is how often read positions are flushed to disk.
+const positionSyncInterval = 5 * time.Second
 
-type Config struct {
-	LogFile  string
-	Endpoint string
-	APIKey   string
-	Secret   string
-}
+// version is the tailer release, set at build time with
+// -ldflags "-X main.version=...".
+var version = "dev"
 
-type CrawlEvent struct {
-	Timestamp     int64  `json:"ts"`
-	Host          string `json:"host"`
-	Path          string `json:"path"`
-	Method        string `json:"method"`
-	Status        int    `json:"status"`
-	UserAgent     string `json:"ua"`
-	IPPrefix      string `json:"ip_prefix"`
-	AcceptLang    string `json:"accept_lang,omitempty"`
-	CrawlerFamily string `json:"crawler_family"`
-	Source        string `json:"source"`
-}
+// CrawlEvent is the event type of the importable packages; the alias keeps
+// the rest of the tailer readable.
+type CrawlEvent = event.CrawlEvent
 
 func main() {
-	cfg := Config{}
-	flag.StringVar(&cfg.LogFile, "file", "/var/log/nginx/peac.log", "Path to nginx log file")
-	flag.StringVar(&cfg.Endpoint, "endpoint", "http://localhost:8787", "Originary Trace API endpoint")
-	flag.StringVar(&cfg.APIKey, "key", "", "Originary Trace API key ID")
-	flag.StringVar(&cfg.Secret, "secret", "", "Originary Trace HMAC secret")
-	flag.Parse()
-
-	if cfg.APIKey == "" || cfg.Secret == "" {
-		log.Fatal("Error: -key and -secret are required")
-	}
-
-	log.Printf("Originary Trace Nginx Tailer starting...")
-	log.Printf("Watching: %s", cfg.LogFile)
-	log.Printf("Endpoint: %s", cfg.Endpoint)
-
-	// Tail the log file
-	t, err := tail.TailFile(cfg.LogFile, tail.Config{
-		Follow:    true,
-		ReOpen:    true,
-		MustExist: false,
-		Poll:      true,
-	})
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(runCheck(os.Args[2:], os.Stdout))
+		case "bench":
+			os.Exit(runBench(os.Args[2:], os.Stdout))
+		case "service":
+			os.Exit(runServiceCommand(os.Args[2:], os.Stdout))
+		}
+	}
+	if runAsService(os.Args[1:]) {
+		return
+	}
+	run(os.Args[1:], nil)
+}
+
+// run is the tailer, configured by args. It stops on a signal, or on one
+// sent to stop, once what was read has been delivered.
+func run(args []string, stop <-chan os.Signal) {
+	cfg, checkConfig, err := parseConfig(args)
+	if errors.Is(err, flag.ErrHelp) || errors.Is(err, errVersion) {
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatalf("Failed to tail file: %v", err)
+		fatal("Invalid configuration", "err", err)
 	}
+	setupLogging(cfg)
+	key, secret, err := loadCredentials(cfg)
+	if err != nil {
+		fatal("Failed to load credentials", "err", err)
+	}
+	readStdin := slices.Contains(cfg.LogFiles, "-")
 
-	client := &http.Client{
-		Timeout: 5 * time.Second,
+	if checkConfig {
+		api, err := newClient(cfg, key, secret)
+		if err != nil {
+			fatal("Invalid configuration", "err", err)
+		}
+		if cfg.Transport == "grpc" {
+			if _, err := newGRPCClient(cfg, key, secret); err != nil {
+				fatal("Invalid configuration", "err", err)
+			}
+		}
+		if _, err := newRouter(cfg, api); err != nil {
+			fatal("Invalid configuration", "err", err)
+		}
+		for _, mirror := range cfg.Mirrors {
+			if _, err := newMirrorClient(cfg, mirror, key, secret); err != nil {
+				fatal("Invalid configuration", "err", err)
+			}
+		}
+		if _, err := newFailover(cfg, api, api); err != nil {
+			fatal("Invalid configuration", "err", err)
+		}
+		if _, err := newParser(cfg); err != nil {
+			fatal("Invalid configuration", "err", err)
+		}
+		if _, err := newRules(cfg, nil); err != nil {
+			fatal("Invalid configuration", "err", err)
+		}
+		if _, err := newUAAnonymizer(cfg); err != nil {
+			fatal("Invalid configuration", "err", err)
+		}
+		if _, err := newPathPrivacy(cfg); err != nil {
+			fatal("Invalid configuration", "err", err)
+		}
+		// A database that can't be read only warns, as it does when
+		// running.
+		NewGeoIP(cfg)
+		if _, err := NewBotRanges(cfg.BotRangesDir); err != nil {
+			fatal("Invalid configuration", "err", err)
+		}
+		if _, err := NewRobots(cfg); err != nil {
+			fatal("Invalid configuration", "err", err)
+		}
+		slog.Info("Configuration OK", "config", configHash(cfg))
+		return
 	}
 
-	for line := range t.Lines {
-		if line.Err != nil {
-			log.Printf("Error reading line: %v", line.Err)
-			continue
+	slog.Info("Originary Trace Nginx Tailer starting", "version", version, "endpoint", cfg.Endpoint, "config", configHash(cfg))
+	if len(cfg.Fallbacks) > 0 {
+		slog.Info("Failover configured", "fallbacks", strings.Join(cfg.Fallbacks, ", "), "threshold", cfg.FailoverAfter)
+	}
+	for _, mirror := range cfg.Mirrors {
+		slog.Info("Mirroring events", "endpoint", mirror.Endpoint, "sample", cfg.MirrorSample)
+	}
+	if cfg.Proxy != "" {
+		if u, err := url.Parse(cfg.Proxy); err == nil {
+			slog.Info("Using proxy", "proxy", u.Redacted())
 		}
+	}
 
-		event, err := parseLine(line.Text)
+	var positions *PositionStore
+	if cfg.PositionFile != "" {
+		positions, err = LoadPositions(cfg.PositionFile)
 		if err != nil {
-			log.Printf("Failed to parse line: %v", err)
-			continue
+			fatal("Failed to load positions", "err", err)
 		}
+		go func() {
+			for range time.Tick(positionSyncInterval) {
+				if err := positions.Sync(); err != nil {
+					slog.Error("Failed to save positions", "err", err)
+				}
+			}
+		}()
+	}
 
-		if err := sendEvent(client, cfg, event); err != nil {
-			log.Printf("Failed to send event: %v", err)
+	api, err := newClient(cfg, key, secret)
+	if err != nil {
+		fatal("Failed to set up the API client", "err", err)
+	}
+	var metricsServer *MetricsServer
+	if cfg.MetricsAddr != "" {
+		metricsServer, err = StartMetricsServer(cfg.MetricsAddr, metrics)
+		if err != nil {
+			fatal("Failed to start metrics server", "err", err)
 		}
 	}
-}
+	if cfg.OTelEndpoint != "" {
+		if telemetry, err = StartOTel(cfg, metrics); err != nil {
+			fatal("Failed to set up telemetry export", "err", err)
+		}
+	}
+	if cfg.StatsdAddr != "" {
+		if statsd, err = StartStatsd(cfg.StatsdAddr, cfg.StatsdInterval, metrics); err != nil {
+			fatal("Failed to set up statsd", "err", err)
+		}
+	}
+	var debugServer *DebugServer
+	if cfg.DebugAddr != "" {
+		debugServer, err = StartDebugServer(cfg.DebugAddr, metrics)
+		if err != nil {
+			fatal("Failed to start debug server", "err", err)
+		}
+	}
+	if cfg.StatsInterval > 0 {
+		go func() {
+			for range time.Tick(cfg.StatsInterval) {
+				metrics.LogStats()
+			}
+		}()
+	}
 
-func parseLine(line string) (*CrawlEvent, error) {
-	matches := lineRe.FindStringSubmatch(strings.TrimSpace(line))
-	if matches == nil {
-		return nil, fmt.Errorf("line did not match expected format")
-	}
-
-	status, _ := strconv.Atoi(matches[4])
-
-	uri := matches[3]
-	path := strings.Split(uri, "?")[0]
-
-	return &CrawlEvent{
-		Timestamp:     time.Now().UnixMilli(),
-		Host:          matches[10],
-		Path:          path,
-		Method:        matches[2],
-		Status:        status,
-		UserAgent:     matches[6],
-		IPPrefix:      toPrefix(matches[7]),
-		AcceptLang:    matches[8],
-		CrawlerFamily: matches[11],
-		Source:        "nginx",
-	}, nil
-}
+	// Cancelling ctx stops everything, delivery included. Cancelling
+	// readCtx only stops the inputs, so that what they read can still be
+	// sent.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	readCtx, stopReading := context.WithCancel(ctx)
 
-func toPrefix(ip string) string {
-	if strings.Contains(ip, ":") {
-		// IPv6
-		parts := strings.Split(ip, ":")
-		if len(parts) >= 3 {
-			return strings.Join(parts[:3], ":") + "::/48"
+	var spool Backlog
+	switch {
+	case cfg.DryRun:
+	case cfg.QueueBackend == "bolt":
+		queue, err := OpenQueue(cfg.QueuePath, cfg.QueueMaxBytes)
+		if err != nil {
+			fatal("Failed to open queue", "err", err)
+		}
+		if positions != nil {
+			positions.SetFlush(queue.Flush)
+		}
+		spool = queue
+	case cfg.SpoolDir != "":
+		if spool, err = OpenSpool(cfg.SpoolDir, cfg.SpoolMaxBytes); err != nil {
+			fatal("Failed to open spool", "err", err)
 		}
-		return ip
 	}
-	// IPv4
-	parts := strings.Split(ip, ".")
-	if len(parts) >= 3 {
-		return parts[0] + "." + parts[1] + "." + parts[2] + ".0/24"
+	delivery, err := NewDelivery(ctx, cfg, api, key, secret, spool)
+	if err != nil {
+		fatal("Failed to set up delivery", "err", err)
 	}
-	return ip
-}
+	sender, router := delivery.Sender, delivery.Router
 
-func sendEvent(client *http.Client, cfg Config, event *CrawlEvent) error {
-	body, err := json.Marshal(event)
+	var rejects *RejectsFile
+	if cfg.RejectsFile != "" {
+		rejects, err = OpenRejects(cfg.RejectsFile, cfg.RejectsMaxBytes)
+		if err != nil {
+			fatal("Failed to open rejects file", "err", err)
+		}
+	}
+	meta, err := loadAgentMeta(cfg)
+	if err != nil {
+		fatal("Failed to load the instance ID", "err", err)
+	}
+	eventMeta := meta
+	if cfg.NoAgentMeta {
+		eventMeta = nil
+	}
+	if cfg.BurstAlerts == "api" && !cfg.DryRun {
+		alerts = NewAlertSender(api, meta)
+	}
+	pipeline, err := NewPipeline(cfg, sender, rejects, eventMeta, router)
 	if err != nil {
-		return fmt.Errorf("marshal event: %w", err)
+		fatal("Invalid configuration", "err", err)
+	}
+	var rollups *Rollups
+	if cfg.Mode == "aggregate" {
+		send := api.SendRollup
+		if cfg.DryRun {
+			send = newRollupPrinter(os.Stdout)
+		}
+		rollups = NewRollups(cfg, send, eventMeta)
+		rollups.Start()
+		pipeline.rollups = rollups
+	}
+	if !cfg.BuiltinCrawlers {
+		signatures := NewSignatures(api, cfg.SignaturesCache, pipeline.SetSignatures)
+		signatures.LoadCache()
+		// Like heartbeats, signatures come from the HTTP API.
+		if cfg.Transport == "http" {
+			go signatures.Run(readCtx, cfg.SignaturesEvery)
+		}
+	}
+	sigs := make(chan os.Signal, 1)
+	notifySignals(sigs)
+	if stop != nil {
+		go func() {
+			for sig := range stop {
+				sigs <- sig
+			}
+		}()
 	}
 
-	signature := sign([]byte(cfg.Secret), body)
+	var watcher *Watcher
+	var checkpoints *Checkpoints
+	files := func() []string { return cfg.LogFiles }
+	inputDone := make(chan struct{})
+	if readStdin {
+		slog.Info("Reading from standard input")
+		go func() {
+			defer close(inputDone)
+			lines, parseErrors, err := readLines(readCtx, os.Stdin, "stdin", pipeline, cfg.ProgressEvery, nil)
+			if err != nil {
+				slog.Error("Stopped reading standard input", "err", err)
+			}
+			slog.Info("End of input", "lines", lines, "parse_errors", parseErrors)
+		}()
+	} else if cfg.Once {
+		replay, err := NewReplay(cfg, pipeline)
+		if err != nil {
+			fatal("Failed to start replay", "err", err)
+		}
+		if checkpoints = replay.checkpoints; checkpoints != nil {
+			if queue, ok := spool.(*Queue); ok {
+				checkpoints.SetFlush(queue.Flush)
+			}
+			go func() {
+				for range time.Tick(positionSyncInterval) {
+					if err := checkpoints.Sync(); err != nil {
+						slog.Error("Failed to save checkpoint", "err", err)
+					}
+				}
+			}()
+		}
+		if cfg.ProgressInterval > 0 {
+			go func() {
+				for range time.Tick(cfg.ProgressInterval) {
+					replay.LogProgress()
+				}
+			}()
+		}
+		slog.Info("Replaying", "files", strings.Join(cfg.LogFiles, ", "))
+		go func() {
+			defer close(inputDone)
+			lines, parseErrors, err := replay.Run(readCtx)
+			if err != nil {
+				slog.Error("Stopped replay", "err", err)
+				replayFailed.Store(true)
+			}
+			slog.Info("Replay finished reading", "lines", lines, "parse_errors", parseErrors)
+		}()
+	} else if len(cfg.LogFiles) > 0 {
+		watcher = NewWatcher(readCtx, cfg, pipeline, positions)
+		if cfg.CatchUp {
+			watcher.StartAfterCatchUp()
+		} else if err := watcher.Start(); err != nil {
+			fatal("Failed to tail files", "err", err)
+		}
+		files = watcher.Files
+	}
+	var journal *Journal
+	if cfg.Input == "journald" {
+		journal, err = StartJournal(readCtx, cfg.Units, pipeline.Process, positions, cfg.FromBeginning)
+		if err != nil {
+			fatal("Failed to read the journal", "err", err)
+		}
+	}
+	var syslog *SyslogListener
+	if len(cfg.SyslogAddrs) > 0 {
+		syslog, err = ListenSyslog(readCtx, cfg.SyslogAddrs, pipeline.Process)
+		if err != nil {
+			fatal("Failed to listen for syslog", "err", err)
+		}
+	}
+	health := &Health{cfg: cfg, m: metrics, watcher: watcher, positions: positions}
+	var healthServer *HealthServer
+	if cfg.HealthAddr != "" {
+		if healthServer, err = StartHealthServer(cfg.HealthAddr, health); err != nil {
+			fatal("Failed to start health server", "err", err)
+		}
+	}
+	// Heartbeats are for the HTTP API; nothing else takes them.
+	if cfg.HeartbeatEvery > 0 && !cfg.DryRun && cfg.Transport == "http" && slices.Contains(cfg.Sinks, "http") {
+		go runHeartbeats(readCtx, api, meta, cfg.HeartbeatEvery, files)
+	}
+	// Under systemd, ready means reading and, unless told not to check,
+	// able to reach the API events go to.
+	var ping func(context.Context) error
+	if !cfg.NoReadyCheck && !cfg.DryRun && cfg.Transport == "http" && slices.Contains(cfg.Sinks, "http") {
+		ping = api.Ping
+	}
+	go notifySystemd(readCtx, ping, cfg.RetryBase, func() error { return health.alive(time.Now()) })
 
-	req, err := http.NewRequest("POST", cfg.Endpoint+"/v1/events", bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+	// The first signal (or the end of standard input) stops reading and
+	// lets the sender flush whatever is still queued. A second signal
+	// means the operator doesn't want to wait for that.
+	interrupted := false
+wait:
+	for {
+		select {
+		case sig := <-sigs:
+			switch sig {
+			case syscall.SIGHUP:
+				cfg = reload(cfg, delivery.creds, pipeline, sender)
+				continue
+			case diagnosticsSignal:
+				metrics.LogDiagnostics()
+				continue
+			}
+			slog.Info("Shutting down; send the signal again to force", "signal", sig.String())
+			interrupted = true
+			break wait
+		case <-inputDone:
+			break wait
+		}
+	}
+	if _, err := sdNotify("STOPPING=1"); err != nil {
+		slog.Error("Failed to notify systemd", "state", "STOPPING=1", "err", err)
+	}
+	go func() {
+		for sig := range sigs {
+			if sig == diagnosticsSignal {
+				metrics.LogDiagnostics()
+			} else if sig != syscall.SIGHUP {
+				slog.Warn("Exiting immediately", "signal", sig.String())
+				os.Exit(1)
+			}
+		}
+	}()
+
+	stopReading()
+	if watcher != nil {
+		watcher.Stop()
+	}
+	if journal != nil {
+		journal.Stop()
+	}
+	if syslog != nil {
+		syslog.Close()
+	}
+	drainWait := cfg.ShutdownWait
+	if cfg.Once && !interrupted {
+		// Delivering the backfill is what the operator is waiting for;
+		// a signal still exits immediately.
+		drainWait = math.MaxInt64
+	}
+	// Rollups still open are what a shutdown must not lose.
+	if rollups != nil {
+		rollups.Close(drainWait)
+	}
+	sender.Close(drainWait)
+	delivery.Close()
+	if cfg.StatsInterval > 0 {
+		metrics.LogStats()
+	}
+
+	if positions != nil {
+		if err := positions.Sync(); err != nil {
+			slog.Error("Failed to save positions", "err", err)
+		}
+	}
+	if checkpoints != nil {
+		if err := checkpoints.Sync(); err != nil {
+			slog.Error("Failed to save checkpoint", "err", err)
+		}
+	}
+	if rejects != nil {
+		rejects.Close()
+	}
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	if healthServer != nil {
+		healthServer.Close()
+	}
+	if debugServer != nil {
+		debugServer.Close()
 	}
+	if telemetry != nil {
+		telemetry.Close()
+	}
+	if statsd != nil {
+		statsd.Close()
+	}
+	slog.Info("Shutdown complete")
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Peac-Key", cfg.APIKey)
-	req.Header.Set("X-Peac-Timestamp", fmt.Sprintf("%d", event.Timestamp))
-	req.Header.Set("X-Peac-Signature", signature)
+	if cfg.Once && (!replaySummary(cfg.MaxFailureRate) || replayFailed.Load()) {
+		os.Exit(1)
+	}
+}
+
+// replayFailed records that -once could not read all its input.
+var replayFailed atomic.Bool
+
+// replaySummary logs the outcome of -once and reports whether the share of
+// lines that failed, by not parsing or not being delivered, is within
+// maxFailureRate.
+func replaySummary(maxFailureRate float64) bool {
+	lines := metrics.LinesRead.Load()
+	parsed := lines - metrics.ParseErrors.Load()
+	failed := metrics.ParseErrors.Load() + metrics.EventsDropped.Load()
+	slog.Info("Replay summary", "lines", lines, "parsed", parsed, "filtered", metrics.Filtered(),
+		"outside_range", metrics.EventsFiltered["time_range"].Load(), "sent", metrics.EventsSent.Load(), "failed", failed)
+	if lines == 0 {
+		return true
+	}
+	if rate := float64(failed) / float64(lines); rate > maxFailureRate {
+		slog.Error("Failure rate exceeds -max-failure-rate",
+			"rate", fmt.Sprintf("%.2f%%", rate*100), "max", fmt.Sprintf("%.2f%%", maxFailureRate*100))
+		return false
+	}
+	return true
+}
 
-	resp, err := client.Do(req)
+// newClient returns the ingest API client configured by cfg.
+func newClient(cfg Config, key, secret string) (*client.Client, error) {
+	hc, err := newHTTPClient(cfg)
 	if err != nil {
-		return fmt.Errorf("send request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	c := client.New(cfg.Endpoint, key, secret)
+	c.HTTPClient = hc
+	if c.Header, err = parseHeaders(cfg.Headers); err != nil {
+		return nil, err
+	}
+	c.Timeout = cfg.HTTPTimeout
+	c.Compression = cfg.Compress
+	c.SignUncompressed = cfg.SignUncompressed
+	c.SigVersion = cfg.SigVersion
+	if cfg.SignAlg == "ed25519" {
+		if c.PrivateKey, err = loadPrivateKey(cfg.PrivateKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	c.OnRequest = func(d time.Duration) { metrics.RequestDuration.Observe(d.Seconds()) }
+	c.OnClockSkew = func(skew time.Duration) { clockSkewed(c, skew) }
+	return c, nil
+}
 
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("API returned status %d", resp.StatusCode)
+// clockSkewed reports a change of the offset c applies to its signing
+// timestamps.
+func clockSkewed(c *client.Client, skew time.Duration) {
+	metrics.ClockSkew.Set(int64(skew.Seconds()))
+	if skew == 0 {
+		slog.Info("Clock agrees with the API again; signing on local time", "endpoint", c.Endpoint)
+		return
 	}
+	slog.Warn("Clock differs from the API's; correcting signing timestamps (check NTP)", "endpoint", c.Endpoint, "skew", skew)
+}
 
-	return nil
+// newStream returns the -stream sender over api.
+func newStream(ctx context.Context, cfg Config, api *client.Client) *client.Stream {
+	s := client.NewStream(ctx, api)
+	s.MaxDuration = cfg.StreamDuration
+	s.MaxBytes = cfg.StreamMaxBytes
+	s.WriteTimeout = cfg.StreamTimeout
+	s.OnFallback = func(err error) {
+		metrics.StreamFallbacks.Inc()
+		slog.Warn("Stream failed; sending batches for now", "err", err, "retry_in", s.RetryDelay)
+	}
+	s.OnLost = func(n int, err error) {
+		metrics.EventsDropped.Add(int64(n))
+		slog.Error("Dropped events of a failed stream", "events", n, "err", err)
+	}
+	return s
 }
 
-func sign(secret, body []byte) string {
-	h := hmac.New(sha256.New, secret)
-	h.Write(body)
-	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+// newParser returns the line parser selected by -format, or compiled from
+// -line-format.
+func newParser(cfg Config) (parser.LineParser, error) {
+	if cfg.LineFormat != "" {
+		p, err := parser.NewTemplate(cfg.LineFormat)
+		if err != nil {
+			return nil, fmt.Errorf("-line-format: %w", err)
+		}
+		return p, nil
+	}
+	p, err := parser.New(cfg.Format, parser.Options{JSONMap: cfg.JSONMap, LTSVMap: cfg.LTSVMap, TSVColumns: cfg.TSVColumns})
+	if err != nil {
+		return nil, fmt.Errorf("-format %s: %w", cfg.Format, err)
+	}
+	return p, nil
 }
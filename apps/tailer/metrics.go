@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// metrics is the process-wide set of counters. It is always maintained;
+// -metrics-addr only controls whether it is exposed over HTTP.
+var metrics = NewMetrics()
+
+// Counter is a monotonically increasing value.
+type Counter struct{ v atomic.Int64 }
+
+func (c *Counter) Add(n int64) { c.v.Add(n) }
+func (c *Counter) Inc()        { c.v.Add(1) }
+func (c *Counter) Load() int64 { return c.v.Load() }
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ v atomic.Int64 }
+
+func (g *Gauge) Add(n int64) { g.v.Add(n) }
+func (g *Gauge) Set(n int64) { g.v.Store(n) }
+func (g *Gauge) Load() int64 { return g.v.Load() }
+
+// CounterMap counts by a key whose values aren't known in advance, such
+// as the crawler family.
+type CounterMap struct {
+	mu sync.Mutex
+	m  map[string]int64
+}
+
+func (c *CounterMap) Inc(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[string]int64{}
+	}
+	c.m[key]++
+}
+
+// Snapshot returns a copy of the counts.
+func (c *CounterMap) Snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.m)
+}
+
+// Histogram counts observations into cumulative buckets, Prometheus style.
+type Histogram struct {
+	bounds []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// Totals returns the number and sum of observations.
+func (h *Histogram) Totals() (count uint64, sum float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count, h.sum
+}
+
+// sendErrorClasses are the label values of send_errors_total.
+var sendErrorClasses = []string{"4xx", "5xx", "network", "other"}
+
+type Metrics struct {
+	LinesRead       Counter
+	ParseErrors     Counter
+	EventsSent      Counter
+	EventsRetried   Counter
+	EventsDropped   Counter
+	QueueOverflow   Counter
+	EventsDeduped   Counter
+	Throttled       Counter
+	Failovers       Counter
+	CircuitOpened   Counter
+	SyslogErrors    Counter
+	TailReopens     Counter
+	LinesTooLong    Counter
+	StreamFallbacks Counter
+	FilesPruned     Counter
+	QueueEvicted    Counter
+	EventsRolledUp  Counter
+	RollupLate      Counter
+	RollupsSent     Counter
+	RollupsDropped  Counter
+	SendErrors      map[string]*Counter
+
+	// EventsFiltered counts events not sent because of a filter flag,
+	// by filterReasons.
+	EventsFiltered map[string]*Counter
+
+	// SentByFamily counts delivered events by crawler family.
+	SentByFamily CounterMap
+
+	// QueueDepth is the number of events held in memory by the sender.
+	QueueDepth Gauge
+
+	// QueueStored is the number of events in the -queue-backend bolt
+	// file.
+	QueueStored Gauge
+
+	// CircuitState is the circuit breaker's: closed, open or half-open.
+	CircuitState Gauge
+
+	// ClockSkew is the offset in seconds added to signing timestamps to
+	// match the API's clock, 0 when none is needed.
+	ClockSkew Gauge
+
+	// LastLine is when a log line was last read, from any input, in Unix
+	// ms; until one is, when the tailer started.
+	LastLine Gauge
+
+	// FailingSince is when requests started failing with network errors
+	// or 5xx responses, in Unix ms, or 0 while they succeed.
+	FailingSince Gauge
+
+	RequestDuration *Histogram
+
+	statsMu   sync.Mutex
+	lastStats statsSnapshot
+
+	// mirrors are the counters of each mirror endpoint's sender; the
+	// ones above count the primary endpoint.
+	mirrorsMu sync.Mutex
+	mirrors   []mirrorMetrics
+}
+
+type mirrorMetrics struct {
+	endpoint string
+	m        *Metrics
+}
+
+// AddMirror returns a new set of metrics for the sender of a mirror
+// endpoint, exported and logged alongside m.
+func (m *Metrics) AddMirror(endpoint string) *Metrics {
+	mm := NewMetrics()
+	m.mirrorsMu.Lock()
+	defer m.mirrorsMu.Unlock()
+	m.mirrors = append(m.mirrors, mirrorMetrics{endpoint: endpoint, m: mm})
+	return mm
+}
+
+// AddSink returns the metrics for the sender of a further -sink. They are
+// reported as a mirror's, under name, except that failed attempts count
+// in the same send error counters as the primary's.
+func (m *Metrics) AddSink(name string) *Metrics {
+	sm := m.AddMirror(name)
+	sm.SendErrors = m.SendErrors
+	return sm
+}
+
+func (m *Metrics) mirrorList() []mirrorMetrics {
+	m.mirrorsMu.Lock()
+	defer m.mirrorsMu.Unlock()
+	return slices.Clone(m.mirrors)
+}
+
+// statsSnapshot is what the counters read at the previous stats line, so
+// that the next one reports the activity in between.
+type statsSnapshot struct {
+	at          time.Time
+	lines       int64
+	parseErrors int64
+	sent        int64
+	dropped     int64
+	deduped     int64
+	filtered    map[string]int64
+	requests    uint64
+	latency     float64
+	families    map[string]int64
+}
+
+func (m *Metrics) snapshot() statsSnapshot {
+	s := statsSnapshot{
+		at:          time.Now(),
+		lines:       m.LinesRead.Load(),
+		parseErrors: m.ParseErrors.Load(),
+		sent:        m.EventsSent.Load(),
+		dropped:     m.EventsDropped.Load(),
+		deduped:     m.EventsDeduped.Load(),
+		filtered:    map[string]int64{},
+		families:    m.SentByFamily.Snapshot(),
+	}
+	for _, reason := range filterReasons {
+		s.filtered[reason] = m.EventsFiltered[reason].Load()
+	}
+	s.requests, s.latency = m.RequestDuration.Totals()
+	return s
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		SendErrors:      map[string]*Counter{},
+		EventsFiltered:  map[string]*Counter{},
+		RequestDuration: NewHistogram([]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}),
+	}
+	m.lastStats = statsSnapshot{at: time.Now()}
+	m.LastLine.Set(time.Now().UnixMilli())
+	for _, class := range sendErrorClasses {
+		m.SendErrors[class] = &Counter{}
+	}
+	for _, reason := range filterReasons {
+		m.EventsFiltered[reason] = &Counter{}
+	}
+	return m
+}
+
+// SendError counts a failed attempt in the class matching err.
+func (m *Metrics) SendError(err error) {
+	m.SendErrors[errorClass(err)].Inc()
+}
+
+func errorClass(err error) string {
+	var se *client.StatusError
+	if errors.As(err, &se) {
+		if se.StatusCode >= 500 {
+			return "5xx"
+		}
+		return "4xx"
+	}
+	var ke kafka.Error
+	if errors.As(err, &ke) {
+		return "other"
+	}
+	var ue *url.Error
+	var ne net.Error
+	if errors.As(err, &ue) || errors.As(err, &ne) {
+		return "network"
+	}
+	return "other"
+}
+
+// LogStats logs a summary of the activity since the previous call (or
+// since startup), for deployments that don't scrape -metrics-addr: counts
+// over the period, the queue depth now, the average request latency and
+// events sent per crawler family.
+func (m *Metrics) LogStats() {
+	cur, prev := m.advanceStats()
+
+	filtered := make([]any, 0, 2*len(filterReasons))
+	for _, reason := range filterReasons {
+		filtered = append(filtered, reason, cur.filtered[reason]-prev.filtered[reason])
+	}
+	latency := avgLatency(cur, prev)
+	names := make([]string, 0, len(cur.families))
+	for family := range cur.families {
+		names = append(names, family)
+	}
+	sort.Strings(names)
+	var families []any
+	for _, family := range names {
+		if n := cur.families[family] - prev.families[family]; n > 0 {
+			families = append(families, family, n)
+		}
+	}
+
+	slog.Info("Stats", "period", cur.at.Sub(prev.at).Round(time.Second),
+		"lines_read", cur.lines-prev.lines, "parse_errors", cur.parseErrors-prev.parseErrors,
+		"events_sent", cur.sent-prev.sent, "dropped", cur.dropped-prev.dropped,
+		slog.Group("filtered", filtered...), "deduplicated", cur.deduped-prev.deduped, "queued", m.QueueDepth.Load(),
+		"avg_latency", latency.Round(time.Millisecond), "since_last_line", m.SinceLastLine().Round(time.Second),
+		slog.Group("families", families...))
+
+	for _, mirror := range m.mirrorList() {
+		cur, prev := mirror.m.advanceStats()
+		slog.Info("Mirror stats", "endpoint", mirror.endpoint, "period", cur.at.Sub(prev.at).Round(time.Second),
+			"events_sent", cur.sent-prev.sent, "dropped", cur.dropped-prev.dropped, "queued", mirror.m.QueueDepth.Load(),
+			"avg_latency", avgLatency(cur, prev).Round(time.Millisecond))
+	}
+}
+
+// advanceStats returns the counters now and at the previous call.
+func (m *Metrics) advanceStats() (cur, prev statsSnapshot) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	cur, prev = m.snapshot(), m.lastStats
+	m.lastStats = cur
+	return cur, prev
+}
+
+// avgLatency is the mean request duration between two snapshots.
+func avgLatency(cur, prev statsSnapshot) time.Duration {
+	n := cur.requests - prev.requests
+	if n == 0 {
+		return 0
+	}
+	return time.Duration((cur.latency - prev.latency) / float64(n) * float64(time.Second))
+}
+
+// SinceLastLine is how long ago a log line was last read.
+func (m *Metrics) SinceLastLine() time.Duration {
+	return time.Since(time.UnixMilli(m.LastLine.Load()))
+}
+
+// Filtered returns the number of events dropped by any filter.
+func (m *Metrics) Filtered() int64 {
+	var n int64
+	for _, c := range m.EventsFiltered {
+		n += c.Load()
+	}
+	return n
+}
+
+// WritePrometheus writes all metrics in the Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	counter := func(name, help string, v int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+
+	counter("trace_tailer_lines_read_total", "Log lines read.", m.LinesRead.Load())
+	counter("trace_tailer_parse_errors_total", "Log lines that could not be parsed.", m.ParseErrors.Load())
+	counter("trace_tailer_lines_too_long_total", "Log lines skipped for being longer than -max-line-bytes.", m.LinesTooLong.Load())
+	counter("trace_tailer_events_sent_total", "Events accepted by the ingest API.", m.EventsSent.Load())
+	counter("trace_tailer_events_retried_total", "Events whose delivery was retried.", m.EventsRetried.Load())
+	counter("trace_tailer_events_dropped_total", "Events given up on.", m.EventsDropped.Load())
+	counter("trace_tailer_stream_fallbacks_total", "Times a -stream request failed and events were sent as batches instead.", m.StreamFallbacks.Load())
+	counter("trace_tailer_output_files_pruned_total", "Files -sink file deleted to stay within -out-max-bytes.", m.FilesPruned.Load())
+	counter("trace_tailer_throttled_total", "Times the API started throttling the tailer with 429.", m.Throttled.Load())
+	counter("trace_tailer_events_deduplicated_total", "Events dropped as repeats by -dedup-window.", m.EventsDeduped.Load())
+	counter("trace_tailer_failovers_total", "Times the tailer failed over to another endpoint.", m.Failovers.Load())
+	counter("trace_tailer_circuit_opened_total", "Times the circuit breaker opened after repeated failures.", m.CircuitOpened.Load())
+	counter("trace_tailer_syslog_malformed_total", "Syslog messages (or TCP streams) that could not be parsed.", m.SyslogErrors.Load())
+	counter("trace_tailer_tail_reopens_total", "Times a stalled tail was reopened by the -stall-timeout watchdog.", m.TailReopens.Load())
+	counter("trace_tailer_queue_overflow_total", "Events that found the delivery queue full.", m.QueueOverflow.Load())
+	counter("trace_tailer_events_rolled_up_total", "Events counted into rollups by -mode aggregate.", m.EventsRolledUp.Load())
+	counter("trace_tailer_rollup_late_total", "Events -mode aggregate dropped for a window already sent.", m.RollupLate.Load())
+	counter("trace_tailer_rollups_sent_total", "Rollups accepted by the ingest API.", m.RollupsSent.Load())
+	counter("trace_tailer_rollups_dropped_total", "Rollups given up on.", m.RollupsDropped.Load())
+	counter("trace_tailer_queue_evicted_total", "Events -queue-backend bolt evicted to stay within -queue-max-bytes.", m.QueueEvicted.Load())
+
+	fmt.Fprintf(w, "# HELP trace_tailer_send_errors_total Failed requests to the ingest API.\n# TYPE trace_tailer_send_errors_total counter\n")
+	for _, class := range sendErrorClasses {
+		fmt.Fprintf(w, "trace_tailer_send_errors_total{class=%q} %d\n", class, m.SendErrors[class].Load())
+	}
+
+	fmt.Fprintf(w, "# HELP trace_tailer_events_filtered_total Events not sent because a filter excluded them.\n# TYPE trace_tailer_events_filtered_total counter\n")
+	for _, reason := range filterReasons {
+		fmt.Fprintf(w, "trace_tailer_events_filtered_total{reason=%q} %d\n", reason, m.EventsFiltered[reason].Load())
+	}
+
+	fmt.Fprintf(w, "# HELP trace_tailer_queue_depth Events buffered in memory awaiting delivery.\n# TYPE trace_tailer_queue_depth gauge\ntrace_tailer_queue_depth %d\n", m.QueueDepth.Load())
+	fmt.Fprintf(w, "# HELP trace_tailer_queue_stored_events Events in the -queue-backend bolt file awaiting delivery.\n# TYPE trace_tailer_queue_stored_events gauge\ntrace_tailer_queue_stored_events %d\n", m.QueueStored.Load())
+	fmt.Fprintf(w, "# HELP trace_tailer_seconds_since_last_line Seconds since a log line was last read.\n# TYPE trace_tailer_seconds_since_last_line gauge\ntrace_tailer_seconds_since_last_line %.3f\n", m.SinceLastLine().Seconds())
+	fmt.Fprintf(w, "# HELP trace_tailer_clock_skew_seconds Offset added to signing timestamps to match the API's clock.\n# TYPE trace_tailer_clock_skew_seconds gauge\ntrace_tailer_clock_skew_seconds %d\n", m.ClockSkew.Load())
+	fmt.Fprintf(w, "# HELP trace_tailer_circuit_state Circuit breaker state: 0 closed, 1 open, 2 half-open.\n# TYPE trace_tailer_circuit_state gauge\ntrace_tailer_circuit_state %d\n", m.CircuitState.Load())
+
+	if mirrors := m.mirrorList(); len(mirrors) > 0 {
+		perMirror := func(name, help, typ string, value func(*Metrics) int64) {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+			for _, mirror := range mirrors {
+				fmt.Fprintf(w, "%s{endpoint=%q} %d\n", name, mirror.endpoint, value(mirror.m))
+			}
+		}
+		perMirror("trace_tailer_mirror_events_sent_total", "Events accepted by a mirror endpoint.", "counter", func(mm *Metrics) int64 { return mm.EventsSent.Load() })
+		perMirror("trace_tailer_mirror_events_retried_total", "Events whose delivery to a mirror was retried.", "counter", func(mm *Metrics) int64 { return mm.EventsRetried.Load() })
+		perMirror("trace_tailer_mirror_events_dropped_total", "Events given up on for a mirror.", "counter", func(mm *Metrics) int64 { return mm.EventsDropped.Load() })
+		perMirror("trace_tailer_mirror_queue_depth", "Events buffered in memory awaiting delivery to a mirror.", "gauge", func(mm *Metrics) int64 { return mm.QueueDepth.Load() })
+		perMirror("trace_tailer_mirror_circuit_state", "Circuit breaker state for a mirror: 0 closed, 1 open, 2 half-open.", "gauge", func(mm *Metrics) int64 { return mm.CircuitState.Load() })
+	}
+
+	h := m.RequestDuration
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	const name = "trace_tailer_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Latency of requests to the ingest API.\n# TYPE %s histogram\n", name, name)
+	var cum uint64
+	for i, bound := range h.bounds {
+		cum += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cum)
+	}
+	cum += h.counts[len(h.bounds)]
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n", name, cum, name, strconv.FormatFloat(h.sum, 'g', -1, 64), name, h.count)
+}
+
+// MetricsServer exposes metrics on /metrics.
+type MetricsServer struct {
+	srv *http.Server
+}
+
+// StartMetricsServer listens on addr and serves metrics in the background.
+func StartMetricsServer(addr string, m *Metrics) (*MetricsServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.WritePrometheus(w)
+	})
+
+	ms := &MetricsServer{srv: &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}}
+	go func() {
+		if err := ms.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "err", err)
+		}
+	}()
+	slog.Info("Serving metrics", "url", "http://"+ln.Addr().String()+"/metrics")
+	return ms, nil
+}
+
+func (ms *MetricsServer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ms.srv.Shutdown(ctx)
+}
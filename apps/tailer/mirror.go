@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// Mirror is a secondary endpoint that receives a copy of the events, from
+// a repeated -endpoint or the config file's mirrors list. Without
+// credentials of its own it uses the default ones (and properties).
+type Mirror struct {
+	Endpoint    string `yaml:"endpoint"`
+	Credentials `yaml:",inline"`
+}
+
+// endpointFlag is -endpoint. The first value replaces the default primary
+// endpoint; any further ones are mirrors.
+type endpointFlag struct {
+	cfg *Config
+	set bool
+}
+
+func (f *endpointFlag) String() string {
+	if f == nil || f.cfg == nil {
+		return ""
+	}
+	urls := []string{f.cfg.Endpoint}
+	for _, m := range f.cfg.Mirrors {
+		urls = append(urls, m.Endpoint)
+	}
+	return strings.Join(urls, ",")
+}
+
+func (f *endpointFlag) Set(v string) error {
+	if !f.set {
+		f.cfg.Endpoint, f.set = v, true
+		return nil
+	}
+	f.cfg.addMirrors([]Mirror{{Endpoint: v}})
+	return nil
+}
+
+// addMirrors adds mirrors, replacing any already listed for the same
+// endpoint so the config file can give a repeated -endpoint credentials.
+func (cfg *Config) addMirrors(mirrors []Mirror) {
+	for _, m := range mirrors {
+		i := slices.IndexFunc(cfg.Mirrors, func(o Mirror) bool { return o.Endpoint == m.Endpoint })
+		if i >= 0 {
+			cfg.Mirrors[i] = m
+			continue
+		}
+		cfg.Mirrors = append(cfg.Mirrors, m)
+	}
+}
+
+// Fanout hands every event to the primary sender and to those of further
+// sinks and, subject to -mirror-sample, a copy to each mirror's. Each
+// sender has its own queue, retries and counters, so a slow or failing
+// mirror or sink never holds up the primary.
+type Fanout struct {
+	primary *Sender
+	sinks   []*Sender
+	mirrors []*Sender
+	sample  float64
+}
+
+// NewFanout returns a fanout to primary and mirrors sending a sample
+// fraction of events to the mirrors.
+func NewFanout(primary *Sender, mirrors []*Sender, sample float64) *Fanout {
+	return &Fanout{primary: primary, mirrors: mirrors, sample: sample}
+}
+
+// AddSink adds the sender of a further sink, which gets every event as
+// the primary does. It must be called before the fanout is used.
+func (f *Fanout) AddSink(s *Sender) {
+	f.sinks = append(f.sinks, s)
+}
+
+// Enqueue hands event to the senders.
+func (f *Fanout) Enqueue(event *CrawlEvent) {
+	f.primary.Enqueue(event)
+	for _, s := range f.sinks {
+		s.Enqueue(event)
+	}
+	if len(f.mirrors) == 0 || (f.sample < 1 && rand.Float64() >= f.sample) {
+		return
+	}
+	// The primary's workers may be encoding the original by now; the
+	// mirrors share a copy, which they only read.
+	mirrored := *event
+	if f.sample < 1 {
+		rate := mirrored.SampleRate
+		if rate == 0 {
+			rate = 1
+		}
+		mirrored.SampleRate = rate * f.sample
+	}
+	for _, s := range f.mirrors {
+		s.Enqueue(&mirrored)
+	}
+}
+
+// SetRateLimit applies the -max-rps limit to each endpoint.
+func (f *Fanout) SetRateLimit(rps float64, burst int) {
+	f.primary.SetRateLimit(rps, burst)
+	for _, s := range f.mirrors {
+		s.SetRateLimit(rps, burst)
+	}
+}
+
+// Close closes all senders at once, each with timeout, and reports
+// whether the primary delivered everything.
+func (f *Fanout) Close(timeout time.Duration) bool {
+	var wg sync.WaitGroup
+	for _, s := range slices.Concat(f.sinks, f.mirrors) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Close(timeout)
+		}()
+	}
+	drained := f.primary.Close(timeout)
+	wg.Wait()
+	return drained
+}
+
+// newMirrorSenders starts a sender for each mirror in cfg. Mirrors never
+// spool and never block: their queues drop the newest events when full.
+func newMirrorSenders(ctx context.Context, cfg Config, key, secret string) ([]*Sender, error) {
+	var senders []*Sender
+	for _, mirror := range cfg.Mirrors {
+		api, err := newMirrorClient(cfg, mirror, key, secret)
+		if err != nil {
+			return nil, err
+		}
+		mcfg := cfg
+		mcfg.Backpressure = "drop-newest"
+		m := metrics.AddMirror(mirror.Endpoint)
+		api.OnRequest = func(d time.Duration) { m.RequestDuration.Observe(d.Seconds()) }
+		var transport client.Sender = api
+		if len(cfg.Properties) > 0 && !mirror.set() {
+			if transport, err = newRouter(cfg, api); err != nil {
+				return nil, err
+			}
+		}
+		senders = append(senders, newSender(ctx, transport, mcfg, nil, m, slog.Default().With("endpoint", mirror.Endpoint)))
+	}
+	return senders, nil
+}
+
+// newMirrorClient returns the API client for mirror, configured like the
+// primary's but with the mirror's own credentials if it has any.
+func newMirrorClient(cfg Config, mirror Mirror, key, secret string) (*client.Client, error) {
+	if mirror.set() {
+		var err error
+		if key, secret, err = mirror.load(cfg); err != nil {
+			return nil, fmt.Errorf("mirror %s: %w", mirror.Endpoint, err)
+		}
+	}
+	api, err := newClient(cfg, key, secret)
+	if err != nil {
+		return nil, err
+	}
+	api.Endpoint = mirror.Endpoint
+	return api, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+func TestEndpointFlag(t *testing.T) {
+	cfg, _, err := parseConfig([]string{"-dry-run", "-endpoint", "https://old.example", "-endpoint", "https://new.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Endpoint != "https://old.example" || len(cfg.Mirrors) != 1 || cfg.Mirrors[0].Endpoint != "https://new.example" {
+		t.Errorf("endpoint %q, mirrors %+v; want old primary and new mirror", cfg.Endpoint, cfg.Mirrors)
+	}
+
+	cfg, _, err = parseConfig([]string{"-dry-run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Endpoint != "http://localhost:8787" || len(cfg.Mirrors) != 0 {
+		t.Errorf("endpoint %q, mirrors %+v; want the default only", cfg.Endpoint, cfg.Mirrors)
+	}
+}
+
+func TestFanout(t *testing.T) {
+	tests := []struct {
+		name         string
+		sample       float64
+		mirrorErrs   []error
+		wantMirrored int
+	}{
+		{name: "all mirrored", sample: 1, wantMirrored: 5},
+		{name: "none mirrored", sample: 0},
+		{name: "failing mirror", sample: 1, mirrorErrs: []error{
+			&client.StatusError{StatusCode: 500},
+			&client.StatusError{StatusCode: 500},
+			&client.StatusError{StatusCode: 500},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primaryAPI, mirrorAPI := &fakeAPI{}, &fakeAPI{errs: tt.mirrorErrs}
+			mm := NewMetrics()
+			f := NewFanout(NewSender(context.Background(), primaryAPI, testConfig(), nil), []*Sender{newSender(context.Background(), mirrorAPI, testConfig(), nil, mm, slog.Default())}, tt.sample)
+			for range 5 {
+				f.Enqueue(&CrawlEvent{Path: "/"})
+			}
+			if !f.Close(5 * time.Second) {
+				t.Fatal("Close timed out")
+			}
+			if len(primaryAPI.received) != 5 {
+				t.Errorf("primary got %d events, want 5", len(primaryAPI.received))
+			}
+			if len(mirrorAPI.received) != tt.wantMirrored || mm.EventsSent.Load() != int64(tt.wantMirrored) {
+				t.Errorf("mirror got %d events, counted %d; want %d", len(mirrorAPI.received), mm.EventsSent.Load(), tt.wantMirrored)
+			}
+			if tt.mirrorErrs != nil && mm.EventsDropped.Load() != 5 {
+				t.Errorf("mirror dropped %d, want 5", mm.EventsDropped.Load())
+			}
+		})
+	}
+}
+
+func TestFanoutSinks(t *testing.T) {
+	primaryAPI, sinkAPI := &fakeAPI{}, &fakeAPI{errs: []error{&client.StatusError{StatusCode: 500}}}
+	m := NewMetrics()
+	sm := m.AddSink("kafka")
+	f := NewFanout(newSender(context.Background(), primaryAPI, testConfig(), nil, m, slog.Default()), nil, 0)
+	f.AddSink(newSender(context.Background(), sinkAPI, testConfig(), nil, sm, slog.Default()))
+	for range 5 {
+		f.Enqueue(&CrawlEvent{Path: "/"})
+	}
+	if !f.Close(5 * time.Second) {
+		t.Fatal("Close timed out")
+	}
+	if len(primaryAPI.received) != 5 || len(sinkAPI.received) != 5 {
+		t.Errorf("primary got %d events, sink %d; want 5 each", len(primaryAPI.received), len(sinkAPI.received))
+	}
+	if m.SendErrors["5xx"].Load() != 1 || m.EventsSent.Load() != 5 || sm.EventsSent.Load() != 5 {
+		t.Errorf("5xx errors %d, sent %d and %d; want the sink's error counted with the primary's, and 5 sent by each",
+			m.SendErrors["5xx"].Load(), m.EventsSent.Load(), sm.EventsSent.Load())
+	}
+}
+
+func TestFanoutSampleRate(t *testing.T) {
+	primaryAPI, mirrorAPI := &fakeAPI{}, &fakeAPI{}
+	f := NewFanout(NewSender(context.Background(), primaryAPI, testConfig(), nil), []*Sender{newSender(context.Background(), mirrorAPI, testConfig(), nil, NewMetrics(), slog.Default())}, 0.999999)
+	f.Enqueue(&CrawlEvent{Path: "/", SampleRate: 0.5})
+	f.Close(5 * time.Second)
+	if len(primaryAPI.received) != 1 || primaryAPI.received[0].SampleRate != 0.5 {
+		t.Fatalf("primary got %+v, want the event unchanged", primaryAPI.received)
+	}
+	if len(mirrorAPI.received) == 1 && mirrorAPI.received[0].SampleRate >= 0.5 {
+		t.Errorf("mirror sample rate = %g, want below 0.5", mirrorAPI.received[0].SampleRate)
+	}
+}
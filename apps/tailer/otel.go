@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+const (
+	// otelSpanInterval is how often spans are exported, unless
+	// otelSpanBatch of them are waiting before then.
+	otelSpanInterval = 5 * time.Second
+	otelSpanBatch    = 512
+
+	// otelMaxSpans is how many spans are kept while the collector is
+	// slow or down; newer ones are dropped beyond it.
+	otelMaxSpans = 4096
+
+	otelTimeout = 10 * time.Second
+	otelScope   = "github.com/originaryx/trace/tailer"
+)
+
+// telemetry exports to -otel-endpoint; nil unless it is set. It is set
+// before any sender starts.
+var telemetry *OTelExporter
+
+// OTelExporter pushes the tailer's own counters, and a span for every
+// request to the ingest API, to an OpenTelemetry collector over OTLP.
+type OTelExporter struct {
+	m         *Metrics
+	transport otlpTransport
+	interval  time.Duration
+	resource  *resourcepb.Resource
+	started   uint64 // start of the cumulative metrics, in Unix ns
+
+	mu      sync.Mutex
+	spans   []*tracepb.Span
+	dropped int
+
+	kick     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	errorLog throttledLog
+}
+
+// otlpTransport carries export requests to the collector.
+type otlpTransport interface {
+	exportMetrics(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error
+	exportTraces(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) error
+	close()
+}
+
+// StartOTel starts exporting m every -otel-interval, and spans as they
+// are recorded, to -otel-endpoint.
+func StartOTel(cfg Config, m *Metrics) (*OTelExporter, error) {
+	transport, err := newOTLPTransport(cfg.OTelEndpoint, cfg.OTelProtocol)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	e := &OTelExporter{
+		m:         m,
+		transport: transport,
+		interval:  cfg.OTelInterval,
+		resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			stringAttr("service.name", "trace-tailer"),
+			stringAttr("service.version", version),
+			stringAttr("host.name", host),
+		}},
+		started:  uint64(time.Now().UnixNano()),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		errorLog: throttledLog{interval: time.Minute},
+	}
+	go e.run()
+	slog.Info("Exporting telemetry", "endpoint", cfg.OTelEndpoint, "protocol", cfg.OTelProtocol)
+	return e, nil
+}
+
+// newOTLPTransport connects to endpoint, an http:// or https:// URL,
+// with protocol grpc or http.
+func newOTLPTransport(endpoint, protocol string) (otlpTransport, error) {
+	u, err := otelEndpointURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	switch protocol {
+	case "grpc":
+		tc := insecure.NewCredentials()
+		if u.Scheme == "https" {
+			tc = credentials.NewTLS(nil)
+		}
+		conn, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(tc))
+		if err != nil {
+			return nil, fmt.Errorf("connect to %s: %w", u.Host, err)
+		}
+		return &otlpGRPC{conn: conn, metrics: colmetricspb.NewMetricsServiceClient(conn), traces: coltracepb.NewTraceServiceClient(conn)}, nil
+	case "http":
+		return &otlpHTTP{base: strings.TrimSuffix(u.String(), "/"), client: &http.Client{Timeout: otelTimeout}}, nil
+	}
+	return nil, fmt.Errorf("unknown -otel-protocol %q (want grpc or http)", protocol)
+}
+
+// otelEndpointURL parses -otel-endpoint.
+func otelEndpointURL(endpoint string) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid -otel-endpoint %q (want an http:// or https:// URL)", endpoint)
+	}
+	return u, nil
+}
+
+func (e *OTelExporter) run() {
+	defer close(e.done)
+	metricsTick := time.NewTicker(e.interval)
+	defer metricsTick.Stop()
+	spansTick := time.NewTicker(otelSpanInterval)
+	defer spansTick.Stop()
+	for {
+		select {
+		case <-metricsTick.C:
+			e.exportMetrics()
+		case <-spansTick.C:
+			e.exportSpans()
+		case <-e.kick:
+			e.exportSpans()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// Close exports what is left and disconnects.
+func (e *OTelExporter) Close() {
+	close(e.stop)
+	<-e.done
+	e.exportSpans()
+	e.exportMetrics()
+	e.transport.close()
+}
+
+// RecordSend records a request of n events to the ingest API, made from
+// start until now, as a span. It does nothing on a nil exporter.
+func (e *OTelExporter) RecordSend(start time.Time, n int, err error) {
+	if e == nil {
+		return
+	}
+	span := &tracepb.Span{
+		TraceId:           randomID(16),
+		SpanId:            randomID(8),
+		Name:              "send events",
+		Kind:              tracepb.Span_SPAN_KIND_CLIENT,
+		StartTimeUnixNano: uint64(start.UnixNano()),
+		EndTimeUnixNano:   uint64(time.Now().UnixNano()),
+		Attributes:        []*commonpb.KeyValue{intAttr("events", int64(n))},
+	}
+	var se *client.StatusError
+	if errors.As(err, &se) {
+		span.Attributes = append(span.Attributes, intAttr("http.response.status_code", int64(se.StatusCode)))
+	}
+	if err != nil {
+		span.Attributes = append(span.Attributes, stringAttr("error.type", errorClass(err)))
+		span.Status = &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: err.Error()}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.spans) >= otelMaxSpans {
+		e.dropped++
+		return
+	}
+	e.spans = append(e.spans, span)
+	if len(e.spans) >= otelSpanBatch {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *OTelExporter) exportSpans() {
+	e.mu.Lock()
+	spans, dropped := e.spans, e.dropped
+	e.spans, e.dropped = nil, 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		e.errorLog.Log(slog.LevelWarn, "Dropped telemetry spans while the collector was behind", "spans", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), otelTimeout)
+	defer cancel()
+	err := e.transport.exportTraces(ctx, &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		Resource:   e.resource,
+		ScopeSpans: []*tracepb.ScopeSpans{{Scope: &commonpb.InstrumentationScope{Name: otelScope, Version: version}, Spans: spans}},
+	}}})
+	if err != nil {
+		e.errorLog.Log(slog.LevelWarn, "Failed to export telemetry spans", "err", err, "spans", len(spans))
+	}
+}
+
+func (e *OTelExporter) exportMetrics() {
+	ctx, cancel := context.WithTimeout(context.Background(), otelTimeout)
+	defer cancel()
+	err := e.transport.exportMetrics(ctx, &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource:     e.resource,
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Scope: &commonpb.InstrumentationScope{Name: otelScope, Version: version}, Metrics: e.collect(time.Now())}},
+	}}})
+	if err != nil {
+		e.errorLog.Log(slog.LevelWarn, "Failed to export telemetry metrics", "err", err)
+	}
+}
+
+// collect returns the values of m at now, as the metrics of
+// -metrics-addr, with dots for namespaces.
+func (e *OTelExporter) collect(now time.Time) []*metricspb.Metric {
+	ts := uint64(now.UnixNano())
+	sum := func(name, description, unit string, v int64) *metricspb.Metric {
+		return &metricspb.Metric{Name: name, Description: description, Unit: unit, Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+			DataPoints:             []*metricspb.NumberDataPoint{{StartTimeUnixNano: e.started, TimeUnixNano: ts, Value: &metricspb.NumberDataPoint_AsInt{AsInt: v}}},
+		}}}
+	}
+	gauge := func(name, description, unit string, v int64) *metricspb.Metric {
+		return &metricspb.Metric{Name: name, Description: description, Unit: unit, Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{TimeUnixNano: ts, Value: &metricspb.NumberDataPoint_AsInt{AsInt: v}}},
+		}}}
+	}
+	m := e.m
+	out := []*metricspb.Metric{
+		sum("trace_tailer.lines_read", "Log lines read.", "{line}", m.LinesRead.Load()),
+		sum("trace_tailer.parse_errors", "Lines that could not be parsed.", "{line}", m.ParseErrors.Load()),
+		sum("trace_tailer.events_sent", "Events accepted by the ingest API.", "{event}", m.EventsSent.Load()),
+		sum("trace_tailer.events_dropped", "Events given up on after retries or on a permanent error.", "{event}", m.EventsDropped.Load()),
+		gauge("trace_tailer.queue_depth", "Events buffered in memory awaiting delivery.", "{event}", m.QueueDepth.Load()),
+	}
+
+	h := m.RequestDuration
+	h.mu.Lock()
+	total := h.sum
+	out = append(out, &metricspb.Metric{Name: "trace_tailer.request.duration", Description: "Latency of requests to the ingest API.", Unit: "s", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+		AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+		DataPoints: []*metricspb.HistogramDataPoint{{
+			StartTimeUnixNano: e.started,
+			TimeUnixNano:      ts,
+			Count:             h.count,
+			Sum:               &total,
+			BucketCounts:      append([]uint64(nil), h.counts...),
+			ExplicitBounds:    h.bounds,
+		}},
+	}}})
+	h.mu.Unlock()
+	return out
+}
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func intAttr(key string, value int64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value}}}
+}
+
+func randomID(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// otlpGRPC exports over OTLP/gRPC.
+type otlpGRPC struct {
+	conn    *grpc.ClientConn
+	metrics colmetricspb.MetricsServiceClient
+	traces  coltracepb.TraceServiceClient
+}
+
+func (t *otlpGRPC) exportMetrics(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	_, err := t.metrics.Export(ctx, req)
+	return err
+}
+
+func (t *otlpGRPC) exportTraces(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) error {
+	_, err := t.traces.Export(ctx, req)
+	return err
+}
+
+func (t *otlpGRPC) close() { t.conn.Close() }
+
+// otlpHTTP exports over OTLP/HTTP, in protobuf, to /v1/metrics and
+// /v1/traces under base.
+type otlpHTTP struct {
+	base   string
+	client *http.Client
+}
+
+func (t *otlpHTTP) exportMetrics(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	return t.post(ctx, "/v1/metrics", req)
+}
+
+func (t *otlpHTTP) exportTraces(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) error {
+	return t.post(ctx, "/v1/traces", req)
+}
+
+func (t *otlpHTTP) post(ctx context.Context, path string, msg proto.Message) error {
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encode %s request: %w", path, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.base+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create %s request: %w", path, err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send %s request: %w", path, err)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned status %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+func (t *otlpHTTP) close() { t.client.CloseIdleConnections() }
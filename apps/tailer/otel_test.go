@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+func TestOTelExport(t *testing.T) {
+	var mu sync.Mutex
+	var metricsReqs []*colmetricspb.ExportMetricsServiceRequest
+	var traceReqs []*coltracepb.ExportTraceServiceRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/metrics":
+			req := &colmetricspb.ExportMetricsServiceRequest{}
+			if err := proto.Unmarshal(body, req); err != nil {
+				t.Error(err)
+			}
+			metricsReqs = append(metricsReqs, req)
+		case "/v1/traces":
+			req := &coltracepb.ExportTraceServiceRequest{}
+			if err := proto.Unmarshal(body, req); err != nil {
+				t.Error(err)
+			}
+			traceReqs = append(traceReqs, req)
+		default:
+			t.Errorf("request for %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	var none *OTelExporter
+	none.RecordSend(time.Now(), 1, nil)
+
+	m := NewMetrics()
+	m.LinesRead.Add(7)
+	m.RequestDuration.Observe(0.2)
+	cfg := testConfig()
+	cfg.OTelEndpoint, cfg.OTelProtocol, cfg.OTelInterval = srv.URL, "http", time.Hour
+	e, err := StartOTel(cfg, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.RecordSend(time.Now().Add(-time.Second), 10, nil)
+	e.RecordSend(time.Now(), 5, &client.StatusError{StatusCode: 503})
+	e.RecordSend(time.Now(), 5, errors.New("dial tcp: connection refused"))
+	e.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(metricsReqs) != 1 || len(traceReqs) != 1 {
+		t.Fatalf("got %d metrics and %d trace requests, want 1 of each", len(metricsReqs), len(traceReqs))
+	}
+	rm := metricsReqs[0].ResourceMetrics[0]
+	attrs := map[string]string{}
+	for _, kv := range rm.Resource.Attributes {
+		attrs[kv.Key] = kv.Value.GetStringValue()
+	}
+	if attrs["service.version"] != version || attrs["host.name"] == "" {
+		t.Errorf("resource attributes %v, want the version and host name", attrs)
+	}
+	got := map[string]bool{}
+	for _, metric := range rm.ScopeMetrics[0].Metrics {
+		got[metric.Name] = true
+		switch metric.Name {
+		case "trace_tailer.lines_read":
+			if v := metric.GetSum().DataPoints[0].GetAsInt(); v != 7 {
+				t.Errorf("lines_read = %d, want 7", v)
+			}
+		case "trace_tailer.request.duration":
+			if dp := metric.GetHistogram().DataPoints[0]; dp.Count != 1 || len(dp.BucketCounts) != len(dp.ExplicitBounds)+1 {
+				t.Errorf("request duration %v, want one observation", dp)
+			}
+		}
+	}
+	for _, name := range []string{"trace_tailer.lines_read", "trace_tailer.parse_errors", "trace_tailer.queue_depth", "trace_tailer.request.duration"} {
+		if !got[name] {
+			t.Errorf("metric %s not exported", name)
+		}
+	}
+
+	spans := traceReqs[0].ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("exported %d spans, want 3", len(spans))
+	}
+	if spans[0].Status != nil || spans[0].EndTimeUnixNano-spans[0].StartTimeUnixNano < uint64(time.Second) {
+		t.Errorf("first span %v, want an OK span of a second", spans[0])
+	}
+	for _, span := range spans[1:] {
+		if span.Status.GetCode().String() != "STATUS_CODE_ERROR" {
+			t.Errorf("span %v, want an error status", span)
+		}
+	}
+}
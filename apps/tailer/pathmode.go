@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// hashedSegmentPrefix marks a path segment replaced by -path-mode
+// hash-segments, followed by hashedSegmentHex hex digits.
+const (
+	hashedSegmentPrefix = "h:"
+	hashedSegmentHex    = 12
+)
+
+// pathPrivacy applies -path-mode, -keep-params and -scrub-params to
+// events, before they are filtered.
+type pathPrivacy struct {
+	truncate int    // segments kept, with truncate:N
+	hashFrom int    // first segment hashed, with hash-segments; -1 if none
+	salt     []byte // -path-salt, with hash-segments
+	keep     []string
+	scrub    []string
+}
+
+// parsePathMode parses -path-mode into the segments truncate:N keeps and
+// whether hash-segments was given.
+func parsePathMode(mode string) (truncate int, hash bool, err error) {
+	switch {
+	case mode == "raw" || mode == "":
+		return 0, false, nil
+	case mode == "hash-segments":
+		return 0, true, nil
+	case strings.HasPrefix(mode, "truncate:"):
+		n, err := strconv.Atoi(strings.TrimPrefix(mode, "truncate:"))
+		if err != nil || n < 1 {
+			return 0, false, fmt.Errorf("-path-mode %s needs a number of segments of at least 1", mode)
+		}
+		return n, false, nil
+	}
+	return 0, false, fmt.Errorf("unknown -path-mode %q (want raw, truncate:N or hash-segments)", mode)
+}
+
+// parseParamPatterns parses a comma-separated list of query parameter
+// names and patterns like utm_*.
+func parseParamPatterns(flagName, list string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q", flagName, p)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// newPathPrivacy returns the path settings of cfg, or nil if paths are
+// sent as logged without their query string.
+func newPathPrivacy(cfg Config) (*pathPrivacy, error) {
+	truncate, hash, err := parsePathMode(cfg.PathMode)
+	if err != nil {
+		return nil, err
+	}
+	keep, err := parseParamPatterns("-keep-params", cfg.KeepParams)
+	if err != nil {
+		return nil, err
+	}
+	scrub, err := parseParamPatterns("-scrub-params", cfg.ScrubParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(scrub) > 0 && len(keep) == 0 {
+		return nil, errors.New("-scrub-params only narrows -keep-params, which is not set")
+	}
+	if truncate == 0 && !hash && len(keep) == 0 {
+		return nil, nil
+	}
+	p := &pathPrivacy{truncate: truncate, hashFrom: -1, keep: keep, scrub: scrub}
+	if hash {
+		salt, err := resolveCredential("path salt", "path-salt", cfg.PathSalt, cfg.PathSaltFile, "TRACE_PATH_SALT")
+		if err != nil {
+			return nil, err
+		}
+		p.hashFrom, p.salt = cfg.PathHashDepth, []byte(salt)
+	}
+	return p, nil
+}
+
+// apply rewrites event's path, adding the query parameters that are
+// kept. A path that already has them, as in events replayed with -format
+// ndjson, is rewritten the same way.
+func (p *pathPrivacy) apply(event *CrawlEvent) {
+	target, query, _ := strings.Cut(event.Path, "?")
+	if event.Query != "" {
+		query = event.Query
+	}
+	switch {
+	case p.truncate > 0:
+		target = truncatePath(target, p.truncate)
+	case p.hashFrom >= 0:
+		target = p.hashSegments(target)
+	}
+	if query = p.params(query); query != "" {
+		target += "?" + query
+	}
+	event.Path, event.Query = target, ""
+}
+
+// truncatePath keeps the first n segments of p.
+func truncatePath(p string, n int) string {
+	for i := 1; i < len(p); i++ {
+		if p[i] == '/' {
+			if n--; n == 0 {
+				return p[:i]
+			}
+		}
+	}
+	return p
+}
+
+// hashSegments replaces the segments of target from hashFrom on that
+// look like identifiers, rather than words like "orders", with a keyed
+// hash.
+func (p *pathPrivacy) hashSegments(target string) string {
+	segments := strings.Split(target, "/")
+	// A path starts with "/", so segments[0] is empty.
+	for i := 1 + p.hashFrom; i < len(segments); i++ {
+		if s := segments[i]; s != "" && !isPathWord(s) && !isHashedSegment(s) {
+			mac := hmac.New(sha256.New, p.salt)
+			mac.Write([]byte(s))
+			segments[i] = hashedSegmentPrefix + hex.EncodeToString(mac.Sum(nil))[:hashedSegmentHex]
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// isPathWord reports whether s is made only of letters, '-', '_' and '.',
+// and so is no ID, email or token.
+func isPathWord(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && r != '-' && r != '_' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHashedSegment(s string) bool {
+	h, ok := strings.CutPrefix(s, hashedSegmentPrefix)
+	if !ok || len(h) != hashedSegmentHex {
+		return false
+	}
+	_, err := hex.DecodeString(h)
+	return err == nil
+}
+
+// params returns the parameters of query that are kept, as logged and in
+// their order.
+func (p *pathPrivacy) params(query string) string {
+	if len(p.keep) == 0 || query == "" {
+		return ""
+	}
+	var kept []string
+	for _, param := range strings.Split(query, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if name != "" && matchParam(p.keep, name) && !matchParam(p.scrub, name) {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+// matchParam reports whether name matches any of patterns.
+func matchParam(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
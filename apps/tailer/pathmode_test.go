@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPathPrivacy(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		keep, scrub string
+		path, query string
+		want        string // with h:… for any hashed segment
+	}{
+		{name: "query dropped", mode: "truncate:9", path: "/search", query: "q=secret", want: "/search"},
+		{name: "truncate", mode: "truncate:2", path: "/docs/guides/install/linux", want: "/docs/guides"},
+		{name: "truncate short path", mode: "truncate:2", path: "/docs", want: "/docs"},
+		{name: "truncate root", mode: "truncate:1", path: "/", want: "/"},
+		{name: "hash IDs", mode: "hash-segments", path: "/user/12345/orders", want: "/user/h:…/orders"},
+		{name: "hash email", mode: "hash-segments", path: "/u/jane%40example.com", want: "/u/h:…"},
+		{name: "first segment kept", mode: "hash-segments", path: "/12345/a", want: "/12345/a"},
+		{name: "kept params", keep: "page,lang", path: "/search", query: "q=secret&page=2&lang=en", want: "/search?page=2&lang=en"},
+		{name: "scrubbed params", keep: "*", scrub: "utm_*,token", path: "/a", query: "utm_source=x&id=7&token=t", want: "/a?id=7"},
+		{name: "none kept", keep: "page", path: "/a", query: "q=1", want: "/a"},
+		{name: "replayed", mode: "hash-segments", keep: "page", path: "/user/h:0123456789ab/orders?page=2", want: "/user/h:0123456789ab/orders?page=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.PathMode, cfg.KeepParams, cfg.ScrubParams, cfg.PathHashDepth, cfg.PathSalt = tt.mode, tt.keep, tt.scrub, 1, "s3cret"
+			p, err := newPathPrivacy(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := &CrawlEvent{Path: tt.path, Query: tt.query}
+			p.apply(e)
+			got := e.Path
+			if strings.Contains(tt.want, "h:…") {
+				// Hashes are checked for their form, not their value.
+				segments := strings.Split(got, "/")
+				for i, s := range segments {
+					if isHashedSegment(s) {
+						segments[i] = "h:…"
+					}
+				}
+				got = strings.Join(segments, "/")
+			}
+			if got != tt.want || e.Query != "" {
+				t.Errorf("path %q, query %q; want %q", e.Path, e.Query, tt.want)
+			}
+		})
+	}
+}
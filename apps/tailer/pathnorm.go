@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const upperHex = "0123456789ABCDEF"
+
+// normalizePath returns p in one canonical form, for -normalize-paths, so
+// that a page requested in different encodings is counted once: escapes
+// of unreserved characters are decoded, other escapes are upper-cased,
+// bytes outside ASCII are escaped, and duplicate slashes and "." and ".."
+// segments are resolved as RFC 3986 does. With nfc, characters outside
+// ASCII are put in Unicode's NFC form first. Malformed escapes are left as
+// they are, and "%2F" stays an escape rather than becoming a separator.
+// Paths that don't start with "/", such as "*", are returned unchanged.
+func normalizePath(p string, nfc bool) string {
+	if !strings.HasPrefix(p, "/") {
+		return p
+	}
+	// First decode every escape beyond ASCII, so that NFC sees the
+	// characters, and settle the ASCII ones.
+	var b strings.Builder
+	b.Grow(len(p))
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c != '%' || i+2 >= len(p) || !isHex(p[i+1]) || !isHex(p[i+2]) {
+			b.WriteByte(c)
+			continue
+		}
+		v := unhex(p[i+1])<<4 | unhex(p[i+2])
+		i += 2
+		switch {
+		case v >= utf8.RuneSelf || isUnreserved(v):
+			b.WriteByte(v)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(upperHex[v>>4])
+			b.WriteByte(upperHex[v&15])
+		}
+	}
+	decoded := b.String()
+	if nfc && utf8.ValidString(decoded) {
+		decoded = norm.NFC.String(decoded)
+	}
+
+	b.Reset()
+	for i := 0; i < len(decoded); i++ {
+		if c := decoded[i]; c >= utf8.RuneSelf || c <= ' ' || c == 0x7f {
+			b.WriteByte('%')
+			b.WriteByte(upperHex[c>>4])
+			b.WriteByte(upperHex[c&15])
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	encoded := b.String()
+
+	// path.Clean drops the trailing slash, which tells "/docs/" from
+	// "/docs".
+	cleaned := path.Clean(encoded)
+	if cleaned != "/" && (strings.HasSuffix(encoded, "/") || strings.HasSuffix(encoded, "/.") || strings.HasSuffix(encoded, "/..")) {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// isUnreserved reports whether c is an unreserved character of RFC 3986,
+// which means the same escaped or not.
+func isUnreserved(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path string
+		nfc  bool
+		want string
+	}{
+		{path: "/docs/a", want: "/docs/a"},
+		{path: "/caf%C3%A9", want: "/caf%C3%A9"},
+		{path: "/caf%c3%a9", want: "/caf%C3%A9"},
+		{path: "/café", want: "/caf%C3%A9"},
+		// "e" and a combining acute accent is "é" in NFC.
+		{path: "/cafe%CC%81", want: "/cafe%CC%81"},
+		{path: "/cafe%CC%81", nfc: true, want: "/caf%C3%A9"},
+		{path: "/café", nfc: true, want: "/caf%C3%A9"},
+		{path: "/%7Euser/%41%62%2d%5F%2E", want: "/~user/Ab-_."},
+		{path: "/a%2fb%2Fc", want: "/a%2Fb%2Fc"},
+		{path: "/a%3fb%20c", want: "/a%3Fb%20c"},
+		{path: "/a b", want: "/a%20b"},
+		{path: "//docs///a//", want: "/docs/a/"},
+		{path: "/docs/./a/../b", want: "/docs/b"},
+		{path: "/docs/a/..", want: "/docs/"},
+		{path: "/docs/.", want: "/docs/"},
+		{path: "/../../etc/passwd", want: "/etc/passwd"},
+		{path: "/%2E%2E/x", want: "/x"},
+		{path: "/", want: "/"},
+		// Malformed escapes are left alone.
+		{path: "/100%", want: "/100%"},
+		{path: "/a%zzb%4", want: "/a%zzb%4"},
+		{path: "/%C3%28", nfc: true, want: "/%C3%28"},
+		{path: "*", want: "*"},
+		{path: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := normalizePath(tt.path, tt.nfc); got != tt.want {
+			t.Errorf("normalizePath(%q, %v) = %q, want %q", tt.path, tt.nfc, got, tt.want)
+		}
+	}
+}
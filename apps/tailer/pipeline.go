@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+	"github.com/originaryx/trace/tailer/pkg/parser"
+)
+
+// parseErrorLogEvery samples parse errors in the log: the first one is
+// logged as a warning and then every parseErrorLogEvery-th, so a
+// mismatched log format doesn't flood the journal. At debug level every
+// one is logged, with the line.
+const parseErrorLogEvery = 1000
+
+// Pipeline turns raw log lines into events and hands them to the sender.
+// It is shared by every tailed file.
+type Pipeline struct {
+	cfg      Config
+	parse    parser.LineParser
+	rules    atomic.Pointer[rules]
+	verifier *BotVerifier
+	proxies  *TrustedProxies // nil unless -trust-proxy
+	dedup    *Dedup
+	eventID  func(*CrawlEvent) string // nil with -event-id-mode off
+	ua       *uaAnonymizer            // nil with -ua-mode raw
+	paths    *pathPrivacy             // nil with -path-mode raw and no -keep-params
+	geoip    *GeoIP                   // nil without -geoip-db and -asn-db
+	ranges   *BotRanges               // nil without -bot-ranges-dir
+	robots   *Robots                  // nil without -robots-file and -robots-fetch
+	bursts   *Bursts                  // nil without bursts in the config file
+	rollups  *Rollups                 // set by main with -mode aggregate
+	sender   *Fanout
+	rejects  *RejectsFile
+	meta     *agentMeta
+	router   *Router // nil without properties
+
+	rejectsLog throttledLog
+	longLog    throttledLog
+
+	// Held while rules are replaced, with what they were built from.
+	rulesMu      sync.Mutex
+	crawlersFile string
+	signatures   []CrawlerRule
+}
+
+// rules are the parts of the pipeline that can be replaced while it runs.
+type rules struct {
+	filter     *Filter
+	classifier *Classifier
+	sampler    *Sampler
+}
+
+func newRules(cfg Config, signatures []CrawlerRule) (*rules, error) {
+	filter, err := NewFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	classifier, err := NewClassifier(cfg.CrawlersFile, signatures)
+	if err != nil {
+		return nil, err
+	}
+	sampler, err := NewSampler(cfg.Sample)
+	if err != nil {
+		return nil, err
+	}
+	return &rules{filter: filter, classifier: classifier, sampler: sampler}, nil
+}
+
+// NewPipeline returns a pipeline feeding sender. Lines that fail to parse
+// are written to rejects if it is non-nil, events are stamped with meta if
+// that is, and events router has no credentials for are dropped.
+func NewPipeline(cfg Config, sender *Fanout, rejects *RejectsFile, meta *agentMeta, router *Router) (*Pipeline, error) {
+	parse, err := newParser(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRules(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pipeline{
+		cfg:          cfg,
+		crawlersFile: cfg.CrawlersFile,
+		parse:        parse,
+		sender:       sender,
+		rejects:      rejects,
+		meta:         meta,
+		router:       router,
+		rejectsLog:   throttledLog{interval: time.Minute},
+		longLog:      throttledLog{interval: time.Minute},
+	}
+	p.rules.Store(r)
+	if p.eventID, err = eventIDFunc(cfg.EventIDMode); err != nil {
+		return nil, err
+	}
+	if p.ua, err = newUAAnonymizer(cfg); err != nil {
+		return nil, err
+	}
+	if p.paths, err = newPathPrivacy(cfg); err != nil {
+		return nil, err
+	}
+	p.geoip = NewGeoIP(cfg)
+	if p.ranges, err = NewBotRanges(cfg.BotRangesDir); err != nil {
+		return nil, err
+	}
+	if p.robots, err = NewRobots(cfg); err != nil {
+		return nil, err
+	}
+	p.bursts = NewBursts(cfg)
+	if cfg.VerifyBots {
+		p.verifier = NewBotVerifier()
+	}
+	if cfg.TrustProxy {
+		if p.proxies, err = NewTrustedProxies(cfg.TrustedProxies); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.DedupWindow > 0 {
+		p.dedup = NewDedup(cfg.DedupWindow, cfg.DedupMaxKeys)
+	}
+	return p, nil
+}
+
+// Reload swaps in the filters and crawler table from cfg. Lines being
+// processed concurrently finish with the old ones.
+func (p *Pipeline) Reload(cfg Config) error {
+	p.rulesMu.Lock()
+	defer p.rulesMu.Unlock()
+	r, err := newRules(cfg, p.signatures)
+	if err != nil {
+		return err
+	}
+	p.rules.Store(r)
+	p.crawlersFile = cfg.CrawlersFile
+	return nil
+}
+
+// SetSignatures replaces the crawler signatures downloaded from the API.
+// If they don't make a valid table with the local rules, the current one
+// is kept.
+func (p *Pipeline) SetSignatures(signatures []CrawlerRule) error {
+	p.rulesMu.Lock()
+	defer p.rulesMu.Unlock()
+	classifier, err := NewClassifier(p.crawlersFile, signatures)
+	if err != nil {
+		return err
+	}
+	next := *p.rules.Load()
+	next.classifier = classifier
+	p.rules.Store(&next)
+	p.signatures = signatures
+	return nil
+}
+
+// errAlreadyRead is returned by ProcessAfter for a line that is no newer
+// than the given time.
+var errAlreadyRead = errors.New("line already read")
+
+// errPastUntil is returned by ProcessAfter for a line logged well after
+// -until, which tells a reader of time-ordered lines that it can stop.
+var errPastUntil = errors.New("line logged after -until")
+
+// untilSlack is how far past -until lines are still skipped one by one
+// before reading stops, as lines are logged a little out of order.
+const untilSlack = 5 * time.Minute
+
+// Process parses one log line from source (a file name, for messages) and
+// queues the resulting event. It returns an error if the line could not
+// be parsed; that has already been logged and recorded.
+func (p *Pipeline) Process(source, line string) error {
+	return p.ProcessAfter(source, line, 0)
+}
+
+// ProcessAfter is Process, except that a line whose timestamp (Unix
+// milliseconds) is at or before after is dropped with errAlreadyRead.
+// Lines logged well past -until are dropped with errPastUntil.
+func (p *Pipeline) ProcessAfter(source, line string, after int64) error {
+	metrics.LinesRead.Inc()
+	metrics.LastLine.Set(time.Now().UnixMilli())
+	// Lines are split at "\n"; Windows servers end them with "\r\n".
+	line = strings.TrimSuffix(line, "\r")
+
+	// However broken the line, parsing it mustn't cost more than a line
+	// of reasonable length would.
+	if p.cfg.MaxLineBytes > 0 && len(line) > p.cfg.MaxLineBytes {
+		metrics.ParseErrors.Inc()
+		metrics.LinesTooLong.Inc()
+		p.longLog.Log(slog.LevelWarn, "Skipped overlong line", "source", source, "bytes", len(line), "max", p.cfg.MaxLineBytes)
+		return fmt.Errorf("line of %d bytes is longer than -max-line-bytes", len(line))
+	}
+	event, err := p.parse.Parse(line)
+	if errors.Is(err, parser.ErrSkip) {
+		return nil
+	}
+	if err != nil {
+		p.parseError(source, line, err)
+		return fmt.Errorf("parse line: %w", err)
+	}
+	parser.Sanitize(event)
+	if event.FetchClass == "" {
+		event.FetchClass = parser.FetchClass(event)
+	}
+	if event.Timestamp <= after {
+		dropEvent(event)
+		return errAlreadyRead
+	}
+	// Lines without a time of their own are as recent as can be.
+	if p.cfg.MaxEventAge > 0 && !event.TimeAssumed && event.Timestamp < time.Now().Add(-p.cfg.MaxEventAge).UnixMilli() {
+		p.filtered("stale", source, line)
+		dropEvent(event)
+		return nil
+	}
+	if !event.TimeAssumed && p.outsideRange(event.Timestamp) {
+		p.filtered("time_range", source, line)
+		ts := event.Timestamp
+		dropEvent(event)
+		if !p.cfg.Until.IsZero() && ts >= p.cfg.Until.Add(untilSlack).UnixMilli() {
+			return errPastUntil
+		}
+		return nil
+	}
+	if !p.enqueue(source, line, event) {
+		dropEvent(event)
+	}
+	return nil
+}
+
+// outsideRange reports whether ts, in Unix milliseconds, is before -since
+// or at or after -until.
+func (p *Pipeline) outsideRange(ts int64) bool {
+	return !p.cfg.Since.IsZero() && ts < p.cfg.Since.UnixMilli() || !p.cfg.Until.IsZero() && ts >= p.cfg.Until.UnixMilli()
+}
+
+// dropEvent gives back an event that was never queued, for the parsers to
+// reuse. Queued events are shared by the senders and never come back.
+func dropEvent(e *CrawlEvent) {
+	event.Put(e)
+}
+
+// enqueue filters, classifies and stamps event, read from line of source,
+// and hands it to the senders, reporting whether it got that far.
+func (p *Pipeline) enqueue(source, line string, event *CrawlEvent) bool {
+	if p.proxies != nil {
+		event.ClientIP = p.proxies.ClientIP(event.ClientIP, event.ForwardedFor)
+	}
+	// Hosts are normalised before anything matches on them.
+	if p.cfg.NormalizeHosts && event.Host != "" {
+		host, ok := normalizeHost(event.Host)
+		switch {
+		case ok:
+			event.Host = host
+		case p.cfg.DefaultHost != "":
+			event.Host = p.cfg.DefaultHost
+		default:
+			p.filtered("invalid_host", source, line)
+			return false
+		}
+	}
+	if p.cfg.NormalizePaths {
+		event.Path = normalizePath(event.Path, p.cfg.PathNFC)
+	}
+	// robots.txt and bursts look at the path as requested.
+	path, target := event.Path, event.Path
+	if event.Query != "" && p.robots != nil {
+		target += "?" + event.Query
+	}
+	r := p.rules.Load()
+	// Extensions are those requested, which -path-mode may cut off.
+	if r.filter.RejectExtension(event.Path) {
+		p.filtered("extension", source, line)
+		return false
+	}
+	// Other filters see paths as they will be sent.
+	if p.paths != nil {
+		p.paths.apply(event)
+	}
+	// Filter before classification so dropped events never cost a DNS
+	// lookup.
+	if reason := r.filter.Reject(event); reason != "" {
+		p.filtered(reason, source, line)
+		return false
+	}
+	if p.router != nil && !p.router.Accepts(event.Host) {
+		p.filtered("host", source, line)
+		return false
+	}
+
+	// Formats without a crawler family field, or nginx configs whose map
+	// left it unset, are classified here.
+	if event.CrawlerFamily == "" || event.CrawlerFamily == "-" {
+		event.CrawlerFamily = r.classifier.Classify(event.UserAgent)
+	}
+	if r.filter.RejectFamily(event) {
+		p.filtered("family", source, line)
+		return false
+	}
+	// Every request counts towards a burst, whether it is sampled or not.
+	if p.bursts != nil {
+		p.bursts.Observe(event.CrawlerFamily, path, event.Status, time.UnixMilli(event.Timestamp))
+	}
+	// Events replayed with -format ndjson were anonymized, verified and
+	// stamped by the tailer that first read them, and keep what it set.
+	if event.IPPrefix == "" {
+		event.IPPrefix = toPrefix(event.ClientIP, p.cfg.IPv4Prefix, p.cfg.IPv6Prefix)
+	}
+	if p.eventID != nil && event.EventID == "" {
+		event.EventID = p.eventID(event)
+	}
+	if p.dedup != nil && p.dedup.Seen(event) {
+		metrics.EventsDeduped.Inc()
+		return false
+	}
+	// In aggregate mode events are counted, and go no further.
+	if p.rollups != nil {
+		p.rollups.Add(event)
+		return false
+	}
+	if !r.sampler.Keep(event) {
+		p.filtered("sample", source, line)
+		return false
+	}
+	// Looked up from the full address, and only for events that are sent.
+	if p.geoip != nil {
+		p.geoip.Enrich(event)
+	}
+	if p.ranges != nil && event.IPMatchesRange == nil {
+		if matched, listed := p.ranges.Match(event.CrawlerFamily, event.ClientIP); listed {
+			event.IPMatchesRange = &matched
+		}
+	}
+	if p.verifier != nil && event.Verified == nil {
+		if domains := r.classifier.Domains(event.CrawlerFamily); len(domains) > 0 {
+			verified := p.verifier.Verify(event.ClientIP, domains)
+			event.Verified = &verified
+		}
+	}
+	if p.robots != nil && event.Disallowed == nil {
+		event.Disallowed = p.robots.Check(event.Host, event.CrawlerFamily, event.UserAgent, target)
+	}
+	if p.ua != nil {
+		p.ua.apply(event)
+	}
+	if p.meta != nil && event.AgentHost == "" {
+		p.meta.stamp(event)
+	}
+	p.sender.Enqueue(event)
+	return true
+}
+
+// filtered counts an event a filter dropped for reason and, with
+// -debug-filters, records its line in the rejects file.
+func (p *Pipeline) filtered(reason, source, line string) {
+	metrics.EventsFiltered[reason].Inc()
+	if p.cfg.DebugFilters && p.rejects != nil {
+		if err := p.rejects.Write(source, line, fmt.Errorf("filtered: %s", reason)); err != nil {
+			p.rejectsLog.Log(slog.LevelError, "Failed to record rejected line", "err", err)
+		}
+	}
+}
+
+func (p *Pipeline) parseError(source, line string, err error) {
+	metrics.ParseErrors.Inc()
+	n := metrics.ParseErrors.Load()
+	if p.rejects != nil {
+		if werr := p.rejects.Write(source, line, err); werr != nil {
+			p.rejectsLog.Log(slog.LevelError, "Failed to record rejected line", "err", werr)
+		}
+	}
+	// In a dry run the operator is setting up a log format, and every bad
+	// line matters, as it is; events go to standard output in full anyway.
+	if p.cfg.DryRun {
+		slog.Warn("Failed to parse line", "source", source, "err", err, "parse_errors", n, "line", truncateLine(line))
+		return
+	}
+	// Otherwise the line may hold sensitive paths and user agents, so it is
+	// only logged at debug level.
+	slog.Debug("Failed to parse line", "source", source, "err", err, "line", truncateLine(line))
+	if n == 1 || n%parseErrorLogEvery == 0 {
+		slog.Warn("Failed to parse line", "source", source, "err", err, "parse_errors", n)
+	}
+}
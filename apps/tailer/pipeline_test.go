@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPipelineReusesOnlyDroppedEvents processes lines from several
+// goroutines, half of which are filtered out, and checks that every event
+// sent is intact. The parser reuses the filtered events while the others
+// are still queued, so run it with -race.
+func TestPipelineReusesOnlyDroppedEvents(t *testing.T) {
+	cfg := testConfig()
+	cfg.Format = "nginx"
+	cfg.ExcludePaths = []string{"^/skip/"}
+	cfg.Backpressure = "block"
+	cfg.Workers = 4
+	api := &fakeAPI{}
+	sender := NewSender(context.Background(), api, cfg, nil)
+	p, err := NewPipeline(cfg, NewFanout(sender, nil, 0), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const goroutines, lines = 4, 500
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range lines {
+				kind := "keep"
+				if i%2 == 1 {
+					kind = "skip"
+				}
+				line := fmt.Sprintf(`1700000000.123 "GET /%s/%d/%d HTTP/1.1" 200 512 "GPTBot/1.0" 203.0.113.7 en 0.010 example.com gptbot`, kind, g, i)
+				if err := p.Process("test", line); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if !sender.Close(5 * time.Second) {
+		t.Fatal("sender did not drain")
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	seen := map[string]bool{}
+	for _, e := range api.received {
+		if !strings.HasPrefix(e.Path, "/keep/") || e.UserAgent != "GPTBot/1.0" || e.Host != "example.com" || seen[e.Path] {
+			t.Fatalf("event sent changed after it was queued: %+v", *e)
+		}
+		seen[e.Path] = true
+	}
+	if len(seen) != goroutines*lines/2 {
+		t.Errorf("sent %d events, want %d", len(seen), goroutines*lines/2)
+	}
+}
+
+func TestMaxEventAge(t *testing.T) {
+	cfg := testConfig()
+	cfg.Format = "nginx"
+	cfg.MaxEventAge = 48 * time.Hour
+	api := &fakeAPI{}
+	sender := NewSender(context.Background(), api, cfg, nil)
+	p, err := NewPipeline(cfg, NewFanout(sender, nil, 0), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := func(ts time.Time) string {
+		return fmt.Sprintf(`%d.000 "GET /a HTTP/1.1" 200 512 "GPTBot/1.0" 203.0.113.7 en 0.010 example.com gptbot`, ts.Unix())
+	}
+	stale := metrics.EventsFiltered["stale"].Load()
+	for _, l := range []string{line(time.Now().Add(-72 * time.Hour)), line(time.Now().Add(-time.Hour))} {
+		if err := p.Process("test", l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !sender.Close(5 * time.Second) {
+		t.Fatal("sender did not drain")
+	}
+	if got := metrics.EventsFiltered["stale"].Load() - stale; got != 1 {
+		t.Errorf("stale events = %d, want 1", got)
+	}
+	api.mu.Lock()
+	if len(api.received) != 1 {
+		t.Errorf("sent %d events, want 1", len(api.received))
+	}
+	api.mu.Unlock()
+
+	// Replays backfill, unless told otherwise.
+	for _, tt := range []struct {
+		args []string
+		want time.Duration
+	}{
+		{[]string{"-dry-run"}, 48 * time.Hour},
+		{[]string{"-dry-run", "-once", "access.log"}, 0},
+		{[]string{"-dry-run", "-file", "-"}, 0},
+		{[]string{"-dry-run", "-file", "-", "-max-event-age", "1h"}, time.Hour},
+		{[]string{"-dry-run", "-stdin"}, 0},
+		{[]string{"-dry-run", "-once", "-max-event-age", "720h", "access.log"}, 720 * time.Hour},
+	} {
+		cfg, _, err := parseConfig(tt.args)
+		if err != nil {
+			t.Fatalf("parseConfig(%v): %v", tt.args, err)
+		}
+		if cfg.MaxEventAge != tt.want {
+			t.Errorf("parseConfig(%v): -max-event-age %v, want %v", tt.args, cfg.MaxEventAge, tt.want)
+		}
+	}
+}
+
+// TestParseErrorLog checks that a line that doesn't parse is quoted in the
+// warning of a dry run, and otherwise only at debug level.
+func TestParseErrorLog(t *testing.T) {
+	const bad = "not an access log line from /private/report"
+	for _, dryRun := range []bool{true, false} {
+		var buf bytes.Buffer
+		prev := slog.Default()
+		slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+		cfg := testConfig()
+		cfg.Format = "nginx"
+		cfg.DryRun = dryRun
+		p, err := NewPipeline(cfg, NewFanout(NewSender(context.Background(), &fakeAPI{}, cfg, nil), nil, 0), nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Process("test", bad)
+		slog.SetDefault(prev)
+
+		logged := buf.String()
+		if quoted := strings.Contains(logged, bad); quoted != dryRun {
+			t.Errorf("dry run %v: line quoted %v in %q", dryRun, quoted, logged)
+		}
+		if dryRun && !strings.Contains(logged, "level=WARN") {
+			t.Errorf("dry run: no warning in %q", logged)
+		}
+	}
+}
+
+// TestPipelineIIS reads IIS lines as a Windows host has them, CRLF and
+// all, and checks the event sent.
+func TestPipelineIIS(t *testing.T) {
+	cfg := testConfig()
+	cfg.Format = "iis"
+	cfg.MaxEventAge = 0
+	api := &fakeAPI{}
+	sender := NewSender(context.Background(), api, cfg, nil)
+	p, err := NewPipeline(cfg, NewFanout(sender, nil, 0), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"#Software: Microsoft Internet Information Services 10.0\r",
+		"#Fields: date time cs-method cs-uri-stem cs-uri-query c-ip cs(User-Agent) cs-host sc-status sc-bytes time-taken\r",
+		"2023-11-14 22:13:20 GET /docs/a - 203.0.113.7 Mozilla/5.0+(compatible;+GPTBot/1.2) www.example.com 200 5120 31\r",
+	} {
+		if err := p.Process("u_ex231114.log", line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !sender.Close(5 * time.Second) {
+		t.Fatal("sender did not drain")
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.received) != 1 {
+		t.Fatalf("sent %d events, want 1", len(api.received))
+	}
+	e := api.received[0]
+	if e.Timestamp != 1700000000000 || e.Host != "www.example.com" || e.Path != "/docs/a" || e.Status != 200 ||
+		e.UserAgent != "Mozilla/5.0 (compatible; GPTBot/1.2)" || e.CrawlerFamily != "gptbot" || e.Bytes != 5120 || e.RequestTimeMs != 31 {
+		t.Errorf("sent %+v", *e)
+	}
+}
+
+// BenchmarkPipeline is a line's way from Process to JSON, with a sender
+// that delivers nowhere.
+func BenchmarkPipeline(b *testing.B) {
+	cfg := testConfig()
+	cfg.Format = "nginx"
+	cfg.MaxEventAge = 0
+	cfg.Backpressure = "block"
+	sender := NewFanout(NewSender(context.Background(), newPrintSender(io.Discard), cfg, nil), nil, 0)
+	defer sender.Close(5 * time.Second)
+	p, err := NewPipeline(cfg, sender, nil, nil, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	now := time.Now()
+	b.ReportAllocs()
+	var line []byte
+	for i := range b.N {
+		line = appendBenchLine(line[:0], i, now)
+		if err := p.Process("bench", string(line)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
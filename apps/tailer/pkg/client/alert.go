@@ -0,0 +1,33 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// Alert reports unusual crawler behaviour an agent noticed, such as a
+// burst of requests from one crawler family. Counts cover the window
+// before Timestamp.
+type Alert struct {
+	Type          string   `json:"type"`
+	Timestamp     int64    `json:"ts"`
+	CrawlerFamily string   `json:"crawler_family"`
+	Reasons       []string `json:"reasons"`
+	WindowMs      int64    `json:"window_ms"`
+	Requests      int64    `json:"requests"`
+	UniquePaths   int64    `json:"unique_paths"`
+	Errors        int64    `json:"errors"`
+	ErrorRatio    float64  `json:"error_ratio"`
+	AgentHost     string   `json:"agent_host,omitempty"`
+	InstanceID    string   `json:"instance_id,omitempty"`
+}
+
+// SendAlert posts a to /v1/alerts, signed like events.
+func (c *Client) SendAlert(ctx context.Context, a *Alert) error {
+	body := getBuffer()
+	if err := body.encodeObject(a); err != nil {
+		body.release()
+		return fmt.Errorf("marshal alert: %w", err)
+	}
+	return c.post(ctx, "/v1/alerts", "application/json", body)
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxErrorBytes is how much of an error response is kept to explain it.
+	maxErrorBytes = 4 << 10
+	// maxErrorText is how much of that goes into a StatusError's Body.
+	maxErrorText = 512
+)
+
+// temporaryCodes gives, for the error codes the API answers with, whether
+// a request refused with them may succeed if sent again. Codes not listed
+// fall back on the status.
+var temporaryCodes = map[string]bool{
+	"rate_limit_exceeded":  true,
+	"internal_error":       true,
+	"timestamp_skew":       true,
+	"missing_auth_headers": false,
+	"invalid_api_key":      false,
+	"invalid_signature":    false,
+	"replay_detected":      false,
+	"missing_body":         false,
+	"invalid_json":         false,
+	"no_valid_events":      false,
+	"validation_failed":    false,
+	"not_implemented":      false,
+}
+
+// StatusError is returned when the API answers with a non-success status.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // from the Retry-After header, if any
+	Code       string        // the error or code field of a JSON body
+	Message    string        // its message field
+	Body       string        // the body otherwise, sanitized and truncated
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("API returned status %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	switch {
+	case e.Message != "":
+		msg += ": " + e.Message
+	case e.Body != "":
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Temporary reports whether the request may succeed if sent again: by the
+// error code when the API gave a known one, and otherwise for 5xx and 429.
+func (e *StatusError) Temporary() bool {
+	if t, ok := temporaryCodes[e.Code]; ok {
+		return t
+	}
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// newStatusError describes a refused request from its response and up to
+// maxErrorBytes of its body.
+func newStatusError(resp *http.Response, body []byte) *StatusError {
+	se := &StatusError{StatusCode: resp.StatusCode}
+	if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		se.RetryAfter = d
+	}
+	var v struct {
+		Error   json.RawMessage `json:"error"`
+		Code    json.RawMessage `json:"code"`
+		Message json.RawMessage `json:"message"`
+	}
+	if json.Unmarshal(body, &v) == nil {
+		se.Code = sanitize(jsonString(v.Error))
+		if se.Code == "" {
+			se.Code = sanitize(jsonString(v.Code))
+		}
+		se.Message = sanitize(jsonString(v.Message))
+		if se.Code != "" || se.Message != "" {
+			return se
+		}
+	}
+	se.Body = sanitize(string(bytes.TrimSpace(body)))
+	return se
+}
+
+// readResponse reads what little the API answers and closes the body, so
+// that the connection can be reused, keeping up to maxErrorBytes of a
+// refusal to see why.
+func readResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	r := io.LimitReader(resp.Body, maxResponseBytes)
+	if resp.StatusCode < 400 {
+		_, err := io.Copy(io.Discard, r)
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(r, maxErrorBytes))
+	if err == nil {
+		_, err = io.Copy(io.Discard, r)
+	}
+	return body, err
+}
+
+// jsonString returns the field v if it is a string or a number, which
+// some APIs use for codes.
+func jsonString(v json.RawMessage) string {
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s
+	}
+	var n json.Number
+	if json.Unmarshal(v, &n) == nil {
+		return n.String()
+	}
+	return ""
+}
+
+// sanitize makes text from a response fit for an error message and a log
+// line: invalid UTF-8 and control characters become spaces, runs of space
+// collapse, and the result is cut to maxErrorText bytes.
+func sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, strings.ToValidUTF8(s, " "))
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= maxErrorText {
+		return s
+	}
+	cut := maxErrorText
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
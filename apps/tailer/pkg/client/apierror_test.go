@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStatusErrorBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		contentType   string
+		body          string
+		wantCode      string
+		wantErr       string
+		wantTemporary bool
+	}{
+		{name: "empty", status: 400, wantErr: "API returned status 400"},
+		{name: "error code", status: 400, contentType: "application/json", body: `{"error":"no_valid_events"}`, wantCode: "no_valid_events", wantErr: "API returned status 400 (no_valid_events)"},
+		{name: "code and message", status: 422, contentType: "application/json", body: `{"code":"bad_host","message":"host\nnot allowed"}`, wantCode: "bad_host", wantErr: "API returned status 422 (bad_host): host not allowed"},
+		{name: "temporary code", status: 401, contentType: "application/json", body: `{"error":"timestamp_skew"}`, wantCode: "timestamp_skew", wantErr: "API returned status 401 (timestamp_skew)", wantTemporary: true},
+		{name: "permanent code", status: 501, contentType: "application/json", body: `{"error":"not_implemented"}`, wantCode: "not_implemented", wantErr: "API returned status 501 (not_implemented)"},
+		{name: "unknown code", status: 503, contentType: "application/json", body: `{"error":"overloaded"}`, wantCode: "overloaded", wantErr: "API returned status 503 (overloaded)", wantTemporary: true},
+		{name: "text", status: 502, contentType: "text/html", body: "<html>\r\n<b>Bad\x00 gateway</b>\xff</html>\n", wantErr: "API returned status 502: <html> <b>Bad gateway</b> </html>", wantTemporary: true},
+		{name: "long text", status: 500, body: strings.Repeat("é", 1000), wantErr: "API returned status 500: " + strings.Repeat("é", maxErrorText/2) + "…", wantTemporary: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			err := New(srv.URL, "pk_test", "sk_test").Send(context.Background(), sampleEvents(1))
+			var se *StatusError
+			if !errors.As(err, &se) {
+				t.Fatalf("err = %v, want *StatusError", err)
+			}
+			if se.Code != tt.wantCode || err.Error() != tt.wantErr || se.Temporary() != tt.wantTemporary {
+				t.Errorf("got code %q, %q, temporary %v; want %q, %q, %v", se.Code, err, se.Temporary(), tt.wantCode, tt.wantErr, tt.wantTemporary)
+			}
+		})
+	}
+}
+
+func TestSendReusesConnections(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"accepted":1,"message":"` + strings.Repeat("x", 2*maxErrorBytes) + `"}`))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	c := New(srv.URL, "pk_test", "sk_test")
+	for range 3 {
+		if err := c.Send(context.Background(), sampleEvents(2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("%d connections for 3 requests, want 1", n)
+	}
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBuffer is the largest buffer kept for reuse. One grown past it
+// by an unusually large batch is left to the garbage collector, so the
+// pool doesn't pin the memory.
+const maxPooledBuffer = 1 << 20
+
+// encodeBuffer is a buffer a request body is built in, with a JSON
+// encoder writing to it. Both are reused from encodeBuffers, so each
+// sender worker ends up encoding every batch with the same ones.
+type encodeBuffer struct {
+	bytes.Buffer
+	enc *json.Encoder
+}
+
+var encodeBuffers = sync.Pool{New: func() any {
+	b := new(encodeBuffer)
+	b.enc = json.NewEncoder(&b.Buffer)
+	return b
+}}
+
+func getBuffer() *encodeBuffer {
+	b := encodeBuffers.Get().(*encodeBuffer)
+	b.Reset()
+	return b
+}
+
+// release returns b to the pool. b must not be used afterwards.
+func (b *encodeBuffer) release() {
+	if b.Cap() <= maxPooledBuffer {
+		encodeBuffers.Put(b)
+	}
+}
+
+// encodeLine appends v to b as a line of NDJSON.
+func (b *encodeBuffer) encodeLine(v any) error {
+	return b.enc.Encode(v)
+}
+
+// encodeObject appends v to b as JSON, without the newline the encoder
+// ends it with, as json.Marshal would.
+func (b *encodeBuffer) encodeObject(v any) error {
+	if err := b.enc.Encode(v); err != nil {
+		return err
+	}
+	b.Truncate(b.Len() - 1)
+	return nil
+}
+
+// sharedBuffer is a buffer held by post and by the request bodies read
+// from it. The transport may still be writing a body after the response
+// has come back, and close it only then, so the buffer is released once
+// the last holder lets go.
+type sharedBuffer struct {
+	buf  *encodeBuffer
+	refs atomic.Int32
+}
+
+func newSharedBuffer(buf *encodeBuffer) *sharedBuffer {
+	s := &sharedBuffer{buf: buf}
+	s.refs.Store(1)
+	return s
+}
+
+// drop lets go of one hold on the buffer.
+func (s *sharedBuffer) drop() {
+	if s.refs.Add(-1) == 0 {
+		s.buf.release()
+	}
+}
+
+// body returns a new reader of the buffer, which holds it until closed.
+// A body that is never closed only keeps the buffer from the pool.
+func (s *sharedBuffer) body() io.ReadCloser {
+	s.refs.Add(1)
+	return &requestBody{Reader: bytes.NewReader(s.buf.Bytes()), shared: s}
+}
+
+type requestBody struct {
+	*bytes.Reader
+	shared *sharedBuffer
+	once   sync.Once
+}
+
+func (b *requestBody) Close() error {
+	b.once.Do(b.shared.drop)
+	return nil
+}
@@ -0,0 +1,311 @@
+// Package client sends crawl events to the Originary Trace ingest API,
+// signing each request with the API key's HMAC secret or, optionally, an
+// Ed25519 private key.
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// DefaultTimeout bounds a request when neither Timeout nor HTTPClient is
+// set.
+const DefaultTimeout = 5 * time.Second
+
+// maxResponseBytes is how much of a response body is read before the
+// connection is given up rather than reused.
+const maxResponseBytes = 64 << 10
+
+// Sender delivers events to the ingest API. Client is the HTTP
+// implementation; tests and other transports can provide their own.
+type Sender interface {
+	// Send delivers events as a single request. It returns a
+	// *StatusError if the API rejected them.
+	Send(ctx context.Context, events []*event.CrawlEvent) error
+}
+
+var _ Sender = (*Client)(nil)
+
+// Client posts events to an ingest endpoint. Options are set on the
+// exported fields before first use; credentials may be replaced at any
+// time, including while requests are in flight.
+type Client struct {
+	// Endpoint is the API base URL, e.g. https://api.trace.originary.xyz,
+	// or a unix socket, e.g. unix:///run/trace/ingest.sock, to which
+	// requests are made as to http://localhost.
+	Endpoint string
+
+	// HTTPClient makes the requests. If nil, http.DefaultTransport is
+	// used.
+	HTTPClient *http.Client
+
+	// Header holds further headers set on every request, such as those a
+	// gateway in front of the API requires. Those the client sets itself
+	// (see ReservedHeader) take precedence.
+	Header http.Header
+
+	// Timeout bounds each request, from connecting to reading the
+	// response. If zero, DefaultTimeout applies unless HTTPClient is set,
+	// in which case its own timeout does.
+	Timeout time.Duration
+
+	// Compression is "gzip" to compress larger bodies, or "" for none.
+	Compression string
+
+	// SignUncompressed signs the body before compression rather than the
+	// bytes sent, for an API that verifies after decompressing.
+	SignUncompressed bool
+
+	// SigVersion selects the signing scheme: 1 (or 0) signs the body
+	// alone, 2 signs a canonical string binding it to the method, path and
+	// timestamp of the request; see SignV2.
+	SigVersion int
+
+	// PrivateKey, if set, signs requests with Ed25519 instead of the HMAC
+	// secret, which is then unused. The API holds only the public key.
+	PrivateKey ed25519.PrivateKey
+
+	// OnRequest, if set, is called with the duration of every request.
+	OnRequest func(time.Duration)
+
+	// ClockResync is how often a clock offset, once applied, is measured
+	// again against the Date header of responses, so that it goes away
+	// when the local clock is fixed. If zero, DefaultClockResync applies.
+	ClockResync time.Duration
+
+	// OnClockSkew, if set, is called with the offset applied to signing
+	// timestamps whenever it changes, 0 once it is dropped.
+	OnClockSkew func(time.Duration)
+
+	creds atomic.Pointer[[2]string]
+	unix  unixTransport
+	clock clock
+}
+
+// New returns a client for endpoint using the given API key ID and secret.
+func New(endpoint, key, secret string) *Client {
+	c := &Client{Endpoint: endpoint}
+	c.SetCredentials(key, secret)
+	return c
+}
+
+// SetCredentials replaces the key ID and secret used for later requests.
+func (c *Client) SetCredentials(key, secret string) {
+	c.creds.Store(&[2]string{key, secret})
+}
+
+// Credentials returns the key ID and secret currently in use.
+func (c *Client) Credentials() (key, secret string) {
+	v := c.creds.Load()
+	return v[0], v[1]
+}
+
+// Send posts a single event as a JSON object and anything else as an
+// NDJSON batch.
+func (c *Client) Send(ctx context.Context, events []*event.CrawlEvent) error {
+	if len(events) == 1 {
+		return c.SendEvent(ctx, events[0])
+	}
+	return c.SendBatch(ctx, events)
+}
+
+// SendEvent posts a single event as a JSON object.
+func (c *Client) SendEvent(ctx context.Context, e *event.CrawlEvent) error {
+	body := getBuffer()
+	if err := body.encodeObject(e); err != nil {
+		body.release()
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	return c.post(ctx, "/v1/events", "application/json", body)
+}
+
+// SendBatch posts events as an NDJSON body. The signature covers the full
+// batch body, exactly as for single events.
+func (c *Client) SendBatch(ctx context.Context, events []*event.CrawlEvent) error {
+	body := getBuffer()
+	for _, e := range events {
+		if err := body.encodeLine(e); err != nil {
+			body.release()
+			return fmt.Errorf("marshal event: %w", err)
+		}
+	}
+	return c.post(ctx, "/v1/events", "application/x-ndjson", body)
+}
+
+// post signs body and sends it to path under the endpoint. The events
+// route accepts a single JSON object, a JSON array, or NDJSON depending on
+// contentType. post takes over body, which is released once the request
+// is done with it.
+//
+// X-Peac-Timestamp is the signing time, not the event time: it must be
+// close to the server's clock to pass the replay window, while the events
+// themselves carry the time they were logged in their ts field.
+func (c *Client) post(ctx context.Context, path, contentType string, body *encodeBuffer) error {
+	wireBuf, encoding, err := compressBody(c.Compression, body)
+	if err != nil {
+		body.release()
+		return err
+	}
+	if wireBuf != body {
+		defer body.release()
+	}
+	shared := newSharedBuffer(wireBuf)
+	defer shared.drop()
+	wire := wireBuf.Bytes()
+	signed := wire
+	if c.SignUncompressed {
+		signed = body.Bytes()
+	}
+
+	ctx, cancel, hc, err := c.prepare(ctx)
+	defer cancel()
+	if err != nil {
+		return err
+	}
+	send := func() (*http.Response, []byte, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", c.requestURL(path), shared.body())
+		if err != nil {
+			return nil, nil, fmt.Errorf("create request: %w", err)
+		}
+		req.ContentLength = int64(len(wire))
+		req.GetBody = func() (io.ReadCloser, error) { return shared.body(), nil }
+
+		c.setHeader(req)
+		req.Header.Set("Content-Type", contentType)
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		c.sign(req, signed)
+
+		start := time.Now()
+		resp, err := hc.Do(req)
+		var body []byte
+		if err == nil {
+			body, err = readResponse(resp)
+		}
+		if c.OnRequest != nil {
+			c.OnRequest(time.Since(start))
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("send request: %w", err)
+		}
+		return resp, body, nil
+	}
+
+	for retried := false; ; retried = true {
+		resp, body, err := send()
+		if err != nil {
+			return err
+		}
+		// A request refused for its timestamp is signed again, once, on the
+		// server's time.
+		if c.checkClock(resp, body) && !retried {
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			return newStatusError(resp, body)
+		}
+		return nil
+	}
+}
+
+// prepare bounds ctx by the request timeout and returns the HTTP client to
+// make the request with.
+func (c *Client) prepare(ctx context.Context) (context.Context, context.CancelFunc, *http.Client, error) {
+	hc, timeout := c.HTTPClient, c.Timeout
+	if hc == nil {
+		hc = http.DefaultClient
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+	}
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	hc, err := c.httpClient(hc)
+	return ctx, cancel, hc, err
+}
+
+// setHeader copies c.Header to req.
+func (c *Client) setHeader(req *http.Request) {
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+}
+
+// ReservedHeader reports whether the client sets the header name itself,
+// so that Header can't replace it: the content headers, Host, and the
+// X-Peac-* authentication headers.
+func ReservedHeader(name string) bool {
+	switch name = http.CanonicalHeaderKey(name); name {
+	case "Content-Type", "Content-Encoding", "Content-Length", "Host":
+		return true
+	}
+	return strings.HasPrefix(name, "X-Peac-")
+}
+
+// sign sets the authentication headers on req, whose body (or, with
+// SignUncompressed, its uncompressed form) is signed.
+func (c *Client) sign(req *http.Request, signed []byte) {
+	signedAt := c.signingTime()
+	msg := signed
+	if c.SigVersion == 2 {
+		msg = []byte(CanonicalRequest(req.Method, req.URL.EscapedPath(), signedAt, signed))
+		req.Header.Set("X-Peac-Sig-Version", "2")
+	}
+	c.setSignature(req, signedAt, msg)
+}
+
+// setSignature signs msg, made at signedAt, and sets the key, timestamp
+// and signature headers on req.
+func (c *Client) setSignature(req *http.Request, signedAt int64, msg []byte) {
+	key, secret := c.Credentials()
+	var signature string
+	if c.PrivateKey != nil {
+		signature = SignEd25519(c.PrivateKey, msg)
+		req.Header.Set("X-Peac-Alg", "ed25519")
+	} else {
+		signature = Sign([]byte(secret), msg)
+	}
+	req.Header.Set("X-Peac-Key", key)
+	req.Header.Set("X-Peac-Timestamp", strconv.FormatInt(signedAt, 10))
+	req.Header.Set("X-Peac-Signature", signature)
+}
+
+// Sign returns the version 1 X-Peac-Signature value for body: the
+// base64-encoded HMAC-SHA256 of the exact bytes under secret.
+func Sign(secret, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write(body)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// SignV2 returns the version 2 X-Peac-Signature value: the base64-encoded
+// HMAC-SHA256 under secret of CanonicalRequest, so that a captured request
+// can't be replayed to another path or outside the timestamp window.
+func SignV2(secret []byte, method, path string, timestamp int64, body []byte) string {
+	return Sign(secret, []byte(CanonicalRequest(method, path, timestamp, body)))
+}
+
+// CanonicalRequest is the string signed by version 2: the method, the
+// escaped request path (including any base path of the endpoint, but no
+// query), the X-Peac-Timestamp value and the hex SHA-256 of the body, each
+// on its own line with no trailing newline.
+func CanonicalRequest(method, path string, timestamp int64, body []byte) string {
+	sum := sha256.Sum256(body)
+	return method + "\n" + path + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + hex.EncodeToString(sum[:])
+}
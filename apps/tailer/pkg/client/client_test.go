@@ -0,0 +1,532 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		want   string
+	}{
+		// RFC 4231 test cases 1 and 2, base64-encoded.
+		{"rfc4231 case 1", strings.Repeat("\x0b", 20), "Hi There", "sDRMYdjbOFNcqK/OrwvxK4gdwgDJgz2nJuk3bC4yz/c="},
+		{"rfc4231 case 2", "Jefe", "what do ya want for nothing?", "W9zBRr9gdU5qBCQmCJV1x1oAPwidJzmDnexYuWTsOEM="},
+		// Same as the API's hmacB64 in apps/api/src/hmac.ts.
+		{"event body", "sk_test_secret", `{"ts":1700000000123,"path":"/a"}`, "p475lnbIf2KNRmpL9qJZpMgWQ3j7zHKhYxn5vEqEITk="},
+		{"empty body", "sk", "", "GhLg1AvvY1Mfb+o0dhiR27fO5xpQMvjUAQZzsUipbd4="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign([]byte(tt.secret), []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignV2(t *testing.T) {
+	// Known answers for the server's verifier; computed independently.
+	tests := []struct {
+		name      string
+		secret    string
+		path      string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{"event body", "sk_test_secret", "/v1/events", 1700000000456, `{"ts":1700000000123,"path":"/a"}`, "TC7jD81GXVVXPCGduQHNfRBqG8gY1lB/139zThpFpSM="},
+		{"base path, empty body", "sk", "/trace/v1/agent/heartbeat", 1700000000000, "", "JmTsy+TcPDBckAKFiNwNHBokBNypDVRp83rLX6WCb4c="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SignV2([]byte(tt.secret), "POST", tt.path, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("SignV2 = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	const want = "POST\n/v1/events\n1700000000456\nc26dc578bfe22a2b6718d2152fd1bdb575042b24d015b4d2e0abc3b0426ecf46"
+	if got := CanonicalRequest("POST", "/v1/events", 1700000000456, []byte(`{"ts":1700000000123,"path":"/a"}`)); got != want {
+		t.Errorf("CanonicalRequest = %q, want %q", got, want)
+	}
+}
+
+// request is what the test server saw.
+type request struct {
+	path     string
+	header   http.Header
+	body     []byte
+	received time.Time
+}
+
+func newServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, <-chan request) {
+	t.Helper()
+	reqs := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		reqs <- request{path: r.URL.Path, header: r.Header.Clone(), body: body, received: time.Now()}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, reqs
+}
+
+func accept(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) }
+
+func status(code int, retryAfter string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(code)
+	}
+}
+
+func sampleEvents(n int) []*event.CrawlEvent {
+	events := make([]*event.CrawlEvent, n)
+	for i := range events {
+		events[i] = &event.CrawlEvent{
+			Timestamp: 1700000000000 + int64(i), Host: "example.com", Path: "/p/" + strconv.Itoa(i),
+			Method: "GET", Status: 200, UserAgent: "GPTBot/1.2", CrawlerFamily: "gptbot", Source: event.SourceNginx,
+		}
+	}
+	return events
+}
+
+func TestSendRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		events      int
+		compression string
+		signRaw     bool
+		sigVersion  int
+		wantType    string
+		wantGzip    bool
+	}{
+		{name: "single event", events: 1, wantType: "application/json"},
+		{name: "batch", events: 3, wantType: "application/x-ndjson"},
+		{name: "small batch not compressed", events: 2, compression: "gzip", wantType: "application/x-ndjson"},
+		{name: "gzip signs compressed bytes", events: 50, compression: "gzip", wantType: "application/x-ndjson", wantGzip: true},
+		{name: "gzip signs uncompressed bytes", events: 50, compression: "gzip", signRaw: true, wantType: "application/x-ndjson", wantGzip: true},
+		{name: "v2 single event", events: 1, sigVersion: 2, wantType: "application/json"},
+		{name: "v2 gzip batch", events: 50, compression: "gzip", sigVersion: 2, wantType: "application/x-ndjson", wantGzip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, reqs := newServer(t, accept)
+			c := New(srv.URL, "pk_old", "old")
+			c.SetCredentials("pk_test", "sk_test")
+			c.Compression = tt.compression
+			c.SignUncompressed = tt.signRaw
+			c.SigVersion = tt.sigVersion
+
+			before := time.Now().UnixMilli()
+			if err := c.Send(context.Background(), sampleEvents(tt.events)); err != nil {
+				t.Fatal(err)
+			}
+			r := <-reqs
+
+			if r.path != "/v1/events" {
+				t.Errorf("path = %q, want /v1/events", r.path)
+			}
+			if got := r.header.Get("X-Peac-Key"); got != "pk_test" {
+				t.Errorf("X-Peac-Key = %q, want pk_test", got)
+			}
+			if got := r.header.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			ts, err := strconv.ParseInt(r.header.Get("X-Peac-Timestamp"), 10, 64)
+			if err != nil || ts < before || ts > r.received.UnixMilli() {
+				t.Errorf("X-Peac-Timestamp = %q, want signing time in ms", r.header.Get("X-Peac-Timestamp"))
+			}
+
+			raw := r.body
+			if gotGzip := r.header.Get("Content-Encoding") == "gzip"; gotGzip != tt.wantGzip {
+				t.Fatalf("gzip = %v, want %v", gotGzip, tt.wantGzip)
+			}
+			if tt.wantGzip {
+				zr, err := gzip.NewReader(bytes.NewReader(r.body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if raw, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if lines := bytes.Count(raw, []byte("\n")); tt.events > 1 && lines != tt.events {
+				t.Errorf("body has %d lines, want %d", lines, tt.events)
+			}
+
+			signed := r.body
+			if tt.signRaw {
+				signed = raw
+			}
+			want := Sign([]byte("sk_test"), signed)
+			if tt.sigVersion == 2 {
+				want = SignV2([]byte("sk_test"), "POST", r.path, ts, signed)
+			}
+			if got := r.header.Get("X-Peac-Sig-Version"); (got == "2") != (tt.sigVersion == 2) {
+				t.Errorf("X-Peac-Sig-Version = %q, want version %d", got, tt.sigVersion)
+			}
+			if got := r.header.Get("X-Peac-Signature"); got != want {
+				t.Errorf("X-Peac-Signature = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSendHeader(t *testing.T) {
+	for _, events := range []int{1, 3} {
+		t.Run(strconv.Itoa(events)+" events", func(t *testing.T) {
+			srv, reqs := newServer(t, accept)
+			c := New(srv.URL, "pk_test", "sk_test")
+			c.Header = http.Header{
+				"X-Org-Id":      {"42"},
+				"Authorization": {"Bearer gw-token"},
+				"Content-Type":  {"text/plain"}, // not one to replace
+			}
+			if err := c.Send(context.Background(), sampleEvents(events)); err != nil {
+				t.Fatal(err)
+			}
+			r := <-reqs
+			if r.header.Get("X-Org-Id") != "42" || r.header.Get("Authorization") != "Bearer gw-token" {
+				t.Errorf("headers %v, want X-Org-Id and Authorization", r.header)
+			}
+			if got := r.header.Get("Content-Type"); got == "text/plain" {
+				t.Errorf("Content-Type = %q, replaced by Header", got)
+			}
+			if got, want := r.header.Get("X-Peac-Signature"), Sign([]byte("sk_test"), r.body); got != want {
+				t.Errorf("X-Peac-Signature = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestReservedHeader(t *testing.T) {
+	for name, want := range map[string]bool{
+		"content-type": true, "Content-Encoding": true, "host": true,
+		"X-Peac-Key": true, "x-peac-signature": true, "X-PEAC-TIMESTAMP": true,
+		"X-Org-Id": false, "Authorization": false,
+	} {
+		if got := ReservedHeader(name); got != want {
+			t.Errorf("ReservedHeader(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		timeout        time.Duration
+		clientTimeout  time.Duration
+		wantStatus     int
+		wantRetryAfter time.Duration
+		wantTimeout    bool
+	}{
+		{name: "accepted", handler: accept},
+		{name: "bad request", handler: status(http.StatusBadRequest, ""), wantStatus: 400},
+		{name: "unauthorized", handler: status(http.StatusUnauthorized, ""), wantStatus: 401},
+		{name: "server error", handler: status(http.StatusServiceUnavailable, ""), wantStatus: 503},
+		{name: "throttled", handler: status(http.StatusTooManyRequests, "60"), wantStatus: 429, wantRetryAfter: time.Minute},
+		{
+			name:        "timeout",
+			handler:     func(w http.ResponseWriter, r *http.Request) { <-r.Context().Done() },
+			timeout:     50 * time.Millisecond,
+			wantTimeout: true,
+		},
+		{
+			name: "slow body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+			},
+			clientTimeout: 50 * time.Millisecond,
+			wantTimeout:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newServer(t, tt.handler)
+			c := New(srv.URL, "pk_test", "sk_test")
+			if tt.timeout > 0 {
+				c.HTTPClient = &http.Client{Timeout: tt.timeout}
+			}
+			c.Timeout = tt.clientTimeout
+
+			err := c.Send(context.Background(), sampleEvents(2))
+
+			var se *StatusError
+			switch {
+			case tt.wantStatus != 0:
+				if !errors.As(err, &se) {
+					t.Fatalf("err = %v, want *StatusError", err)
+				}
+				if se.StatusCode != tt.wantStatus || se.RetryAfter != tt.wantRetryAfter {
+					t.Errorf("got status %d, Retry-After %s; want %d, %s", se.StatusCode, se.RetryAfter, tt.wantStatus, tt.wantRetryAfter)
+				}
+			case tt.wantTimeout:
+				var te interface{ Timeout() bool }
+				if !errors.As(err, &te) || !te.Timeout() {
+					t.Errorf("err = %v, want a timeout", err)
+				}
+			default:
+				if err != nil {
+					t.Errorf("err = %v, want nil", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSendCancelled(t *testing.T) {
+	srv, _ := newServer(t, func(w http.ResponseWriter, r *http.Request) { <-r.Context().Done() })
+	c := New(srv.URL, "pk_test", "sk_test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := c.Send(ctx, sampleEvents(1)); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0, true},
+		{"-5", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.in, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %s, %v; want %s, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSendHeartbeat(t *testing.T) {
+	srv, reqs := newServer(t, accept)
+	c := New(srv.URL, "pk_test", "sk_test")
+
+	hb := &Heartbeat{AgentVersion: "1.2.3", Hostname: "edge-1", Files: []string{"/var/log/nginx/peac.log"}, LinesRead: 10}
+	if err := c.SendHeartbeat(context.Background(), hb); err != nil {
+		t.Fatalf("SendHeartbeat: %v", err)
+	}
+	req := <-reqs
+	if req.path != "/v1/agent/heartbeat" {
+		t.Errorf("path = %s, want /v1/agent/heartbeat", req.path)
+	}
+	if got, want := req.header.Get("X-Peac-Signature"), Sign([]byte("sk_test"), req.body); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if !bytes.Contains(req.body, []byte(`"hostname":"edge-1"`)) {
+		t.Errorf("body = %s, want the hostname", req.body)
+	}
+}
+
+func TestSendAlert(t *testing.T) {
+	srv, reqs := newServer(t, accept)
+	c := New(srv.URL, "pk_test", "sk_test")
+
+	a := &Alert{Type: "burst", Timestamp: 1700000000000, CrawlerFamily: "gptbot", Reasons: []string{"requests"}, WindowMs: 600000, Requests: 5000}
+	if err := c.SendAlert(context.Background(), a); err != nil {
+		t.Fatalf("SendAlert: %v", err)
+	}
+	req := <-reqs
+	if req.path != "/v1/alerts" {
+		t.Errorf("path = %s, want /v1/alerts", req.path)
+	}
+	if got, want := req.header.Get("X-Peac-Signature"), Sign([]byte("sk_test"), req.body); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if !bytes.Contains(req.body, []byte(`"crawler_family":"gptbot"`)) || !bytes.Contains(req.body, []byte(`"reasons":["requests"]`)) {
+		t.Errorf("body = %s, want the family and reasons", req.body)
+	}
+}
+
+func TestSendRollup(t *testing.T) {
+	srv, reqs := newServer(t, accept)
+	c := New(srv.URL, "pk_test", "sk_test")
+
+	r := &Rollup{WindowStart: 1700000040000, WindowMs: 60000, PathDepth: 2, Counts: []RollupCount{{Host: "example.com", CrawlerFamily: "gptbot", StatusClass: "2xx", PathPrefix: "/docs/guides", Requests: 42}}}
+	if err := c.SendRollup(context.Background(), r); err != nil {
+		t.Fatalf("SendRollup: %v", err)
+	}
+	req := <-reqs
+	if req.path != "/v1/rollups" {
+		t.Errorf("path = %s, want /v1/rollups", req.path)
+	}
+	if got, want := req.header.Get("X-Peac-Signature"), Sign([]byte("sk_test"), req.body); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+	if !bytes.Contains(req.body, []byte(`"path_prefix":"/docs/guides","requests":42`)) {
+		t.Errorf("body = %s, want the counts", req.body)
+	}
+}
+
+// discardTransport answers every request with 202 after reading its body,
+// without a network.
+type discardTransport struct{}
+
+func (discardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	io.Copy(io.Discard, req.Body)
+	req.Body.Close()
+	return &http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody, Header: http.Header{}, Request: req}, nil
+}
+
+// BenchmarkSendBatch measures building, signing and handing over a
+// 100-event batch, which is what each sender worker does per request.
+func BenchmarkSendBatch(b *testing.B) {
+	events := sampleEvents(100)
+	for _, compress := range []string{"", "gzip"} {
+		name := compress
+		if name == "" {
+			name = "none"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := New("http://api.invalid", "pk_test", "sk_test")
+			c.HTTPClient = &http.Client{Transport: discardTransport{}}
+			c.Compression = compress
+			b.ReportAllocs()
+			for range b.N {
+				if err := c.SendBatch(context.Background(), events); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// lateReadTransport answers at once and reads the body afterwards, as a
+// transport does when the server responds before the request is fully
+// written. It checks each body against its signature.
+type lateReadTransport struct {
+	secret []byte
+	wg     sync.WaitGroup
+	bad    atomic.Int32
+}
+
+func (lt *lateReadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	lt.wg.Add(1)
+	go func() {
+		defer lt.wg.Done()
+		time.Sleep(time.Millisecond)
+		body, _ := io.ReadAll(req.Body)
+		req.Body.Close()
+		if Sign(lt.secret, body) != req.Header.Get("X-Peac-Signature") || int64(len(body)) != req.ContentLength {
+			lt.bad.Add(1)
+		}
+	}()
+	return &http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody, Header: http.Header{}, Request: req}, nil
+}
+
+// TestSendReusesBuffersSafely sends from many goroutines at once, so that
+// pooled buffers are reused while earlier bodies are still being read.
+// Run it with -race.
+func TestSendReusesBuffersSafely(t *testing.T) {
+	for _, compress := range []string{"", "gzip"} {
+		lt := &lateReadTransport{secret: []byte("sk_test")}
+		c := New("http://api.invalid", "pk_test", "sk_test")
+		c.HTTPClient = &http.Client{Transport: lt}
+		c.Compression = compress
+
+		var wg sync.WaitGroup
+		for g := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 50 {
+					events := sampleEvents(1 + (g+i)%40)
+					if err := c.Send(context.Background(), events); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		lt.wg.Wait()
+		if n := lt.bad.Load(); n > 0 {
+			t.Errorf("compression %q: %d bodies changed after they were signed", compress, n)
+		}
+	}
+}
+
+func TestFetchSignatures(t *testing.T) {
+	const list = `[{"family":"newbot","match":"newbot"}]`
+	srv, reqs := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, list)
+	})
+	c := New(srv.URL, "pk_test", "sk_test")
+
+	body, etag, err := c.FetchSignatures(context.Background(), "")
+	if err != nil {
+		t.Fatalf("FetchSignatures: %v", err)
+	}
+	if string(body) != list || etag != `"v1"` {
+		t.Errorf("got %s with ETag %s, want %s with \"v1\"", body, etag, list)
+	}
+	req := <-reqs
+	if req.path != "/v1/crawlers/signatures" {
+		t.Errorf("path = %s, want /v1/crawlers/signatures", req.path)
+	}
+	if got, want := req.header.Get("X-Peac-Signature"), Sign([]byte("sk_test"), nil); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+
+	if _, etag, err = c.FetchSignatures(context.Background(), etag); !errors.Is(err, ErrNotModified) || etag != `"v1"` {
+		t.Errorf("FetchSignatures with the current ETag = %q, %v; want \"v1\", ErrNotModified", etag, err)
+	}
+	<-reqs
+}
+
+func TestPing(t *testing.T) {
+	srv, reqs := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"status":"ok"}`)
+	})
+	c := New(srv.URL, "pk_test", "sk_test")
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if req := <-reqs; req.path != "/health" {
+		t.Errorf("path = %s, want /health", req.path)
+	}
+
+	srv, _ = newServer(t, status(http.StatusServiceUnavailable, ""))
+	var se *StatusError
+	if err := New(srv.URL, "pk_test", "sk_test").Ping(context.Background()); !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Ping of a failing API = %v, want a 503 StatusError", err)
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultClockResync is how often a clock offset is measured again by
+// default.
+const DefaultClockResync = 10 * time.Minute
+
+const (
+	// clockSkewMin is the smallest offset applied. Date has whole seconds
+	// and a response takes a while to arrive, so less is noise, and the
+	// API's window is minutes wide anyway.
+	clockSkewMin = 2 * time.Second
+
+	// clockSkewSuspect is the skew at which a 401 is put down to the
+	// timestamp even when the API doesn't say so.
+	clockSkewSuspect = time.Minute
+)
+
+// clock is the offset from the local time to the server's added to
+// signing timestamps, for hosts whose clock has drifted out of the API's
+// replay window.
+type clock struct {
+	mu       sync.Mutex
+	offset   time.Duration
+	measured time.Time
+}
+
+// signingTime is the X-Peac-Timestamp value for a request signed now.
+func (c *Client) signingTime() int64 {
+	c.clock.mu.Lock()
+	offset := c.clock.offset
+	c.clock.mu.Unlock()
+	return time.Now().Add(offset).UnixMilli()
+}
+
+// checkClock measures the skew from the server's Date header when resp
+// was refused for its timestamp, and every ClockResync while an offset is
+// applied. It reports whether the offset was changed for a refused
+// request, which is then worth signing again.
+func (c *Client) checkClock(resp *http.Response, body []byte) bool {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	// Date is truncated to the second, so the server's time was on
+	// average half a second later.
+	skew := date.Add(500 * time.Millisecond).Sub(now)
+	refused := resp.StatusCode == http.StatusUnauthorized &&
+		(bytes.Contains(bytes.ToLower(body), []byte("timestamp")) || skew.Abs() >= clockSkewSuspect)
+
+	resync := c.ClockResync
+	if resync <= 0 {
+		resync = DefaultClockResync
+	}
+	k := &c.clock
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !refused && (k.offset == 0 || now.Sub(k.measured) < resync) {
+		return false
+	}
+	k.measured = now
+	if skew.Abs() < clockSkewMin {
+		skew = 0
+	}
+	if (skew - k.offset).Abs() < clockSkewMin {
+		return false
+	}
+	k.offset = skew.Round(time.Second)
+	if c.OnClockSkew != nil {
+		c.OnClockSkew(k.offset)
+	}
+	return refused
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// skewedServer is an API whose clock is ahead of the local one by the
+// stored offset. It refuses timestamps more than five minutes off its own
+// time, as the API does, or on a bad signature.
+type skewedServer struct {
+	*httptest.Server
+	ahead    atomic.Int64 // nanoseconds
+	requests atomic.Int32
+}
+
+func newSkewedServer(t *testing.T, ahead time.Duration) *skewedServer {
+	t.Helper()
+	s := &skewedServer{}
+	s.ahead.Store(int64(ahead))
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		now := time.Now().Add(time.Duration(s.ahead.Load()))
+		w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+		ts, _ := strconv.ParseInt(r.Header.Get("X-Peac-Timestamp"), 10, 64)
+		switch {
+		case r.Header.Get("X-Peac-Key") != "pk":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_signature"}`))
+		case time.UnixMilli(ts).Sub(now).Abs() > 5*time.Minute:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"timestamp_skew"}`))
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestClockSkewCorrected(t *testing.T) {
+	srv := newSkewedServer(t, 10*time.Minute)
+	c := New(srv.URL, "pk", "sk")
+	var skews []time.Duration
+	c.OnClockSkew = func(d time.Duration) { skews = append(skews, d) }
+
+	for i := range 2 {
+		if err := c.Send(context.Background(), sampleEvents(2)); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+	}
+	if n := srv.requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3: one refused, retried, then one signed right away", n)
+	}
+	if len(skews) != 1 || (skews[0]-10*time.Minute).Abs() > 2*time.Second {
+		t.Errorf("OnClockSkew called with %v, want about 10m once", skews)
+	}
+
+	// Once the local clock is fixed, the offset is refused in turn and
+	// measured again as none.
+	srv.ahead.Store(0)
+	if err := c.Send(context.Background(), sampleEvents(2)); err != nil {
+		t.Fatal(err)
+	}
+	if len(skews) != 2 || skews[1] != 0 {
+		t.Errorf("OnClockSkew called with %v, want 0 after the clock was fixed", skews)
+	}
+}
+
+func TestClockSkewResync(t *testing.T) {
+	srv := newSkewedServer(t, 0)
+	c := New(srv.URL, "pk", "sk")
+	c.ClockResync = time.Minute
+	c.clock.offset = 2 * time.Minute // within the window, so never refused
+	c.clock.measured = time.Now()
+
+	if err := c.Send(context.Background(), sampleEvents(2)); err != nil {
+		t.Fatal(err)
+	}
+	if c.clock.offset == 0 {
+		t.Fatal("offset dropped before ClockResync passed")
+	}
+	c.clock.measured = time.Now().Add(-time.Hour)
+	if err := c.Send(context.Background(), sampleEvents(2)); err != nil {
+		t.Fatal(err)
+	}
+	if c.clock.offset != 0 {
+		t.Errorf("offset %v kept after the server's Date agreed with the local clock", c.clock.offset)
+	}
+}
+
+func TestClockSkewOtherRefusals(t *testing.T) {
+	tests := []struct {
+		name  string
+		ahead time.Duration
+		want  int32
+	}{
+		{"clock right", 0, 1},
+		// Put down to the skew, so retried, but only once.
+		{"clock off", 10 * time.Minute, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSkewedServer(t, tt.ahead)
+			c := New(srv.URL, "pk_wrong", "sk")
+			var se *StatusError
+			if err := c.Send(context.Background(), sampleEvents(2)); !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("Send = %v, want 401", err)
+			}
+			if n := srv.requests.Load(); n != tt.want {
+				t.Errorf("%d requests, want %d", n, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"compress/gzip"
+	"fmt"
+	"sync"
+)
+
+// compressMinBytes is the smallest body worth compressing. Below it the
+// gzip header and trailer outweigh the savings; a single event is
+// usually smaller.
+const compressMinBytes = 1024
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// compressBody returns body as it should go on the wire, which is body
+// itself or a new buffer holding it compressed, and its Content-Encoding,
+// "" if it is sent as is.
+func compressBody(compression string, body *encodeBuffer) (*encodeBuffer, string, error) {
+	if compression != "gzip" || body.Len() < compressMinBytes {
+		return body, "", nil
+	}
+
+	buf := getBuffer()
+	buf.Grow(body.Len() / 4)
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(&buf.Buffer)
+	if _, err := zw.Write(body.Bytes()); err != nil {
+		buf.release()
+		return nil, "", fmt.Errorf("compress body: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		buf.release()
+		return nil, "", fmt.Errorf("compress body: %w", err)
+	}
+	return buf, "gzip", nil
+}
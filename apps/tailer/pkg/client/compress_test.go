@@ -0,0 +1,107 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// sampleBatch is a representative NDJSON batch of crawler events.
+func sampleBatch(n int) []byte {
+	uas := []string{
+		"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)",
+		"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; ClaudeBot/1.0; +claudebot@anthropic.com)",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; PerplexityBot/1.0; +https://perplexity.ai/perplexitybot)",
+	}
+	families := []string{"gptbot", "claudebot", "googlebot", "perplexitybot"}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range n {
+		enc.Encode(&event.CrawlEvent{
+			Timestamp:     1700000000123 + int64(i)*37,
+			Host:          "example.com",
+			Path:          fmt.Sprintf("/blog/2024/%02d/post-%d", i%12+1, i*7919%1000),
+			Method:        "GET",
+			Status:        200,
+			UserAgent:     uas[i%len(uas)],
+			IPPrefix:      fmt.Sprintf("20.%d.%d.0/24", i%200, i*31%256),
+			AcceptLang:    "en-US",
+			CrawlerFamily: families[i%len(families)],
+			Source:        event.SourceNginx,
+		})
+	}
+	return buf.Bytes()
+}
+
+// bufferOf returns a pooled buffer holding b.
+func bufferOf(b []byte) *encodeBuffer {
+	buf := getBuffer()
+	buf.Write(b)
+	return buf
+}
+
+func TestCompressBody(t *testing.T) {
+	body := sampleBatch(100)
+
+	wireBuf, encoding, err := compressBody("gzip", bufferOf(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding != "gzip" {
+		t.Fatalf("encoding = %q, want gzip", encoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(wireBuf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatal("decompressed body differs from original")
+	}
+
+	small := body[:compressMinBytes-1]
+	if wire, encoding, _ := compressBody("gzip", bufferOf(small)); encoding != "" || !bytes.Equal(wire.Bytes(), small) {
+		t.Errorf("body under %d bytes was compressed", compressMinBytes)
+	}
+	if _, encoding, _ := compressBody("", bufferOf(body)); encoding != "" {
+		t.Error("body compressed with compression disabled")
+	}
+}
+
+// BenchmarkCompressBatch reports bytes on the wire for a 100-event batch
+// with and without gzip.
+func BenchmarkCompressBatch(b *testing.B) {
+	body := sampleBatch(100)
+	for _, compress := range []string{"", "gzip"} {
+		name := compress
+		if name == "" {
+			name = "none"
+		}
+		b.Run(name, func(b *testing.B) {
+			in := bufferOf(body)
+			var wireLen int
+			b.SetBytes(int64(len(body)))
+			for range b.N {
+				wire, _, err := compressBody(compress, in)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if wireLen = wire.Len(); wire != in {
+					wire.release()
+				}
+			}
+			b.ReportMetric(float64(wireLen), "wire-bytes")
+			b.ReportMetric(float64(len(body))/float64(wireLen), "ratio")
+		})
+	}
+}
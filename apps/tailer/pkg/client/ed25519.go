@@ -0,0 +1,55 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SignEd25519 returns the X-Peac-Signature value for msg under key: the
+// base64-encoded Ed25519 signature. msg is what the HMAC would cover, the
+// body for version 1 or CanonicalRequest for version 2.
+func SignEd25519(key ed25519.PrivateKey, msg []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, msg))
+}
+
+// ParsePrivateKey reads an Ed25519 private key from a PEM "PRIVATE KEY"
+// block (PKCS #8, as written by openssl genpkey -algorithm ed25519) or
+// from base64 text holding the 32-byte seed or the 64-byte private key.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "PRIVATE KEY" {
+			return nil, fmt.Errorf("PEM block is %q, want PRIVATE KEY", block.Type)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse PKCS #8 key: %w", err)
+		}
+		ed, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("key is %T, not Ed25519", key)
+		}
+		return ed, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.New("not a PEM private key or base64 seed")
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		key := ed25519.NewKeyFromSeed(raw[:ed25519.SeedSize])
+		if !key.Equal(ed25519.PrivateKey(raw)) {
+			return nil, errors.New("private key does not match its public half")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("decoded key is %d bytes, want a %d-byte seed or %d-byte key", len(raw), ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+}
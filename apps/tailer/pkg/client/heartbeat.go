@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// Heartbeat tells the API an agent is alive, so a quiet site can be told
+// apart from a dead agent. Counters cover the period since the previous
+// heartbeat that was delivered.
+type Heartbeat struct {
+	AgentVersion  string   `json:"agent_version"`
+	Hostname      string   `json:"hostname"`
+	InstanceID    string   `json:"instance_id,omitempty"`
+	Files         []string `json:"files"`
+	PeriodMs      int64    `json:"period_ms"`
+	LinesRead     int64    `json:"lines_read"`
+	ParseErrors   int64    `json:"parse_errors"`
+	EventsSent    int64    `json:"events_sent"`
+	EventsDropped int64    `json:"events_dropped"`
+	QueueDepth    int64    `json:"queue_depth"`
+}
+
+// SendHeartbeat posts hb to /v1/agent/heartbeat, signed like events.
+func (c *Client) SendHeartbeat(ctx context.Context, hb *Heartbeat) error {
+	body := getBuffer()
+	if err := body.encodeObject(hb); err != nil {
+		body.release()
+		return fmt.Errorf("marshal heartbeat: %w", err)
+	}
+	return c.post(ctx, "/v1/agent/heartbeat", "application/json", body)
+}
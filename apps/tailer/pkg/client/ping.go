@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Ping checks that the API answers, with a GET of /health. The request
+// isn't signed, so it says nothing about the credentials.
+func (c *Client) Ping(ctx context.Context) error {
+	ctx, cancel, hc, err := c.prepare(ctx)
+	defer cancel()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.requestURL("/health"), nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	c.setHeader(req)
+	req.Header.Set("Accept", "application/json")
+
+	start := time.Now()
+	resp, err := hc.Do(req)
+	var body []byte
+	if err == nil {
+		body, err = readResponse(resp)
+	}
+	if c.OnRequest != nil {
+		c.OnRequest(time.Since(start))
+	}
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return newStatusError(resp, body)
+	}
+	return nil
+}
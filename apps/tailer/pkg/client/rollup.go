@@ -0,0 +1,39 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// Rollup summarises the events of one window, for agents sending counts
+// instead of events. The window starts at WindowStart, in Unix ms, on a
+// multiple of WindowMs.
+type Rollup struct {
+	WindowStart int64         `json:"window_start"`
+	WindowMs    int64         `json:"window_ms"`
+	PathDepth   int           `json:"path_depth"`
+	Counts      []RollupCount `json:"counts"`
+	AgentHost   string        `json:"agent_host,omitempty"`
+	InstanceID  string        `json:"instance_id,omitempty"`
+}
+
+// RollupCount is the requests of the window with the same host, crawler
+// family, status class ("2xx") and path prefix.
+type RollupCount struct {
+	Host          string `json:"host"`
+	CrawlerFamily string `json:"crawler_family"`
+	StatusClass   string `json:"status_class"`
+	PathPrefix    string `json:"path_prefix"`
+	Requests      int64  `json:"requests"`
+	Bytes         int64  `json:"bytes"`
+}
+
+// SendRollup posts r to /v1/rollups, signed like events.
+func (c *Client) SendRollup(ctx context.Context, r *Rollup) error {
+	body := getBuffer()
+	if err := body.encodeObject(r); err != nil {
+		body.release()
+		return fmt.Errorf("marshal rollup: %w", err)
+	}
+	return c.post(ctx, "/v1/rollups", "application/json", body)
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxSignaturesBytes bounds the crawler signature list.
+const maxSignaturesBytes = 4 << 20
+
+// ErrNotModified is returned by FetchSignatures when the list is still the
+// one with the ETag given.
+var ErrNotModified = errors.New("signature list not modified")
+
+// FetchSignatures gets the crawler signature list from
+// /v1/crawlers/signatures, signed like events with an empty body, and
+// returns it with its ETag. If etag is that of the current list, it
+// returns ErrNotModified instead.
+func (c *Client) FetchSignatures(ctx context.Context, etag string) (list []byte, newETag string, err error) {
+	ctx, cancel, hc, err := c.prepare(ctx)
+	defer cancel()
+	if err != nil {
+		return nil, "", err
+	}
+	for retried := false; ; retried = true {
+		req, err := http.NewRequestWithContext(ctx, "GET", c.requestURL("/v1/crawlers/signatures"), nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("create request: %w", err)
+		}
+		c.setHeader(req)
+		req.Header.Set("Accept", "application/json")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		c.sign(req, nil)
+
+		start := time.Now()
+		resp, err := hc.Do(req)
+		var body []byte
+		if err == nil {
+			body, err = readSignatures(resp)
+		}
+		if c.OnRequest != nil {
+			c.OnRequest(time.Since(start))
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("send request: %w", err)
+		}
+		if c.checkClock(resp, body) && !retried {
+			continue
+		}
+		switch {
+		case resp.StatusCode == http.StatusNotModified:
+			return nil, etag, ErrNotModified
+		case resp.StatusCode >= 400:
+			return nil, "", newStatusError(resp, body)
+		}
+		return body, resp.Header.Get("ETag"), nil
+	}
+}
+
+func readSignatures(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSignaturesBytes+1))
+	if err == nil && len(body) > maxSignaturesBytes {
+		err = fmt.Errorf("signature list larger than %d bytes", maxSignaturesBytes)
+	}
+	return body, err
+}
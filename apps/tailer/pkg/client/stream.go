@@ -0,0 +1,356 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// StreamPath is the route events are streamed to.
+const StreamPath = "/v1/events/stream"
+
+// Defaults for the limits of a Stream, as set by NewStream.
+const (
+	DefaultStreamMaxDuration  = time.Minute
+	DefaultStreamMaxBytes     = 8 << 20
+	DefaultStreamWriteTimeout = 10 * time.Second
+	DefaultStreamRetryDelay   = time.Minute
+)
+
+// streamResendLines is the most events sent again in one batch when a
+// stream fails.
+const streamResendLines = 500
+
+var (
+	errStreamStalled = errors.New("stream stalled: the server stopped reading")
+	errStreamEnded   = errors.New("stream ended by the server")
+	errStreamPaused  = errors.New("streaming paused after a failure")
+)
+
+var _ Sender = (*Stream)(nil)
+
+// Stream sends events as NDJSON lines of one long-lived chunked POST to
+// StreamPath, rather than a request per batch. A stream is ended, and the
+// next one opened, once it has been open MaxDuration or carried MaxBytes;
+// only the response that ends it says the server took the events.
+//
+// When a stream can't be opened, the server ends it, fails it, or stops
+// reading for WriteTimeout, the events go as ordinary batches instead:
+// those already written to the stream are sent again, so the server may
+// see some of them twice, and streaming is retried after RetryDelay. The
+// events of the open stream are all that is kept in memory for this, so
+// MaxBytes also bounds it.
+type Stream struct {
+	// Client signs the streams and sends the batches.
+	Client *Client
+
+	MaxDuration  time.Duration
+	MaxBytes     int
+	WriteTimeout time.Duration
+	RetryDelay   time.Duration
+
+	// OnFallback, if set, is called with the reason a stream failed.
+	OnFallback func(error)
+
+	// OnLost, if set, is called with the number of events written to a
+	// failed stream that could not be sent again either.
+	OnLost func(events int, err error)
+
+	ctx     context.Context
+	mu      sync.Mutex
+	cur     *openStream
+	retryAt time.Time
+}
+
+// NewStream returns a stream sender over c with the default limits.
+// Cancelling ctx aborts any open stream.
+func NewStream(ctx context.Context, c *Client) *Stream {
+	return &Stream{
+		Client:       c,
+		MaxDuration:  DefaultStreamMaxDuration,
+		MaxBytes:     DefaultStreamMaxBytes,
+		WriteTimeout: DefaultStreamWriteTimeout,
+		RetryDelay:   DefaultStreamRetryDelay,
+		ctx:          ctx,
+	}
+}
+
+// Send writes events to the open stream, opening one if needed, and
+// returns once the server has read them. If it can't, it posts them as a
+// batch and returns the result of that.
+func (s *Stream) Send(ctx context.Context, events []*event.CrawlEvent) error {
+	body := getBuffer()
+	for _, e := range events {
+		if err := body.encodeLine(e); err != nil {
+			body.release()
+			return fmt.Errorf("marshal event: %w", err)
+		}
+	}
+	s.mu.Lock()
+	err := s.write(body.Bytes(), len(events))
+	s.mu.Unlock()
+	if err == nil {
+		body.release()
+		return nil
+	}
+	return s.Client.post(ctx, "/v1/events", "application/x-ndjson", body)
+}
+
+// Close ends the open stream, if any, and waits for the server's answer.
+// Events of a stream that fails then are sent again as batches.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o := s.cur; o != nil {
+		s.cur = nil
+		s.finish(o)
+	}
+}
+
+// write writes p, holding n events, to the open stream. s.mu is held.
+func (s *Stream) write(p []byte, n int) error {
+	if time.Now().Before(s.retryAt) {
+		return errStreamPaused
+	}
+	if o := s.cur; o != nil && (o.ended() || o.sent.Len() > 0 && o.sent.Len()+len(p) > s.MaxBytes) {
+		s.cur = nil
+		s.finish(o)
+	}
+	if s.cur == nil {
+		o, err := s.open()
+		if err != nil {
+			s.pause(err)
+			return err
+		}
+		s.cur = o
+	}
+	o := s.cur
+	if err := o.write(p, s.WriteTimeout); err != nil {
+		s.cur = nil
+		s.failed(o, err)
+		return err
+	}
+	o.sent.Write(p)
+	o.events += n
+	return nil
+}
+
+// open starts a new stream. The request runs until the stream is ended.
+func (s *Stream) open() (*openStream, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, fmt.Errorf("generate stream nonce: %w", err)
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.Client.requestURL(StreamPath), pr)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.ContentLength = -1
+	s.Client.setHeader(req)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	s.Client.signStream(req, hex.EncodeToString(nonce[:]))
+
+	// The stream lasts far longer than a request may, so the client's
+	// own timeout can't apply to it.
+	hc := http.Client{}
+	if s.Client.HTTPClient != nil {
+		hc = *s.Client.HTTPClient
+		hc.Timeout = 0
+	}
+	sc, err := s.Client.httpClient(&hc)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	o := &openStream{pw: pw, cancel: cancel, done: make(chan struct{})}
+	go o.run(sc, req)
+	o.timer = time.AfterFunc(s.MaxDuration, func() { s.expire(o) })
+	return o, nil
+}
+
+// expire ends o once it has been open MaxDuration, unless it has already
+// ended, so that a quiet stream doesn't leave its events unconfirmed.
+func (s *Stream) expire(o *openStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur == o {
+		s.cur = nil
+		s.finish(o)
+	}
+}
+
+// finish ends o and waits for the server's answer.
+func (s *Stream) finish(o *openStream) {
+	o.timer.Stop()
+	o.pw.Close()
+	t := time.NewTimer(s.Client.requestTimeout())
+	defer t.Stop()
+	select {
+	case <-o.done:
+		if o.err != nil {
+			s.failed(o, o.err)
+			return
+		}
+		o.cancel()
+	case <-t.C:
+		s.failed(o, errors.New("no answer to the end of the stream"))
+	}
+}
+
+// failed gives up on o after err: its events are sent again as batches,
+// and streaming pauses for RetryDelay.
+func (s *Stream) failed(o *openStream, err error) {
+	o.timer.Stop()
+	o.cancel()
+	s.pause(err)
+	if o.events == 0 {
+		return
+	}
+	if lost, err := s.resend(o.sent.Bytes()); err != nil && s.OnLost != nil {
+		s.OnLost(lost, err)
+	}
+}
+
+func (s *Stream) pause(err error) {
+	s.retryAt = time.Now().Add(s.RetryDelay)
+	if s.OnFallback != nil {
+		s.OnFallback(err)
+	}
+}
+
+// resend posts the NDJSON lines in data as batches. On failure it stops
+// and returns how many lines were not sent.
+func (s *Stream) resend(data []byte) (int, error) {
+	for len(data) > 0 {
+		end, lines := 0, 0
+		for end < len(data) && lines < streamResendLines {
+			i := bytes.IndexByte(data[end:], '\n')
+			if i < 0 {
+				end = len(data)
+			} else {
+				end += i + 1
+			}
+			lines++
+		}
+		body := getBuffer()
+		body.Write(data[:end])
+		if err := s.Client.post(s.ctx, "/v1/events", "application/x-ndjson", body); err != nil {
+			return bytes.Count(data, []byte{'\n'}), err
+		}
+		data = data[end:]
+	}
+	return 0, nil
+}
+
+// openStream is one stream request in progress.
+type openStream struct {
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+	timer  *time.Timer  // ends the stream after MaxDuration
+	sent   bytes.Buffer // the lines written, to send again if the stream fails
+	events int
+
+	done chan struct{} // closed once the request is over
+	err  error         // why it failed, set before done is closed
+}
+
+// ended reports whether the request is over, as when the server closed
+// an idle stream.
+func (o *openStream) ended() bool {
+	select {
+	case <-o.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (o *openStream) run(hc *http.Client, req *http.Request) {
+	defer close(o.done)
+	resp, err := hc.Do(req)
+	if err != nil {
+		o.err = fmt.Errorf("send stream: %w", err)
+		return
+	}
+	body, _ := readResponse(resp)
+	if resp.StatusCode >= 400 {
+		o.err = newStatusError(resp, body)
+	}
+}
+
+// write writes p to the stream, giving up and breaking the stream if the
+// server hasn't read it within timeout. The transport flushes each write
+// as a chunk, so a server that stops reading soon fills the connection's
+// buffers and blocks it.
+func (o *openStream) write(p []byte, timeout time.Duration) error {
+	written := make(chan error, 1)
+	go func() {
+		_, err := o.pw.Write(p)
+		written <- err
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	var err error
+	select {
+	case err = <-written:
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("write stream: %w", err)
+	case <-o.done:
+		err = o.err
+		if err == nil {
+			err = errStreamEnded
+		}
+	case <-t.C:
+		err = errStreamStalled
+	}
+	// Closing the pipe returns a pending Write, after which p is no longer
+	// in use.
+	o.pw.CloseWithError(err)
+	<-written
+	return err
+}
+
+// requestTimeout is how long a request may take, as post applies it.
+func (c *Client) requestTimeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	if c.HTTPClient != nil && c.HTTPClient.Timeout > 0 {
+		return c.HTTPClient.Timeout
+	}
+	return DefaultTimeout
+}
+
+// signStream sets the authentication headers on a stream request. Its
+// body isn't known yet, so the signature covers CanonicalStream instead
+// and the stream identifies itself with nonce.
+func (c *Client) signStream(req *http.Request, nonce string) {
+	signedAt := c.signingTime()
+	req.Header.Set("X-Peac-Sig-Version", "stream")
+	req.Header.Set("X-Peac-Stream-Nonce", nonce)
+	c.setSignature(req, signedAt, []byte(CanonicalStream(req.Method, req.URL.EscapedPath(), signedAt, nonce)))
+}
+
+// CanonicalStream is the string signed for a stream: the method, the
+// escaped request path, the X-Peac-Timestamp value and the
+// X-Peac-Stream-Nonce value, each on its own line with no trailing
+// newline. The server refuses a nonce it has seen before, so captured
+// headers can't open another stream.
+func CanonicalStream(method, path string, timestamp int64, nonce string) string {
+	return method + "\n" + path + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce
+}
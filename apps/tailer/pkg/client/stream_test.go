@@ -0,0 +1,188 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamServer serves StreamPath with streamHandler and counts the events
+// posted as batches.
+type streamServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	streams int
+	batched int
+}
+
+func newStreamServer(t *testing.T, streamHandler http.HandlerFunc) *streamServer {
+	t.Helper()
+	s := &streamServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		if r.URL.Path == StreamPath {
+			s.streams++
+			s.mu.Unlock()
+			streamHandler(w, r)
+			return
+		}
+		s.mu.Unlock()
+		var buf bytes.Buffer
+		buf.ReadFrom(r.Body)
+		s.mu.Lock()
+		s.batched += bytes.Count(buf.Bytes(), []byte{'\n'})
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *streamServer) counts() (streams, batched int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams, s.batched
+}
+
+func TestStreamSendsLinesAsTheyArrive(t *testing.T) {
+	lines := make(chan string, 10)
+	srv := newStreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+		ts, _ := strconv.ParseInt(r.Header.Get("X-Peac-Timestamp"), 10, 64)
+		want := Sign([]byte("sk"), []byte(CanonicalStream("POST", StreamPath, ts, r.Header.Get("X-Peac-Stream-Nonce"))))
+		if r.Header.Get("X-Peac-Sig-Version") != "stream" || r.Header.Get("X-Peac-Signature") != want {
+			t.Errorf("stream headers %v, want signature %s", r.Header, want)
+		}
+		if len(r.Header.Get("X-Peac-Stream-Nonce")) != 32 {
+			t.Errorf("nonce %q, want 16 hex bytes", r.Header.Get("X-Peac-Stream-Nonce"))
+		}
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	s := NewStream(context.Background(), New(srv.URL, "pk", "sk"))
+
+	for i := range 3 {
+		if err := s.Send(context.Background(), sampleEvents(2)); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+		// Each batch must reach the server while the stream stays open.
+		for range 2 {
+			select {
+			case <-lines:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("batch %d not received before the stream ended", i)
+			}
+		}
+	}
+	s.Close()
+	if streams, batched := srv.counts(); streams != 1 || batched != 0 {
+		t.Errorf("%d streams and %d batched events, want 1 stream and none batched", streams, batched)
+	}
+}
+
+func TestStreamRotates(t *testing.T) {
+	srv := newStreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+		bufio.NewReader(r.Body).WriteTo(new(bytes.Buffer))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	s := NewStream(context.Background(), New(srv.URL, "pk", "sk"))
+	s.MaxBytes = 1
+
+	for range 3 {
+		if err := s.Send(context.Background(), sampleEvents(2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	if streams, batched := srv.counts(); streams != 3 || batched != 0 {
+		t.Errorf("%d streams and %d batched events, want 3 streams and none batched", streams, batched)
+	}
+}
+
+func TestStreamFallsBack(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"refused", status(http.StatusNotFound, "")},
+		{"failed at the end", func(w http.ResponseWriter, r *http.Request) {
+			bufio.NewReader(r.Body).WriteTo(new(bytes.Buffer))
+			w.WriteHeader(http.StatusInternalServerError)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFallback(t, newStreamServer(t, tt.handler), 3)
+		})
+	}
+}
+
+func TestStreamFallsBackWhenServerStopsReading(t *testing.T) {
+	// The server never reads, so sending goes on until the connection's
+	// buffers are full and a write stalls.
+	release := make(chan struct{})
+	srv := newStreamServer(t, func(w http.ResponseWriter, r *http.Request) { <-release })
+	t.Cleanup(func() { close(release) })
+	checkFallback(t, srv, 1000)
+}
+
+// checkFallback sends up to batches batches of 1000 events over a stream
+// to srv, stopping at the first fallback, and checks that every event was
+// then posted as a batch exactly once.
+func checkFallback(t *testing.T, srv *streamServer, batches int) {
+	t.Helper()
+	c := New(srv.URL, "pk", "sk")
+	c.Timeout = 2 * time.Second
+	s := NewStream(context.Background(), c)
+	s.MaxBytes = 1 << 30
+	s.WriteTimeout = 200 * time.Millisecond
+	var fallbacks int
+	s.OnFallback = func(error) { fallbacks++ }
+	s.OnLost = func(n int, err error) { t.Errorf("lost %d events: %v", n, err) }
+
+	sent := 0
+	for i := 0; i < batches && fallbacks == 0; i++ {
+		if err := s.Send(context.Background(), sampleEvents(1000)); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+		sent += 1000
+	}
+	s.Close()
+	if fallbacks != 1 {
+		t.Errorf("%d fallbacks, want 1", fallbacks)
+	}
+	if _, batched := srv.counts(); batched != sent {
+		t.Errorf("%d events batched, want all %d sent", batched, sent)
+	}
+}
+
+func TestStreamPausesAfterFailure(t *testing.T) {
+	srv := newStreamServer(t, status(http.StatusNotFound, ""))
+	s := NewStream(context.Background(), New(srv.URL, "pk", "sk"))
+	s.WriteTimeout = 200 * time.Millisecond
+
+	for range 3 {
+		if err := s.Send(context.Background(), sampleEvents(2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	if streams, batched := srv.counts(); streams != 1 || batched != 6 {
+		t.Errorf("%d streams and %d batched events, want 1 stream and 6 batched", streams, batched)
+	}
+}
+
+func TestCanonicalStream(t *testing.T) {
+	const want = "POST\n/v1/events/stream\n1700000000456\n00112233445566778899aabbccddeeff"
+	if got := CanonicalStream("POST", StreamPath, 1700000000456, "00112233445566778899aabbccddeeff"); got != want {
+		t.Errorf("CanonicalStream = %q, want %q", got, want)
+	}
+}
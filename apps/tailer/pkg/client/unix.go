@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// UnixPrefix starts an Endpoint that is a unix socket rather than a URL,
+// as in unix:///run/trace/ingest.sock.
+const UnixPrefix = "unix://"
+
+// unixHost is the host in the URL, and so the Host header, of requests
+// over a unix socket. Nothing resolves it: the connection is to the
+// socket.
+const unixHost = "localhost"
+
+// unixTransport is the transport dialling a unix socket endpoint, derived
+// from the one the client was given.
+type unixTransport struct {
+	mu     sync.Mutex
+	base   http.RoundTripper
+	socket string
+	rt     *http.Transport
+}
+
+// socketPath returns the socket of a unix:// endpoint.
+func socketPath(endpoint string) (string, bool) {
+	return strings.CutPrefix(endpoint, UnixPrefix)
+}
+
+// requestURL returns the URL of path on the endpoint.
+func (c *Client) requestURL(path string) string {
+	if _, ok := socketPath(c.Endpoint); ok {
+		return "http://" + unixHost + path
+	}
+	return c.Endpoint + path
+}
+
+// httpClient returns hc to make requests to the endpoint with or, for a
+// unix socket endpoint, a copy of it whose transport dials the socket
+// instead of the URL's host. The transport is otherwise hc's, with the
+// same connection limits and timeouts, but no proxy.
+func (c *Client) httpClient(hc *http.Client) (*http.Client, error) {
+	socket, ok := socketPath(c.Endpoint)
+	if !ok {
+		return hc, nil
+	}
+	base := hc.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ut := &c.unix
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	if ut.rt == nil || ut.base != base || ut.socket != socket {
+		t, ok := base.(*http.Transport)
+		if !ok {
+			return nil, errors.New("a unix socket endpoint needs an *http.Transport")
+		}
+		rt := t.Clone()
+		rt.Proxy = nil
+		rt.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		if ut.rt != nil {
+			ut.rt.CloseIdleConnections()
+		}
+		ut.base, ut.socket, ut.rt = base, socket, rt
+	}
+	cp := *hc
+	cp.Transport = ut.rt
+	return &cp, nil
+}
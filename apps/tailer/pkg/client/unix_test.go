@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newUnixServer serves handler on a unix socket, returning its path.
+func newUnixServer(t *testing.T, handler http.HandlerFunc) (string, <-chan request) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "ingest.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := make(chan request, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		h := r.Header.Clone()
+		h.Set("Host", r.Host)
+		reqs <- request{path: r.URL.Path, header: h, body: body, received: time.Now()}
+		handler(w, r)
+	}))
+	srv.Listener.Close()
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return socket, reqs
+}
+
+func TestUnixSocketEndpoint(t *testing.T) {
+	socket, reqs := newUnixServer(t, accept)
+	c := New(UnixPrefix+socket, "pk", "sk")
+	c.SigVersion = 2
+	// As the tailer sets it up: a transport that would reach the URL's
+	// host, and a proxy that must not be used.
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = func(*http.Request) (*url.URL, error) { return nil, errors.New("proxy used") }
+	c.HTTPClient = &http.Client{Transport: tr}
+
+	for range 2 {
+		if err := c.Send(context.Background(), sampleEvents(2)); err != nil {
+			t.Fatal(err)
+		}
+		got := <-reqs
+		if got.path != "/v1/events" || got.header.Get("Host") != "localhost" {
+			t.Errorf("request to %s %s, want localhost /v1/events", got.header.Get("Host"), got.path)
+		}
+		ts, _ := strconv.ParseInt(got.header.Get("X-Peac-Timestamp"), 10, 64)
+		if want := SignV2([]byte("sk"), "POST", "/v1/events", ts, got.body); got.header.Get("X-Peac-Signature") != want {
+			t.Errorf("X-Peac-Signature = %s, want %s", got.header.Get("X-Peac-Signature"), want)
+		}
+	}
+}
+
+func TestUnixSocketErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		socket func(t *testing.T) string
+		want   string
+	}{
+		{"missing", func(t *testing.T) string { return filepath.Join(t.TempDir(), "ingest.sock") }, "no such file or directory"},
+		{"permission denied", func(t *testing.T) string {
+			if os.Geteuid() == 0 {
+				t.Skip("root may connect to any socket")
+			}
+			socket, _ := newUnixServer(t, accept)
+			if err := os.Chmod(socket, 0); err != nil {
+				t.Fatal(err)
+			}
+			return socket
+		}, "permission denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socket := tt.socket(t)
+			err := New(UnixPrefix+socket, "pk", "sk").Send(context.Background(), sampleEvents(1))
+			var ne net.Error
+			if !errors.As(err, &ne) || !strings.Contains(err.Error(), socket) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Send = %v, want a network error naming %s: %s", err, socket, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Package event defines the crawl event sent to the Originary Trace
+// ingest API.
+package event
+
+import "sync"
+
+// SourceNginx is the source of events read from server access logs. The
+// API has a single value for these whatever the server.
+const SourceNginx = "nginx"
+
+// CrawlEvent is one request, in the shape POST /v1/events accepts.
+type CrawlEvent struct {
+	Timestamp     int64  `json:"ts"`
+	Host          string `json:"host"`
+	Path          string `json:"path"`
+	Method        string `json:"method"`
+	Status        int    `json:"status"`
+	UserAgent     string `json:"ua,omitempty"`
+	IPPrefix      string `json:"ip_prefix"`
+	AcceptLang    string `json:"accept_lang,omitempty"`
+	CrawlerFamily string `json:"crawler_family"`
+	Source        string `json:"source"`
+
+	// FetchClass tells content fetches from revalidations, probes,
+	// redirects and errors: full, conditional, probe, redirect or error.
+	FetchClass string `json:"fetch_class,omitempty"`
+
+	// Referer and Bytes are set by log formats that record them. Bytes is
+	// the response size as logged, with or without headers depending on
+	// the variable used.
+	Referer string `json:"referer,omitempty"`
+	Bytes   int64  `json:"bytes,omitempty"`
+
+	// RequestTimeMs and UpstreamTimeMs are the server time spent on the
+	// request and waiting on upstreams, in milliseconds, when logged.
+	RequestTimeMs  int64 `json:"request_time_ms,omitempty"`
+	UpstreamTimeMs int64 `json:"upstream_time_ms,omitempty"`
+
+	// Verified is set for crawler families with published domains when
+	// the client address has been checked: true if it checked out.
+	Verified *bool `json:"verified,omitempty"`
+
+	// Disallowed is set when the site's robots.txt is known, with
+	// -robots-file or -robots-fetch: true if it disallows the path for the
+	// crawler that requested it.
+	Disallowed *bool `json:"disallowed,omitempty"`
+
+	// Country is the ISO 3166-1 code of the client address's country, with
+	// -geoip-db.
+	Country string `json:"country,omitempty"`
+
+	// ASN and ASNOrg are the autonomous system the client address is
+	// announced from, with -asn-db: its number, as the worker sends it,
+	// and the organisation it is registered to.
+	ASN    string `json:"asn,omitempty"`
+	ASNOrg string `json:"asn_org,omitempty"`
+
+	// IPMatchesRange is set for crawler families with published address
+	// ranges in -bot-ranges-dir: true if the client address is in one.
+	IPMatchesRange *bool `json:"ip_matches_published_range,omitempty"`
+
+	// SampleRate is the fraction of this crawler family's events being
+	// sent, when it is sampled: each stands for 1/SampleRate requests.
+	SampleRate float64 `json:"sample_rate,omitempty"`
+
+	// EventID identifies the event across retries, spooling and
+	// replays, so that the API can drop copies delivered twice.
+	EventID string `json:"event_id,omitempty"`
+
+	// AgentHost, AgentVersion and InstanceID identify the tailer that
+	// read the event, when several feed the same property.
+	AgentHost    string `json:"agent_host,omitempty"`
+	AgentVersion string `json:"agent_version,omitempty"`
+	InstanceID   string `json:"instance_id,omitempty"`
+
+	// ClientIP is the full client address as logged. It is only used on
+	// the host to derive IPPrefix and is never serialised.
+	ClientIP string `json:"-"`
+
+	// ForwardedFor is the X-Forwarded-For value, when the log records it.
+	// Like ClientIP it is never serialised.
+	ForwardedFor string `json:"-"`
+
+	// TimeAssumed is set when the line had no usable time, and Timestamp
+	// is when it was read instead. It is never serialised.
+	TimeAssumed bool `json:"-"`
+
+	// Query is the query string of the request, without the "?". It is
+	// never serialised either; -keep-params decides what of it is added
+	// to Path.
+	Query string `json:"-"`
+}
+
+var pool = sync.Pool{New: func() any { return new(CrawlEvent) }}
+
+// Get returns a zero event, one given back with Put if there is one.
+func Get() *CrawlEvent {
+	return pool.Get().(*CrawlEvent)
+}
+
+// Put gives back an event nothing holds any more, for Get to reuse.
+func Put(e *CrawlEvent) {
+	*e = CrawlEvent{}
+	pool.Put(e)
+}
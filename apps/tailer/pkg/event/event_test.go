@@ -0,0 +1,78 @@
+package event
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCrawlEventJSON(t *testing.T) {
+	verified := true
+	tests := []struct {
+		name  string
+		event CrawlEvent
+		want  string
+	}{
+		{
+			name: "minimal",
+			event: CrawlEvent{
+				Timestamp: 1700000000123, Host: "example.com", Path: "/a", Method: "GET", Status: 200,
+				UserAgent: "GPTBot/1.0", IPPrefix: "1.2.3.0/24", CrawlerFamily: "gptbot", Source: SourceNginx,
+				ClientIP: "1.2.3.4",
+			},
+			want: `{"ts":1700000000123,"host":"example.com","path":"/a","method":"GET","status":200,"ua":"GPTBot/1.0","ip_prefix":"1.2.3.0/24","crawler_family":"gptbot","source":"nginx"}`,
+		},
+		{
+			name: "optional fields",
+			event: CrawlEvent{
+				Timestamp: 1, Path: "/", Method: "GET", Status: 404, AcceptLang: "en",
+				CrawlerFamily: "unknown", Source: SourceNginx, Verified: &verified,
+			},
+			want: `{"ts":1,"host":"","path":"/","method":"GET","status":404,"ip_prefix":"","accept_lang":"en","crawler_family":"unknown","source":"nginx","verified":true}`,
+		},
+		{
+			name: "referer, bytes and timings",
+			event: CrawlEvent{
+				Timestamp: 1, Path: "/", Method: "GET", Status: 200, CrawlerFamily: "gptbot", Source: SourceNginx,
+				Referer: "https://example.com/", Bytes: 512, RequestTimeMs: 12, UpstreamTimeMs: 9,
+			},
+			want: `{"ts":1,"host":"","path":"/","method":"GET","status":200,"ip_prefix":"","crawler_family":"gptbot","source":"nginx","referer":"https://example.com/","bytes":512,"request_time_ms":12,"upstream_time_ms":9}`,
+		},
+		{
+			name: "event ID and agent metadata",
+			event: CrawlEvent{
+				Timestamp: 1, Path: "/", Method: "GET", Status: 200, CrawlerFamily: "gptbot", Source: SourceNginx,
+				EventID:   "0192a5c8-7e10-7abc-9def-0123456789ab",
+				AgentHost: "edge-1", AgentVersion: "1.4.0", InstanceID: "0b7e6f0c-5d3a-4b8e-9f21-6c1d2e3f4a5b",
+			},
+			want: `{"ts":1,"host":"","path":"/","method":"GET","status":200,"ip_prefix":"","crawler_family":"gptbot","source":"nginx","event_id":"0192a5c8-7e10-7abc-9def-0123456789ab","agent_host":"edge-1","agent_version":"1.4.0","instance_id":"0b7e6f0c-5d3a-4b8e-9f21-6c1d2e3f4a5b"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(&tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	verified := true
+	e := &CrawlEvent{
+		Timestamp: 1700000000123, Host: "example.com", Path: "/docs/a", Method: "GET", Status: 200,
+		UserAgent: "Mozilla/5.0 (compatible; GPTBot/1.2; +https://openai.com/gptbot)", IPPrefix: "203.0.113.0/24",
+		AcceptLang: "en-US", CrawlerFamily: "gptbot", Source: SourceNginx, Verified: &verified,
+		Referer: "https://example.com/", Bytes: 512, RequestTimeMs: 12, FetchClass: "full",
+		EventID: "0192a5c8-7e10-7abc-9def-0123456789ab",
+	}
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := json.Marshal(e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
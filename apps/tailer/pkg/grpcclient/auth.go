@@ -0,0 +1,45 @@
+package grpcclient
+
+import (
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// Auth supplies the metadata that authenticates an Ingest call.
+type Auth interface {
+	// Metadata returns the metadata for a call of method, the full RPC
+	// name, carrying msg, the serialized request, under the key ID and
+	// secret.
+	Metadata(key, secret, method string, msg []byte) metadata.MD
+}
+
+// HMAC signs each call as the HTTP API client signs a request: x-peac-key,
+// x-peac-timestamp, and in x-peac-signature the HMAC-SHA256 of msg or,
+// with SigVersion 2, of client.CanonicalRequest for a POST to method.
+type HMAC struct {
+	SigVersion int
+}
+
+func (h HMAC) Metadata(key, secret, method string, msg []byte) metadata.MD {
+	signedAt := time.Now().UnixMilli()
+	md := metadata.Pairs("x-peac-key", key, "x-peac-timestamp", strconv.FormatInt(signedAt, 10))
+	if h.SigVersion == 2 {
+		md.Set("x-peac-sig-version", "2")
+		md.Set("x-peac-signature", client.SignV2([]byte(secret), "POST", method, signedAt, msg))
+	} else {
+		md.Set("x-peac-signature", client.Sign([]byte(secret), msg))
+	}
+	return md
+}
+
+// Bearer sends the secret as a bearer token in authorization, for
+// services that authenticate callers that way, with the key ID alongside.
+type Bearer struct{}
+
+func (Bearer) Metadata(key, secret, method string, msg []byte) metadata.MD {
+	return metadata.Pairs("authorization", "Bearer "+secret, "x-peac-key", key)
+}
@@ -0,0 +1,214 @@
+// Package grpcclient sends crawl events to an ingest service over gRPC,
+// with the Ingest RPC of pkg/ingestpb, as an alternative to the HTTP API
+// client of pkg/client.
+package grpcclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+	"github.com/originaryx/trace/tailer/pkg/event"
+	"github.com/originaryx/trace/tailer/pkg/ingestpb"
+)
+
+var _ client.Sender = (*Client)(nil)
+
+// Client sends each batch as one Ingest call. Options are set on the
+// exported fields before first use; credentials may be replaced at any
+// time, as with client.Client.
+type Client struct {
+	// Auth authenticates each call. If nil, HMAC{} is used.
+	Auth Auth
+
+	// Timeout bounds each call. If zero, client.DefaultTimeout applies.
+	Timeout time.Duration
+
+	// Compression is "gzip" to compress the calls, or "" for none.
+	Compression string
+
+	// OnRequest, if set, is called with the duration of every call.
+	OnRequest func(time.Duration)
+
+	conn   *grpc.ClientConn // nil if the connection was passed to New
+	ingest ingestpb.IngestClient
+	creds  atomic.Pointer[[2]string]
+}
+
+// Dial returns a client for target, such as dns:///ingest.internal:443,
+// using the given API key ID and secret. It connects when first used. A
+// nil tlsConfig means a plaintext connection, for tests.
+func Dial(target string, tlsConfig *tls.Config, key, secret string) (*Client, error) {
+	tc := insecure.NewCredentials()
+	if tlsConfig != nil {
+		tc = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(tc))
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", target, err)
+	}
+	c := New(conn, key, secret)
+	c.conn = conn
+	return c, nil
+}
+
+// New returns a client making its calls on conn.
+func New(conn grpc.ClientConnInterface, key, secret string) *Client {
+	c := &Client{ingest: ingestpb.NewIngestClient(conn)}
+	c.SetCredentials(key, secret)
+	return c
+}
+
+// SetCredentials replaces the key ID and secret used for later calls.
+func (c *Client) SetCredentials(key, secret string) {
+	c.creds.Store(&[2]string{key, secret})
+}
+
+// Credentials returns the key ID and secret currently in use.
+func (c *Client) Credentials() (key, secret string) {
+	v := c.creds.Load()
+	return v[0], v[1]
+}
+
+// Close closes the connection opened by Dial.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// Send delivers events as one batch in an Ingest call. Failures come back
+// as the *client.StatusError the HTTP API would have answered, so that
+// they are retried the same way.
+func (c *Client) Send(ctx context.Context, events []*event.CrawlEvent) error {
+	req := &ingestpb.IngestRequest{Events: make([]*ingestpb.CrawlEvent, len(events))}
+	for i, e := range events {
+		req.Events[i] = toProto(e)
+	}
+	// The message has no maps, so it serializes to these same bytes again
+	// when sent.
+	msg, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal events: %w", err)
+	}
+	auth := c.Auth
+	if auth == nil {
+		auth = HMAC{}
+	}
+	key, secret := c.Credentials()
+	ctx = metadata.NewOutgoingContext(ctx, auth.Metadata(key, secret, ingestpb.Ingest_Ingest_FullMethodName, msg))
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = client.DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var opts []grpc.CallOption
+	if c.Compression == "gzip" {
+		opts = append(opts, grpc.UseCompressor(gzip.Name))
+	}
+	var trailer metadata.MD
+	opts = append(opts, grpc.Trailer(&trailer))
+
+	start := time.Now()
+	err = c.call(ctx, req, opts)
+	if c.OnRequest != nil {
+		c.OnRequest(time.Since(start))
+	}
+	return statusError(err, trailer)
+}
+
+// call makes an Ingest call carrying the one batch req.
+func (c *Client) call(ctx context.Context, req *ingestpb.IngestRequest, opts []grpc.CallOption) error {
+	stream, err := c.ingest.Ingest(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(req); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	// After a failed Send, the call's status comes from CloseAndRecv.
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// httpStatus is the HTTP status the API would answer for each gRPC code,
+// as far as retrying is concerned.
+var httpStatus = map[codes.Code]int{
+	codes.InvalidArgument:    400,
+	codes.FailedPrecondition: 400,
+	codes.OutOfRange:         400,
+	codes.Unauthenticated:    401,
+	codes.PermissionDenied:   403,
+	codes.NotFound:           404,
+	codes.Unimplemented:      404,
+	codes.AlreadyExists:      409,
+	codes.ResourceExhausted:  429,
+	codes.Unknown:            500,
+	codes.Internal:           500,
+	codes.DataLoss:           500,
+	codes.Aborted:            503,
+	codes.Unavailable:        503,
+	codes.DeadlineExceeded:   504,
+}
+
+// statusError turns the error of a call into a *client.StatusError, with
+// RetryAfter from a retry-after trailer in seconds.
+func statusError(err error, trailer metadata.MD) error {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() == codes.OK {
+		return err
+	}
+	code, ok := httpStatus[st.Code()]
+	if !ok {
+		return fmt.Errorf("ingest call: %w", err)
+	}
+	se := &client.StatusError{StatusCode: code}
+	if v := trailer.Get("retry-after"); len(v) > 0 {
+		if secs, err := strconv.Atoi(v[0]); err == nil && secs >= 0 {
+			se.RetryAfter = time.Duration(secs) * time.Second
+		}
+	}
+	return se
+}
+
+func toProto(e *event.CrawlEvent) *ingestpb.CrawlEvent {
+	return &ingestpb.CrawlEvent{
+		Ts:             e.Timestamp,
+		Host:           e.Host,
+		Path:           e.Path,
+		Method:         e.Method,
+		Status:         int32(e.Status),
+		Ua:             e.UserAgent,
+		IpPrefix:       e.IPPrefix,
+		AcceptLang:     e.AcceptLang,
+		CrawlerFamily:  e.CrawlerFamily,
+		Source:         e.Source,
+		Referer:        e.Referer,
+		Bytes:          e.Bytes,
+		RequestTimeMs:  e.RequestTimeMs,
+		UpstreamTimeMs: e.UpstreamTimeMs,
+		Verified:       e.Verified,
+		SampleRate:     e.SampleRate,
+		AgentHost:      e.AgentHost,
+		AgentVersion:   e.AgentVersion,
+		InstanceId:     e.InstanceID,
+	}
+}
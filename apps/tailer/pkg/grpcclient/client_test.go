@@ -0,0 +1,179 @@
+package grpcclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+	"github.com/originaryx/trace/tailer/pkg/event"
+	"github.com/originaryx/trace/tailer/pkg/ingestpb"
+)
+
+// call is what the test server saw of one Ingest call.
+type call struct {
+	md      metadata.MD
+	batches []*ingestpb.IngestRequest
+}
+
+type ingestServer struct {
+	ingestpb.UnimplementedIngestServer
+	calls chan call
+	err   error // returned by every call, if set
+}
+
+func (s *ingestServer) Ingest(stream grpc.ClientStreamingServer[ingestpb.IngestRequest, ingestpb.IngestResponse]) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	c := call{md: md}
+	var n int64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		c.batches = append(c.batches, req)
+		n += int64(len(req.Events))
+	}
+	s.calls <- c
+	if s.err != nil {
+		stream.SetTrailer(metadata.Pairs("retry-after", "7"))
+		return s.err
+	}
+	return stream.SendAndClose(&ingestpb.IngestResponse{Accepted: n})
+}
+
+func newTestClient(t *testing.T, srv *ingestServer) *Client {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	ingestpb.RegisterIngestServer(gs, srv)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return New(conn, "pk_test", "sk_test")
+}
+
+func sampleEvents(n int) []*event.CrawlEvent {
+	verified := true
+	events := make([]*event.CrawlEvent, n)
+	for i := range events {
+		events[i] = &event.CrawlEvent{
+			Timestamp: 1700000000000 + int64(i), Host: "example.com", Path: "/p/" + strconv.Itoa(i),
+			Method: "GET", Status: 200, UserAgent: "GPTBot/1.2", CrawlerFamily: "gptbot", Source: event.SourceNginx,
+			Verified: &verified,
+		}
+	}
+	return events
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name  string
+		auth  Auth
+		check func(t *testing.T, md metadata.MD, msg []byte)
+	}{
+		{"hmac", nil, func(t *testing.T, md metadata.MD, msg []byte) {
+			if got, want := md.Get("x-peac-signature"), client.Sign([]byte("sk_test"), msg); len(got) != 1 || got[0] != want {
+				t.Errorf("x-peac-signature = %v, want %s", got, want)
+			}
+		}},
+		{"hmac v2", HMAC{SigVersion: 2}, func(t *testing.T, md metadata.MD, msg []byte) {
+			ts, _ := strconv.ParseInt(md.Get("x-peac-timestamp")[0], 10, 64)
+			want := client.SignV2([]byte("sk_test"), "POST", ingestpb.Ingest_Ingest_FullMethodName, ts, msg)
+			if got := md.Get("x-peac-signature"); len(got) != 1 || got[0] != want {
+				t.Errorf("x-peac-signature = %v, want %s", got, want)
+			}
+		}},
+		{"bearer", Bearer{}, func(t *testing.T, md metadata.MD, msg []byte) {
+			if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer sk_test" {
+				t.Errorf("authorization = %v", got)
+			}
+			if len(md.Get("x-peac-signature")) != 0 {
+				t.Error("bearer call is also signed")
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &ingestServer{calls: make(chan call, 1)}
+			c := newTestClient(t, srv)
+			c.Auth = tt.auth
+			if err := c.Send(context.Background(), sampleEvents(3)); err != nil {
+				t.Fatal(err)
+			}
+			got := <-srv.calls
+			if len(got.batches) != 1 || len(got.batches[0].Events) != 3 {
+				t.Fatalf("server got %d batches, want one of 3 events", len(got.batches))
+			}
+			if e := got.batches[0].Events[2]; e.Path != "/p/2" || e.Ua != "GPTBot/1.2" || e.Status != 200 || !e.GetVerified() {
+				t.Errorf("event = %v", e)
+			}
+			if k := got.md.Get("x-peac-key"); len(k) != 1 || k[0] != "pk_test" {
+				t.Errorf("x-peac-key = %v", k)
+			}
+			msg, err := proto.Marshal(got.batches[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, got.md, msg)
+		})
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want int
+	}{
+		{codes.InvalidArgument, 400},
+		{codes.Unauthenticated, 401},
+		{codes.ResourceExhausted, 429},
+		{codes.Unavailable, 503},
+		{codes.Internal, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			srv := &ingestServer{calls: make(chan call, 1), err: status.Error(tt.code, "no")}
+			c := newTestClient(t, srv)
+			err := c.Send(context.Background(), sampleEvents(1))
+			var se *client.StatusError
+			if !errors.As(err, &se) || se.StatusCode != tt.want || se.RetryAfter != 7*time.Second {
+				t.Errorf("Send = %v, want status %d with Retry-After 7s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendUnreachable(t *testing.T) {
+	c, err := Dial("passthrough:///127.0.0.1:1", nil, "pk", "sk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Timeout = time.Second
+	var se *client.StatusError
+	if err := c.Send(context.Background(), sampleEvents(1)); !errors.As(err, &se) || se.StatusCode < 500 {
+		t.Errorf("Send = %v, want a 5xx status, retried like a network error", err)
+	}
+}
@@ -0,0 +1,5 @@
+// Package ingestpb holds the protobuf messages and gRPC stubs of the Ingest
+// service, generated from ingest.proto.
+package ingestpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ingest.proto
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// ALB field positions in an Application Load Balancer access log entry:
+//
+//	type time elb client:port target:port request_processing_time
+//	target_processing_time response_processing_time elb_status_code
+//	target_status_code received_bytes sent_bytes "request" "user_agent" ...
+//
+// Later fields (TLS details, trace ID, actions and so on) are ignored.
+const (
+	albTime = 1 + iota
+	_
+	albClient
+	_
+	albRequestTime
+	albTargetTime
+	albResponseTime
+	albStatus
+	_
+	_
+	albSentBytes
+	albRequest
+	albUserAgent
+)
+
+// ALB parses AWS Application Load Balancer access logs. The request field
+// holds the full URL, so the host comes from it too.
+type ALB struct{}
+
+func (ALB) Parse(line string) (*event.CrawlEvent, error) {
+	fields, err := splitQuoted(strings.TrimSpace(line))
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) <= albUserAgent {
+		return nil, fmt.Errorf("line has %d fields, want at least %d", len(fields), albUserAgent+1)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, fields[albTime])
+	if err != nil {
+		return nil, fmt.Errorf("parse timestamp %q: %w", fields[albTime], err)
+	}
+	status, err := strconv.Atoi(fields[albStatus])
+	if err != nil {
+		return nil, fmt.Errorf("invalid status %q", fields[albStatus])
+	}
+
+	// "GET https://example.com:443/a?x=1 HTTP/1.1"; requests the load
+	// balancer couldn't parse are logged as "- http://example.com:80- -".
+	var method, host, path, query string
+	if parts := strings.Fields(fields[albRequest]); len(parts) >= 2 {
+		method = dashEmpty(parts[0])
+		host, path, query = splitTarget(parts[1])
+	}
+
+	// Each processing time is -1 when the load balancer couldn't get that
+	// far, which parseSeconds reads as 0.
+	target := parseSeconds(fields[albTargetTime])
+	return &event.CrawlEvent{
+		Timestamp:      t.UnixMilli(),
+		Host:           host,
+		Path:           path,
+		Query:          query,
+		Method:         method,
+		Status:         status,
+		UserAgent:      dashEmpty(fields[albUserAgent]),
+		ClientIP:       cutPort(fields[albClient]),
+		Source:         event.SourceNginx,
+		Bytes:          parseBytes(fields[albSentBytes]),
+		RequestTimeMs:  parseSeconds(fields[albRequestTime]) + target + parseSeconds(fields[albResponseTime]),
+		UpstreamTimeMs: target,
+	}, nil
+}
+
+// splitQuoted splits a line into space separated fields, a field in
+// double quotes running to the closing quote. Inside quotes, \" and \\
+// stand for a quote and a backslash.
+func splitQuoted(line string) ([]string, error) {
+	var fields []string
+	for line != "" {
+		if line[0] == ' ' {
+			line = line[1:]
+			continue
+		}
+		if line[0] != '"' {
+			field, rest, _ := strings.Cut(line, " ")
+			fields = append(fields, field)
+			line = rest
+			continue
+		}
+		var b strings.Builder
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			b.WriteByte(line[i])
+		}
+		if i == len(line) {
+			return nil, fmt.Errorf("unterminated quoted field")
+		}
+		fields = append(fields, b.String())
+		line = line[i+1:]
+	}
+	return fields, nil
+}
+
+// cutPort drops the port from an address that always has one, such as
+// ALB's client:port, where IPv6 addresses aren't bracketed.
+func cutPort(s string) string {
+	if strings.HasPrefix(s, "[") {
+		return stripPort(s)
+	}
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
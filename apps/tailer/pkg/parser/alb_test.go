@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestALBParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want event.CrawlEvent
+	}{
+		{
+			name: "https",
+			line: `https 2023-11-14T22:13:20.123456Z app/my-loadbalancer/50dc6c495c0c9188 203.0.113.7:2817 10.0.0.1:80 0.001 0.048 0.000 200 200 361 5123 "GET https://www.example.com:443/docs/a?x=1 HTTP/2.0" "Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337281-1d84f3d73c47ec4e58577259" "www.example.com" "arn:aws:acm:us-east-2:123456789012:certificate/12345678-1234-1234-1234-123456789012" 1 2023-11-14T22:13:20.073000Z "forward" "-" "-" "10.0.0.1:80" "200" "-" "-" TID_1234abcd`,
+			want: event.CrawlEvent{Timestamp: 1700000000123, Host: "www.example.com", Path: "/docs/a", Query: "x=1", Method: "GET", Status: 200,
+				UserAgent: "Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)",
+				ClientIP:  "203.0.113.7", Source: event.SourceNginx, Bytes: 5123, RequestTimeMs: 49, UpstreamTimeMs: 48},
+		},
+		{
+			name: "ipv6 client, target unreachable, quote in user agent",
+			line: `http 2023-11-14T22:13:20.5Z app/my-loadbalancer/50dc6c495c0c9188 2001:db8::7:51532 - -1 -1 -1 502 - 34 277 "GET http://www.example.com:80/ HTTP/1.1" "Mozilla/5.0 (compatible; \"odd\" bot)" - - - "Root=1-58337364-23a8c76965a2ef7629b185e3" "-" "-" 0 2023-11-14T22:13:20.490000Z "forward" "-" "-" "-" "-" "-" "-"`,
+			want: event.CrawlEvent{Timestamp: 1700000000500, Host: "www.example.com", Path: "/", Method: "GET", Status: 502,
+				UserAgent: `Mozilla/5.0 (compatible; "odd" bot)`, ClientIP: "2001:db8::7", Source: event.SourceNginx, Bytes: 277},
+		},
+		{
+			name: "request the load balancer couldn't parse",
+			line: `http 2023-11-14T22:13:20.000000Z app/my-loadbalancer/50dc6c495c0c9188 198.51.100.2:40262 - -1 -1 -1 400 - 0 0 "- http://www.example.com:80- -" "-" - - - "-" "-" "-" - 2023-11-14T22:13:20.000000Z "-" "-" "-" "-" "-" "-" "-"`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Status: 400, ClientIP: "198.51.100.2", Source: event.SourceNginx},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ALB{}.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestALBParseRejects(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"https 2023-11-14T22:13:20.123456Z app/my-loadbalancer/50dc6c495c0c9188 203.0.113.7:2817",
+		`https 2023-11-14T22:13:20.123456Z app/lb/1 203.0.113.7:2817 10.0.0.1:80 0.001 0.048 0.000 200 200 361 5123 "GET https://www.example.com:443/ HTTP/2.0" "unterminated`,
+		`https 14/Nov/2023:22:13:20 app/lb/1 203.0.113.7:2817 10.0.0.1:80 0.001 0.048 0.000 200 200 361 5123 "GET https://www.example.com:443/ HTTP/2.0" "curl/8.0"`,
+	} {
+		if _, err := (ALB{}).Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// apacheRe matches the Apache combined log format:
+//
+//	%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"
+//
+// Quoted fields may contain backslash-escaped quotes. The referer and user
+// agent are optional so plain common log format lines are accepted too.
+var apacheRe = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}|-) (\d+|-)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+const apacheTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// ApacheCombined parses Apache combined (and common) format lines.
+type ApacheCombined struct{}
+
+func (ApacheCombined) Parse(line string) (*event.CrawlEvent, error) {
+	matches := apacheRe.FindStringSubmatch(strings.TrimSpace(line))
+	if matches == nil {
+		return nil, fmt.Errorf("line did not match apache combined format")
+	}
+
+	ts, err := time.Parse(apacheTimeLayout, matches[2])
+	if err != nil {
+		return nil, fmt.Errorf("parse timestamp %q: %w", matches[2], err)
+	}
+
+	status, _ := strconv.Atoi(matches[4])
+
+	// A request line of "-" (e.g. a 408 before any bytes arrived) leaves
+	// method and path empty rather than rejecting the line.
+	var method, path, query string
+	if req := unescapeApache(matches[3]); req != "-" {
+		parts := strings.Fields(req)
+		if len(parts) > 0 {
+			method = parts[0]
+		}
+		if len(parts) > 1 {
+			path, query = splitQuery(parts[1])
+		}
+	}
+
+	return &event.CrawlEvent{
+		Timestamp: ts.UnixMilli(),
+		Path:      path,
+		Query:     query,
+		Method:    method,
+		Status:    status,
+		UserAgent: dashEmpty(unescapeApache(matches[7])),
+		ClientIP:  matches[1],
+		Source:    event.SourceNginx,
+		Referer:   stripQuery(dashEmpty(unescapeApache(matches[6]))),
+		Bytes:     parseBytes(matches[5]),
+	}, nil
+}
+
+// unescapeApache undoes the backslash escaping Apache applies to quotes
+// and backslashes inside quoted fields.
+func unescapeApache(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestApacheCombinedParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want event.CrawlEvent
+	}{
+		{
+			name: "combined",
+			line: `203.0.113.7 - - [14/Nov/2023:22:13:20 +0000] "GET /a?b=c HTTP/1.1" 200 512 "https://example.com/?q=1" "ClaudeBot/1.0"`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Path: "/a", Query: "b=c", Method: "GET", Status: 200, UserAgent: "ClaudeBot/1.0", ClientIP: "203.0.113.7", Source: event.SourceNginx, Referer: "https://example.com/", Bytes: 512},
+		},
+		{
+			name: "escaped quote in user agent",
+			line: `203.0.113.7 - - [14/Nov/2023:22:13:20 +0000] "GET / HTTP/1.1" 404 - "-" "say \"hi\""`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Path: "/", Method: "GET", Status: 404, UserAgent: `say "hi"`, ClientIP: "203.0.113.7", Source: event.SourceNginx},
+		},
+		{
+			name: "common log format",
+			line: `2001:db8::1 - bob [14/Nov/2023:23:13:20 +0100] "POST /login HTTP/1.1" 302 0`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Path: "/login", Method: "POST", Status: 302, ClientIP: "2001:db8::1", Source: event.SourceNginx},
+		},
+		{
+			name: "head",
+			line: `203.0.113.7 - - [14/Nov/2023:22:13:20 +0000] "HEAD /feed.xml HTTP/1.1" 304 - "-" "GPTBot/1.2"`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Path: "/feed.xml", Method: "HEAD", Status: 304, UserAgent: "GPTBot/1.2", ClientIP: "203.0.113.7", Source: event.SourceNginx},
+		},
+		{
+			name: "options",
+			line: `203.0.113.7 - - [14/Nov/2023:22:13:20 +0000] "OPTIONS /api HTTP/1.1" 204 0 "-" "curl/8.0"`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Path: "/api", Method: "OPTIONS", Status: 204, UserAgent: "curl/8.0", ClientIP: "203.0.113.7", Source: event.SourceNginx},
+		},
+		{
+			name: "empty request line",
+			line: `203.0.113.7 - - [14/Nov/2023:22:13:20 +0000] "-" 408 - "-" "-"`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Status: 408, ClientIP: "203.0.113.7", Source: event.SourceNginx},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApacheCombined{}.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApacheCombinedParseRejects(t *testing.T) {
+	for _, line := range []string{
+		"",
+		`203.0.113.7 - - [yesterday] "GET / HTTP/1.1" 200 512`,
+		`1700000000.123 "GET /a HTTP/1.1" 200 512 "ua" 1.2.3.4 en 0.01 example.com gptbot`,
+	} {
+		if _, err := (ApacheCombined{}).Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
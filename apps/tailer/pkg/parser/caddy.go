@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// caddyEntry is the part of a Caddy access log entry (the http.log.access
+// logger's "handled request" message) that events are made from. ts and
+// duration are seconds with the default encoder settings; ts may also be
+// a time string when the log is configured so.
+type caddyEntry struct {
+	TS       any     `json:"ts"`
+	Duration float64 `json:"duration"`
+	Size     int64   `json:"size"`
+	Status   *int    `json:"status"`
+	Request  *struct {
+		ClientIP   string      `json:"client_ip"`
+		RemoteIP   string      `json:"remote_ip"`
+		RemoteAddr string      `json:"remote_addr"` // before Caddy 2.5, with the port
+		Method     string      `json:"method"`
+		Host       string      `json:"host"`
+		URI        string      `json:"uri"`
+		Headers    http.Header `json:"headers"`
+	} `json:"request"`
+}
+
+// Caddy parses Caddy's JSON access logs. The client address is
+// request.client_ip, which honours Caddy's trusted_proxies, and otherwise
+// request.remote_ip.
+type Caddy struct{}
+
+func (Caddy) Parse(line string) (*event.CrawlEvent, error) {
+	var e caddyEntry
+	if err := decodeJSON(line, &e); err != nil {
+		return nil, err
+	}
+	r := e.Request
+	if r == nil || r.Method == "" || e.Status == nil {
+		return nil, fmt.Errorf("not a caddy access log entry: missing request or status")
+	}
+
+	ts, ok := jsonTime(e.TS)
+	if !ok {
+		ts = time.Now().UnixMilli()
+	}
+	ip := r.ClientIP
+	if ip == "" {
+		ip = r.RemoteIP
+	}
+	if ip == "" {
+		ip = r.RemoteAddr
+	}
+
+	path, query := splitQuery(r.URI)
+	return &event.CrawlEvent{
+		Timestamp:     ts,
+		TimeAssumed:   !ok,
+		Host:          stripPort(r.Host),
+		Path:          path,
+		Query:         query,
+		Method:        r.Method,
+		Status:        *e.Status,
+		UserAgent:     r.Headers.Get("User-Agent"),
+		AcceptLang:    r.Headers.Get("Accept-Language"),
+		ClientIP:      stripPort(ip),
+		Source:        event.SourceNginx,
+		Referer:       stripQuery(r.Headers.Get("Referer")),
+		Bytes:         max(e.Size, 0),
+		RequestTimeMs: durationMs(time.Duration(e.Duration * float64(time.Second))),
+		ForwardedFor:  strings.Join(r.Headers.Values("X-Forwarded-For"), ", "),
+	}, nil
+}
+
+// decodeJSON decodes a log line holding a single JSON object into v.
+func decodeJSON(line string, v any) error {
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("decode json: %w", err)
+	}
+	return nil
+}
+
+// jsonTime converts a decoded timestamp, Unix seconds as a number or a
+// string parseJSONTime accepts, to Unix milliseconds.
+func jsonTime(v any) (int64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		return parseMsec(v.String())
+	case string:
+		return parseJSONTime(v)
+	default:
+		return 0, false
+	}
+}
+
+// stripPort drops the port from "host:port" or "[v6]:port", and the
+// brackets from "[v6]". Anything else is returned as is.
+func stripPort(s string) string {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+}
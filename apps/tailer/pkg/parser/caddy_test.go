@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestCaddyParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want event.CrawlEvent
+	}{
+		{
+			name: "caddy 2.7 behind a proxy",
+			line: `{"level":"info","ts":1700000000.1234567,"logger":"http.log.access.log0","msg":"handled request","request":{"remote_ip":"10.0.0.5","remote_port":"52344","client_ip":"203.0.113.7","proto":"HTTP/2.0","method":"GET","host":"example.com","uri":"/docs/a?x=1","headers":{"User-Agent":["Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)"],"Accept-Language":["en-US,en;q=0.9"],"Referer":["https://example.org/?q=1"],"Accept-Encoding":["gzip, br"],"X-Forwarded-For":["203.0.113.7, 10.0.0.5"]},"tls":{"resumed":false,"version":772,"cipher_suite":4865,"proto":"h2","server_name":"example.com"}},"bytes_read":0,"user_id":"","duration":0.021394217,"size":5123,"status":200,"resp_headers":{"Server":["Caddy"],"Content-Type":["text/html; charset=utf-8"]}}`,
+			want: event.CrawlEvent{Timestamp: 1700000000123, Host: "example.com", Path: "/docs/a", Query: "x=1", Method: "GET", Status: 200,
+				UserAgent:  "Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2; +https://openai.com/gptbot)",
+				AcceptLang: "en-US,en;q=0.9", ClientIP: "203.0.113.7", Source: event.SourceNginx, Referer: "https://example.org/",
+				Bytes: 5123, RequestTimeMs: 21, ForwardedFor: "203.0.113.7, 10.0.0.5"},
+		},
+		{
+			name: "no client_ip, no optional headers",
+			line: `{"level":"info","ts":1700000000.5,"logger":"http.log.access","msg":"handled request","request":{"remote_ip":"2001:db8::7","remote_port":"443","proto":"HTTP/1.1","method":"HEAD","host":"example.com:8443","uri":"/","headers":{}},"bytes_read":0,"user_id":"","duration":0.000412,"size":0,"status":404,"resp_headers":{}}`,
+			want: event.CrawlEvent{Timestamp: 1700000000500, Host: "example.com", Path: "/", Method: "HEAD", Status: 404, ClientIP: "2001:db8::7", Source: event.SourceNginx},
+		},
+		{
+			name: "caddy 2.4 remote_addr with port, iso8601 ts",
+			line: `{"level":"info","ts":"2023-11-14T22:13:20.250Z","logger":"http.log.access.log0","msg":"handled request","request":{"remote_addr":"[2001:db8::7]:52344","proto":"HTTP/1.1","method":"GET","host":"example.com","uri":"/robots.txt","headers":{"User-Agent":["ClaudeBot/1.0"]}},"common_log":"2001:db8::7 - - [14/Nov/2023:22:13:20 +0000] \"GET /robots.txt HTTP/1.1\" 200 68","duration":0.0015,"size":68,"status":200,"resp_headers":{}}`,
+			want: event.CrawlEvent{Timestamp: 1700000000250, Host: "example.com", Path: "/robots.txt", Method: "GET", Status: 200, UserAgent: "ClaudeBot/1.0", ClientIP: "2001:db8::7", Source: event.SourceNginx, Bytes: 68, RequestTimeMs: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Caddy{}.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaddyParseRejects(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"not json",
+		`{"level":"info","ts":1700000000.1,"logger":"tls.obtain","msg":"certificate obtained successfully","identifier":"example.com"}`,
+		`{"level":"info","ts":1700000000.1,"msg":"handled request","request":{"method":"GET","uri":"/"}}`,
+	} {
+		if _, err := (Caddy{}).Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// CloudFront field positions in a standard (legacy) access log entry, which
+// are tab separated and start:
+//
+//	date time x-edge-location sc-bytes c-ip cs-method cs(Host)
+//	cs-uri-stem sc-status cs(Referer) cs(User-Agent) cs-uri-query
+//	cs(Cookie) x-edge-result-type x-edge-request-id x-host-header
+//	cs-protocol cs-bytes time-taken x-forwarded-for ...
+//
+// Older logs end earlier; fields past the user agent are optional.
+const (
+	cfDate = iota
+	cfTime
+	_
+	cfBytes
+	cfClientIP
+	cfMethod
+	cfDistribution
+	cfPath
+	cfStatus
+	cfReferer
+	cfUserAgent
+	cfQuery
+	_
+	_
+	_
+	cfHostHeader
+	_
+	_
+	cfTimeTaken
+	cfForwardedFor
+)
+
+// CloudFront parses Amazon CloudFront standard access logs. The host is
+// the Host header the viewer sent, when logged, rather than the
+// distribution's cloudfront.net name. The #Version and #Fields lines at
+// the top of each file are skipped.
+type CloudFront struct{}
+
+func (CloudFront) Parse(line string) (*event.CrawlEvent, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "#") {
+		return nil, ErrSkip
+	}
+	fields := strings.Split(line, "\t")
+	if len(fields) <= cfUserAgent {
+		return nil, fmt.Errorf("line has %d fields, want at least %d", len(fields), cfUserAgent+1)
+	}
+	field := func(i int) string {
+		if i >= len(fields) {
+			return ""
+		}
+		return dashEmpty(fields[i])
+	}
+
+	t, err := time.Parse("2006-01-02 15:04:05", fields[cfDate]+" "+fields[cfTime])
+	if err != nil {
+		return nil, fmt.Errorf("parse timestamp %q: %w", fields[cfDate]+" "+fields[cfTime], err)
+	}
+	// 000 is logged when the viewer went away before a response.
+	status, err := strconv.Atoi(fields[cfStatus])
+	if err != nil {
+		return nil, fmt.Errorf("invalid status %q", fields[cfStatus])
+	}
+	host := field(cfHostHeader)
+	if host == "" {
+		host = field(cfDistribution)
+	}
+
+	return &event.CrawlEvent{
+		Timestamp:     t.UnixMilli(),
+		Host:          host,
+		Path:          field(cfPath),
+		Query:         field(cfQuery),
+		Method:        field(cfMethod),
+		Status:        status,
+		UserAgent:     unescapeCloudFront(field(cfUserAgent)),
+		ClientIP:      field(cfClientIP),
+		Source:        event.SourceNginx,
+		Referer:       stripQuery(unescapeCloudFront(field(cfReferer))),
+		Bytes:         parseBytes(fields[cfBytes]),
+		RequestTimeMs: parseSeconds(field(cfTimeTaken)),
+		ForwardedFor:  field(cfForwardedFor),
+	}, nil
+}
+
+// unescapeCloudFront undoes the URL encoding CloudFront applies to header
+// values such as the user agent ("Mozilla/5.0%20(compatible;..."). A
+// value that doesn't decode is returned as logged.
+func unescapeCloudFront(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// cfLine joins CloudFront fields with tabs.
+func cfLine(fields ...string) string { return strings.Join(fields, "\t") }
+
+func TestCloudFrontParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want event.CrawlEvent
+	}{
+		{
+			name: "current fields",
+			line: cfLine("2023-11-14", "22:13:20", "LAX1-C1", "5123", "203.0.113.7", "GET", "d111111abcdef8.cloudfront.net", "/docs/a", "200",
+				"https://example.org/search%3Fq=1", "Mozilla/5.0%20(compatible;%20ClaudeBot/1.0;%20+claudebot@anthropic.com)", "x=1", "-", "Miss",
+				"SOKRLo0ictte1fs0XuvhdR0LL9Xx0hstw7Pc5wTXj02WpMLuQqo7LsQ==", "www.example.com", "https", "187", "0.021", "-", "TLSv1.3",
+				"TLS_AES_128_GCM_SHA256", "Miss", "HTTP/2.0", "-", "-", "11040", "0.020", "Miss", "text/html", "5000", "-", "-"),
+			want: event.CrawlEvent{Timestamp: 1700000000000, Host: "www.example.com", Path: "/docs/a", Query: "x=1", Method: "GET", Status: 200,
+				UserAgent: "Mozilla/5.0 (compatible; ClaudeBot/1.0; +claudebot@anthropic.com)", ClientIP: "203.0.113.7",
+				Source: event.SourceNginx, Referer: "https://example.org/search", Bytes: 5123, RequestTimeMs: 21},
+		},
+		{
+			name: "through a proxy, ipv6, no referer",
+			line: cfLine("2023-11-14", "22:13:21", "FRA56-P5", "389", "2001:db8::7", "HEAD", "d111111abcdef8.cloudfront.net", "/", "301",
+				"-", "curl/8.4.0", "-", "-", "Redirect", "k6WGMNkEzR5BEM_SaF47gjtX9zBDO2m349OY2an0QPEaUum1ZOLrow==", "example.com", "http", "83",
+				"0.000", "198.51.100.2", "-", "-", "Redirect", "HTTP/1.1", "-", "-", "41114", "0.000", "Redirect", "text/html", "167", "-", "-"),
+			want: event.CrawlEvent{Timestamp: 1700000001000, Host: "example.com", Path: "/", Method: "HEAD", Status: 301, UserAgent: "curl/8.4.0",
+				ClientIP: "2001:db8::7", Source: event.SourceNginx, Bytes: 389, ForwardedFor: "198.51.100.2"},
+		},
+		{
+			name: "short legacy line, viewer gone",
+			line: cfLine("2023-11-14", "22:13:22", "IAD89-C2", "0", "192.0.2.10", "GET", "d111111abcdef8.cloudfront.net", "/big.bin", "000",
+				"-", "Wget/1.21"),
+			want: event.CrawlEvent{Timestamp: 1700000002000, Host: "d111111abcdef8.cloudfront.net", Path: "/big.bin", Method: "GET",
+				UserAgent: "Wget/1.21", ClientIP: "192.0.2.10", Source: event.SourceNginx},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CloudFront{}.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudFrontParseRejects(t *testing.T) {
+	for _, line := range []string{"#Version: 1.0", "#Fields: date time x-edge-location sc-bytes c-ip"} {
+		if _, err := (CloudFront{}).Parse(line); !errors.Is(err, ErrSkip) {
+			t.Errorf("Parse(%q) = %v, want ErrSkip", line, err)
+		}
+	}
+	for _, line := range []string{
+		"",
+		"2023-11-14 22:13:20 LAX1 5123 203.0.113.7 GET d111111abcdef8.cloudfront.net /a 200 - curl",
+		cfLine("14/11/2023", "22:13:20", "LAX1", "0", "192.0.2.10", "GET", "d1.cloudfront.net", "/", "200", "-", "curl"),
+		cfLine("2023-11-14", "22:13:20", "LAX1", "0", "192.0.2.10", "GET", "d1.cloudfront.net", "/", "-", "-", "curl"),
+	} {
+		if _, err := (CloudFront{}).Parse(line); err == nil || errors.Is(err, ErrSkip) {
+			t.Errorf("Parse(%q) = %v, want a parse error", line, err)
+		}
+	}
+}
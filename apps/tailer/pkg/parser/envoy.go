@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// envoyRe matches Envoy's default access log format:
+//
+//	[%START_TIME%] "%REQ(:METHOD)% %REQ(X-ENVOY-ORIGINAL-PATH?:PATH)% %PROTOCOL%"
+//	%RESPONSE_CODE% %RESPONSE_FLAGS% %BYTES_RECEIVED% %BYTES_SENT% %DURATION%
+//	%RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)% "%REQ(X-FORWARDED-FOR)%"
+//	"%REQ(USER-AGENT)%" "%REQ(X-REQUEST-ID)%" "%REQ(:AUTHORITY)%" "%UPSTREAM_HOST%"
+//
+// and Istio's variant of it, which adds %RESPONSE_CODE_DETAILS%,
+// %CONNECTION_TERMINATION_DETAILS% and the quoted
+// %UPSTREAM_TRANSPORT_FAILURE_REASON% after the flags and, at the end, the
+// upstream cluster and addresses followed by %DOWNSTREAM_REMOTE_ADDRESS%.
+var envoyRe = regexp.MustCompile(`^\[([^\]]+)\] "(\S+) (\S+) [^"]*" (\d+) \S+(?: \S+ \S+ "[^"]*")? \S+ (\d+|-) (\d+|-) (\d+|-) "([^"]*)" "([^"]*)" "[^"]*" "([^"]*)" "[^"]*"(?: \S+ \S+ \S+ (\S+))?`)
+
+// Envoy parses Envoy's default access log lines. The default format has
+// no client address; the client is the last X-Forwarded-For hop, which
+// Envoy appends when use_remote_address is set, and with Istio's format
+// the downstream remote address.
+type Envoy struct{}
+
+func (Envoy) Parse(line string) (*event.CrawlEvent, error) {
+	m := envoyRe.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return nil, fmt.Errorf("line did not match envoy default format")
+	}
+
+	t, err := time.Parse(time.RFC3339, m[1])
+	if err != nil {
+		return nil, fmt.Errorf("parse timestamp %q: %w", m[1], err)
+	}
+	status, _ := strconv.Atoi(m[4])
+
+	xff := dashEmpty(m[8])
+	ip := dashEmpty(m[11])
+	if ip == "" {
+		hops := strings.Split(xff, ",")
+		ip = strings.TrimSpace(hops[len(hops)-1])
+	}
+
+	path, query := splitQuery(dashEmpty(m[3]))
+	return &event.CrawlEvent{
+		Timestamp:      t.UnixMilli(),
+		Host:           stripPort(dashEmpty(m[10])),
+		Path:           path,
+		Query:          query,
+		Method:         dashEmpty(m[2]),
+		Status:         status,
+		UserAgent:      dashEmpty(m[9]),
+		ClientIP:       stripPort(ip),
+		Source:         event.SourceNginx,
+		Bytes:          parseBytes(m[5]),
+		RequestTimeMs:  parseMillis(m[6]),
+		UpstreamTimeMs: parseMillis(m[7]),
+		ForwardedFor:   xff,
+	}, nil
+}
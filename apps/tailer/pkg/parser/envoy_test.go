@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestEnvoyParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want event.CrawlEvent
+	}{
+		{
+			name: "default format",
+			line: `[2023-11-14T22:13:20.123Z] "GET /docs/a?x=1 HTTP/1.1" 200 - 0 5123 21 20 "198.51.100.2, 203.0.113.7" "Mozilla/5.0 (compatible; PerplexityBot/1.0; +https://perplexity.ai/perplexitybot)" "cc21d9b0-cf5c-432b-8c7e-98aeb7988cd2" "www.example.com" "10.0.2.1:80"`,
+			want: event.CrawlEvent{Timestamp: 1700000000123, Host: "www.example.com", Path: "/docs/a", Query: "x=1", Method: "GET", Status: 200,
+				UserAgent: "Mozilla/5.0 (compatible; PerplexityBot/1.0; +https://perplexity.ai/perplexitybot)", ClientIP: "203.0.113.7",
+				Source: event.SourceNginx, Bytes: 5123, RequestTimeMs: 21, UpstreamTimeMs: 20, ForwardedFor: "198.51.100.2, 203.0.113.7"},
+		},
+		{
+			name: "default format, no upstream, headers missing",
+			line: `[2023-11-14T22:13:20.500Z] "HEAD / HTTP/2" 503 UF 0 91 30001 - "-" "-" "5f1a3c2e-0000-4b5e-9d2a-1c2b3d4e5f60" "example.com:8443" "-"`,
+			want: event.CrawlEvent{Timestamp: 1700000000500, Host: "example.com", Path: "/", Method: "HEAD", Status: 503,
+				Source: event.SourceNginx, Bytes: 91, RequestTimeMs: 30001},
+		},
+		{
+			name: "istio",
+			line: `[2023-11-14T22:13:20.000Z] "GET /robots.txt HTTP/1.1" 200 - via_upstream - "-" 0 68 3 2 "-" "ClaudeBot/1.0" "7a9f3e2b-1c4d-4e5f-8a9b-0c1d2e3f4a5b" "www.example.com" "10.44.0.12:8080" outbound|8080||web.default.svc.cluster.local 10.44.0.7:48872 10.44.0.7:8080 203.0.113.9:52344 - default`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Host: "www.example.com", Path: "/robots.txt", Method: "GET", Status: 200,
+				UserAgent: "ClaudeBot/1.0", ClientIP: "203.0.113.9", Source: event.SourceNginx, Bytes: 68, RequestTimeMs: 3, UpstreamTimeMs: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Envoy{}.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvoyParseRejects(t *testing.T) {
+	for _, line := range []string{
+		"",
+		`[2023-11-14 22:13:20.123][1][info][main] [source/server/server.cc:939] starting main dispatch loop`,
+		`[2023-11-14T22:13:20.123Z] "GET / HTTP/1.1" 200 - 0 5123 21 20`,
+		`[14/Nov/2023:22:13:20] "GET / HTTP/1.1" 200 - 0 5123 21 20 "-" "-" "-" "example.com" "-"`,
+	} {
+		if _, err := (Envoy{}).Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// The fetch classes an event can have, telling requests that transferred
+// content apart from those that didn't.
+const (
+	FetchFull        = "full"        // content was sent
+	FetchConditional = "conditional" // a 304: the crawler's copy is current
+	FetchProbe       = "probe"       // HEAD or OPTIONS: headers only
+	FetchRedirect    = "redirect"    // another 3xx
+	FetchError       = "error"       // a 4xx or 5xx
+)
+
+// FetchClass returns the fetch class of e, from its method and status. A
+// failed request is an error whatever its method.
+func FetchClass(e *event.CrawlEvent) string {
+	switch {
+	case e.Status >= 400:
+		return FetchError
+	case e.Status == 304:
+		return FetchConditional
+	case strings.EqualFold(e.Method, "HEAD") || strings.EqualFold(e.Method, "OPTIONS"):
+		return FetchProbe
+	case e.Status >= 300:
+		return FetchRedirect
+	}
+	return FetchFull
+}
@@ -0,0 +1,33 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestFetchClass(t *testing.T) {
+	tests := []struct {
+		method string
+		status int
+		want   string
+	}{
+		{"GET", 200, FetchFull},
+		{"POST", 201, FetchFull},
+		{"GET", 206, FetchFull},
+		{"GET", 304, FetchConditional},
+		{"HEAD", 304, FetchConditional},
+		{"HEAD", 200, FetchProbe},
+		{"head", 200, FetchProbe},
+		{"OPTIONS", 204, FetchProbe},
+		{"HEAD", 301, FetchProbe},
+		{"GET", 301, FetchRedirect},
+		{"GET", 404, FetchError},
+		{"HEAD", 503, FetchError},
+	}
+	for _, tt := range tests {
+		if got := FetchClass(&event.CrawlEvent{Method: tt.method, Status: tt.status}); got != tt.want {
+			t.Errorf("FetchClass(%s %d) = %s, want %s", tt.method, tt.status, got, tt.want)
+		}
+	}
+}
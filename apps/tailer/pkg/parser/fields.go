@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// The formats that name their values (json, ltsv) or list them by
+// position (tsv) share the event fields they map: ts, host, path, method,
+// status, ua, ip, accept_lang, crawler_family, referer, bytes,
+// request_time, upstream_time and xff.
+
+// parseFieldMap returns defaults overridden by spec, a list like
+// "status=st,ua=agent" mapping event fields to the keys (or labels) of a
+// kind of log.
+func parseFieldMap(kind string, defaults map[string]string, spec string) (map[string]string, error) {
+	m := make(map[string]string, len(defaults))
+	for field, key := range defaults {
+		m[field] = key
+	}
+	if spec == "" {
+		return m, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		field, key, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid %s map entry %q, want field=key", kind, pair)
+		}
+		if _, known := defaults[field]; !known {
+			return nil, fmt.Errorf("unknown %s map field %q (known: %s)", kind, field, strings.Join(knownFields(defaults), ", "))
+		}
+		m[field] = key
+	}
+	return m, nil
+}
+
+// knownFields returns the fields of a field map, sorted.
+func knownFields(m map[string]string) []string {
+	fields := make([]string, 0, len(m))
+	for f := range m {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// fieldEvent builds an event from get, which returns the value logged for
+// an event field, or "" if there is none. A "request" line ("GET /a
+// HTTP/1.1") fills in the method and path when they aren't logged on
+// their own.
+func fieldEvent(get func(field string) string) (*event.CrawlEvent, error) {
+	status, err := strconv.Atoi(get("status"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid status %q", get("status"))
+	}
+
+	ts, ok := parseTime(get("ts"))
+	if !ok {
+		ts = time.Now().UnixMilli()
+	}
+
+	method := get("method")
+	path, query := splitQuery(get("path"))
+	if parts := strings.Fields(get("request")); len(parts) >= 2 {
+		if method == "" {
+			method = parts[0]
+		}
+		if path == "" {
+			_, path, query = splitTarget(parts[1])
+		}
+	}
+
+	return &event.CrawlEvent{
+		Timestamp:      ts,
+		TimeAssumed:    !ok,
+		Host:           get("host"),
+		Path:           path,
+		Query:          query,
+		Method:         method,
+		Status:         status,
+		UserAgent:      get("ua"),
+		AcceptLang:     get("accept_lang"),
+		CrawlerFamily:  get("crawler_family"),
+		ClientIP:       get("ip"),
+		Source:         event.SourceNginx,
+		Referer:        stripQuery(get("referer")),
+		Bytes:          parseBytes(get("bytes")),
+		RequestTimeMs:  parseSeconds(get("request_time")),
+		UpstreamTimeMs: parseUpstreamTime(get("upstream_time")),
+		ForwardedFor:   get("xff"),
+	}, nil
+}
+
+// parseTime accepts what parseJSONTime does, and $time_local
+// ("14/Nov/2023:22:13:20 +0000") with or without its brackets.
+func parseTime(s string) (int64, bool) {
+	if ts, ok := parseJSONTime(s); ok {
+		return ts, true
+	}
+	if t, err := time.Parse(apacheTimeLayout, strings.Trim(s, "[]")); err == nil {
+		return t.UnixMilli(), true
+	}
+	return 0, false
+}
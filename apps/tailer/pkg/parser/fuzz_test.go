@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// FuzzParse checks that no line can make a parser panic, and that
+// whatever it returns comes out of Sanitize clean. Run it with
+// go test -fuzz FuzzParse ./pkg/parser.
+func FuzzParse(f *testing.F) {
+	for _, line := range []string{
+		`1700000000.123 "GET /docs/a?x=1 HTTP/1.1" 200 512 "Mozilla/5.0 (compatible; GPTBot/1.0)" 203.0.113.7 en-US 0.010 example.com gptbot`,
+		`127.0.0.1 - - [14/Nov/2023:22:13:20 +0000] "GET / HTTP/1.1" 200 512 "-" "curl/8.0"`,
+		`{"msec":"1700000000.123","request_uri":"/a","status":"200","http_user_agent":"GPTBot"}`,
+		"time:2023-11-14T22:13:20+00:00\thost:203.0.113.7\treq:GET /a HTTP/1.1\tstatus:200\tua:GPTBot",
+		`{"ts":1700000000.5,"request":{"remote_ip":"2001:db8::7","method":"HEAD","host":"example.com:8443","uri":"/","headers":{}},"duration":0.000412,"size":0,"status":404}`,
+		`http 2023-11-14T22:13:20.5Z app/lb/1 2001:db8::7:51532 - -1 -1 -1 502 - 34 277 "GET http://www.example.com:80/ HTTP/1.1" "Mozilla/5.0 (compatible; \"odd\" bot)" - -`,
+		"2023-11-14\t22:13:20\tIAD89-C1\t5123\t203.0.113.7\tGET\td111111abcdef8.cloudfront.net\t/a\t200\t-\tGPTBot%2F1.0",
+		`203.0.113.7:33317 [14/Nov/2023:22:13:20.655] https-in~ static/srv1 10/0/30/69/109 200 2750 - - ---- 1/1/1/1/0 0/0 {www.example.com|GPTBot} "GET /a HTTP/1.1"`,
+		`[2023-11-14T22:13:20.123Z] "GET /a HTTP/1.1" 200 - 0 5123 21 20 "203.0.113.7" "GPTBot" "id" "example.com" "10.0.0.1:80"`,
+		"\"\\x22\x00\n\t{[",
+		"2023-11-14 22:13:20 10.0.0.4 GET /docs/a x=1 443 - 2001:db8::7 Mozilla/5.0+(compatible;+GPTBot/1.2) - 200 0 0 21\r",
+		"#Fields: date time cs-method cs-uri-stem sc-status cs(User-Agent)",
+		`1700000000.123 "GET /%2e%2e/%c0%af/%00?q=%ff%fe%%zz HTTP/1.1" 400 0 "-" "%22%3Cscript%3E" 2001:db8:abcd:1234::1 en 0.000 example.com unknown`,
+		`1700000000.123 "GET /a HTTP/1.1" 200 512 "https://example.com/" "Mozilla/5.0 (compatible; "odd" \"bot\")" ::ffff:198.51.100.9 en 0.010 example.com gptbot 0.008 "fe80::1%eth0, 203.0.113.7"`,
+		`1700000000.123 "GET /a HTTP/1.1" 200 512 "\x22\x5C\xE2\x80\xAE" "curl/8.0 \xF0\x9F\x98\x80" 203.0.113.7 - 0.010 example.com -`,
+		// A line of several megabytes, as -max-line-bytes 0 allows.
+		`1700000000.123 "GET /` + strings.Repeat("a/", 1<<19) + ` HTTP/1.1" 200 512 "` + strings.Repeat(`x"`, 1<<19) + `" 203.0.113.7 en 0.010 example.com gptbot`,
+	} {
+		f.Add(line)
+	}
+	parsers := map[string]LineParser{}
+	for _, format := range []string{"nginx", "apache-combined", "json", "ltsv", "caddy", "traefik", "alb", "cloudfront", "haproxy", "envoy", "iis", "ndjson"} {
+		p, err := New(format, Options{})
+		if err != nil {
+			f.Fatal(err)
+		}
+		parsers[format] = p
+	}
+	tsv, err := NewTSV("ts,method,path,status,ua,ip,host")
+	if err != nil {
+		f.Fatal(err)
+	}
+	parsers["tsv"] = tsv
+	tmpl, err := NewTemplate(`$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`)
+	if err != nil {
+		f.Fatal(err)
+	}
+	parsers["template"] = tmpl
+
+	f.Fuzz(func(t *testing.T, line string) {
+		for format, p := range parsers {
+			e, err := p.Parse(line)
+			if err != nil {
+				continue
+			}
+			Sanitize(e)
+			for _, s := range []string{e.Host, e.Path, e.Method, e.UserAgent, e.AcceptLang, e.CrawlerFamily, e.Referer, e.ClientIP, e.ForwardedFor} {
+				if strings.IndexFunc(s, unicode.IsControl) >= 0 {
+					t.Errorf("%s: control character left in %q", format, s)
+				}
+			}
+			if len(e.UserAgent) > MaxUserAgent || len(e.Path) > MaxPath || len(e.AcceptLang) > MaxAcceptLang || len(e.Referer) > MaxPath {
+				t.Errorf("%s: field over its limit", format)
+			}
+		}
+	})
+}
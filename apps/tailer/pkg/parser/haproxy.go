@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// haproxyRe matches HAProxy's HTTP log format (option httplog):
+//
+//	%ci:%cp [%tr] %ft %b/%s %TR/%Tw/%Tc/%Tr/%Ta %ST %B %CC %CS %tsc
+//	%ac/%fc/%bc/%sc/%rc %sq/%bq [{%hr}] [{%hs}] %{+Q}r
+//
+// and the older layout with %t and Tq/Tw/Tc/Tr/Tt, which has the same
+// shape. A syslog prefix ("Feb  6 12:14:14 lb haproxy[14389]: ") is
+// skipped. The header captures are only there with capture directives.
+var haproxyRe = regexp.MustCompile(`(\S+):(\d+) \[([^\]]+)\] \S+ \S+ -?\d+/-?\d+/-?\d+/(-?\d+)/\+?(-?\d+) (-?\d+) \+?(\d+) \S+ \S+ \S+ \S+ \S+(?: \{([^}]*)\})?(?: \{[^}]*\})? "([^"]*)"`)
+
+const haproxyTimeLayout = "02/Jan/2006:15:04:05.000"
+
+// HAProxy parses HAProxy HTTP logs. HAProxy doesn't log a time zone; the
+// accept date is taken to be in the tailer's local time. Request headers
+// are read from the first capture block, in the order the integration
+// guide declares them: Host then User-Agent. A block with a single
+// capture is taken to be the user agent.
+type HAProxy struct{}
+
+func (HAProxy) Parse(line string) (*event.CrawlEvent, error) {
+	m := haproxyRe.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("line did not match haproxy httplog format")
+	}
+
+	t, err := time.ParseInLocation(haproxyTimeLayout, m[3], time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("parse timestamp %q: %w", m[3], err)
+	}
+	status, _ := strconv.Atoi(m[6])
+
+	var host, ua string
+	if m[8] != "" {
+		captures := strings.Split(m[8], "|")
+		if len(captures) == 1 {
+			ua = captures[0]
+		} else {
+			host, ua = captures[0], captures[1]
+		}
+	}
+
+	// "<BADREQ>" and truncated requests leave method and path empty.
+	var method, path, query string
+	if parts := strings.Fields(unescapeHAProxy(m[9])); len(parts) >= 2 {
+		var target string
+		method = parts[0]
+		target, path, query = splitTarget(parts[1])
+		if target != "" {
+			host = target
+		}
+	}
+
+	return &event.CrawlEvent{
+		Timestamp:      t.UnixMilli(),
+		Host:           stripPort(unescapeHAProxy(host)),
+		Path:           path,
+		Query:          query,
+		Method:         method,
+		Status:         max(status, 0),
+		UserAgent:      unescapeHAProxy(ua),
+		ClientIP:       m[1],
+		Source:         event.SourceNginx,
+		Bytes:          parseBytes(m[7]),
+		RequestTimeMs:  parseMillis(m[5]),
+		UpstreamTimeMs: parseMillis(m[4]),
+	}, nil
+}
+
+// unescapeHAProxy decodes the "#XX" hex escapes HAProxy writes for quotes,
+// braces, pipes, '#' itself and unprintable bytes in captures and the
+// request line.
+func unescapeHAProxy(s string) string {
+	if !strings.Contains(s, "#") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && i+2 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestHAProxyParse(t *testing.T) {
+	// HAProxy logs local time without a zone.
+	ts := time.Date(2023, 11, 14, 22, 13, 20, 655e6, time.Local).UnixMilli()
+	tests := []struct {
+		name string
+		line string
+		want event.CrawlEvent
+	}{
+		{
+			name: "syslog, host and user agent captured",
+			line: `Nov 14 22:13:20 lb1 haproxy[14389]: 203.0.113.7:33317 [14/Nov/2023:22:13:20.655] https-in~ static/srv1 10/0/30/69/109 200 2750 - - ---- 1/1/1/1/0 0/0 {www.example.com|Mozilla/5.0 (compatible; GPTBot/1.2; +https://openai.com/gptbot)} {} "GET /docs/a?x=1 HTTP/1.1"`,
+			want: event.CrawlEvent{Timestamp: ts, Host: "www.example.com", Path: "/docs/a", Query: "x=1", Method: "GET", Status: 200,
+				UserAgent: "Mozilla/5.0 (compatible; GPTBot/1.2; +https://openai.com/gptbot)", ClientIP: "203.0.113.7",
+				Source: event.SourceNginx, Bytes: 2750, RequestTimeMs: 109, UpstreamTimeMs: 69},
+		},
+		{
+			name: "no captures, logasap, aborted before the server answered",
+			line: `203.0.113.8:40112 [14/Nov/2023:22:13:20.655] http-in app/<NOSRV> 0/-1/-1/-1/+0 503 +217 - - SC-- 5/5/0/0/0 0/0 "HEAD / HTTP/1.1"`,
+			want: event.CrawlEvent{Timestamp: ts, Path: "/", Method: "HEAD", Status: 503, ClientIP: "203.0.113.8", Source: event.SourceNginx, Bytes: 217},
+		},
+		{
+			name: "user agent only, escapes, HTTP/2 absolute URI, ipv6",
+			line: `2001:db8::7:51532 [14/Nov/2023:22:13:20.655] https-in~ web/web1 0/0/1/12/13 404 512 - - ---- 2/2/1/1/0 0/0 {curl/8.4.0 #22x#7Cy#22} "GET https://example.com/missing HTTP/2.0"`,
+			want: event.CrawlEvent{Timestamp: ts, Host: "example.com", Path: "/missing", Method: "GET", Status: 404,
+				UserAgent: `curl/8.4.0 "x|y"`, ClientIP: "2001:db8::7", Source: event.SourceNginx, Bytes: 512, RequestTimeMs: 13, UpstreamTimeMs: 12},
+		},
+		{
+			name: "bad request",
+			line: `198.51.100.2:40262 [14/Nov/2023:22:13:20.655] http-in http-in/<NOSRV> -1/-1/-1/-1/0 400 187 - - PR-- 1/1/0/0/0 0/0 "<BADREQ>"`,
+			want: event.CrawlEvent{Timestamp: ts, Status: 400, ClientIP: "198.51.100.2", Source: event.SourceNginx, Bytes: 187},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HAProxy{}.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHAProxyParseRejects(t *testing.T) {
+	for _, line := range []string{
+		"",
+		// option tcplog
+		`203.0.113.7:33317 [14/Nov/2023:22:13:20.655] tcp-in tcp/srv1 0/0/5007 212 -- 0/0/0/0/3 0/0`,
+		`Nov 14 22:13:20 lb1 haproxy[14389]: Proxy http-in started.`,
+	} {
+		if _, err := (HAProxy{}).Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// iisDefaultFields are the fields IIS logs in the W3C format unless told
+// otherwise, as its #Fields line lists them.
+const iisDefaultFields = "date time s-ip cs-method cs-uri-stem cs-uri-query s-port cs-username c-ip cs(User-Agent) cs(Referer) sc-status sc-substatus sc-win32-status time-taken"
+
+// IIS parses the W3C extended log files of Microsoft IIS. Fields are
+// separated by spaces and named by the #Fields line at the top of each
+// file, which IIS repeats whenever it starts a file or the logged fields
+// change; until one is read, IIS's default fields are assumed. Other
+// lines starting with "#" are skipped.
+//
+// Its field list is one for all the files it parses, so the sites tailed
+// together should log the same fields.
+type IIS struct {
+	fields atomic.Pointer[[]string]
+}
+
+// NewIIS returns an IIS parser expecting the default fields.
+func NewIIS() *IIS {
+	p := &IIS{}
+	fields := strings.Fields(iisDefaultFields)
+	p.fields.Store(&fields)
+	return p
+}
+
+func (p *IIS) Parse(line string) (*event.CrawlEvent, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "#") {
+		if names, ok := strings.CutPrefix(line, "#Fields:"); ok {
+			fields := strings.Fields(names)
+			p.fields.Store(&fields)
+		}
+		return nil, ErrSkip
+	}
+	names := *p.fields.Load()
+	values := strings.Split(line, " ")
+	if len(values) != len(names) {
+		return nil, fmt.Errorf("line has %d fields, #Fields lists %d", len(values), len(names))
+	}
+
+	e := &event.CrawlEvent{Source: event.SourceNginx}
+	var date, clock string
+	status := -1
+	for i, name := range names {
+		v := values[i]
+		if v == "-" {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "date":
+			date = v
+		case "time":
+			clock = v
+		case "cs-method":
+			e.Method = v
+		case "cs-uri-stem":
+			e.Path = v
+		case "cs-uri-query":
+			e.Query = v
+		case "c-ip":
+			e.ClientIP = v
+		case "cs(user-agent)":
+			e.UserAgent = strings.ReplaceAll(v, "+", " ")
+		case "cs(referer)":
+			e.Referer = stripQuery(v)
+		case "cs(accept-language)":
+			e.AcceptLang = v
+		case "cs-host", "cs(host)":
+			e.Host = stripPort(v)
+		case "x-forwarded-for", "cs(x-forwarded-for)":
+			e.ForwardedFor = v
+		case "sc-status":
+			s, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid status %q", v)
+			}
+			status = s
+		case "sc-bytes":
+			e.Bytes = parseBytes(v)
+		case "time-taken":
+			// In milliseconds, unlike nginx's times.
+			e.RequestTimeMs, _ = strconv.ParseInt(v, 10, 64)
+		}
+	}
+	if status < 0 {
+		return nil, fmt.Errorf("no sc-status field")
+	}
+	e.Status = status
+	if e.Path == "" {
+		return nil, fmt.Errorf("no cs-uri-stem field")
+	}
+	if date == "" && clock == "" {
+		e.Timestamp, e.TimeAssumed = time.Now().UnixMilli(), true
+		return e, nil
+	}
+	// IIS logs in UTC.
+	t, err := time.Parse("2006-01-02 15:04:05", date+" "+clock)
+	if err != nil {
+		return nil, fmt.Errorf("parse timestamp %q: %w", date+" "+clock, err)
+	}
+	e.Timestamp = t.UnixMilli()
+	return e, nil
+}
@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestIISParse(t *testing.T) {
+	p := NewIIS()
+	tests := []struct {
+		name string
+		line string
+		want *event.CrawlEvent // nil for a line that is skipped
+	}{
+		{
+			name: "default fields",
+			line: "2023-11-14 22:13:20 10.0.0.4 GET /docs/a x=1 443 - 203.0.113.7 Mozilla/5.0+(compatible;+GPTBot/1.2) https://example.org/search?q=1 200 0 0 21\r\n",
+			want: &event.CrawlEvent{Timestamp: 1700000000000, Path: "/docs/a", Query: "x=1", Method: "GET", Status: 200,
+				UserAgent: "Mozilla/5.0 (compatible; GPTBot/1.2)", ClientIP: "203.0.113.7",
+				Source: event.SourceNginx, Referer: "https://example.org/search", RequestTimeMs: 21},
+		},
+		{name: "header", line: "#Software: Microsoft Internet Information Services 10.0"},
+		{name: "new fields", line: "#Fields: date time s-sitename cs-method cs-uri-stem cs-uri-query c-ip cs(User-Agent) cs(Referer) cs-host sc-status sc-bytes time-taken X-Forwarded-For"},
+		{
+			name: "fields of the new list",
+			line: "2023-11-14 22:13:21 W3SVC2 HEAD / - 2001:db8::7 curl/8.4.0 - www.example.com:443 301 389 0 198.51.100.2",
+			want: &event.CrawlEvent{Timestamp: 1700000001000, Host: "www.example.com", Path: "/", Method: "HEAD", Status: 301,
+				UserAgent: "curl/8.4.0", ClientIP: "2001:db8::7", Source: event.SourceNginx, Bytes: 389, ForwardedFor: "198.51.100.2"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := p.Parse(tt.line)
+		if tt.want == nil {
+			if !errors.Is(err, ErrSkip) {
+				t.Errorf("%s: Parse = %v, %v; want ErrSkip", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if *got != *tt.want {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, *got, *tt.want)
+		}
+	}
+}
+
+func TestIISParseRejects(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"2023-11-14 22:13:20 10.0.0.4 GET /docs/a - 443",
+		"2023-11-14 22:13:20 10.0.0.4 GET /docs/a - 443 - 203.0.113.7 curl/8.0 - OK 0 0 21",
+		"14/11/2023 22:13:20 10.0.0.4 GET /docs/a - 443 - 203.0.113.7 curl/8.0 - 200 0 0 21",
+	} {
+		if _, err := NewIIS().Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
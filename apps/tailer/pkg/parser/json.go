@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// defaultJSONMap maps event fields to the keys used by a typical
+// nginx `log_format ... escape=json` definition named after the variables.
+var defaultJSONMap = map[string]string{
+	"ts":             "time",
+	"host":           "host",
+	"path":           "request_uri",
+	"method":         "request_method",
+	"status":         "status",
+	"ua":             "http_user_agent",
+	"ip":             "remote_addr",
+	"accept_lang":    "http_accept_language",
+	"crawler_family": "crawler_family",
+	"referer":        "http_referer",
+	"bytes":          "body_bytes_sent",
+	"request_time":   "request_time",
+	"upstream_time":  "upstream_response_time",
+	"xff":            "http_x_forwarded_for",
+}
+
+// requiredJSONFields must be present in every line.
+var requiredJSONFields = []string{"path", "method", "status"}
+
+// JSON parses access logs written one JSON object per line, such as
+// nginx's log_format with escape=json. Keys it doesn't map are ignored.
+type JSON struct {
+	fieldMap map[string]string
+}
+
+// NewJSON returns a JSON parser using the default key for each field,
+// overridden by spec, a list like "status=st,ua=agent". Fields are ts,
+// host, path, method, status, ua, ip, accept_lang, crawler_family, referer,
+// bytes, request_time, upstream_time and xff.
+func NewJSON(spec string) (*JSON, error) {
+	m, err := parseFieldMap("json", defaultJSONMap, spec)
+	if err != nil {
+		return nil, err
+	}
+	return &JSON{fieldMap: m}, nil
+}
+
+func (p *JSON) Parse(line string) (*event.CrawlEvent, error) {
+	fieldMap := p.fieldMap
+	var obj map[string]any
+	if err := decodeJSON(line, &obj); err != nil {
+		return nil, err
+	}
+
+	for _, field := range requiredJSONFields {
+		if _, ok := obj[fieldMap[field]]; !ok {
+			return nil, fmt.Errorf("missing key %q", fieldMap[field])
+		}
+	}
+
+	e, err := fieldEvent(func(field string) string {
+		key, ok := fieldMap[field]
+		if !ok {
+			return ""
+		}
+		switch v := obj[key].(type) {
+		case string:
+			return dashEmpty(v)
+		case json.Number:
+			return v.String()
+		default:
+			return ""
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", fieldMap["status"], err)
+	}
+	return e, nil
+}
+
+// parseJSONTime accepts $msec ("1700000000.123") or $time_iso8601.
+func parseJSONTime(s string) (int64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	if ts, ok := parseMsec(s); ok {
+		return ts, true
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UnixMilli(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestJSONParse(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		line string
+		want event.CrawlEvent
+	}{
+		{
+			name: "default keys",
+			line: `{"time":"1700000000.123","host":"example.com","request_uri":"/a?x=1","request_method":"GET","status":"200","http_user_agent":"GPTBot/1.0","remote_addr":"203.0.113.7","http_accept_language":"-","crawler_family":"gptbot","http_referer":"-","body_bytes_sent":"512","request_time":"0.021","upstream_response_time":"0.004, 0.015"}`,
+			want: event.CrawlEvent{Timestamp: 1700000000123, Host: "example.com", Path: "/a", Query: "x=1", Method: "GET", Status: 200, UserAgent: "GPTBot/1.0", CrawlerFamily: "gptbot", ClientIP: "203.0.113.7", Source: event.SourceNginx, Bytes: 512, RequestTimeMs: 21, UpstreamTimeMs: 19},
+		},
+		{
+			name: "mapped keys, numeric status, RFC 3339 time",
+			spec: "status=code, ua=agent,ts=@timestamp",
+			line: `{"@timestamp":"2023-11-14T22:13:20Z","request_uri":"/b","request_method":"HEAD","code":301,"agent":"curl"}`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Path: "/b", Method: "HEAD", Status: 301, UserAgent: "curl", Source: event.SourceNginx},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewJSON(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONParseRejects(t *testing.T) {
+	p, err := NewJSON("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"",
+		"not json",
+		`{"request_uri":"/a","request_method":"GET"}`,
+		`{"request_uri":"/a","request_method":"GET","status":"OK"}`,
+	} {
+		if _, err := p.Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestNewJSONRejectsBadSpec(t *testing.T) {
+	for _, spec := range []string{"status", "status=", "bogus=x"} {
+		if _, err := NewJSON(spec); err == nil {
+			t.Errorf("NewJSON(%q) succeeded, want error", spec)
+		}
+	}
+}
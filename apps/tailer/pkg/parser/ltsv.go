@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// defaultLTSVMap maps event fields to the labels ltsv.org recommends, so
+// host is the client and vhost the site. request is the request line,
+// used when method or path have no label of their own.
+var defaultLTSVMap = map[string]string{
+	"ts":             "time",
+	"host":           "vhost",
+	"path":           "uri",
+	"method":         "method",
+	"status":         "status",
+	"ua":             "ua",
+	"ip":             "host",
+	"accept_lang":    "accept_lang",
+	"crawler_family": "crawler_family",
+	"referer":        "referer",
+	"bytes":          "size",
+	"request_time":   "reqtime",
+	"upstream_time":  "apptime",
+	"xff":            "forwardedfor",
+	"request":        "req",
+}
+
+// LTSV parses Labeled Tab-separated Values lines ("time:...\thost:...").
+// Labels it doesn't map are ignored.
+type LTSV struct {
+	labels map[string]string
+}
+
+// NewLTSV returns an LTSV parser using the default label for each field,
+// overridden by spec, a list like "host=domain,ua=agent". The fields are
+// those of NewJSON plus request.
+func NewLTSV(spec string) (*LTSV, error) {
+	m, err := parseFieldMap("ltsv", defaultLTSVMap, spec)
+	if err != nil {
+		return nil, err
+	}
+	return &LTSV{labels: m}, nil
+}
+
+func (p *LTSV) Parse(line string) (*event.CrawlEvent, error) {
+	values := map[string]string{}
+	for _, field := range strings.Split(strings.TrimRight(line, "\r\n"), "\t") {
+		// Values may hold colons themselves, as times and URLs do.
+		label, value, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		values[label] = dashEmpty(value)
+	}
+	if _, ok := values[p.labels["status"]]; !ok {
+		return nil, fmt.Errorf("missing label %q", p.labels["status"])
+	}
+	if _, ok := values[p.labels["path"]]; !ok {
+		if _, ok := values[p.labels["request"]]; !ok {
+			return nil, fmt.Errorf("missing label %q or %q", p.labels["path"], p.labels["request"])
+		}
+	}
+
+	e, err := fieldEvent(func(field string) string { return values[p.labels[field]] })
+	if err != nil {
+		return nil, fmt.Errorf("label %q: %w", p.labels["status"], err)
+	}
+	return e, nil
+}
@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// ltsvLine joins label:value fields with tabs.
+func ltsvLine(fields ...string) string { return strings.Join(fields, "\t") }
+
+func TestLTSVParse(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		line string
+		want event.CrawlEvent
+	}{
+		{
+			name: "default labels, unknown labels, colons in values",
+			line: ltsvLine("time:[14/Nov/2023:22:13:20 +0000]", "host:203.0.113.7", "forwardedfor:-", "user:-", "req:GET /a?x=1 HTTP/1.1",
+				"method:GET", "uri:/a?x=1", "protocol:HTTP/1.1", "status:200", "size:512", "reqsize:120", "referer:https://example.org/?q=1",
+				"ua:Mozilla/5.0 (compatible; GPTBot/1.2; +https://openai.com/gptbot)", "vhost:example.com", "reqtime:0.021", "cache:MISS",
+				"apptime:0.019", "ssl:TLSv1.3"),
+			want: event.CrawlEvent{Timestamp: 1700000000000, Host: "example.com", Path: "/a", Query: "x=1", Method: "GET", Status: 200,
+				UserAgent: "Mozilla/5.0 (compatible; GPTBot/1.2; +https://openai.com/gptbot)", ClientIP: "203.0.113.7",
+				Source: event.SourceNginx, Referer: "https://example.org/", Bytes: 512, RequestTimeMs: 21, UpstreamTimeMs: 19},
+		},
+		{
+			name: "request line only, mapped labels",
+			spec: "ts=epoch,host=domain,ip=remote_addr",
+			line: ltsvLine("epoch:1700000000.5", "remote_addr:2001:db8::7", "domain:example.com", "req:HEAD /b HTTP/2.0", "status:301", "ua:curl/8.4.0"),
+			want: event.CrawlEvent{Timestamp: 1700000000500, Host: "example.com", Path: "/b", Method: "HEAD", Status: 301,
+				UserAgent: "curl/8.4.0", ClientIP: "2001:db8::7", Source: event.SourceNginx},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewLTSV(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLTSVParseRejects(t *testing.T) {
+	p, err := NewLTSV("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"",
+		"not ltsv",
+		ltsvLine("time:1700000000.5", "uri:/a"),
+		ltsvLine("uri:/a", "status:OK"),
+		ltsvLine("method:GET", "status:200"),
+	} {
+		if _, err := p.Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+	if _, err := NewLTSV("bogus=x"); err == nil {
+		t.Error("NewLTSV with an unknown field succeeded, want error")
+	}
+}
@@ -0,0 +1,28 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// NDJSON parses events in the shape the API takes, one JSON object per
+// line, as written by -sink file. They have been through a tailer
+// already, so IPPrefix, CrawlerFamily and Verified are as that tailer set
+// them and there is no ClientIP.
+type NDJSON struct{}
+
+func (NDJSON) Parse(line string) (*event.CrawlEvent, error) {
+	e := event.Get()
+	if err := json.Unmarshal([]byte(line), e); err != nil {
+		event.Put(e)
+		return nil, fmt.Errorf("decode json: %w", err)
+	}
+	if e.Timestamp <= 0 || e.Path == "" || e.Method == "" || e.Status == 0 {
+		event.Put(e)
+		return nil, errors.New("missing ts, path, method or status")
+	}
+	return e, nil
+}
@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestNDJSONParse(t *testing.T) {
+	verified := true
+	tests := []struct {
+		name  string
+		event event.CrawlEvent
+	}{
+		{
+			name: "minimal",
+			event: event.CrawlEvent{Timestamp: 1700000000123, Host: "example.com", Path: "/a", Method: "GET", Status: 200,
+				UserAgent: "GPTBot/1.0", IPPrefix: "203.0.113.0/24", CrawlerFamily: "gptbot", Source: event.SourceNginx},
+		},
+		{
+			name: "every field",
+			event: event.CrawlEvent{Timestamp: 1700000000123, Host: "example.com", Path: "/a", Method: "GET", Status: 200,
+				UserAgent: "GPTBot/1.0", IPPrefix: "2001:db8::/48", AcceptLang: "en", CrawlerFamily: "gptbot", Source: event.SourceNginx,
+				Referer: "https://example.com/", Bytes: 512, RequestTimeMs: 21, UpstreamTimeMs: 19, Verified: &verified,
+				SampleRate: 0.5, AgentHost: "edge-1", AgentVersion: "1.4.0", InstanceID: "0b7e6f0c-5d3a-4b8e-9f21-6c1d2e3f4a5b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, err := json.Marshal(&tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := NDJSON{}.Parse(string(line))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.event) {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.event)
+			}
+		})
+	}
+}
+
+func TestNDJSONParseRejects(t *testing.T) {
+	for _, line := range []string{
+		"",
+		`{"ts":1700000000123,"path":"/a","method":"GET"`,
+		`{"ts":1700000000123,"host":"example.com","path":"/a","method":"GET"}`,
+		`{"time":"1700000000.123","request_uri":"/a","request_method":"GET","status":"200"}`,
+	} {
+		if _, err := (NDJSON{}).Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
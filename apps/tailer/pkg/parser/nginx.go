@@ -0,0 +1,270 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// nginxRe matches the peac log_format from the integration guide:
+//
+//	$msec "$request" $status $bytes_sent ["$http_referer"] "$http_user_agent"
+//	$remote_addr $http_accept_language $request_time $server_name $peac_family
+//	[$upstream_response_time] ["$http_x_forwarded_for"]
+//
+// The quoted referer, the upstream time and the quoted X-Forwarded-For are
+// optional. The upstream time may be a list such as "0.010, 0.020" when
+// several upstreams were tried.
+//
+// nginx escapes quotes, backslashes and bytes outside printable ASCII in
+// values as \xHH, which event decodes, and quoted values may hold quotes
+// escaped with a backslash. With escape=none nothing is escaped. A user
+// agent may be anything, so it alone may still contain bare quotes: it
+// ends at the first one after which the rest of the line matches.
+var nginxRe = regexp.MustCompile(`^(\d+\.\d+)\s+"(\w+)\s+([^\s]+)\s+HTTP/[\d.]+"\s+(\d+)\s+(\d+)\s+(?:"((?s:[^"\\]|\\.)*)"\s+)?"((?s:(?:[^\\]|\\.)*?))"\s+([^\s]+)\s+([^\s]*)\s+([\d.]+)\s+([^\s]+)\s+([^\s]+)(?:\s+([\d.-]+(?:\s*[,:]\s*[\d.-]+)*))?(?:\s+"((?s:[^"\\]|\\.)*)")?`)
+
+// Nginx parses lines written with the peac log_format.
+type Nginx struct{}
+
+// Parse reads line with scanNginx, which is several times faster than
+// nginxRe, and with nginxRe for the odd lines scanNginx gives up on.
+func (Nginx) Parse(line string) (*event.CrawlEvent, error) {
+	line = strings.TrimSpace(line)
+	if f, ok := scanNginx(line); ok {
+		return f.event(), nil
+	}
+	return parseNginxRegexp(line)
+}
+
+// parseNginxRegexp parses a trimmed line with nginxRe.
+func parseNginxRegexp(line string) (*event.CrawlEvent, error) {
+	m := nginxRe.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("line did not match expected format")
+	}
+	f := nginxFields{
+		msec: m[1], method: m[2], path: m[3], status: m[4], bytes: m[5],
+		referer: m[6], ua: m[7], ip: m[8], lang: m[9], requestTime: m[10],
+		host: m[11], family: m[12], upstream: m[13], xff: m[14],
+	}
+	return f.event(), nil
+}
+
+// nginxFields are the values of a peac log line, as captured by nginxRe.
+type nginxFields struct {
+	msec, method, path, status, bytes, referer, ua, ip, lang string
+	requestTime, host, family, upstream, xff                 string
+}
+
+func (f *nginxFields) event() *event.CrawlEvent {
+	status, _ := strconv.Atoi(f.status)
+
+	ts, ok := parseMsec(f.msec)
+	if !ok {
+		ts = time.Now().UnixMilli()
+	}
+
+	path, query := splitQuery(unescapeNginx(f.path))
+	e := event.Get()
+	*e = event.CrawlEvent{
+		Timestamp:      ts,
+		TimeAssumed:    !ok,
+		Host:           unescapeNginx(f.host),
+		Path:           path,
+		Query:          query,
+		Method:         f.method,
+		Status:         status,
+		UserAgent:      unescapeNginx(f.ua),
+		ClientIP:       f.ip,
+		AcceptLang:     unescapeNginx(f.lang),
+		CrawlerFamily:  f.family,
+		Source:         event.SourceNginx,
+		Referer:        stripQuery(dashEmpty(unescapeNginx(f.referer))),
+		Bytes:          parseBytes(f.bytes),
+		RequestTimeMs:  parseSeconds(f.requestTime),
+		UpstreamTimeMs: parseUpstreamTime(f.upstream),
+		ForwardedFor:   dashEmpty(unescapeNginx(f.xff)),
+	}
+	return e
+}
+
+// scanNginx captures what nginxRe would from a trimmed line, without
+// backtracking: each field is taken as the longest run its pattern
+// allows, which is the match nginxRe prefers. Where that doesn't work out
+// nginxRe might still match by backtracking, so scanNginx gives up and
+// returns false rather than guess.
+func scanNginx(line string) (f nginxFields, ok bool) {
+	sc := lineScanner{s: line}
+
+	start := sc.i
+	if sc.run(isDigit) == "" || !sc.lit(".") || sc.run(isDigit) == "" {
+		return f, false
+	}
+	f.msec = line[start:sc.i]
+	if !sc.space() || !sc.lit(`"`) {
+		return f, false
+	}
+	if f.method = sc.run(isWord); f.method == "" || !sc.space() {
+		return f, false
+	}
+	if f.path = sc.run(notSpace); f.path == "" || !sc.space() {
+		return f, false
+	}
+	if !sc.lit("HTTP/") || sc.run(isVersion) == "" || !sc.lit(`"`) || !sc.space() {
+		return f, false
+	}
+	if f.status = sc.run(isDigit); f.status == "" || !sc.space() {
+		return f, false
+	}
+	if f.bytes = sc.run(isDigit); f.bytes == "" || !sc.space() {
+		return f, false
+	}
+
+	// The referer is optional, so the first quoted value is the user
+	// agent unless another follows it.
+	first, ok := sc.quoted()
+	if !ok || !sc.space() {
+		return f, false
+	}
+	if sc.peek() == '"' {
+		f.referer = first
+		if f.ua, ok = sc.quoted(); !ok || !sc.space() {
+			return f, false
+		}
+	} else {
+		f.ua = first
+	}
+
+	if f.ip = sc.run(notSpace); f.ip == "" || !sc.space() {
+		return f, false
+	}
+	if f.lang = sc.run(notSpace); !sc.space() {
+		return f, false
+	}
+	if f.requestTime = sc.run(isVersion); f.requestTime == "" || !sc.space() {
+		return f, false
+	}
+	if f.host = sc.run(notSpace); f.host == "" || !sc.space() {
+		return f, false
+	}
+	if f.family = sc.run(notSpace); f.family == "" {
+		return f, false
+	}
+
+	// The rest is optional, and anything after it is ignored, as nginxRe
+	// isn't anchored at the end.
+	mark := sc.i
+	if sc.space() && isUpstream(sc.peek()) {
+		start := sc.i
+		sc.run(isUpstream)
+		for {
+			next := sc.i
+			sc.optSpace()
+			if !sc.lit(",") && !sc.lit(":") {
+				sc.i = next
+				break
+			}
+			sc.optSpace()
+			if sc.run(isUpstream) == "" {
+				sc.i = next
+				break
+			}
+		}
+		f.upstream = line[start:sc.i]
+	} else {
+		sc.i = mark
+	}
+	if sc.space() {
+		if xff, ok := sc.quoted(); ok {
+			f.xff = xff
+		}
+	}
+	return f, true
+}
+
+// lineScanner steps through a line byte by byte. The classes below are
+// those of nginxRe, which are ASCII only: \s is [\t\n\f\r ].
+type lineScanner struct {
+	s string
+	i int
+}
+
+func (sc *lineScanner) peek() byte {
+	if sc.i < len(sc.s) {
+		return sc.s[sc.i]
+	}
+	return 0
+}
+
+// run consumes and returns the longest run of bytes in class.
+func (sc *lineScanner) run(class func(byte) bool) string {
+	start := sc.i
+	for sc.i < len(sc.s) && class(sc.s[sc.i]) {
+		sc.i++
+	}
+	return sc.s[start:sc.i]
+}
+
+// space consumes a run of whitespace and reports whether there was any.
+func (sc *lineScanner) space() bool {
+	return sc.run(isSpace) != ""
+}
+
+func (sc *lineScanner) optSpace() {
+	sc.run(isSpace)
+}
+
+// lit consumes prefix if the line continues with it.
+func (sc *lineScanner) lit(prefix string) bool {
+	if !strings.HasPrefix(sc.s[sc.i:], prefix) {
+		return false
+	}
+	sc.i += len(prefix)
+	return true
+}
+
+// quoted consumes a double-quoted value, in which a backslash escapes the
+// byte after it, and returns what is between the quotes.
+func (sc *lineScanner) quoted() (string, bool) {
+	if sc.peek() != '"' {
+		return "", false
+	}
+	// Most values hold no backslash, and IndexByte is much faster.
+	if end := strings.IndexByte(sc.s[sc.i+1:], '"'); end >= 0 && strings.IndexByte(sc.s[sc.i+1:sc.i+1+end], '\\') < 0 {
+		v := sc.s[sc.i+1 : sc.i+1+end]
+		sc.i += end + 2
+		return v, true
+	}
+	for i := sc.i + 1; i < len(sc.s); i++ {
+		switch sc.s[i] {
+		case '\\':
+			i++
+		case '"':
+			v := sc.s[sc.i+1 : i]
+			sc.i = i + 1
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func notSpace(c byte) bool { return !isSpace(c) }
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isWord(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
+
+// isVersion is [\d.], for HTTP versions and times in seconds.
+func isVersion(c byte) bool { return isDigit(c) || c == '.' }
+
+func isUpstream(c byte) bool { return isVersion(c) || c == '-' }
@@ -0,0 +1,293 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestNginxParse(t *testing.T) {
+	line := `1700000000.123 "GET /docs/a?x=1 HTTP/1.1" 200 512 "Mozilla/5.0 (compatible; GPTBot/1.0)" 203.0.113.7 en-US 0.010 example.com gptbot`
+	got, err := Nginx{}.Parse(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := event.CrawlEvent{
+		Timestamp:     1700000000123,
+		Host:          "example.com",
+		Path:          "/docs/a",
+		Query:         "x=1",
+		Method:        "GET",
+		Status:        200,
+		UserAgent:     "Mozilla/5.0 (compatible; GPTBot/1.0)",
+		AcceptLang:    "en-US",
+		CrawlerFamily: "gptbot",
+		ClientIP:      "203.0.113.7",
+		Source:        event.SourceNginx,
+		Bytes:         512,
+		RequestTimeMs: 10,
+	}
+	if *got != want {
+		t.Errorf("got  %+v\nwant %+v", *got, want)
+	}
+}
+
+func TestNginxParseReferer(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`1700000000.123 "GET /a HTTP/1.1" 200 512 "https://example.com/docs?q=1" "GPTBot/1.0" 203.0.113.7 en 0.010 example.com gptbot`, "https://example.com/docs"},
+		{`1700000000.123 "GET /a HTTP/1.1" 200 512 "-" "GPTBot/1.0" 203.0.113.7 en 0.010 example.com gptbot`, ""},
+	}
+	for _, tt := range tests {
+		got, err := Nginx{}.Parse(tt.line)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.line, err)
+		}
+		if got.Referer != tt.want || got.UserAgent != "GPTBot/1.0" || got.ClientIP != "203.0.113.7" {
+			t.Errorf("Parse(%q) = referer %q, ua %q, ip %q; want referer %q", tt.line, got.Referer, got.UserAgent, got.ClientIP, tt.want)
+		}
+	}
+}
+
+func TestNginxParseQuotedUserAgent(t *testing.T) {
+	tests := []struct {
+		line        string
+		ua, referer string
+	}{
+		{`1700000000.123 "GET /a HTTP/1.1" 200 512 "Mozilla/5.0 (compatible; "odd" bot)" 203.0.113.7 en 0.010 example.com gptbot`, `Mozilla/5.0 (compatible; "odd" bot)`, ""},
+		{`1700000000.123 "GET /a HTTP/1.1" 200 512 "https://example.com/" ""quoted"" 2001:db8::7 en 0.010 example.com gptbot "203.0.113.7"`, `"quoted"`, "https://example.com/"},
+		{`1700000000.123 "GET /a HTTP/1.1" 200 512 "-" "Foo" Bar/1.0" 203.0.113.7 en 0.010 example.com gptbot`, `Foo" Bar/1.0`, ""},
+	}
+	for _, tt := range tests {
+		got, err := Nginx{}.Parse(tt.line)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.line, err)
+		}
+		if got.UserAgent != tt.ua || got.Referer != tt.referer || got.Host != "example.com" || got.RequestTimeMs != 10 {
+			t.Errorf("Parse(%q) = ua %q, referer %q, host %q; want ua %q, referer %q", tt.line, got.UserAgent, got.Referer, got.Host, tt.ua, tt.referer)
+		}
+	}
+}
+
+// TestNginxParseEscapes checks lines as nginx logs them with escape=default,
+// and with escape=none, against what the user agent was sent as.
+func TestNginxParseEscapes(t *testing.T) {
+	tests := []struct {
+		ua       string // as logged
+		want     string
+		wantJSON string
+	}{
+		{`Mozilla/5.0 (compatible; \x22Bytespider\x22; spider-feedback@bytedance.com)`, `Mozilla/5.0 (compatible; "Bytespider"; spider-feedback@bytedance.com)`, `"Mozilla/5.0 (compatible; \"Bytespider\"; spider-feedback@bytedance.com)"`},
+		{`Mozilla/5.0 \xF0\x9F\xA4\x96 RoboCrawler/2.1`, "Mozilla/5.0 🤖 RoboCrawler/2.1", `"Mozilla/5.0 🤖 RoboCrawler/2.1"`},
+		{"Mozilla/5.0 🤖 RoboCrawler/2.1", "Mozilla/5.0 🤖 RoboCrawler/2.1", `"Mozilla/5.0 🤖 RoboCrawler/2.1"`},
+		{`python-requests/2.31 C:\x5CUsers\x5Cbot`, `python-requests/2.31 C:\Users\bot`, `"python-requests/2.31 C:\\Users\\bot"`},
+		{`curl/8.0 \"quoted\"`, `curl/8.0 "quoted"`, `"curl/8.0 \"quoted\""`},
+		{`Go-http-client/1.1\x0D\x0AX-Injected: 1`, "Go-http-client/1.1X-Injected: 1", `"Go-http-client/1.1X-Injected: 1"`},
+		{`bot\xFF`, "bot\uFFFD", "\"bot\uFFFD\""},
+		{`bot\xZZ \\`, `bot\xZZ \`, `"bot\\xZZ \\"`},
+	}
+	for _, tt := range tests {
+		line := `1700000000.123 "GET /a HTTP/1.1" 200 512 "https://example.com/\x22a\x22" "` + tt.ua + `" 203.0.113.7 en 0.010 example.com gptbot`
+		e, err := Nginx{}.Parse(line)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", line, err)
+			continue
+		}
+		Sanitize(e)
+		if e.UserAgent != tt.want || e.Referer != `https://example.com/"a"` || e.ClientIP != "203.0.113.7" {
+			t.Errorf("user agent logged as %q: got ua %q, referer %q, ip %q; want ua %q", tt.ua, e.UserAgent, e.Referer, e.ClientIP, tt.want)
+			continue
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back event.CrawlEvent
+		if err := json.Unmarshal(data, &back); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"ua":`+tt.wantJSON+`,`) || back.UserAgent != tt.want {
+			t.Errorf("user agent %q in JSON: %s, want %s", tt.want, data, tt.wantJSON)
+		}
+	}
+}
+
+func TestNginxParseMethod(t *testing.T) {
+	for _, method := range []string{"GET", "HEAD", "OPTIONS", "POST", "PROPFIND"} {
+		line := `1700000000.123 "` + method + ` /a HTTP/1.1" 304 0 "GPTBot/1.0" 203.0.113.7 en 0.001 example.com gptbot`
+		got, err := Nginx{}.Parse(line)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", line, err)
+		}
+		if got.Method != method || got.Path != "/a" || got.Status != 304 {
+			t.Errorf("%s: method %q, path %q, status %d", method, got.Method, got.Path, got.Status)
+		}
+	}
+}
+
+func TestNginxParseRejects(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"not a log line",
+		`1700000000.123 "GET /a HTTP/1.1" 200 512 "ua"`,
+		`127.0.0.1 - - [14/Nov/2023:22:13:20 +0000] "GET / HTTP/1.1" 200 512 "-" "curl/8.0"`,
+	} {
+		if _, err := (Nginx{}).Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestNginxParseUpstreamTime(t *testing.T) {
+	const prefix = `1700000000.123 "GET /a HTTP/1.1" 200 512 "GPTBot/1.0" 203.0.113.7 en 0.050 example.com gptbot`
+	tests := []struct {
+		suffix string
+		want   int64
+	}{
+		{"", 0},
+		{" -", 0},
+		{" 0.012", 12},
+		{" 0.010, 0.020", 30},
+		{" 0.010, - : 0.005", 15},
+	}
+	for _, tt := range tests {
+		got, err := Nginx{}.Parse(prefix + tt.suffix)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.suffix, err)
+		}
+		if got.UpstreamTimeMs != tt.want || got.RequestTimeMs != 50 || got.CrawlerFamily != "gptbot" {
+			t.Errorf("suffix %q: upstream %d, request %d, family %q; want upstream %d", tt.suffix, got.UpstreamTimeMs, got.RequestTimeMs, got.CrawlerFamily, tt.want)
+		}
+	}
+}
+
+func TestNginxParseForwardedFor(t *testing.T) {
+	const prefix = `1700000000.123 "GET /a HTTP/1.1" 200 512 "GPTBot/1.0" 10.0.0.5 en 0.050 example.com gptbot`
+	tests := []struct {
+		suffix, want string
+	}{
+		{"", ""},
+		{` "-"`, ""},
+		{` "203.0.113.7, 10.0.0.9"`, "203.0.113.7, 10.0.0.9"},
+		{` 0.012 "203.0.113.7"`, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		got, err := Nginx{}.Parse(prefix + tt.suffix)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.suffix, err)
+		}
+		if got.ForwardedFor != tt.want || got.ClientIP != "10.0.0.5" {
+			t.Errorf("suffix %q: ForwardedFor %q, ClientIP %q; want %q", tt.suffix, got.ForwardedFor, got.ClientIP, tt.want)
+		}
+	}
+}
+
+// nginxCorpus returns n lines in the peac format, mostly well formed and
+// some not, built from the pieces real logs vary in.
+func nginxCorpus(n int) []string {
+	rng := rand.New(rand.NewSource(1))
+	pick := func(s ...string) string { return s[rng.Intn(len(s))] }
+	lines := make([]string, 0, n)
+	for range n {
+		sp := func() string { return pick(" ", " ", " ", "  ", "\t") }
+		line := fmt.Sprintf("%d.%03d", 1700000000+rng.Intn(1e6), rng.Intn(1000)) + sp() +
+			`"` + pick("GET", "HEAD", "POST", "PROPFIND") + sp() +
+			pick("/", "/docs/a?x=1", "/a%20b", `/q"x`, "/"+strings.Repeat("p", rng.Intn(300))) + sp() +
+			pick("HTTP/1.1", "HTTP/2.0", "HTTP/1") + `"` + sp() +
+			pick("200", "304", "404", "503") + sp() + fmt.Sprint(rng.Intn(100000)) + sp() +
+			pick(``, `"-" `, `"https://example.com/docs?q=1" `, `"" `) +
+			`"` + pick("Mozilla/5.0 (compatible; GPTBot/1.0; +https://openai.com/gptbot)", "curl/8.0", "-", "", "ua \\x22quoted\\x22") + `"` + sp() +
+			pick("203.0.113.7", "2001:db8::7", "-") + sp() +
+			pick("en-US", "-", "de-DE,de;q=0.9") + sp() +
+			pick("0.010", "0.000", "12.5") + sp() +
+			pick("example.com", "_", "www.example.org") + sp() +
+			pick("gptbot", "-", "googlebot") +
+			pick("", " -", " 0.012", " 0.010, 0.020", " 0.010, - : 0.005", " 0.01 ,0.02") +
+			pick("", ` "-"`, ` "203.0.113.7, 10.0.0.9"`, ` "x`, " trailing")
+		switch rng.Intn(20) {
+		case 0:
+			line = line[:rng.Intn(len(line))]
+		case 1:
+			i := rng.Intn(len(line))
+			line = line[:i] + pick(`"`, " ", "x", "\t\t", "\x00") + line[i:]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// TestNginxScanMatchesRegexp checks that every line Parse reads without
+// nginxRe gives the event nginxRe would have.
+func TestNginxScanMatchesRegexp(t *testing.T) {
+	scanned := 0
+	for _, line := range nginxCorpus(50000) {
+		line = strings.TrimSpace(line)
+		want, wantErr := parseNginxRegexp(line)
+		f, ok := scanNginx(line)
+		if !ok {
+			continue
+		}
+		scanned++
+		if wantErr != nil {
+			t.Fatalf("scanNginx(%q) succeeded, nginxRe doesn't match", line)
+		}
+		if got := f.event(); *got != *want {
+			t.Fatalf("scanNginx(%q)\ngot  %+v\nwant %+v", line, *got, *want)
+		}
+	}
+	// Most of the corpus is well formed and shouldn't need nginxRe.
+	if scanned < 40000 {
+		t.Errorf("scanNginx read %d of 50000 lines", scanned)
+	}
+}
+
+// FuzzNginxScan is TestNginxScanMatchesRegexp for arbitrary lines.
+func FuzzNginxScan(f *testing.F) {
+	for _, line := range nginxCorpus(20) {
+		f.Add(line)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		line = strings.TrimSpace(line)
+		fields, ok := scanNginx(line)
+		if !ok {
+			return
+		}
+		want, err := parseNginxRegexp(line)
+		if err != nil {
+			t.Fatalf("scanNginx(%q) succeeded, nginxRe doesn't match", line)
+		}
+		got := fields.event()
+		if _, ok := parseMsec(fields.msec); !ok {
+			got.Timestamp = want.Timestamp // both are the time of parsing
+		}
+		if *got != *want {
+			t.Fatalf("scanNginx(%q)\ngot  %+v\nwant %+v", line, *got, *want)
+		}
+	})
+}
+
+func BenchmarkParseLine(b *testing.B) {
+	const line = `1700000000.123 "GET /docs/a?x=1 HTTP/1.1" 200 512 "https://example.com/" "Mozilla/5.0 (compatible; GPTBot/1.0; +https://openai.com/gptbot)" 203.0.113.7 en-US 0.010 example.com gptbot 0.008 "203.0.113.7, 10.0.0.9"`
+	b.Run("regexp", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := parseNginxRegexp(line); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("scanner", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := (Nginx{}).Parse(line); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
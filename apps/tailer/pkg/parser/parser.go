@@ -0,0 +1,186 @@
+// Package parser turns access log lines into crawl events.
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// LineParser parses a single access log line. The returned event has no
+// IPPrefix yet; ClientIP holds the logged address (and ForwardedFor the
+// X-Forwarded-For header, if logged), and CrawlerFamily is whatever the
+// log recorded, possibly empty.
+type LineParser interface {
+	Parse(line string) (*event.CrawlEvent, error)
+}
+
+// Explainer is implemented by parsers that can tell where a line that
+// failed to parse diverged from the format.
+type Explainer interface {
+	// Explain returns the part of the format line failed at, such as
+	// "$status", and the byte offset into line where it did, or "" and
+	// -1 if it can't tell.
+	Explain(line string) (part string, offset int)
+}
+
+// ErrSkip is returned for lines that are part of the log but aren't
+// requests, such as the header lines of a CloudFront log file.
+var ErrSkip = errors.New("not a request")
+
+// Options configure the formats that need more than a name.
+type Options struct {
+	JSONMap    string // key overrides for "json", see NewJSON
+	LTSVMap    string // label overrides for "ltsv", see NewLTSV
+	TSVColumns string // the columns of "tsv", see NewTSV
+}
+
+// New returns the parser for a format name: "nginx", "apache-combined",
+// "json", "ltsv", "tsv", "caddy", "traefik", "alb", "cloudfront",
+// "haproxy", "envoy", "iis" or "ndjson".
+func New(format string, opts Options) (LineParser, error) {
+	switch format {
+	case "nginx":
+		return Nginx{}, nil
+	case "apache-combined":
+		return ApacheCombined{}, nil
+	case "json":
+		return NewJSON(opts.JSONMap)
+	case "ltsv":
+		return NewLTSV(opts.LTSVMap)
+	case "tsv":
+		return NewTSV(opts.TSVColumns)
+	case "caddy":
+		return Caddy{}, nil
+	case "traefik":
+		return Traefik{}, nil
+	case "alb":
+		return ALB{}, nil
+	case "cloudfront":
+		return CloudFront{}, nil
+	case "haproxy":
+		return HAProxy{}, nil
+	case "envoy":
+		return Envoy{}, nil
+	case "iis":
+		return NewIIS(), nil
+	case "ndjson":
+		return NDJSON{}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// parseMsec converts an nginx $msec value ("1700000000.123", seconds with
+// millisecond resolution) to Unix milliseconds.
+func parseMsec(s string) (int64, bool) {
+	secs, frac, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil || sec <= 0 {
+		return 0, false
+	}
+	frac = (frac + "000")[:3]
+	ms, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return sec*1000 + ms, true
+}
+
+// stripQuery drops the query string from a URI.
+func stripQuery(uri string) string {
+	path, _, _ := strings.Cut(uri, "?")
+	return path
+}
+
+// splitQuery splits a request URI into path and query string.
+func splitQuery(uri string) (path, query string) {
+	path, query, _ = strings.Cut(uri, "?")
+	return path, query
+}
+
+// splitTarget splits a request target into host, path and query string.
+// Proxies log absolute URLs ("https://example.com:443/a?x=1") for some
+// requests, from which the host is taken; an origin form target ("/a?x=1")
+// has none.
+func splitTarget(target string) (host, path, query string) {
+	if !strings.Contains(target, "://") {
+		path, query = splitQuery(target)
+		return "", path, query
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", ""
+	}
+	if path = u.EscapedPath(); path == "" {
+		path = "/"
+	}
+	return u.Hostname(), path, u.RawQuery
+}
+
+// parseMillis parses a duration logged in milliseconds. A leading "+"
+// (HAProxy's mark for a total logged before the end) is ignored, and "-",
+// -1 for "never got there" and anything invalid give 0.
+func parseMillis(s string) int64 {
+	return parseBytes(strings.TrimPrefix(s, "+"))
+}
+
+// parseBytes parses a byte count, treating "-" and anything invalid as 0.
+func parseBytes(s string) int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// parseSeconds converts a time in seconds such as nginx's $request_time
+// ("0.012") to whole milliseconds. "-" and invalid values give 0.
+func parseSeconds(s string) int64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return int64(math.Round(f * 1000))
+}
+
+// parseUpstreamTime converts $upstream_response_time to milliseconds. With
+// several upstreams nginx lists one time each, separated by commas (or
+// colons across internal redirects); they are summed, since together
+// they are the upstream time the request cost.
+func parseUpstreamTime(s string) int64 {
+	var total int64
+	for s != "" {
+		part := s
+		if i := strings.IndexAny(s, ",:"); i >= 0 {
+			part, s = s[:i], s[i+1:]
+		} else {
+			s = ""
+		}
+		total += parseSeconds(part)
+	}
+	return total
+}
+
+// durationMs converts a logged duration to whole milliseconds, treating
+// negative values as 0.
+func durationMs(d time.Duration) int64 {
+	if d < 0 {
+		return 0
+	}
+	return d.Round(time.Millisecond).Milliseconds()
+}
+
+// dashEmpty maps the "-" placeholder used for missing values to "".
+func dashEmpty(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,41 @@
+package parser
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	for _, format := range []string{"nginx", "apache-combined", "json", "ltsv", "caddy", "traefik", "alb", "cloudfront", "haproxy", "envoy", "ndjson"} {
+		if _, err := New(format, Options{}); err != nil {
+			t.Errorf("New(%q): %v", format, err)
+		}
+	}
+	if _, err := New("tsv", Options{TSVColumns: "ts,path,status"}); err != nil {
+		t.Errorf("New(tsv): %v", err)
+	}
+	if _, err := New("syslog", Options{}); err == nil {
+		t.Error("New(syslog) succeeded, want error")
+	}
+	if _, err := New("json", Options{JSONMap: "bogus=x"}); err == nil {
+		t.Error("New(json) with an unknown field succeeded, want error")
+	}
+}
+
+func TestParseMsec(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   int64
+		wantOK bool
+	}{
+		{"1700000000.123", 1700000000123, true},
+		{"1700000000.1", 1700000000100, true},
+		{"1700000000", 1700000000000, true},
+		{"0.5", 0, false},
+		{"abc", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseMsec(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseMsec(%q) = %d, %v; want %d, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
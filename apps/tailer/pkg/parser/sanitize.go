@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// The longest values Sanitize leaves in an event's fields, in bytes,
+// including the marker that ends a value cut short. Referers are URLs
+// like paths and get the same limit.
+const (
+	MaxUserAgent  = 1024
+	MaxPath       = 2048
+	MaxAcceptLang = 256
+)
+
+// truncatedMarker ends a value Sanitize shortened.
+const truncatedMarker = "..."
+
+// Sanitize removes control characters and invalid UTF-8 from the text
+// fields of e, so a hostile user agent can't inject line breaks into
+// whatever stores or displays the events, and cuts the user agent, path,
+// referer and Accept-Language down to their limits.
+func Sanitize(e *event.CrawlEvent) {
+	e.Host = stripControl(e.Host)
+	e.Path = truncateField(stripControl(e.Path), MaxPath)
+	e.Method = stripControl(e.Method)
+	e.UserAgent = truncateField(stripControl(e.UserAgent), MaxUserAgent)
+	e.AcceptLang = truncateField(stripControl(e.AcceptLang), MaxAcceptLang)
+	e.CrawlerFamily = stripControl(e.CrawlerFamily)
+	e.Referer = truncateField(stripControl(e.Referer), MaxPath)
+	e.ClientIP = stripControl(e.ClientIP)
+	e.ForwardedFor = stripControl(e.ForwardedFor)
+}
+
+// stripControl removes C0 and C1 control characters (including tabs and
+// line breaks) and DEL from s, and replaces invalid UTF-8, such as bytes
+// a log escaped that aren't text, with U+FFFD.
+func stripControl(s string) string {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "\uFFFD")
+	}
+	if strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// truncateField shortens s to at most max bytes, ending it with
+// truncatedMarker, without splitting a UTF-8 sequence.
+func truncateField(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max - len(truncatedMarker)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + truncatedMarker
+}
@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestSanitize(t *testing.T) {
+	e := event.CrawlEvent{
+		Host:          "example.com\r\n",
+		Path:          "/a\x00b" + strings.Repeat("p", 3000),
+		Method:        "GET",
+		UserAgent:     "Mozilla/5.0\nX-Injected: 1\t" + strings.Repeat("é", 600),
+		AcceptLang:    strings.Repeat("en,", 100),
+		Referer:       "https://example.org/\x1b[31m",
+		CrawlerFamily: "bot\xff\xfe",
+		ClientIP:      "203.0.113.7",
+	}
+	Sanitize(&e)
+
+	if e.Host != "example.com" {
+		t.Errorf("Host = %q", e.Host)
+	}
+	if len(e.Path) != MaxPath || !strings.HasPrefix(e.Path, "/ab") || !strings.HasSuffix(e.Path, truncatedMarker) {
+		t.Errorf("Path has %d bytes, starts %q, ends %q", len(e.Path), e.Path[:3], e.Path[len(e.Path)-3:])
+	}
+	if !strings.HasPrefix(e.UserAgent, "Mozilla/5.0X-Injected: 1é") || len(e.UserAgent) > MaxUserAgent || !utf8.ValidString(e.UserAgent) {
+		t.Errorf("UserAgent = %q (%d bytes)", e.UserAgent[:30], len(e.UserAgent))
+	}
+	if len(e.AcceptLang) != MaxAcceptLang {
+		t.Errorf("AcceptLang has %d bytes, want %d", len(e.AcceptLang), MaxAcceptLang)
+	}
+	if e.Referer != "https://example.org/[31m" {
+		t.Errorf("Referer = %q", e.Referer)
+	}
+	if e.CrawlerFamily != "bot\uFFFD" {
+		t.Errorf("CrawlerFamily = %q, want invalid UTF-8 replaced", e.CrawlerFamily)
+	}
+	if e.Method != "GET" || e.ClientIP != "203.0.113.7" {
+		t.Errorf("clean fields changed: %q %q", e.Method, e.ClientIP)
+	}
+}
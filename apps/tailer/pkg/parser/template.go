@@ -0,0 +1,275 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// templateQuoted captures a variable between double quotes, where the
+// value may hold escaped quotes (\" with escape=json, \x22 by default).
+const templateQuoted = `((?:[^"\\]|\\.)*)`
+
+// templateUnquoted are the captures for variables whose values can hold
+// spaces without being quoted; any other variable outside quotes runs to
+// the next space.
+var templateUnquoted = map[string]string{
+	"time_local":             `(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})`,
+	"request":                `(-|\S+ \S+(?: \S+)?)`,
+	"upstream_response_time": `(-|[\d.]+(?:\s*[,:]\s*(?:-|[\d.]+))*)`,
+}
+
+// Template parses lines written with an nginx log_format, given as the
+// format string itself. Variables the tailer knows fill the event's
+// fields; other variables are matched and ignored.
+type Template struct {
+	re   *regexp.Regexp
+	vars []string // the variable each capture group holds
+
+	// prefixes match the line up to each variable and then up to the
+	// text after the last, for Explain.
+	prefixes []*regexp.Regexp
+}
+
+// NewTemplate compiles format, an nginx log_format string such as
+// `$msec "$request" $status "$http_user_agent" $remote_addr`. Variables
+// are $name or ${name}; between two of them there must be some literal
+// text. $status and one of $request, $request_uri or $uri are required.
+func NewTemplate(format string) (*Template, error) {
+	format = strings.TrimSpace(format)
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	t := &Template{}
+	literal, prev := "", ""
+	var prefixes []string
+	for rest := format; rest != ""; {
+		i := strings.IndexByte(rest, '$')
+		if i < 0 {
+			literal, rest = literal+rest, ""
+			break
+		}
+		literal += rest[:i]
+		rest = rest[i+1:]
+
+		var name string
+		if strings.HasPrefix(rest, "{") {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ${ in %q", "$"+rest)
+			}
+			name, rest = rest[1:end], rest[end+1:]
+		} else {
+			end := strings.IndexFunc(rest, func(r rune) bool {
+				return !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+			})
+			if end < 0 {
+				end = len(rest)
+			}
+			name, rest = rest[:end], rest[end:]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("$ without a variable name after %q", format[:len(format)-len(rest)])
+		}
+		if prev != "" && literal == "" {
+			return nil, fmt.Errorf("variable $%s directly follows $%s; the values can't be told apart", name, prev)
+		}
+
+		pattern.WriteString(templateLiteral(literal))
+		switch {
+		case strings.HasSuffix(literal, `"`) && strings.HasPrefix(rest, `"`):
+			pattern.WriteString(templateQuoted)
+		case templateUnquoted[name] != "":
+			pattern.WriteString(templateUnquoted[name])
+		default:
+			pattern.WriteString(`(\S*)`)
+		}
+		t.vars = append(t.vars, name)
+		prefixes = append(prefixes, pattern.String())
+		literal, prev = "", name
+	}
+	pattern.WriteString(templateLiteral(literal))
+	prefixes = append(prefixes, pattern.String())
+	pattern.WriteString("$")
+
+	if !slices.Contains(t.vars, "status") {
+		return nil, fmt.Errorf("no $status variable")
+	}
+	if !slices.Contains(t.vars, "request") && !slices.Contains(t.vars, "request_uri") && !slices.Contains(t.vars, "uri") {
+		return nil, fmt.Errorf("no $request, $request_uri or $uri variable")
+	}
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, fmt.Errorf("compile format: %w", err)
+	}
+	t.re = re
+	for _, prefix := range prefixes {
+		re, err := regexp.Compile(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("compile format: %w", err)
+		}
+		t.prefixes = append(t.prefixes, re)
+	}
+	return t, nil
+}
+
+// templateLiteral returns the pattern for literal text in a format. Runs
+// of whitespace match any amount of it.
+func templateLiteral(s string) string {
+	var b strings.Builder
+	for s != "" {
+		i := strings.IndexFunc(s, unicode.IsSpace)
+		if i < 0 {
+			i = len(s)
+		}
+		b.WriteString(regexp.QuoteMeta(s[:i]))
+		if s = s[i:]; s != "" {
+			b.WriteString(`\s+`)
+			s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		}
+	}
+	return b.String()
+}
+
+// Explain tells which variable a line that failed to parse went wrong at:
+// the first whose value, or the text before it, didn't match, or whose
+// value was invalid. The offset is where in line that was.
+func (t *Template) Explain(line string) (part string, offset int) {
+	trimmed := strings.TrimSpace(line)
+	lead := strings.Index(line, trimmed)
+	if m := t.re.FindStringSubmatchIndex(trimmed); m != nil {
+		for i, name := range t.vars {
+			if v := trimmed[m[2*i+2]:m[2*i+3]]; name == "status" {
+				if _, err := strconv.Atoi(dashEmpty(v)); err != nil {
+					return "$status", lead + m[2*i+2]
+				}
+			}
+		}
+		return "", -1
+	}
+	end := 0
+	for i, prefix := range t.prefixes {
+		loc := prefix.FindStringIndex(trimmed)
+		switch {
+		case loc == nil && i == len(t.vars):
+			return "text after $" + t.vars[i-1], lead + end
+		case loc == nil:
+			return "$" + t.vars[i], lead + end
+		}
+		end = loc[1]
+	}
+	return "end of line", lead + end
+}
+
+func (t *Template) Parse(line string) (*event.CrawlEvent, error) {
+	m := t.re.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return nil, fmt.Errorf("line did not match -line-format")
+	}
+
+	e := &event.CrawlEvent{Source: event.SourceNginx}
+	for i, name := range t.vars {
+		v := dashEmpty(unescapeNginx(m[i+1]))
+		switch name {
+		case "msec":
+			e.Timestamp, _ = parseMsec(v)
+		case "time_iso8601":
+			if ts, err := time.Parse(time.RFC3339, v); err == nil {
+				e.Timestamp = ts.UnixMilli()
+			}
+		case "time_local":
+			if ts, err := time.Parse(apacheTimeLayout, v); err == nil {
+				e.Timestamp = ts.UnixMilli()
+			}
+		case "request":
+			if parts := strings.Fields(v); len(parts) >= 2 {
+				var host string
+				e.Method = parts[0]
+				host, e.Path, e.Query = splitTarget(parts[1])
+				if host != "" && e.Host == "" {
+					e.Host = host
+				}
+			}
+		case "request_method":
+			e.Method = v
+		case "request_uri", "uri":
+			e.Path, e.Query = splitQuery(v)
+		case "status":
+			status, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("$status: invalid status %q", v)
+			}
+			e.Status = status
+		case "body_bytes_sent", "bytes_sent":
+			e.Bytes = parseBytes(v)
+		case "http_user_agent":
+			e.UserAgent = v
+		case "http_accept_language":
+			e.AcceptLang = v
+		case "http_referer":
+			e.Referer = stripQuery(v)
+		case "remote_addr":
+			e.ClientIP = v
+		case "http_x_forwarded_for":
+			e.ForwardedFor = v
+		case "request_time":
+			e.RequestTimeMs = parseSeconds(v)
+		case "upstream_response_time":
+			e.UpstreamTimeMs = parseUpstreamTime(v)
+		case "host", "server_name", "http_host":
+			if v != "" {
+				e.Host = stripPort(v)
+			}
+		case "crawler_family", "peac_family":
+			e.CrawlerFamily = v
+		}
+	}
+	if e.Timestamp == 0 {
+		e.Timestamp, e.TimeAssumed = time.Now().UnixMilli(), true
+	}
+	return e, nil
+}
+
+// unescapeNginx undoes the escaping nginx applies to variable values: \xHH
+// for quotes, backslashes and unprintable bytes by default, backslash
+// escapes with escape=json.
+func unescapeNginx(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch c := s[i+1]; c {
+		case 'x':
+			if i+3 < len(s) {
+				if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+					b.WriteByte(byte(n))
+					i += 3
+					continue
+				}
+			}
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+			i++
+		case 't':
+			b.WriteByte('\t')
+			i++
+		case '"', '\\', '/':
+			b.WriteByte(c)
+			i++
+		default:
+			b.WriteByte('\\')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,133 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestTemplateParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		line   string
+		want   event.CrawlEvent
+	}{
+		{
+			name:   "request's example",
+			format: `$msec "$request" $status $body_bytes_sent "$http_user_agent" $remote_addr $http_accept_language $request_time $ssl_protocol $host $crawler_family`,
+			line:   `1700000000.123 "GET /a?x=1 HTTP/1.1" 200 512 "Mozilla/5.0 (compatible; GPTBot/1.2; +https://openai.com/gptbot)" 203.0.113.7 en-US 0.021 TLSv1.3 example.com gptbot`,
+			want: event.CrawlEvent{Timestamp: 1700000000123, Host: "example.com", Path: "/a", Query: "x=1", Method: "GET", Status: 200,
+				UserAgent: "Mozilla/5.0 (compatible; GPTBot/1.2; +https://openai.com/gptbot)", AcceptLang: "en-US", CrawlerFamily: "gptbot",
+				ClientIP: "203.0.113.7", Source: event.SourceNginx, Bytes: 512, RequestTimeMs: 21},
+		},
+		{
+			name:   "combined, escaped quotes and placeholders",
+			format: `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+			line:   `203.0.113.7 - - [14/Nov/2023:22:13:20 +0000] "GET /b HTTP/1.1" 404 0 "-" "curl/8.4.0 \x22quoted\x22"`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Path: "/b", Method: "GET", Status: 404,
+				UserAgent: `curl/8.4.0 "quoted"`, ClientIP: "203.0.113.7", Source: event.SourceNginx},
+		},
+		{
+			name:   "escape=json, braces, several upstreams",
+			format: `${time_iso8601}|$request_method|$request_uri|$status|"$http_user_agent"|$upstream_response_time|$http_host`,
+			line:   `2023-11-14T22:13:20+00:00|HEAD|/c?y=2|301|"say \"hi\""|0.004, 0.015|www.example.com:8443`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Host: "www.example.com", Path: "/c", Query: "y=2", Method: "HEAD", Status: 301,
+				UserAgent: `say "hi"`, Source: event.SourceNginx, UpstreamTimeMs: 19},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewTemplate(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateParseWithoutTime(t *testing.T) {
+	p, err := NewTemplate(`"$request" $status`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Parse(`"GET /a HTTP/1.1" 200`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.TimeAssumed || time.Since(time.UnixMilli(got.Timestamp)) > time.Minute {
+		t.Errorf("Timestamp %d, TimeAssumed %v; want now, assumed", got.Timestamp, got.TimeAssumed)
+	}
+}
+
+func TestTemplateParseRejects(t *testing.T) {
+	p, err := NewTemplate(`$msec "$request" $status "$http_user_agent"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"",
+		`1700000000.123 "GET / HTTP/1.1" 200`,
+		`1700000000.123 "GET / HTTP/1.1" OK "curl"`,
+		`1700000000.123 "GET / HTTP/1.1" 200 "curl" extra`,
+	} {
+		if _, err := p.Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestTemplateExplain(t *testing.T) {
+	p, err := NewTemplate(`$msec "$request" $status "$http_user_agent" [$request_time]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line, part string
+		at         string // where in line, by what follows: the start of the literal before a variable
+	}{
+		{`1700000000.123 "GET / HTTP/1.1" 200`, "$http_user_agent", ""},
+		{`1700000000.123 "GET / HTTP/1.1" OK "curl" [0.1]`, "$status", `OK "curl" [0.1]`},
+		{`1700000000.123 GET / HTTP/1.1 200 "curl" [0.1]`, "$request", ` GET / HTTP/1.1 200 "curl" [0.1]`},
+		{`1700000000.123 "GET / HTTP/1.1" 200 "curl" 0.1`, "$request_time", `" 0.1`},
+		{`1700000000.123 "GET / HTTP/1.1" 200 "curl" [0.1`, "text after $request_time", ``},
+		{`  1700000000.123 "GET / HTTP/1.1" 200 "curl" [0.1] extra`, "end of line", ` extra`},
+	}
+	for _, tt := range tests {
+		part, offset := p.Explain(tt.line)
+		if want := len(tt.line) - len(tt.at); part != tt.part || offset != want {
+			t.Errorf("Explain(%q) = %q at %d, want %q at %d", tt.line, part, offset, tt.part, want)
+		}
+	}
+	if part, offset := p.Explain(`1700000000.123 "GET / HTTP/1.1" 200 "curl" [0.1]`); part != "" || offset != -1 {
+		t.Errorf("Explain of a good line = %q at %d", part, offset)
+	}
+}
+
+func TestNewTemplateErrors(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string // in the error
+	}{
+		{`$msec "$request" $status$body_bytes_sent`, "$body_bytes_sent directly follows $status"},
+		{`$msec "$request" ${status`, "unterminated ${"},
+		{`$msec "$request" $ $status`, "$ without a variable name"},
+		{`$msec "$request" $body_bytes_sent`, "no $status"},
+		{`$msec $status`, "no $request"},
+	}
+	for _, tt := range tests {
+		_, err := NewTemplate(tt.format)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("NewTemplate(%q) = %v, want an error mentioning %q", tt.format, err, tt.want)
+		}
+	}
+}
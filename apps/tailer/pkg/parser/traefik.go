@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// traefikEntry is the part of a Traefik JSON access log entry that events
+// are made from. Durations are nanoseconds. Request headers are only
+// logged when accessLog.fields.headers keeps them, as request_<Name>.
+type traefikEntry struct {
+	StartUTC              string `json:"StartUTC"`
+	ClientAddr            string `json:"ClientAddr"`
+	ClientHost            string `json:"ClientHost"`
+	RequestHost           string `json:"RequestHost"`
+	RequestMethod         string `json:"RequestMethod"`
+	RequestPath           string `json:"RequestPath"`
+	DownstreamStatus      *int   `json:"DownstreamStatus"`
+	DownstreamContentSize int64  `json:"DownstreamContentSize"`
+	Duration              int64  `json:"Duration"`
+	OriginDuration        int64  `json:"OriginDuration"`
+	UserAgent             string `json:"request_User-Agent"`
+	AcceptLang            string `json:"request_Accept-Language"`
+	Referer               string `json:"request_Referer"`
+	ForwardedFor          string `json:"request_X-Forwarded-For"`
+}
+
+// Traefik parses Traefik's access log in its JSON format. The client
+// address is ClientAddr without the port, and otherwise ClientHost.
+type Traefik struct{}
+
+func (Traefik) Parse(line string) (*event.CrawlEvent, error) {
+	var e traefikEntry
+	if err := decodeJSON(line, &e); err != nil {
+		return nil, err
+	}
+	if e.RequestMethod == "" || e.DownstreamStatus == nil {
+		return nil, fmt.Errorf("not a traefik access log entry: missing RequestMethod or DownstreamStatus")
+	}
+
+	ts, ok := parseJSONTime(e.StartUTC)
+	if !ok {
+		ts = time.Now().UnixMilli()
+	}
+	ip := stripPort(e.ClientAddr)
+	if ip == "" {
+		ip = e.ClientHost
+	}
+
+	path, query := splitQuery(e.RequestPath)
+	return &event.CrawlEvent{
+		Timestamp:      ts,
+		TimeAssumed:    !ok,
+		Host:           stripPort(e.RequestHost),
+		Path:           path,
+		Query:          query,
+		Method:         e.RequestMethod,
+		Status:         *e.DownstreamStatus,
+		UserAgent:      e.UserAgent,
+		AcceptLang:     e.AcceptLang,
+		ClientIP:       ip,
+		Source:         event.SourceNginx,
+		Referer:        stripQuery(e.Referer),
+		Bytes:          max(e.DownstreamContentSize, 0),
+		RequestTimeMs:  durationMs(time.Duration(e.Duration)),
+		UpstreamTimeMs: durationMs(time.Duration(e.OriginDuration)),
+		ForwardedFor:   e.ForwardedFor,
+	}, nil
+}
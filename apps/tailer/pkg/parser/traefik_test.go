@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestTraefikParse(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want event.CrawlEvent
+	}{
+		{
+			name: "headers kept",
+			line: `{"ClientAddr":"203.0.113.7:52344","ClientHost":"203.0.113.7","ClientPort":"52344","ClientUsername":"-","DownstreamContentSize":5123,"DownstreamStatus":200,"Duration":21394217,"OriginContentSize":5123,"OriginDuration":20123456,"OriginStatus":200,"Overhead":1270761,"RequestAddr":"example.com","RequestContentSize":0,"RequestCount":42,"RequestHost":"example.com","RequestMethod":"GET","RequestPath":"/docs/a?x=1","RequestPort":"-","RequestProtocol":"HTTP/2.0","RequestScheme":"https","RetryAttempts":0,"RouterName":"web@docker","ServiceAddr":"172.18.0.3:8080","ServiceName":"web@docker","ServiceURL":{"Scheme":"http","Opaque":"","User":null,"Host":"172.18.0.3:8080","Path":"","RawPath":"","ForceQuery":false,"RawQuery":"","Fragment":"","RawFragment":""},"StartLocal":"2023-11-14T22:13:20.123456789Z","StartUTC":"2023-11-14T22:13:20.123456789Z","entryPointName":"websecure","level":"info","msg":"","request_Accept-Language":"en","request_Referer":"https://example.org/?q=1","request_User-Agent":"Mozilla/5.0 (compatible; PerplexityBot/1.0; +https://perplexity.ai/perplexitybot)","request_X-Forwarded-For":"198.51.100.2","time":"2023-11-14T22:13:20Z"}`,
+			want: event.CrawlEvent{Timestamp: 1700000000123, Host: "example.com", Path: "/docs/a", Query: "x=1", Method: "GET", Status: 200,
+				UserAgent: "Mozilla/5.0 (compatible; PerplexityBot/1.0; +https://perplexity.ai/perplexitybot)", AcceptLang: "en",
+				ClientIP: "203.0.113.7", Source: event.SourceNginx, Referer: "https://example.org/", Bytes: 5123,
+				RequestTimeMs: 21, UpstreamTimeMs: 20, ForwardedFor: "198.51.100.2"},
+		},
+		{
+			name: "default fields, headers dropped, no origin",
+			line: `{"ClientAddr":"[2001:db8::7]:52344","ClientHost":"2001:db8::7","ClientPort":"52344","ClientUsername":"-","DownstreamContentSize":21,"DownstreamStatus":502,"Duration":30001234567,"OriginContentSize":0,"OriginDuration":0,"OriginStatus":0,"Overhead":30001234567,"RequestAddr":"example.com:8443","RequestContentSize":0,"RequestCount":43,"RequestHost":"example.com","RequestMethod":"GET","RequestPath":"/","RequestPort":"8443","RequestProtocol":"HTTP/1.1","RequestScheme":"https","RetryAttempts":0,"RouterName":"web@docker","ServiceName":"web@docker","ServiceURL":"http://172.18.0.3:8080","StartLocal":"2023-11-14T23:13:20.5+01:00","StartUTC":"2023-11-14T22:13:20.5Z","entryPointName":"websecure","level":"info","msg":"","time":"2023-11-14T22:13:50Z"}`,
+			want: event.CrawlEvent{Timestamp: 1700000000500, Host: "example.com", Path: "/", Method: "GET", Status: 502, ClientIP: "2001:db8::7", Source: event.SourceNginx, Bytes: 21, RequestTimeMs: 30001},
+		},
+		{
+			name: "ClientHost only",
+			line: `{"ClientHost":"203.0.113.9","DownstreamContentSize":0,"DownstreamStatus":304,"Duration":950000,"RequestHost":"example.com","RequestMethod":"GET","RequestPath":"/feed.xml","StartUTC":"2023-11-14T22:13:20Z"}`,
+			want: event.CrawlEvent{Timestamp: 1700000000000, Host: "example.com", Path: "/feed.xml", Method: "GET", Status: 304, ClientIP: "203.0.113.9", Source: event.SourceNginx, RequestTimeMs: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Traefik{}.Parse(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got  %+v\nwant %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraefikParseRejects(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"not json",
+		`{"level":"info","msg":"Configuration loaded from flags.","time":"2023-11-14T22:13:20Z"}`,
+		`{"RequestMethod":"GET","RequestPath":"/"}`,
+	} {
+		if _, err := (Traefik{}).Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
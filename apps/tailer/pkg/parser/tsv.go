@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+// TSV parses tab-separated lines whose columns are given by position.
+type TSV struct {
+	columns []string // the event field of each column, "" to skip it
+}
+
+// NewTSV returns a TSV parser for columns, a list of event fields in the
+// order they are logged, such as "time,method,path,status,ua,ip,host".
+// The fields are those of NewLTSV, with time for ts; "-" skips a column.
+// Columns past the end of the list are ignored.
+func NewTSV(columns string) (*TSV, error) {
+	if strings.TrimSpace(columns) == "" {
+		return nil, fmt.Errorf("-tsv-columns is required")
+	}
+	p := &TSV{}
+	for _, col := range strings.Split(columns, ",") {
+		col = strings.TrimSpace(col)
+		switch {
+		case col == "-":
+			col = ""
+		case col == "time":
+			col = "ts"
+		case defaultLTSVMap[col] == "":
+			return nil, fmt.Errorf("unknown tsv column %q (known: %s, or - to skip)", col, strings.Join(knownFields(defaultLTSVMap), ", "))
+		}
+		p.columns = append(p.columns, col)
+	}
+	if !slices.Contains(p.columns, "status") {
+		return nil, fmt.Errorf("-tsv-columns has no status column")
+	}
+	if !slices.Contains(p.columns, "path") && !slices.Contains(p.columns, "request") {
+		return nil, fmt.Errorf("-tsv-columns has no path or request column")
+	}
+	return p, nil
+}
+
+func (p *TSV) Parse(line string) (*event.CrawlEvent, error) {
+	values := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+	if len(values) < len(p.columns) {
+		return nil, fmt.Errorf("line has %d columns, want %d", len(values), len(p.columns))
+	}
+	fields := make(map[string]string, len(p.columns))
+	for i, col := range p.columns {
+		if col != "" {
+			fields[col] = dashEmpty(values[i])
+		}
+	}
+	return fieldEvent(func(field string) string { return fields[field] })
+}
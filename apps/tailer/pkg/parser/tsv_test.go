@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/event"
+)
+
+func TestTSVParse(t *testing.T) {
+	p, err := NewTSV("time,method,path,status,ua,ip,host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Parse(strings.Join([]string{"2023-11-14T22:13:20Z", "GET", "/a?x=1", "200", "ClaudeBot/1.0", "203.0.113.7", "example.com", "extra", "columns"}, "\t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := event.CrawlEvent{Timestamp: 1700000000000, Host: "example.com", Path: "/a", Query: "x=1", Method: "GET", Status: 200,
+		UserAgent: "ClaudeBot/1.0", ClientIP: "203.0.113.7", Source: event.SourceNginx}
+	if *got != want {
+		t.Errorf("got  %+v\nwant %+v", *got, want)
+	}
+
+	// A skipped column and a placeholder.
+	p, err = NewTSV("ts, -, request, status, ua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.Parse(strings.Join([]string{"1700000000.250", "LAX1", "HEAD / HTTP/1.1", "404", "-"}, "\t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = event.CrawlEvent{Timestamp: 1700000000250, Path: "/", Method: "HEAD", Status: 404, Source: event.SourceNginx}
+	if *got != want {
+		t.Errorf("got  %+v\nwant %+v", *got, want)
+	}
+
+	for _, line := range []string{"", "1700000000.250\tLAX1\tHEAD / HTTP/1.1", "1700000000.250\tLAX1\tHEAD / HTTP/1.1\tOK\tcurl"} {
+		if _, err := p.Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestNewTSVErrors(t *testing.T) {
+	for _, columns := range []string{"", "ts,path,stauts", "ts,path,ua", "ts,status,ua"} {
+		if _, err := NewTSV(columns); err == nil {
+			t.Errorf("NewTSV(%q) succeeded, want error", columns)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nxadm/tail"
+)
+
+// Position is how far into a given file (identified by inode, so a
+// rotated file is never mistaken for its successor) lines have been read.
+// For the systemd journal, which has no files to speak of, it is the
+// cursor of the last entry read instead.
+type Position struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+	Cursor string `json:"cursor,omitempty"`
+	Time   int64  `json:"time,omitempty"` // when Offset was reached, in Unix ms
+}
+
+// PositionStore persists read positions, keyed by log file path, so a
+// restarted tailer resumes where the previous run stopped.
+type PositionStore struct {
+	path string
+
+	mu        sync.Mutex
+	positions map[string]Position
+	dirty     bool
+	flush     func() error // nil unless SetFlush was called
+	err       error        // from the last Sync that wrote
+}
+
+// LoadPositions reads the position file at path. A missing file is not an
+// error; it simply means there is nothing to resume from.
+func LoadPositions(path string) (*PositionStore, error) {
+	ps := &PositionStore{path: path, positions: map[string]Position{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read position file: %w", err)
+	}
+	if err := json.Unmarshal(data, &ps.positions); err != nil {
+		return nil, fmt.Errorf("parse position file %s: %w", path, err)
+	}
+	return ps, nil
+}
+
+// Resume returns where tailing of file should start. It returns nil (the
+// beginning of the file) when nothing is stored, when the file has been
+// replaced by rotation, or when it shrank below the stored offset.
+func (ps *PositionStore) Resume(file string) *tail.SeekInfo {
+	ps.mu.Lock()
+	pos, ok := ps.positions[file]
+	ps.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil
+	}
+	if inode := fileInode(file, fi); inode != pos.Inode {
+		slog.Info("Log file was rotated since the last run, starting from the beginning", "file", file, "old_inode", pos.Inode, "inode", inode)
+		return nil
+	}
+	if fi.Size() < pos.Offset {
+		slog.Info("Log file shrank since the last run, starting from the beginning", "file", file, "size", fi.Size(), "offset", pos.Offset)
+		return nil
+	}
+
+	slog.Info("Resuming", "file", file, "offset", pos.Offset)
+	return &tail.SeekInfo{Offset: pos.Offset, Whence: io.SeekStart}
+}
+
+// Get returns the position stored for file.
+func (ps *PositionStore) Get(file string) (Position, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	pos, ok := ps.positions[file]
+	return pos, ok
+}
+
+// Update records that file (with the given inode) has been read up to offset.
+func (ps *PositionStore) Update(file string, inode uint64, offset int64) {
+	now := time.Now().UnixMilli()
+	ps.mu.Lock()
+	ps.positions[file] = Position{Inode: inode, Offset: offset, Time: now}
+	ps.dirty = true
+	ps.mu.Unlock()
+}
+
+// ResumeCursor returns the journal cursor stored under key, or "".
+func (ps *PositionStore) ResumeCursor(key string) string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.positions[key].Cursor
+}
+
+// UpdateCursor records that the journal read under key has got as far as
+// the entry at cursor.
+func (ps *PositionStore) UpdateCursor(key, cursor string) {
+	ps.mu.Lock()
+	ps.positions[key] = Position{Cursor: cursor}
+	ps.dirty = true
+	ps.mu.Unlock()
+}
+
+// SetFlush makes Sync call flush after taking the positions and before
+// writing them, so that whatever holds the events of the lines read so
+// far can make them durable first.
+func (ps *PositionStore) SetFlush(flush func() error) {
+	ps.mu.Lock()
+	ps.flush = flush
+	ps.mu.Unlock()
+}
+
+// Sync writes the positions to disk if they changed since the last call.
+// The file is replaced atomically and fsynced so a crash never leaves a
+// truncated position file behind.
+func (ps *PositionStore) Sync() error {
+	ps.mu.Lock()
+	if !ps.dirty {
+		ps.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(ps.positions)
+	ps.dirty = false
+	flush := ps.flush
+	ps.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("marshal positions: %w", err)
+	}
+
+	if flush != nil {
+		err = flush()
+	}
+	if err == nil {
+		err = writeFileSync(ps.path, data)
+	}
+	ps.mu.Lock()
+	ps.err = err
+	if err != nil {
+		ps.dirty = true
+	}
+	ps.mu.Unlock()
+	return err
+}
+
+// Writable returns why positions can't be saved, if they can't: the last
+// save failed, or the file can't be written.
+func (ps *PositionStore) Writable() error {
+	ps.mu.Lock()
+	err := ps.err
+	ps.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// Sync creates the file, and any missing directories, so it is the
+	// nearest of them that exists that must be writable.
+	path := ps.path
+	for {
+		if _, err := os.Stat(path); !os.IsNotExist(err) || filepath.Dir(path) == path {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+	if err := canWrite(path); err != nil {
+		return fmt.Errorf("can't write %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeFileSync atomically replaces path with data via a fsynced temp file.
+func writeFileSync(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("fsync %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename %s: %w", tmp.Name(), err)
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// statInode returns the inode of file, or 0 if it cannot be determined.
+func statInode(file string) uint64 {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return 0
+	}
+	return fileInode(file, fi)
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileInode returns the inode of file, whose FileInfo is fi, or 0 if it
+// cannot be determined.
+func fileInode(file string, fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
+
+// canWrite returns why path can't be written to, if it can't.
+func canWrite(path string) error {
+	return unix.Access(path, unix.W_OK)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileInode returns what stands for an inode on Windows, the NTFS file
+// index of file, or 0 if it cannot be determined. Unlike an inode it isn't
+// in fi, so file is opened to ask for it.
+func fileInode(file string, fi os.FileInfo) uint64 {
+	name, err := windows.UTF16PtrFromString(file)
+	if err != nil {
+		return 0
+	}
+	// Without access rights, opening the file doesn't stand in the way of
+	// the server writing, renaming or deleting it.
+	h, err := windows.CreateFile(name, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0
+	}
+	defer windows.CloseHandle(h)
+	var info windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(h, &info); err != nil {
+		return 0
+	}
+	return uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow)
+}
+
+// canWrite returns why path can't be written to, if it can't. Windows has
+// no access(2); file permissions come down to the read-only attribute,
+// short of reading ACLs.
+func canWrite(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() && fi.Mode().Perm()&0o200 == 0 {
+		return errors.New("file is read-only")
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+	"github.com/originaryx/trace/tailer/pkg/event"
+	"gopkg.in/yaml.v3"
+)
+
+// Credentials are an API key and secret given in the config file, each
+// inline or in a file.
+type Credentials struct {
+	Key        string `yaml:"key"`
+	Secret     string `yaml:"secret"`
+	KeyFile    string `yaml:"key_file"`
+	SecretFile string `yaml:"secret_file"`
+}
+
+// Property is one entry of the config file's properties list: events for
+// hosts matching Host are sent with that property's credentials rather
+// than the default ones. Host is a name or a pattern like *.example.com.
+type Property struct {
+	Host        string `yaml:"host"`
+	Credentials `yaml:",inline"`
+
+	// UASalt, if set, replaces -ua-salt for this property's events.
+	UASalt string `yaml:"ua_salt"`
+}
+
+// configLists are the config file sections that aren't flags, so
+// loadConfigFile leaves them alone.
+type configLists struct {
+	Properties []Property  `yaml:"properties"`
+	Mirrors    []Mirror    `yaml:"mirrors"`
+	Bursts     []BurstRule `yaml:"bursts"`
+}
+
+// loadConfigLists reads the properties, mirrors and bursts lists from the
+// YAML config file at path, expanding ${VAR} references like other values.
+func loadConfigLists(path string) (configLists, error) {
+	var lists configLists
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return lists, fmt.Errorf("read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &lists); err != nil {
+		return lists, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	for i := range lists.Properties {
+		p := &lists.Properties[i]
+		if err := expandStrings(&p.Host, &p.Key, &p.Secret, &p.KeyFile, &p.SecretFile, &p.UASalt); err != nil {
+			return lists, fmt.Errorf("config file %s: properties[%d]: %w", path, i, err)
+		}
+		if p.Host == "" {
+			return lists, fmt.Errorf("config file %s: properties[%d]: host is not set", path, i)
+		}
+		if _, err := matchHost(p.Host, ""); err != nil {
+			return lists, fmt.Errorf("config file %s: properties[%d]: invalid host pattern %q", path, i, p.Host)
+		}
+	}
+	for i := range lists.Mirrors {
+		m := &lists.Mirrors[i]
+		if err := expandStrings(&m.Endpoint, &m.Key, &m.Secret, &m.KeyFile, &m.SecretFile); err != nil {
+			return lists, fmt.Errorf("config file %s: mirrors[%d]: %w", path, i, err)
+		}
+		if m.Endpoint == "" {
+			return lists, fmt.Errorf("config file %s: mirrors[%d]: endpoint is not set", path, i)
+		}
+	}
+	for i, b := range lists.Bursts {
+		if err := b.validate(); err != nil {
+			return lists, fmt.Errorf("config file %s: bursts[%d]: %w", path, i, err)
+		}
+	}
+	return lists, nil
+}
+
+// expandStrings applies configString to each value in place.
+func expandStrings(values ...*string) error {
+	for _, v := range values {
+		s, err := configString(*v)
+		if err != nil {
+			return err
+		}
+		*v = s
+	}
+	return nil
+}
+
+// matchHost reports whether host matches pattern, ignoring case.
+func matchHost(pattern, host string) (bool, error) {
+	return path.Match(strings.ToLower(pattern), strings.ToLower(host))
+}
+
+// Router is a client.Sender that signs each request with the credentials
+// of the property its events belong to. The Sender batches per property
+// (see BatchKey), so live batches never mix properties; a batch replayed
+// from the spool may, and is split into one request per property.
+type Router struct {
+	props []routedProperty
+	def   *client.Client // nil if events of other hosts are dropped
+}
+
+type routedProperty struct {
+	host string
+	api  *client.Client
+}
+
+// newRouter returns a router for cfg.Properties. Each property gets a
+// client like def, and events for hosts no property matches go to def
+// unless -unmatched-host is drop.
+func newRouter(cfg Config, def *client.Client) (*Router, error) {
+	r := &Router{def: def}
+	if cfg.UnmatchedHost == "drop" {
+		r.def = nil
+	}
+	for _, p := range cfg.Properties {
+		key, secret, err := p.load(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %w", p.Host, err)
+		}
+		api := client.New(def.Endpoint, key, secret)
+		api.HTTPClient = def.HTTPClient
+		api.Header = def.Header
+		api.Timeout = def.Timeout
+		api.Compression = def.Compression
+		api.SignUncompressed = def.SignUncompressed
+		api.SigVersion = def.SigVersion
+		api.PrivateKey = def.PrivateKey
+		api.OnRequest = def.OnRequest
+		api.OnClockSkew = def.OnClockSkew
+		r.props = append(r.props, routedProperty{host: p.Host, api: api})
+	}
+	return r, nil
+}
+
+// set reports whether any credentials were given.
+func (c Credentials) set() bool {
+	return c.Key != "" || c.KeyFile != "" || c.Secret != "" || c.SecretFile != ""
+}
+
+// load resolves the key and secret, like loadCredentials does for the
+// default ones.
+func (c Credentials) load(cfg Config) (key, secret string, err error) {
+	if cfg.DryRun {
+		return "", "", nil
+	}
+	if key, err = credential("key", c.Key, c.KeyFile); err != nil {
+		return "", "", err
+	}
+	if cfg.SignAlg == "ed25519" {
+		return key, "", nil
+	}
+	if secret, err = credential("secret", c.Secret, c.SecretFile); err != nil {
+		return "", "", err
+	}
+	return key, secret, nil
+}
+
+// credential returns a key or secret given inline or in a file.
+func credential(name, val, file string) (string, error) {
+	if val == "" && file == "" {
+		return "", fmt.Errorf("%s is not set: use %s or %s_file", name, name, name)
+	}
+	return resolveCredential(name, name, val, file, "")
+}
+
+// route returns the property index for host, -1 for the default
+// credentials, or -2 if the event is to be dropped.
+func (r *Router) route(host string) int {
+	for i, p := range r.props {
+		if ok, _ := matchHost(p.host, host); ok {
+			return i
+		}
+	}
+	if r.def == nil {
+		return -2
+	}
+	return -1
+}
+
+// Accepts reports whether events for host have credentials to go with.
+func (r *Router) Accepts(host string) bool {
+	return r.route(host) != -2
+}
+
+// BatchKey is the property host pattern for event, or "" for the default
+// credentials.
+func (r *Router) BatchKey(event *CrawlEvent) string {
+	if i := r.route(event.Host); i >= 0 {
+		return r.props[i].host
+	}
+	return ""
+}
+
+func (r *Router) client(i int) *client.Client {
+	if i >= 0 {
+		return r.props[i].api
+	}
+	return r.def
+}
+
+// Send delivers events with one request per property, in order of first
+// appearance. It stops at the first failure, so with mixed batches the
+// requests before it may be sent again on retry.
+func (r *Router) Send(ctx context.Context, events []*event.CrawlEvent) error {
+	var order []int
+	groups := map[int][]*event.CrawlEvent{}
+	for _, e := range events {
+		i := r.route(e.Host)
+		if i == -2 {
+			// Only spooled events get here; the pipeline drops the
+			// rest before they are queued.
+			continue
+		}
+		if _, ok := groups[i]; !ok {
+			order = append(order, i)
+		}
+		groups[i] = append(groups[i], e)
+	}
+	for _, i := range order {
+		if err := r.client(i).Send(ctx, groups[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+func TestLoadProperties(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "b.secret")
+	if err := os.WriteFile(secretFile, []byte("sk_b\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "tailer.yaml")
+	yaml := "batch_size: 5\nproperties:\n  - host: a.example\n    key: pk_a\n    secret: ${TEST_PROPERTY_SECRET}\n  - host: \"*.b.example\"\n    key: pk_b\n    secret_file: " + secretFile + "\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_PROPERTY_SECRET", "sk_a")
+	cfg, _, err := parseConfig([]string{"-config", path, "-key", "pk_default", "-secret", "sk_default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BatchSize != 5 || len(cfg.Properties) != 2 || cfg.Properties[0].Secret != "sk_a" || cfg.Properties[1].Host != "*.b.example" {
+		t.Fatalf("got batch size %d, properties %+v", cfg.BatchSize, cfg.Properties)
+	}
+	key, secret, err := cfg.Properties[1].load(cfg)
+	if err != nil || key != "pk_b" || secret != "sk_b" {
+		t.Errorf("property credentials = %q, %q, %v; want pk_b, sk_b", key, secret, err)
+	}
+}
+
+func TestRouterSend(t *testing.T) {
+	var mu sync.Mutex
+	keys := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys[r.Header.Get("X-Peac-Key")]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	props := []Property{
+		{Host: "a.example", Credentials: Credentials{Key: "pk_a", Secret: "sk_a"}},
+		{Host: "*.b.example", Credentials: Credentials{Key: "pk_b", Secret: "sk_b"}},
+	}
+	tests := []struct {
+		unmatched string
+		want      map[string]int
+		accepts   bool
+	}{
+		{"default", map[string]int{"pk_a": 1, "pk_b": 1, "pk_default": 1}, true},
+		{"drop", map[string]int{"pk_a": 1, "pk_b": 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unmatched, func(t *testing.T) {
+			clear(keys)
+			cfg := Config{Properties: props, UnmatchedHost: tt.unmatched, SignAlg: "hmac"}
+			r, err := newRouter(cfg, client.New(srv.URL, "pk_default", "sk_default"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			events := []*CrawlEvent{{Host: "A.example"}, {Host: "www.b.example"}, {Host: "other.example"}, {Host: "a.example"}}
+			if err := r.Send(context.Background(), events); err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(keys, tt.want) {
+				t.Errorf("requests by key = %v, want %v", keys, tt.want)
+			}
+			if got := r.Accepts("other.example"); got != tt.accepts {
+				t.Errorf("Accepts(other.example) = %v, want %v", got, tt.accepts)
+			}
+			if got := r.BatchKey(&CrawlEvent{Host: "x.b.example"}); got != "*.b.example" {
+				t.Errorf("BatchKey = %q, want *.b.example", got)
+			}
+		})
+	}
+}
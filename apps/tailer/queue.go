@@ -0,0 +1,508 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// queueCommitInterval is how often events added to the queue are
+	// written to disk, and delivered ones deleted, when nothing asks for
+	// it sooner.
+	queueCommitInterval = 200 * time.Millisecond
+
+	// queueCommitEvents starts a commit early once this many events wait
+	// for one.
+	queueCommitEvents = 1000
+
+	// queueCompactMin is the smallest file worth compacting.
+	queueCompactMin = 8 << 20
+)
+
+var queueBucket = []byte("events")
+
+// Backlog keeps the events a Sender could not deliver for its drainer
+// to send again later: the Spool, or the Queue with -queue-backend bolt.
+type Backlog interface {
+	Append(events []*CrawlEvent) error
+	Drain(send func([]*CrawlEvent) error, batchSize int)
+	Kick()
+	Close()
+}
+
+// persistentBacklog is a Backlog that holds every event from the moment
+// it is enqueued, rather than only those that failed, and so needs to be
+// told which have been dealt with.
+type persistentBacklog interface {
+	Add(event *CrawlEvent) error
+	Ack(events []*CrawlEvent)
+}
+
+// Queue is a BoltDB store of every event not yet delivered, for
+// at-least-once delivery across crashes. Events are added as they are
+// enqueued and deleted once the API has accepted them (or refused them
+// for good). Writes are grouped into a commit every queueCommitInterval,
+// and Flush commits at once: the position file is only written after a
+// Flush, so it never gets ahead of the queue. Whatever a previous run
+// left undelivered is sent again by the drainer, along with the events
+// of batches that failed.
+//
+// Once the events in it exceed maxBytes the oldest are evicted, and the
+// file is compacted when it holds much more space than its events need.
+type Queue struct {
+	path     string
+	maxBytes int64
+
+	mu       sync.Mutex
+	nextKey  uint64
+	adds     []queueEntry           // not committed yet
+	acked    map[uint64]bool        // delivered, not deleted yet
+	keys     map[*CrawlEvent]uint64 // events out for delivery
+	inflight map[uint64]bool        // and their keys
+	waiting  int                    // stored events out for nobody
+	draining bool                   // Drain was called
+
+	// dbMu guards db, which compaction replaces, and keeps commits in
+	// order.
+	dbMu   sync.Mutex
+	db     *bolt.DB
+	stored int64 // events in db
+	bytes  int64 // and their size
+
+	commit chan struct{}
+	kick   chan struct{}
+	stop   chan struct{}
+	done   chan struct{} // the drainer's
+	synced chan struct{} // the committer's
+}
+
+type queueEntry struct {
+	key  uint64
+	data []byte
+}
+
+// OpenQueue opens (creating if needed) the queue file at path and picks
+// up any events left by a previous run.
+func OpenQueue(path string, maxBytes int64) (*Queue, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create queue directory: %w", err)
+	}
+	db, err := openQueueDB(path)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		path:     path,
+		maxBytes: maxBytes,
+		db:       db,
+		acked:    map[uint64]bool{},
+		keys:     map[*CrawlEvent]uint64{},
+		inflight: map[uint64]bool{},
+		commit:   make(chan struct{}, 1),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		synced:   make(chan struct{}),
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(queueBucket)
+		if k, _ := b.Cursor().Last(); k != nil {
+			q.nextKey = binary.BigEndian.Uint64(k) + 1
+		}
+		return b.ForEach(func(_, v []byte) error {
+			q.stored++
+			q.bytes += int64(len(v))
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("read queue %s: %w", path, err)
+	}
+	q.waiting = int(q.stored)
+	metrics.QueueStored.Set(q.stored)
+	if err := q.compactIfSparse(); err != nil {
+		q.db.Close()
+		return nil, err
+	}
+	if q.stored > 0 {
+		slog.Info("Queue holds undelivered events from a previous run", "file", path, "events", q.stored)
+	}
+	go q.commitLoop()
+	return q, nil
+}
+
+func openQueueDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("open queue %s: in use by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open queue %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(queueBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open queue %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Add stores event, at the next commit, and counts it out for delivery.
+func (q *Queue) Add(event *CrawlEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	q.mu.Lock()
+	key := q.nextKey
+	q.nextKey++
+	q.adds = append(q.adds, queueEntry{key: key, data: data})
+	q.keys[event] = key
+	q.inflight[key] = true
+	full := len(q.adds) >= queueCommitEvents
+	q.mu.Unlock()
+	if full {
+		select {
+		case q.commit <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Ack deletes events, at the next commit, as delivered or given up on.
+func (q *Queue) Ack(events []*CrawlEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range events {
+		if key, ok := q.keys[e]; ok {
+			delete(q.keys, e)
+			delete(q.inflight, key)
+			q.acked[key] = true
+		}
+	}
+}
+
+// Append hands back events the sender could not deliver. They are stored
+// already, so they are only left for the drainer; any that were never
+// added are added first.
+func (q *Queue) Append(events []*CrawlEvent) error {
+	for _, e := range events {
+		q.mu.Lock()
+		_, ok := q.keys[e]
+		q.mu.Unlock()
+		if !ok {
+			if err := q.Add(e); err != nil {
+				return err
+			}
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range events {
+		delete(q.inflight, q.keys[e])
+		delete(q.keys, e)
+		q.waiting++
+	}
+	return nil
+}
+
+// Flush commits what has been added and acknowledged so far.
+func (q *Queue) Flush() error {
+	q.dbMu.Lock()
+	defer q.dbMu.Unlock()
+	if q.db == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	adds, acked := q.adds, q.acked
+	q.adds, q.acked = nil, map[uint64]bool{}
+	q.mu.Unlock()
+	if len(adds) == 0 && len(acked) == 0 {
+		return nil
+	}
+
+	stored, bytes := q.stored, q.bytes
+	var evicted []uint64
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(queueBucket)
+		for _, e := range adds {
+			// Bolt keeps the key until the transaction ends.
+			if err := b.Put(binary.BigEndian.AppendUint64(nil, e.key), e.data); err != nil {
+				return err
+			}
+			stored++
+			bytes += int64(len(e.data))
+		}
+		for key := range acked {
+			k := binary.BigEndian.AppendUint64(nil, key)
+			if v := b.Get(k); v != nil {
+				stored--
+				bytes -= int64(len(v))
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && bytes > q.maxBytes; k, v = c.First() {
+			stored--
+			bytes -= int64(len(v))
+			evicted = append(evicted, binary.BigEndian.Uint64(k))
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	q.mu.Lock()
+	if err != nil {
+		// Keep it all for the next commit.
+		q.adds = append(adds, q.adds...)
+		for key := range acked {
+			q.acked[key] = true
+		}
+		q.mu.Unlock()
+		return fmt.Errorf("commit queue: %w", err)
+	}
+	for _, key := range evicted {
+		if !q.inflight[key] && !q.acked[key] {
+			q.waiting--
+		}
+	}
+	q.waiting = max(q.waiting, 0)
+	q.mu.Unlock()
+
+	q.stored, q.bytes = stored, bytes
+	metrics.QueueStored.Set(stored)
+	if len(evicted) > 0 {
+		metrics.QueueEvicted.Add(int64(len(evicted)))
+		slog.Warn("Queue full, dropped oldest events", "events", len(evicted))
+	}
+	if len(acked) > 0 {
+		return q.compactIfSparse()
+	}
+	return nil
+}
+
+// commitLoop flushes the queue every queueCommitInterval, or sooner when
+// Add asks, until Close.
+func (q *Queue) commitLoop() {
+	defer close(q.synced)
+	ticker := time.NewTicker(queueCommitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		case <-q.commit:
+		}
+		if err := q.Flush(); err != nil {
+			slog.Error("Failed to commit queue", "err", err)
+		}
+	}
+}
+
+// compactIfSparse rewrites the file when it takes more than twice the
+// space of the events in it, as after a large backlog was delivered.
+// q.dbMu is held.
+func (q *Queue) compactIfSparse() error {
+	fi, err := os.Stat(q.path)
+	if err != nil || fi.Size() < queueCompactMin || fi.Size() < 2*q.bytes {
+		return nil
+	}
+	before := fi.Size()
+	tmp := q.path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0o600, nil)
+	if err != nil {
+		return fmt.Errorf("compact queue: %w", err)
+	}
+	err = bolt.Compact(dst, q.db, 4<<20)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("compact queue: %w", err)
+	}
+
+	q.db.Close()
+	renamed := os.Rename(tmp, q.path)
+	if renamed == nil {
+		syncDir(filepath.Dir(q.path))
+	} else {
+		os.Remove(tmp)
+	}
+	// Reopen whichever file is now at path: the compacted one, or the
+	// original if the rename failed.
+	if q.db, err = openQueueDB(q.path); err != nil {
+		q.db = nil
+		return err
+	}
+	if renamed != nil {
+		return fmt.Errorf("compact queue: %w", renamed)
+	}
+	after := before
+	if fi, err := os.Stat(q.path); err == nil {
+		after = fi.Size()
+	}
+	slog.Info("Compacted queue", "file", q.path, "bytes_before", before, "bytes_after", after)
+	return nil
+}
+
+// Drain sends stored events no one else is delivering through send, in
+// chunks of batchSize, until Close is called. It runs in its own
+// goroutine.
+func (q *Queue) Drain(send func([]*CrawlEvent) error, batchSize int) {
+	q.mu.Lock()
+	q.draining = true
+	q.mu.Unlock()
+	defer close(q.done)
+
+	ticker := time.NewTicker(spoolRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		for q.drainOne(send, batchSize) {
+			select {
+			case <-q.stop:
+				return
+			default:
+			}
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-q.kick:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Kick asks the drainer to retry now, e.g. after the endpoint recovered.
+func (q *Queue) Kick() {
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+// drainOne sends the oldest batchSize stored events out for nobody. It
+// returns true if they were dealt with and more may be waiting.
+func (q *Queue) drainOne(send func([]*CrawlEvent) error, batchSize int) bool {
+	q.mu.Lock()
+	waiting := q.waiting
+	q.mu.Unlock()
+	if waiting == 0 {
+		return false
+	}
+
+	var events []*CrawlEvent
+	keys := map[*CrawlEvent]uint64{}
+	q.dbMu.Lock()
+	err := q.view(func(b *bolt.Bucket) error {
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && len(events) < batchSize; k, v = c.Next() {
+			key := binary.BigEndian.Uint64(k)
+			q.mu.Lock()
+			busy := q.inflight[key] || q.acked[key]
+			q.mu.Unlock()
+			if busy {
+				continue
+			}
+			e := &CrawlEvent{}
+			if err := json.Unmarshal(v, e); err != nil {
+				// Nothing writes such an entry, but it mustn't wedge the
+				// queue.
+				slog.Warn("Dropping corrupt queue entry", "key", key, "err", err)
+				q.mu.Lock()
+				q.acked[key] = true
+				q.mu.Unlock()
+				continue
+			}
+			events = append(events, e)
+			keys[e] = key
+		}
+		return nil
+	})
+	q.dbMu.Unlock()
+	if err != nil {
+		slog.Error("Failed to read queue", "err", err)
+		return false
+	}
+
+	q.mu.Lock()
+	if len(events) == 0 {
+		q.waiting = 0
+		q.mu.Unlock()
+		return false
+	}
+	for e, key := range keys {
+		q.keys[e] = key
+		q.inflight[key] = true
+	}
+	q.waiting = max(q.waiting-len(events), 0)
+	q.mu.Unlock()
+
+	if err := send(events); err != nil {
+		// Only a refusal the API will repeat is given up on; anything
+		// else, an open circuit or shutdown included, is for later.
+		if retryable(err) || errors.Is(err, errCircuitOpen) || errors.Is(err, errShutdown) {
+			q.Append(events)
+			slog.Warn("Queue drain paused", "err", err)
+			return false
+		}
+		slog.Warn("Dropping queued events", "events", len(events), "err", err)
+	}
+	q.Ack(events)
+	return true
+}
+
+// view runs fn on the bucket in a read transaction. q.dbMu is held.
+func (q *Queue) view(fn func(*bolt.Bucket) error) error {
+	if q.db == nil {
+		return errors.New("queue is closed")
+	}
+	return q.db.View(func(tx *bolt.Tx) error { return fn(tx.Bucket(queueBucket)) })
+}
+
+// Close stops the drainer, if there is one, commits what is pending and
+// closes the file. Events not delivered stay in it for the next run.
+func (q *Queue) Close() {
+	close(q.stop)
+	q.mu.Lock()
+	draining := q.draining
+	q.mu.Unlock()
+	if draining {
+		<-q.done
+	}
+	<-q.synced
+	if err := q.Flush(); err != nil {
+		slog.Error("Failed to commit queue", "err", err)
+	}
+
+	q.dbMu.Lock()
+	defer q.dbMu.Unlock()
+	if q.db != nil {
+		q.db.Close()
+		q.db = nil
+	}
+}
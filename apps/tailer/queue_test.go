@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+func queueEvents(n int) []*CrawlEvent {
+	events := make([]*CrawlEvent, n)
+	for i := range events {
+		events[i] = &CrawlEvent{Timestamp: int64(i + 1), Path: fmt.Sprintf("/%d", i), Method: "GET", Status: 200, EventID: fmt.Sprintf("id-%d", i)}
+	}
+	return events
+}
+
+// drainQueue returns what the queue at path holds, delivering it.
+func drainQueue(t *testing.T, path string) []*CrawlEvent {
+	t.Helper()
+	q, err := OpenQueue(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeAPI{}
+	s := NewSender(context.Background(), api, testConfig(), q)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		q.mu.Lock()
+		waiting := q.waiting
+		q.mu.Unlock()
+		if waiting == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Close(time.Second)
+	return api.received
+}
+
+func TestQueueResendsUndelivered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	q, err := OpenQueue(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unavailable := &client.StatusError{StatusCode: 503}
+	// The first batch, of 10, is delivered; the second fails for good.
+	api := &fakeAPI{errs: []error{nil, unavailable, unavailable, unavailable}}
+	s := NewSender(context.Background(), api, testConfig(), q)
+	for _, e := range queueEvents(15) {
+		s.Enqueue(e)
+	}
+	s.Close(time.Second)
+
+	got := drainQueue(t, path)
+	if len(got) != 5 || got[0].Path != "/10" || got[0].EventID != "id-10" {
+		t.Fatalf("redelivered %d events starting %+v, want the 5 that failed", len(got), got[0])
+	}
+	if got := drainQueue(t, path); len(got) != 0 {
+		t.Errorf("redelivered %d events again, want none", len(got))
+	}
+}
+
+func TestQueueEvictsOldest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	events := queueEvents(20)
+	size, _ := json.Marshal(events[10])
+	q, err := OpenQueue(path, int64(10*len(size)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		q.Add(e)
+	}
+	q.Append(events)
+	if err := q.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if q.stored != 10 || metrics.QueueStored.Load() != 10 {
+		t.Errorf("stored %d events (gauge %d), want 10", q.stored, metrics.QueueStored.Load())
+	}
+	q.Close()
+
+	got := drainQueue(t, path)
+	if len(got) != 10 || got[0].Path != "/10" {
+		t.Errorf("kept %d events starting %+v, want the newest 10", len(got), got[0])
+	}
+}
+
+func TestQueueCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	q, err := OpenQueue(path, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	events := queueEvents(2 * queueCompactMin >> 16)
+	for _, e := range events {
+		e.UserAgent = strings.Repeat("x", 64<<10)
+		q.Add(e)
+	}
+	if err := q.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	full, _ := os.Stat(path)
+	q.Ack(events)
+	if err := q.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	empty, _ := os.Stat(path)
+	if full.Size() < queueCompactMin || empty.Size() >= full.Size()/4 {
+		t.Errorf("file of %d bytes is %d once emptied, want it compacted", full.Size(), empty.Size())
+	}
+}
+
+// TestQueueSurvivesKill kills a tailer's sender mid-request, with some
+// batches delivered and the rest queued, and checks that a restart
+// delivers every event, any twice only with the same event_id.
+func TestQueueSurvivesKill(t *testing.T) {
+	if path := os.Getenv("TRACE_TAILER_QUEUE_CHILD"); path != "" {
+		runQueueChild(path, os.Getenv("TRACE_TAILER_QUEUE_URL"))
+		return
+	}
+	const events = 100
+
+	var mu sync.Mutex
+	requests := 0
+	delivered := map[string][]string{} // path -> event IDs
+	blocked := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+		if n == 3 {
+			// Take the batch but never answer, until the child is gone.
+			io.Copy(io.Discard, r.Body)
+			close(blocked)
+			<-r.Context().Done()
+			return
+		}
+		dec := json.NewDecoder(r.Body)
+		var got []CrawlEvent
+		for {
+			var e CrawlEvent
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			got = append(got, e)
+		}
+		mu.Lock()
+		for _, e := range got {
+			delivered[e.Path] = append(delivered[e.Path], e.EventID)
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "queue.db")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestQueueSurvivesKill$")
+	cmd.Env = append(os.Environ(), "TRACE_TAILER_QUEUE_CHILD="+path, "TRACE_TAILER_QUEUE_URL="+srv.URL)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	flushed := make(chan bool, 1)
+	go func() {
+		sc := bufio.NewScanner(stdout)
+		for sc.Scan() {
+			if sc.Text() == "flushed" {
+				flushed <- true
+				io.Copy(io.Discard, stdout)
+				return
+			}
+		}
+		flushed <- false
+	}()
+	select {
+	case <-blocked:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("child never got to its third request")
+	}
+	if !<-flushed {
+		cmd.Process.Kill()
+		t.Fatal("child exited before queueing its events")
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	q, err := OpenQueue(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSender(context.Background(), client.New(srv.URL, "pk_test", "sk_test"), testConfig(), q)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		n := len(delivered)
+		mu.Unlock()
+		if n == events || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Close(time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	dups := 0
+	for i := range events {
+		ids := delivered[fmt.Sprintf("/%d", i)]
+		if len(ids) == 0 {
+			t.Errorf("event %d was lost", i)
+		}
+		for _, id := range ids {
+			if id != fmt.Sprintf("id-%d", i) {
+				t.Errorf("event %d delivered with event_id %q", i, id)
+			}
+		}
+		dups += max(len(ids)-1, 0)
+	}
+	t.Logf("%d events delivered again after the restart", dups)
+}
+
+// runQueueChild queues and starts sending events to url, and then waits
+// to be killed.
+func runQueueChild(path, url string) {
+	q, err := OpenQueue(path, 1<<20)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	s := NewSender(context.Background(), client.New(url, "pk_test", "sk_test"), testConfig(), q)
+	for _, e := range queueEvents(100) {
+		s.Enqueue(e)
+	}
+	if err := q.Flush(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("flushed")
+	select {}
+}
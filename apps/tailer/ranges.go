@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// BotRanges are the address ranges crawler operators publish, read from
+// -bot-ranges-dir. Each file there, named after a crawler family
+// (gptbot.json), holds the ranges of that family: either the JSON Google,
+// Bing and OpenAI publish, with a "prefixes" list of ipv4Prefix and
+// ipv6Prefix entries, or a JSON array of CIDRs. The directory is checked
+// for changes as lookups are made, at most every enrichCheckInterval, and
+// read again when it has changed; if that fails the ranges in use are
+// kept.
+type BotRanges struct {
+	dir   string
+	check fileCheck
+
+	ranges atomic.Pointer[map[string][]netip.Prefix] // by family
+
+	mu    sync.Mutex // held while reading the directory
+	stamp string     // of the files last read
+}
+
+type publishedRanges struct {
+	Prefixes []struct {
+		IPv4 string `json:"ipv4Prefix"`
+		IPv6 string `json:"ipv6Prefix"`
+	} `json:"prefixes"`
+}
+
+// NewBotRanges reads the ranges in dir, or returns nil if dir is empty.
+func NewBotRanges(dir string) (*BotRanges, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	b := &BotRanges{dir: dir}
+	b.check.start(enrichCheckInterval)
+	stamp, err := b.dirStamp()
+	if err != nil {
+		return nil, err
+	}
+	if err := b.load(stamp); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Match reports whether ip is in the published ranges of family, and
+// whether family has any.
+func (b *BotRanges) Match(family, ip string) (matched, listed bool) {
+	if b.check.due() {
+		b.reload()
+	}
+	prefixes, listed := (*b.ranges.Load())[family]
+	addr, err := netip.ParseAddr(ip)
+	if !listed || err != nil {
+		return false, false
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+func (b *BotRanges) reload() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stamp, err := b.dirStamp()
+	if err == nil && stamp == b.stamp {
+		return
+	}
+	if err == nil {
+		err = b.load(stamp)
+	}
+	if err != nil {
+		// Not retried until the files change again.
+		b.stamp = stamp
+		slog.Error("Keeping the current published crawler ranges", "err", err)
+		return
+	}
+	slog.Info("Reloaded published crawler ranges", "dir", b.dir, "families", len(*b.ranges.Load()))
+}
+
+// load reads every file in the directory and replaces the ranges.
+func (b *BotRanges) load(stamp string) error {
+	files, err := filepath.Glob(filepath.Join(b.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("list -bot-ranges-dir: %w", err)
+	}
+	ranges := map[string][]netip.Prefix{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("read crawler ranges: %w", err)
+		}
+		prefixes, err := parseRanges(data)
+		if err != nil {
+			return fmt.Errorf("parse crawler ranges %s: %w", file, err)
+		}
+		family := strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".json"))
+		ranges[family] = prefixes
+	}
+	b.ranges.Store(&ranges)
+	b.stamp = stamp
+	return nil
+}
+
+// dirStamp describes the files in the directory, so that a change to any
+// of them changes it.
+func (b *BotRanges) dirStamp() (string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return "", fmt.Errorf("read -bot-ranges-dir: %w", err)
+	}
+	var stamp strings.Builder
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		// Files being replaced may vanish in between; the next check
+		// sees how they ended up.
+		if fi, err := e.Info(); err == nil {
+			fmt.Fprintf(&stamp, "%s %d %d\n", e.Name(), fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return stamp.String(), nil
+}
+
+// parseRanges parses one family's file.
+func parseRanges(data []byte) ([]netip.Prefix, error) {
+	var cidrs []string
+	if err := json.Unmarshal(data, &cidrs); err != nil {
+		var published publishedRanges
+		if json.Unmarshal(data, &published) != nil {
+			return nil, errors.New("neither a list of CIDRs nor an object with prefixes")
+		}
+		for _, p := range published.Prefixes {
+			cidrs = append(cidrs, p.IPv4+p.IPv6)
+		}
+	}
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q", cidr)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBotRanges(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("gptbot.json", `{"creationTime": "2024-01-01T00:00:00Z", "prefixes": [{"ipv4Prefix": "20.171.206.0/24"}, {"ipv6Prefix": "2001:db8:1::/48"}]}`)
+	write("googlebot.json", `["66.249.64.0/27"]`)
+	write("README", "not read")
+
+	b, err := NewBotRanges(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		family, ip      string
+		matched, listed bool
+	}{
+		{family: "gptbot", ip: "20.171.206.12", matched: true, listed: true},
+		{family: "gptbot", ip: "2001:db8:1::7", matched: true, listed: true},
+		{family: "gptbot", ip: "::ffff:20.171.206.12", matched: true, listed: true},
+		{family: "gptbot", ip: "203.0.113.7", listed: true},
+		{family: "googlebot", ip: "66.249.64.3", matched: true, listed: true},
+		{family: "googlebot", ip: "66.249.64.40", listed: true},
+		{family: "claudebot", ip: "20.171.206.12"},
+		{family: "gptbot", ip: ""},
+	}
+	for _, tt := range tests {
+		if matched, listed := b.Match(tt.family, tt.ip); matched != tt.matched || listed != tt.listed {
+			t.Errorf("Match(%s, %q) = %v, %v; want %v, %v", tt.family, tt.ip, matched, listed, tt.matched, tt.listed)
+		}
+	}
+
+	// Changes are picked up at the next check, unless a file is invalid.
+	b.check.every = 0
+	write("claudebot.json", `["160.79.104.0/23"]`)
+	if matched, _ := b.Match("claudebot", "160.79.104.10"); !matched {
+		t.Error("added family not matched after reload")
+	}
+	write("googlebot.json", `{"prefixes": [{"ipv4Prefix": "not a range"}]}`)
+	os.Chtimes(filepath.Join(dir, "googlebot.json"), time.Now(), time.Now().Add(time.Minute))
+	if matched, _ := b.Match("googlebot", "66.249.64.3"); !matched {
+		t.Error("ranges not kept after an invalid file")
+	}
+
+	if _, err := NewBotRanges(dir); err == nil {
+		t.Error("NewBotRanges accepted an invalid file")
+	}
+	if _, err := NewBotRanges(filepath.Join(dir, "missing")); err == nil {
+		t.Error("NewBotRanges accepted a missing directory")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// TokenBucket limits how often requests are made: it holds up to burst
+// tokens, refilled at rate per second, and each request takes one.
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a token is available or cancel is closed, and reports
+// whether a token was taken.
+func (b *TokenBucket) Wait(cancel <-chan struct{}) bool {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return true
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// throttledLog logs at most once per interval and reports how many
+// messages were suppressed in between, for conditions that can repeat on
+// every event.
+type throttledLog struct {
+	interval time.Duration
+	logger   *slog.Logger // nil for the default logger
+
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+func (l *throttledLog) Log(level slog.Level, msg string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.last) < l.interval {
+		l.suppressed++
+		return
+	}
+	if l.suppressed > 0 {
+		args = append(args, "suppressed", l.suppressed)
+	}
+	logger := l.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Log(context.Background(), level, msg, args...)
+	l.last = time.Now()
+	l.suppressed = 0
+}
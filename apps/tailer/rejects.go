@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// RejectsFile records lines that failed to parse, for -rejects-file. Each
+// reject is a comment line with the time, source and reason followed by
+// the raw line. When the file would grow past maxBytes it is renamed to
+// path.1, replacing the previous one, so at most twice maxBytes is used.
+type RejectsFile struct {
+	path     string
+	maxBytes int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRejects opens (creating if needed) the rejects file at path.
+func OpenRejects(path string, maxBytes int64) (*RejectsFile, error) {
+	rf := &RejectsFile{path: path, maxBytes: maxBytes}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RejectsFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open rejects file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat rejects file: %w", err)
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+// Write appends a rejected line.
+func (rf *RejectsFile) Write(source, line string, reason error) error {
+	entry := fmt.Sprintf("# %s %s: %v\n%s\n", time.Now().UTC().Format(time.RFC3339), source, reason, line)
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return fmt.Errorf("rejects file closed")
+	}
+	if rf.size > 0 && rf.size+int64(len(entry)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := rf.f.WriteString(entry)
+	rf.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write rejects file: %w", err)
+	}
+	return nil
+}
+
+// rotate moves the current file to path.1 and starts a new one. Callers
+// hold mu.
+func (rf *RejectsFile) rotate() error {
+	rf.f.Close()
+	rf.f = nil
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+		return fmt.Errorf("rotate rejects file: %w", err)
+	}
+	return rf.open()
+}
+
+func (rf *RejectsFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// credentialStore holds the credentials reload updates: the API client,
+// or a Failover across several.
+type credentialStore interface {
+	Credentials() (key, secret string)
+	SetCredentials(key, secret string)
+}
+
+// reload re-reads the configuration on SIGHUP and applies the settings
+// that can change while running: filters, sampling, the crawler table,
+// credentials, the rate limit and the log level. Tailing, positions and
+// queued events are untouched. It returns the configuration now in
+// effect; on any error that is cur.
+func reload(cur Config, api credentialStore, pipeline *Pipeline, sender *Fanout) Config {
+	slog.Info("Received SIGHUP, reloading configuration")
+	next, _, err := parseConfig(os.Args[1:])
+	if err == nil {
+		err = pipeline.Reload(next)
+	}
+	var key, secret string
+	if err == nil {
+		key, secret, err = loadCredentials(next)
+	}
+	if err != nil {
+		slog.Error("Reload failed, configuration stays in effect", "config", configHash(cur), "err", err)
+		return cur
+	}
+
+	// Start from what is running and take over only the live settings.
+	applied := cur
+	applied.ConfigFile = next.ConfigFile
+	applied.IncludePaths = next.IncludePaths
+	applied.ExcludePaths = next.ExcludePaths
+	applied.ExcludeExts = next.ExcludeExts
+	applied.Statuses = next.Statuses
+	applied.OnlyCrawlers = next.OnlyCrawlers
+	applied.Families = next.Families
+	applied.Hosts, applied.ExcludeHosts, applied.EmptyHost = next.Hosts, next.ExcludeHosts, next.EmptyHost
+	applied.Sample = next.Sample
+	applied.CrawlersFile = next.CrawlersFile
+	applied.APIKey, applied.Secret = next.APIKey, next.Secret
+	applied.KeyFile, applied.SecretFile = next.KeyFile, next.SecretFile
+	applied.MaxRPS, applied.Burst = next.MaxRPS, next.Burst
+	applied.LogLevel = next.LogLevel
+
+	if oldKey, oldSecret := api.Credentials(); key != oldKey || secret != oldSecret {
+		api.SetCredentials(key, secret)
+		slog.Info("Reloaded credentials", "key", key)
+	}
+	if applied.MaxRPS != cur.MaxRPS || applied.Burst != cur.Burst {
+		sender.SetRateLimit(applied.MaxRPS, applied.Burst)
+	}
+	if applied.LogLevel != cur.LogLevel {
+		level, _ := parseLogLevel(applied.LogLevel)
+		logLevel.Set(level)
+	}
+
+	if pending := changedFields(applied, next); len(pending) > 0 {
+		slog.Warn("Some changes need a restart and were not applied", "fields", strings.Join(pending, ", "))
+	}
+	slog.Info("Reloaded configuration", "config", configHash(applied), "was", configHash(cur))
+	return applied
+}
+
+// changedFields lists the Config fields that differ between a and b.
+func changedFields(a, b Config) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var changed []string
+	for i := range va.NumField() {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, va.Type().Field(i).Name)
+		}
+	}
+	return changed
+}
+
+// configHash identifies a configuration in logs, so an operator can tell
+// which version is running. Secrets are left out; the crawler rules file
+// is included since it can change without the configuration changing.
+func configHash(cfg Config) string {
+	cfg.Secret = ""
+	cfg.Properties = slices.Clone(cfg.Properties)
+	for i := range cfg.Properties {
+		cfg.Properties[i].Secret = ""
+	}
+	cfg.Mirrors = slices.Clone(cfg.Mirrors)
+	for i := range cfg.Mirrors {
+		cfg.Mirrors[i].Secret = ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%#v", cfg)
+	if cfg.CrawlersFile != "" {
+		if data, err := os.ReadFile(cfg.CrawlersFile); err == nil {
+			h.Write(data)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Replay reads every file matched by patterns once, from start to EOF,
+// for -once. Files matched by one glob are read oldest first so rotated
+// logs (access.log.3.gz, access.log.2.gz, ...) replay in order. With
+// -checkpoint-file it saves how far it got, and with -resume it starts from
+// there.
+type Replay struct {
+	files         []string
+	pipeline      *Pipeline
+	since         time.Time
+	progressEvery int
+	checkpoints   *Checkpoints // nil without -checkpoint-file
+
+	total   int64        // bytes of the files on disk
+	read    atomic.Int64 // of those read so far, gzipped or not
+	skipped atomic.Int64 // of those skipped as read before or too old
+	started time.Time
+	sent    int64 // metrics.EventsSent when started
+}
+
+// NewReplay expands the -file patterns of cfg and, with -resume, loads the
+// checkpoint to resume from. A pattern matching nothing is an error.
+func NewReplay(cfg Config, pipeline *Pipeline) (*Replay, error) {
+	r := &Replay{pipeline: pipeline, since: cfg.Since, progressEvery: cfg.ProgressEvery}
+	for _, pattern := range cfg.LogFiles {
+		files, err := expandOldestFirst(pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.files = append(r.files, files...)
+	}
+	for _, file := range r.files {
+		if fi, err := os.Stat(file); err == nil {
+			r.total += fi.Size()
+		}
+	}
+	if cfg.CheckpointFile != "" {
+		cp, err := LoadCheckpoints(cfg.CheckpointFile, cfg.Resume)
+		if err != nil {
+			return nil, err
+		}
+		r.checkpoints = cp
+	}
+	return r, nil
+}
+
+// Run replays the files. Files last written before -since are skipped
+// without being read, since none of their lines can be newer, and so are
+// those the checkpoint resumed from has them done. Replay stops early,
+// with ctx's error, when ctx is done.
+func (r *Replay) Run(ctx context.Context) (lines, parseErrors int, err error) {
+	r.started, r.sent = time.Now(), metrics.EventsSent.Load()
+	for _, file := range r.files {
+		fi, statErr := os.Stat(file)
+		if statErr == nil && !r.since.IsZero() && fi.ModTime().Before(r.since) {
+			slog.Info("Skipped file last written before -since", "file", file, "modified", fi.ModTime().UTC().Format(time.RFC3339))
+			r.skip(fi.Size())
+			continue
+		}
+		var offset int64
+		if r.checkpoints != nil {
+			var done bool
+			if done, offset = r.checkpoints.Start(file, fi); done {
+				slog.Info("Skipped file replayed before", "file", file)
+				if statErr == nil {
+					r.skip(fi.Size())
+				}
+				continue
+			}
+		}
+		n, perrs, err := r.replayFile(ctx, file, offset)
+		lines += n
+		parseErrors += perrs
+		if err != nil {
+			return lines, parseErrors, fmt.Errorf("read %s: %w", file, err)
+		}
+		if r.checkpoints != nil {
+			r.checkpoints.Done(file)
+		}
+		slog.Info("Replayed file", "file", file, "lines", n, "parse_errors", perrs)
+	}
+	return lines, parseErrors, nil
+}
+
+func (r *Replay) skip(size int64) {
+	r.read.Add(size)
+	r.skipped.Add(size)
+}
+
+func (r *Replay) replayFile(ctx context.Context, file string, offset int64) (lines, parseErrors int, err error) {
+	f, err := openLogCounted(file, &r.read)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, f, offset); err != nil {
+			return 0, 0, fmt.Errorf("skip to offset %d: %w", offset, err)
+		}
+		slog.Info("Resuming replay", "file", file, "offset", offset)
+	}
+	var consumed func(int)
+	if r.checkpoints != nil {
+		consumed = r.checkpoints.Advance
+	}
+	return readLines(ctx, f, file, r.pipeline, r.progressEvery, consumed)
+}
+
+// LogProgress logs how much of the files has been read, how fast events
+// are being sent and how long the rest should take at that pace.
+func (r *Replay) LogProgress() {
+	read, elapsed := r.read.Load(), time.Since(r.started)
+	if r.started.IsZero() || elapsed <= 0 {
+		return
+	}
+	percent := 100.0
+	if r.total > 0 {
+		percent = min(100, 100*float64(read)/float64(r.total))
+	}
+	var eta time.Duration
+	if done := read - r.skipped.Load(); done > 0 && read < r.total {
+		eta = time.Duration(float64(elapsed) * float64(r.total-read) / float64(done))
+	}
+	rate := float64(metrics.EventsSent.Load()-r.sent) / elapsed.Seconds()
+	slog.Info("Replay progress", "percent", math.Round(percent*10)/10, "events_per_sec", math.Round(rate),
+		"eta", eta.Round(time.Second), "lines", metrics.LinesRead.Load())
+}
+
+// expandOldestFirst expands a glob, sorting matches by modification time.
+// A pattern without glob characters must name an existing file.
+func expandOldestFirst(pattern string) ([]string, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern %q: %w", pattern, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s", pattern)
+	}
+	mtimes := make(map[string]int64, len(files))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			mtimes[f] = fi.ModTime().UnixNano()
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return mtimes[files[i]] < mtimes[files[j]] })
+	return files, nil
+}
+
+// gzipReader closes both the gzip stream and the file under it.
+type gzipReader struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipReader) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// openLog opens a log file, decompressing it if it is gzipped. Detection
+// is by content, not name, so it works whatever the rotation scheme.
+func openLog(file string) (io.ReadCloser, error) {
+	return openLogCounted(file, nil)
+}
+
+// openLogCounted is openLog, adding the bytes read from the file, before
+// any decompression, to read unless it is nil.
+func openLogCounted(file string, read *atomic.Int64) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	var src io.Reader = f
+	if read != nil {
+		src = countingReader{f, read}
+	}
+	br := bufio.NewReader(src)
+	magic, _ := br.Peek(2)
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, f}, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open gzip stream: %w", err)
+	}
+	return gzipReader{zr, f}, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// timeFlag is -since or -until: a time in RFC 3339, a date, taken as
+// midnight UTC, or a negative duration, taken as that long before now.
+type timeFlag struct{ t *time.Time }
+
+func (f timeFlag) String() string {
+	if f.t == nil || f.t.IsZero() {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f timeFlag) Set(v string) error {
+	t, err := parseTimeBound(v, time.Now())
+	if err != nil {
+		return err
+	}
+	*f.t = t
+	return nil
+}
+
+// parseTimeBound parses a timeFlag value, relative to now.
+func parseTimeBound(v string, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(v, "-") {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse relative time %q: %w", v, err)
+		}
+		return now.Add(d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("parse time %q: want RFC 3339, a date such as 2024-06-01, or a duration such as -72h", v)
+}
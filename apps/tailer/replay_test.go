@@ -0,0 +1,157 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenLog(t *testing.T) {
+	dir := t.TempDir()
+	const content = "line one\nline two\n"
+
+	plain := filepath.Join(dir, "access.log")
+	if err := os.WriteFile(plain, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Deliberately no .gz suffix: detection is by content.
+	zipped := filepath.Join(dir, "access.log.1")
+	f, err := os.Create(zipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte(content))
+	zw.Close()
+	f.Close()
+
+	for _, file := range []string{plain, zipped} {
+		r, err := openLog(file)
+		if err != nil {
+			t.Fatalf("openLog(%s): %v", file, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		if string(got) != content {
+			t.Errorf("%s: got %q, want %q", file, got, content)
+		}
+	}
+}
+
+func TestExpandOldestFirst(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	names := []string{"access.log", "access.log.1", "access.log.2.gz"}
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := expandOldestFirst(filepath.Join(dir, "access.log*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		got[i] = filepath.Base(got[i])
+	}
+	want := []string{"access.log.2.gz", "access.log.1", "access.log"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := expandOldestFirst(filepath.Join(dir, "missing*")); err == nil {
+		t.Error("no error for a pattern matching nothing")
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2024, 6, 20, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2024-06-01T08:30:00Z", time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC)},
+		{"2024-06-01T10:30:00+02:00", time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC)},
+		{"2024-06-16", time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC)},
+		{"-72h", time.Date(2024, 6, 17, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeBound(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseTimeBound(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "72h", "June 1", "-3d"} {
+		if _, err := parseTimeBound(in, now); err == nil {
+			t.Errorf("parseTimeBound(%q): no error", in)
+		}
+	}
+}
+
+func TestReplayTimeRange(t *testing.T) {
+	cfg := testConfig()
+	cfg.Format = "nginx"
+	cfg.Since = time.Unix(1_700_000_000, 0)
+	cfg.Until = cfg.Since.Add(time.Hour)
+	api := &fakeAPI{}
+	sender := NewSender(context.Background(), api, cfg, nil)
+	p, err := NewPipeline(cfg, NewFanout(sender, nil, 0), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log strings.Builder
+	// Before, inside, just past -until, and far enough past to stop.
+	for _, offset := range []time.Duration{-time.Minute, 0, 30 * time.Minute, time.Hour + time.Minute, 2 * time.Hour, 30 * time.Minute} {
+		fmt.Fprintf(&log, "%d.000 \"GET /a HTTP/1.1\" 200 512 \"GPTBot/1.0\" 203.0.113.7 en 0.010 example.com gptbot\n", cfg.Since.Add(offset).Unix())
+	}
+	skipped := metrics.EventsFiltered["time_range"].Load()
+	lines, parseErrors, err := readLines(context.Background(), strings.NewReader(log.String()), "test", p, 0, nil)
+	if err != nil || lines != 5 || parseErrors != 0 {
+		t.Errorf("readLines = %d, %d, %v, want 5 lines read", lines, parseErrors, err)
+	}
+	if !sender.Close(5 * time.Second) {
+		t.Fatal("sender did not drain")
+	}
+	if got := metrics.EventsFiltered["time_range"].Load() - skipped; got != 3 {
+		t.Errorf("skipped %d events, want 3", got)
+	}
+	api.mu.Lock()
+	if len(api.received) != 2 {
+		t.Errorf("sent %d events, want 2", len(api.received))
+	}
+	api.mu.Unlock()
+
+	// A file last written before -since isn't read at all.
+	old := filepath.Join(t.TempDir(), "access.log.1")
+	if err := os.WriteFile(old, []byte(log.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := cfg.Since.Add(-time.Hour)
+	if err := os.Chtimes(old, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	cfg.LogFiles = []string{old}
+	r, err := NewReplay(cfg, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines, _, err := r.Run(context.Background()); err != nil || lines != 0 {
+		t.Errorf("Run = %d lines, %v, want the file skipped", lines, err)
+	}
+}
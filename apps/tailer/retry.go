@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// maxBackoff caps the delay between two attempts regardless of -retry-base.
+const maxBackoff = 30 * time.Second
+
+// retryable reports whether a failed send is worth another attempt:
+// network errors, API refusals the client deems temporary (by their error
+// code, or else 5xx and 429) and temporary Kafka errors are; other
+// refusals and Kafka errors, and local failures such as marshalling
+// errors, are permanent.
+func retryable(err error) bool {
+	var se *client.StatusError
+	if errors.As(err, &se) {
+		return se.Temporary()
+	}
+	// Kafka errors are net.Errors too, so they are told apart first.
+	var ke kafka.Error
+	if errors.As(err, &ke) {
+		return ke.Temporary()
+	}
+	var ue *url.Error
+	var ne net.Error
+	return errors.As(err, &ue) || errors.As(err, &ne)
+}
+
+// backoff returns the delay before retry number attempt (starting at 1):
+// base doubled per attempt, capped at maxBackoff, with the upper half
+// randomised so that many tailers don't retry in lockstep.
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// robotsMaxBytes is how much of a robots.txt is read, the least RFC
+	// 9309 asks crawlers to parse.
+	robotsMaxBytes = 500 << 10
+
+	robotsTimeout     = 5 * time.Second
+	robotsRetryTTL    = 5 * time.Minute // after a failed fetch
+	robotsConcurrency = 4
+	robotsCacheMax    = 1000
+)
+
+// Robots tells whether requests were for paths robots.txt disallows for
+// the crawler that made them: the -robots-file for every host, or with
+// -robots-fetch each host's own, fetched from it over HTTPS and cached for
+// -robots-ttl. A robots.txt that can't be had leaves the question open.
+//
+// The Host of a request is whatever its client sent, so only the hosts of
+// -hosts and of the properties are fetched, by name, and only from public
+// addresses: a log line must not make the tailer request anything on its
+// network.
+type Robots struct {
+	file *robotsRules // nil with -robots-fetch
+
+	hc           *http.Client
+	scheme       string
+	ttl          time.Duration
+	sem          chan struct{}
+	hosts        []string // patterns of the hosts fetched
+	excludeHosts []string
+
+	mu    sync.Mutex
+	cache map[string]*robotsEntry // by host
+}
+
+type robotsEntry struct {
+	done    chan struct{}
+	rules   *robotsRules // nil if it couldn't be fetched
+	expires time.Time
+}
+
+// NewRobots returns the robots.txt source of cfg, or nil if there is none.
+func NewRobots(cfg Config) (*Robots, error) {
+	switch {
+	case cfg.RobotsFile != "":
+		data, err := os.ReadFile(cfg.RobotsFile)
+		if err != nil {
+			return nil, fmt.Errorf("read -robots-file: %w", err)
+		}
+		return &Robots{file: parseRobots(data)}, nil
+	case cfg.RobotsFetch:
+		hosts, err := parseHostPatterns("-hosts", cfg.Hosts)
+		if err != nil {
+			return nil, err
+		}
+		excludeHosts, err := parseHostPatterns("-exclude-hosts", cfg.ExcludeHosts)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range cfg.Properties {
+			hosts = append(hosts, strings.ToLower(p.Host))
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = (&net.Dialer{Timeout: robotsTimeout, Control: dialPublic}).DialContext
+		return &Robots{
+			hc:           &http.Client{Timeout: robotsTimeout, Transport: transport, CheckRedirect: sameHostRedirect},
+			scheme:       "https",
+			ttl:          cfg.RobotsTTL,
+			sem:          make(chan struct{}, robotsConcurrency),
+			hosts:        hosts,
+			excludeHosts: excludeHosts,
+			cache:        map[string]*robotsEntry{},
+		}, nil
+	}
+	return nil, nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, as
+// private as those netip.Addr.IsPrivate knows.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// dialPublic is a net.Dialer Control refusing connections to any address
+// but a public unicast one, whatever the name resolved to.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if addr := ap.Addr().Unmap(); !addr.IsGlobalUnicast() || addr.IsPrivate() || sharedAddressSpace.Contains(addr) {
+		return fmt.Errorf("refusing to fetch robots.txt from non-public address %s", addr)
+	}
+	return nil
+}
+
+// sameHostRedirect is an http.Client CheckRedirect following at most 10
+// redirects, all on the host first asked.
+func sameHostRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		return fmt.Errorf("refusing to follow a redirect from %s to %s", via[0].URL.Host, req.URL.Host)
+	}
+	return nil
+}
+
+// Check reports whether robots.txt disallows target, a path with any
+// query, on host for the crawler of family with user agent ua, or nil if
+// there is no robots.txt to tell.
+func (r *Robots) Check(host, family, ua, target string) *bool {
+	rules := r.file
+	if rules == nil {
+		if rules = r.rulesFor(strings.ToLower(stripPort(host))); rules == nil {
+			return nil
+		}
+	}
+	disallowed := rules.Disallowed(family, ua, target)
+	return &disallowed
+}
+
+// rulesFor returns the robots.txt of host, a lower-case name without a
+// port, fetching it if it isn't cached. Concurrent checks of the same host
+// share one fetch.
+func (r *Robots) rulesFor(host string) *robotsRules {
+	if !isFetchableHost(host) || !r.fetches(host) {
+		return nil
+	}
+	r.mu.Lock()
+	e, ok := r.cache[host]
+	if ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+		r.mu.Unlock()
+		<-e.done
+		return e.rules
+	}
+	if len(r.cache) >= robotsCacheMax {
+		r.evictLocked()
+		if len(r.cache) >= robotsCacheMax {
+			r.mu.Unlock()
+			return nil
+		}
+	}
+	e = &robotsEntry{done: make(chan struct{})}
+	r.cache[host] = e
+	r.mu.Unlock()
+
+	r.sem <- struct{}{}
+	rules, err := r.fetch(host)
+	<-r.sem
+
+	r.mu.Lock()
+	e.rules, e.expires = rules, time.Now().Add(r.ttl)
+	if err != nil {
+		e.expires = time.Now().Add(robotsRetryTTL)
+	}
+	r.mu.Unlock()
+	close(e.done)
+	return e.rules
+}
+
+// evictLocked drops expired entries. Callers hold mu.
+func (r *Robots) evictLocked() {
+	now := time.Now()
+	for host, e := range r.cache {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(r.cache, host)
+		}
+	}
+}
+
+// fetch gets the robots.txt of host. As RFC 9309 has it, one that is
+// missing (a 4xx other than 429) allows everything; a server error or a
+// network failure tells nothing.
+func (r *Robots) fetch(host string) (*robotsRules, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), robotsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", r.scheme+"://"+host+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "originary-trace-tailer/"+version)
+	resp, err := r.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		data, err := io.ReadAll(io.LimitReader(resp.Body, robotsMaxBytes))
+		if err != nil {
+			return nil, err
+		}
+		return parseRobots(data), nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return &robotsRules{}, nil
+	}
+	return nil, fmt.Errorf("fetch robots.txt of %s: %s", host, resp.Status)
+}
+
+// fetches reports whether host is one of those whose robots.txt is
+// fetched: matching -hosts or a property, and not -exclude-hosts.
+func (r *Robots) fetches(host string) bool {
+	return !matchHostPattern(r.excludeHosts, host) && matchHostPattern(r.hosts, host)
+}
+
+// isFetchableHost reports whether host, from a request's Host header, is
+// a host name and nothing more: no port, and not an IP address.
+func isFetchableHost(host string) bool {
+	if host == "" || strings.ContainsAny(host, "/\\@?#:[] ") {
+		return false
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return false
+	}
+	u, err := url.Parse("https://" + host)
+	return err == nil && u.Host == host && u.Hostname() != ""
+}
+
+// robotsRules is a parsed robots.txt.
+type robotsRules struct {
+	groups []robotsGroup
+}
+
+type robotsGroup struct {
+	agents []string // product tokens, lower case
+	rules  []robotsRule
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// parseRobots parses a robots.txt, ignoring lines it doesn't understand.
+func parseRobots(data []byte) *robotsRules {
+	r := &robotsRules{}
+	var g *robotsGroup
+	inRules := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 4096), robotsMaxBytes)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "user-agent":
+			// A user-agent line after rules starts a new group; those in
+			// a row share one.
+			if g == nil || inRules {
+				r.groups = append(r.groups, robotsGroup{})
+				g, inRules = &r.groups[len(r.groups)-1], false
+			}
+			token, _, _ := strings.Cut(value, "/")
+			if token = strings.ToLower(strings.TrimSpace(token)); token != "" {
+				g.agents = append(g.agents, token)
+			}
+		case "allow", "disallow":
+			if g == nil {
+				continue
+			}
+			inRules = true
+			// An empty disallow allows everything, which is the default.
+			if value != "" {
+				g.rules = append(g.rules, robotsRule{allow: strings.EqualFold(strings.TrimSpace(key), "allow"), pattern: value})
+			}
+		}
+	}
+	return r
+}
+
+// Disallowed reports whether the rules for the crawler disallow target.
+// The crawler's groups are those naming its family, or a product token in
+// its user agent, the longest such name if several do, or else the "*"
+// ones. Of their rules the one with the longest pattern matching target
+// wins, allow if an allow and a disallow are as long.
+func (r *robotsRules) Disallowed(family, ua, target string) bool {
+	if target == "/robots.txt" {
+		return false
+	}
+	family, ua = strings.ToLower(family), strings.ToLower(ua)
+	best := 0
+	for _, g := range r.groups {
+		for _, agent := range g.agents {
+			if agent != "*" && len(agent) > best && (agent == family || strings.Contains(ua, agent)) {
+				best = len(agent)
+			}
+		}
+	}
+	applies := func(g robotsGroup) bool {
+		for _, agent := range g.agents {
+			if best == 0 && agent == "*" || best > 0 && len(agent) == best && (agent == family || strings.Contains(ua, agent)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	matched, disallowed := -1, false
+	for _, g := range r.groups {
+		if !applies(g) {
+			continue
+		}
+		for _, rule := range g.rules {
+			if n := len(rule.pattern); n >= matched && robotsMatch(rule.pattern, target) {
+				if n > matched || rule.allow {
+					disallowed = !rule.allow
+				}
+				matched = n
+			}
+		}
+	}
+	return disallowed
+}
+
+// robotsMatch reports whether the robots.txt path pattern matches target:
+// as a prefix, with "*" matching any run of characters and a final "$"
+// the end of target.
+func robotsMatch(pattern, target string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(target, parts[0]) {
+		return false
+	}
+	if len(parts) == 1 {
+		return !anchored || len(target) == len(parts[0])
+	}
+	rest := target[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+		j := strings.Index(rest, part)
+		if j < 0 {
+			return false
+		}
+		rest = rest[j+len(part):]
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRobotsRules(t *testing.T) {
+	const robots = `# example
+User-agent: *
+Disallow: /private/
+Allow: /private/press/
+Disallow: /*.pdf$
+Disallow: /search?*q=
+
+User-agent: GPTBot
+User-agent: CCBot/2.0
+Disallow: /
+Allow: /public
+Allow: /docs/
+Disallow: /docs/
+
+User-agent: Googlebot-News
+Disallow: /
+
+User-agent: Googlebot
+Disallow:
+Disallow: /tmp  # comment
+Sitemap: https://example.com/sitemap.xml
+`
+	rules := parseRobots([]byte(robots))
+	tests := []struct {
+		family, ua, target string
+		want               bool
+	}{
+		{family: "claudebot", ua: "ClaudeBot/1.0", target: "/", want: false},
+		{family: "claudebot", ua: "ClaudeBot/1.0", target: "/private/x", want: true},
+		{family: "claudebot", ua: "ClaudeBot/1.0", target: "/private/press/2024", want: false},
+		{family: "claudebot", ua: "ClaudeBot/1.0", target: "/files/a.pdf", want: true},
+		{family: "claudebot", ua: "ClaudeBot/1.0", target: "/files/a.pdf?v=2", want: false},
+		{family: "claudebot", ua: "ClaudeBot/1.0", target: "/search?lang=en&q=bots", want: true},
+		{family: "claudebot", ua: "ClaudeBot/1.0", target: "/search", want: false},
+		// Its own group, whatever the "*" one says.
+		{family: "gptbot", ua: "Mozilla/5.0 (compatible; GPTBot/1.2)", target: "/anything", want: true},
+		{family: "gptbot", ua: "GPTBot/1.2", target: "/public/x", want: false},
+		{family: "gptbot", ua: "GPTBot/1.2", target: "/private/press/", want: true},
+		// An allow and a disallow as long: allow.
+		{family: "gptbot", ua: "GPTBot/1.2", target: "/docs/a", want: false},
+		{family: "ccbot", ua: "CCBot/2.0 (https://commoncrawl.org/faq/)", target: "/x", want: true},
+		// The most specific token in the user agent.
+		{family: "googlebot", ua: "Googlebot-News", target: "/a", want: true},
+		{family: "googlebot", ua: "Mozilla/5.0 (compatible; Googlebot/2.1)", target: "/a", want: false},
+		{family: "googlebot", ua: "Mozilla/5.0 (compatible; Googlebot/2.1)", target: "/tmp/a", want: true},
+		{family: "gptbot", ua: "GPTBot/1.2", target: "/robots.txt", want: false},
+	}
+	for _, tt := range tests {
+		if got := rules.Disallowed(tt.family, tt.ua, tt.target); got != tt.want {
+			t.Errorf("Disallowed(%s, %q, %s) = %v, want %v", tt.family, tt.ua, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern, target string
+		want            bool
+	}{
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish.asp", false},
+		{"/fish*", "/fishheads/yummy.html", true},
+		{"/fish/", "/fish", false},
+		{"/*.php", "/folder/filename.php?parameters", true},
+		{"/*.php$", "/filename.php", true},
+		{"/*.php$", "/filename.php?parameters", false},
+		{"/fish*.php", "/fishheads/catfish.php?parameters", true},
+		{"/fish*.php", "/Fish.PHP", false},
+		{"/a$", "/a", true},
+		{"/a$", "/ab", false},
+		{"/*/b*/c$", "/x/bb/y/c", true},
+	}
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.target); got != tt.want {
+			t.Errorf("robotsMatch(%s, %s) = %v, want %v", tt.pattern, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestRobotsFetch(t *testing.T) {
+	var fetches atomic.Int32
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		switch {
+		case r.URL.Path == "/moved":
+			http.Redirect(w, r, "http://169.254.169.254/robots.txt", http.StatusFound)
+			return
+		case r.URL.Path != "/robots.txt":
+			t.Errorf("fetched %s", r.URL.Path)
+		case r.Host == "redirect.example.com":
+			http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+		io.WriteString(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer srv.Close()
+
+	r, err := NewRobots(Config{RobotsFetch: true, RobotsTTL: time.Hour, Hosts: "www.example.com,*.example.com", ExcludeHosts: "internal.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.scheme = "http"
+	// Every host is the test server, which dialPublic would refuse.
+	r.hc.Transport = &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}}
+	const host = "www.example.com"
+	check := func(target string) *bool { return r.Check(host, "gptbot", "GPTBot/1.2", target) }
+
+	if got := check("/private/a"); got == nil || !*got {
+		t.Errorf("Check(/private/a) = %v, want true", got)
+	}
+	if got := check("/a"); got == nil || *got {
+		t.Errorf("Check(/a) = %v, want false", got)
+	}
+	if got := r.Check("WWW.example.com:443", "gptbot", "GPTBot/1.2", "/private/a"); got == nil || !*got {
+		t.Errorf("Check with a port = %v, want true", got)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetched %d times, want once", n)
+	}
+
+	// A missing robots.txt allows everything; one that can't be had tells
+	// nothing.
+	for code, want := range map[int]*bool{http.StatusNotFound: new(bool), http.StatusServiceUnavailable: nil} {
+		r.cache = map[string]*robotsEntry{}
+		status.Store(int32(code))
+		got := r.Check(host, "gptbot", "GPTBot/1.2", "/private/a")
+		if (got == nil) != (want == nil) || got != nil && *got != *want {
+			t.Errorf("with %d: Check = %v, want %v", code, got, want)
+		}
+	}
+
+	// A redirect is followed on the same host only.
+	status.Store(http.StatusOK)
+	fetches.Store(0)
+	if got := r.Check("redirect.example.com", "gptbot", "GPTBot/1.2", "/private/a"); got != nil {
+		t.Errorf("Check through a redirect off the host = %v, want nil", *got)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("%d requests for a redirect off the host, want 2", n)
+	}
+
+	fetches.Store(0)
+	for _, host := range []string{"", "evil.example/x", "user@evil.example", "a b", "evil.example",
+		"internal.example.com", "169.254.169.254", "127.0.0.1:8443", "10.0.0.1", "[::1]:443", "::1"} {
+		if got := r.Check(host, "gptbot", "GPTBot/1.2", "/"); got != nil {
+			t.Errorf("Check on host %q = %v, want nil", host, *got)
+		}
+	}
+	if n := fetches.Load(); n != 0 {
+		t.Errorf("fetched %d times for hosts that aren't to be fetched", n)
+	}
+}
+
+func TestDialPublic(t *testing.T) {
+	for address, want := range map[string]bool{
+		"93.184.216.34:443":        true,
+		"[2606:2800:220:1::]:443":  true,
+		"127.0.0.1:443":            false,
+		"[::1]:443":                false,
+		"169.254.169.254:80":       false,
+		"10.1.2.3:443":             false,
+		"172.16.0.1:443":           false,
+		"192.168.1.1:443":          false,
+		"100.64.0.1:443":           false,
+		"0.0.0.0:443":              false,
+		"[fd00::1]:443":            false,
+		"[fe80::1]:443":            false,
+		"[::ffff:127.0.0.1]:443":   false,
+		"[::ffff:93.184.216.34]:1": true,
+	} {
+		if got := dialPublic("tcp", address, nil) == nil; got != want {
+			t.Errorf("dialPublic(%s) allowed %v, want %v", address, got, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+const (
+	// rollupMaxKeys bounds the counts of one window. Past it, requests
+	// for new path prefixes are counted under the prefix "*".
+	rollupMaxKeys = 50000
+
+	// rollupPending is how many closed windows can wait for delivery;
+	// past it, windows are dropped as they close.
+	rollupPending = 60
+
+	rollupTick = time.Second
+)
+
+// Rollups counts events, with -mode aggregate, by host, crawler family,
+// status class and the first -agg-path-depth segments of the path, in
+// windows of -agg-window aligned to the clock. A window closes once events
+// -agg-grace past its end have been read, or, when the logs go quiet, once
+// the clock is; events for a window already closed are dropped as late.
+// Closed windows are sent as one rollup each, and Close sends those still
+// open.
+type Rollups struct {
+	window, grace int64 // in ms
+	depth         int
+	send          func(context.Context, *client.Rollup) error
+	meta          *agentMeta
+	maxRetries    int
+	retryBase     time.Duration
+	now           func() time.Time
+
+	mu        sync.Mutex
+	open      map[int64]map[rollupKey]*rollupCount // by window start
+	closed    int64                                // end of the latest window closed
+	watermark int64                                // latest event time read
+	lastAdd   time.Time
+
+	out       chan *client.Rollup
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stop      chan struct{}
+	ticking   sync.WaitGroup
+	delivered chan struct{}
+	lateLog   throttledLog
+	dropLog   throttledLog
+}
+
+type rollupKey struct {
+	host, family, class, prefix string
+}
+
+type rollupCount struct {
+	requests, bytes int64
+}
+
+// NewRollups returns the aggregator for cfg, handing each rollup to send.
+// Rollups are stamped with meta, unless it is nil.
+func NewRollups(cfg Config, send func(context.Context, *client.Rollup) error, meta *agentMeta) *Rollups {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Rollups{
+		window:     cfg.AggWindow.Milliseconds(),
+		grace:      cfg.AggGrace.Milliseconds(),
+		depth:      cfg.AggPathDepth,
+		send:       send,
+		meta:       meta,
+		maxRetries: cfg.MaxRetries,
+		retryBase:  cfg.RetryBase,
+		now:        time.Now,
+		open:       map[int64]map[rollupKey]*rollupCount{},
+		out:        make(chan *client.Rollup, rollupPending),
+		ctx:        ctx,
+		cancel:     cancel,
+		stop:       make(chan struct{}),
+		delivered:  make(chan struct{}),
+		lateLog:    throttledLog{interval: time.Minute},
+		dropLog:    throttledLog{interval: time.Minute},
+	}
+}
+
+// Start closes windows as they are due and delivers them, until Close.
+func (r *Rollups) Start() {
+	r.ticking.Add(1)
+	go func() {
+		defer r.ticking.Done()
+		ticker := time.NewTicker(rollupTick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.mu.Lock()
+				r.closeLocked(false)
+				r.mu.Unlock()
+			}
+		}
+	}()
+	go func() {
+		defer close(r.delivered)
+		for rollup := range r.out {
+			r.deliver(rollup)
+		}
+	}()
+}
+
+// Close closes every window still open and waits up to wait for the
+// rollups to be delivered.
+func (r *Rollups) Close(wait time.Duration) {
+	close(r.stop)
+	r.ticking.Wait()
+	r.mu.Lock()
+	r.closeLocked(true)
+	r.mu.Unlock()
+	close(r.out)
+	timer := time.AfterFunc(wait, r.cancel)
+	defer timer.Stop()
+	<-r.delivered
+	r.cancel()
+}
+
+// Add counts event in the window of its timestamp.
+func (r *Rollups) Add(event *CrawlEvent) {
+	ts := event.Timestamp
+	start := ts - ts%r.window
+	key := rollupKey{host: event.Host, family: event.CrawlerFamily, class: statusClass(event.Status), prefix: pathPrefix(event.Path, r.depth)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if start+r.window <= r.closed {
+		metrics.RollupLate.Inc()
+		r.lateLog.Log(slog.LevelWarn, "Dropped an event for a window already sent; raise -agg-grace if logs lag",
+			"window_start", time.UnixMilli(start).UTC().Format(time.RFC3339), "grace", time.Duration(r.grace)*time.Millisecond)
+		return
+	}
+	metrics.EventsRolledUp.Inc()
+	r.watermark = max(r.watermark, ts)
+	r.lastAdd = r.now()
+	counts := r.open[start]
+	if counts == nil {
+		counts = map[rollupKey]*rollupCount{}
+		r.open[start] = counts
+	}
+	c := counts[key]
+	if c == nil {
+		if len(counts) >= rollupMaxKeys {
+			key.prefix = "*"
+			c = counts[key]
+		}
+		if c == nil {
+			c = &rollupCount{}
+			counts[key] = c
+		}
+	}
+	c.requests++
+	c.bytes += event.Bytes
+	// Windows are due when one starts, which the ticker would notice too
+	// late when replaying.
+	if len(r.open) > 1 {
+		r.closeLocked(false)
+	}
+}
+
+// closeLocked closes the windows that are due, or all of them, oldest
+// first, and queues them for delivery. Callers hold mu.
+func (r *Rollups) closeLocked(all bool) {
+	now := r.now()
+	idle := now.Sub(r.lastAdd).Milliseconds() >= r.grace
+	var due []int64
+	for start := range r.open {
+		end := start + r.window
+		if all || end+r.grace <= r.watermark || idle && end+r.grace <= now.UnixMilli() {
+			due = append(due, start)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i] < due[j] })
+	for _, start := range due {
+		rollup := r.rollup(start, r.open[start])
+		delete(r.open, start)
+		r.closed = max(r.closed, start+r.window)
+		select {
+		case r.out <- rollup:
+		default:
+			metrics.RollupsDropped.Inc()
+			r.dropLog.Log(slog.LevelError, "Dropped a rollup, too many are waiting to be sent", "window_start", time.UnixMilli(start).UTC().Format(time.RFC3339))
+		}
+	}
+}
+
+func (r *Rollups) rollup(start int64, counts map[rollupKey]*rollupCount) *client.Rollup {
+	rollup := &client.Rollup{WindowStart: start, WindowMs: r.window, PathDepth: r.depth, Counts: make([]client.RollupCount, 0, len(counts))}
+	for k, c := range counts {
+		rollup.Counts = append(rollup.Counts, client.RollupCount{
+			Host: k.host, CrawlerFamily: k.family, StatusClass: k.class, PathPrefix: k.prefix,
+			Requests: c.requests, Bytes: c.bytes,
+		})
+	}
+	// A stable order makes rollups easy to compare and to read.
+	sort.Slice(rollup.Counts, func(i, j int) bool {
+		a, b := rollup.Counts[i], rollup.Counts[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.CrawlerFamily != b.CrawlerFamily {
+			return a.CrawlerFamily < b.CrawlerFamily
+		}
+		if a.StatusClass != b.StatusClass {
+			return a.StatusClass < b.StatusClass
+		}
+		return a.PathPrefix < b.PathPrefix
+	})
+	if r.meta != nil {
+		rollup.AgentHost, rollup.InstanceID = r.meta.host, r.meta.instanceID
+	}
+	return rollup
+}
+
+// deliver sends rollup, retrying temporary failures like the senders do.
+func (r *Rollups) deliver(rollup *client.Rollup) {
+	for attempt := 1; ; attempt++ {
+		err := r.send(r.ctx, rollup)
+		if err == nil {
+			metrics.RollupsSent.Inc()
+			return
+		}
+		if !retryable(err) || attempt > r.maxRetries || r.ctx.Err() != nil {
+			metrics.RollupsDropped.Inc()
+			slog.Error("Failed to send rollup", "window_start", time.UnixMilli(rollup.WindowStart).UTC().Format(time.RFC3339), "attempts", attempt, "err", err)
+			return
+		}
+		select {
+		case <-time.After(backoff(r.retryBase, attempt)):
+		case <-r.ctx.Done():
+		}
+	}
+}
+
+// statusClass returns the class of an HTTP status, such as "4xx".
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "other"
+	}
+	return string(rune('0'+status/100)) + "xx"
+}
+
+// pathPrefix returns the first depth segments of path, without any query.
+func pathPrefix(path string, depth int) string {
+	path, _, _ = strings.Cut(path, "?")
+	return truncatePath(path, depth)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+func TestRollups(t *testing.T) {
+	var mu sync.Mutex
+	var sent []*client.Rollup
+	failures := 1
+	send := func(ctx context.Context, r *client.Rollup) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			return &client.StatusError{StatusCode: http.StatusServiceUnavailable}
+		}
+		sent = append(sent, r)
+		return nil
+	}
+	cfg := testConfig()
+	cfg.AggWindow, cfg.AggGrace, cfg.AggPathDepth = time.Minute, 10*time.Second, 2
+	cfg.RetryBase = time.Millisecond
+	r := NewRollups(cfg, send, &agentMeta{host: "edge-1", instanceID: "i-1"})
+	minute := int64(1_700_000_040_000) // on the minute
+	r.now = func() time.Time { return time.UnixMilli(minute + 40_000) }
+	r.Start()
+	late := metrics.RollupLate.Load()
+
+	add := func(offset time.Duration, host, family string, status int, path string) {
+		r.Add(&CrawlEvent{Timestamp: minute + offset.Milliseconds(), Host: host, CrawlerFamily: family, Status: status, Path: path, Bytes: 100})
+	}
+	add(0, "example.com", "gptbot", 200, "/docs/guides/install")
+	add(30*time.Second, "example.com", "gptbot", 200, "/docs/guides/upgrade?page=2")
+	add(59*time.Second, "example.com", "gptbot", 404, "/docs/nope")
+	add(59*time.Second, "example.com", "claudebot", 503, "/")
+	add(61*time.Second, "example.com", "gptbot", 200, "/docs/guides")
+	// Within the grace period, a late line still counts in its window.
+	add(50*time.Second, "example.com", "gptbot", 200, "/docs/guides")
+	add(70*time.Second, "example.com", "gptbot", 200, "/docs/guides")
+	// The first window closed once a line 10s past its end was read.
+	add(40*time.Second, "example.com", "gptbot", 200, "/docs/guides")
+	if got := metrics.RollupLate.Load() - late; got != 1 {
+		t.Errorf("late events = %d, want 1", got)
+	}
+	r.Close(time.Second)
+
+	if len(sent) != 2 {
+		t.Fatalf("sent %d rollups, want 2", len(sent))
+	}
+	first := sent[0]
+	if first.WindowStart != minute || first.WindowMs != 60000 || first.AgentHost != "edge-1" {
+		t.Errorf("first rollup = %+v", first)
+	}
+	want := []client.RollupCount{
+		{Host: "example.com", CrawlerFamily: "claudebot", StatusClass: "5xx", PathPrefix: "/", Requests: 1, Bytes: 100},
+		{Host: "example.com", CrawlerFamily: "gptbot", StatusClass: "2xx", PathPrefix: "/docs/guides", Requests: 3, Bytes: 300},
+		{Host: "example.com", CrawlerFamily: "gptbot", StatusClass: "4xx", PathPrefix: "/docs/nope", Requests: 1, Bytes: 100},
+	}
+	if fmt.Sprint(first.Counts) != fmt.Sprint(want) {
+		t.Errorf("counts = %+v, want %+v", first.Counts, want)
+	}
+	// Close sends the window still open.
+	if second := sent[1]; second.WindowStart != minute+60000 || len(second.Counts) != 1 || second.Counts[0].Requests != 2 {
+		t.Errorf("second rollup = %+v", second)
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for status, want := range map[int]string{200: "2xx", 301: "3xx", 404: "4xx", 599: "5xx", 0: "other", 999: "other"} {
+		if got := statusClass(status); got != want {
+			t.Errorf("statusClass(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// Sampler keeps a fraction of the events of each crawler family, for
+// -sample. Sampling is random per event rather than by a hash of the
+// request, so a busy path isn't kept or dropped as a whole and counts
+// scaled up by 1/sample_rate stay unbiased. A nil *Sampler keeps
+// everything.
+type Sampler struct {
+	rates map[string]float64
+	def   float64
+}
+
+// NewSampler parses a spec such as "bytespider=0.1,default=1". Families
+// not listed use the default rate, which is 1 unless given. It returns nil
+// for an empty spec.
+func NewSampler(spec string) (*Sampler, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	s := &Sampler{rates: map[string]float64{}, def: 1}
+	for _, item := range strings.Split(spec, ",") {
+		family, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("-sample: invalid entry %q, want family=rate", item)
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("-sample: rate for %s must be between 0 and 1, got %q", family, value)
+		}
+		family = strings.ToLower(family)
+		if family == "default" {
+			s.def = rate
+		} else {
+			s.rates[family] = rate
+		}
+	}
+	return s, nil
+}
+
+// Keep reports whether event is sampled in. Kept events of a family
+// sampled below 1 carry the rate in SampleRate.
+func (s *Sampler) Keep(event *CrawlEvent) bool {
+	if s == nil {
+		return true
+	}
+	rate, ok := s.rates[event.CrawlerFamily]
+	if !ok {
+		rate = s.def
+	}
+	if rate >= 1 {
+		return true
+	}
+	if rand.Float64() >= rate {
+		return false
+	}
+	event.SampleRate = rate
+	return true
+}
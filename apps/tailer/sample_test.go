@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestNewSampler(t *testing.T) {
+	tests := []struct {
+		spec    string
+		family  string
+		want    float64
+		wantErr bool
+	}{
+		{spec: "bytespider=0.1", family: "bytespider", want: 0.1},
+		{spec: "bytespider=0.1", family: "gptbot", want: 1},
+		{spec: "Bytespider=0.1, default=0.5", family: "bytespider", want: 0.1},
+		{spec: "bytespider=0.1,default=0.5", family: "gptbot", want: 0.5},
+		{spec: "gptbot=0", family: "gptbot", want: 0},
+		{spec: "bytespider", wantErr: true},
+		{spec: "bytespider=2", wantErr: true},
+		{spec: "bytespider=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec+"/"+tt.family, func(t *testing.T) {
+			s, err := NewSampler(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rate, ok := s.rates[tt.family]
+			if !ok {
+				rate = s.def
+			}
+			if rate != tt.want {
+				t.Errorf("rate = %v, want %v", rate, tt.want)
+			}
+		})
+	}
+}
+
+func TestSamplerKeep(t *testing.T) {
+	s, err := NewSampler("bytespider=0.25,gptbot=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for range 10000 {
+		event := &CrawlEvent{CrawlerFamily: "bytespider"}
+		if s.Keep(event) {
+			kept++
+			if event.SampleRate != 0.25 {
+				t.Fatalf("SampleRate = %v, want 0.25", event.SampleRate)
+			}
+		}
+	}
+	if kept < 2200 || kept > 2800 {
+		t.Errorf("kept %d of 10000 at rate 0.25", kept)
+	}
+	if s.Keep(&CrawlEvent{CrawlerFamily: "gptbot"}) {
+		t.Error("kept an event at rate 0")
+	}
+	if event := (&CrawlEvent{CrawlerFamily: "claudebot"}); !s.Keep(event) || event.SampleRate != 0 {
+		t.Errorf("unlisted family: SampleRate = %v, want kept unsampled", event.SampleRate)
+	}
+}
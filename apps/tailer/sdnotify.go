@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state, such as READY=1, to the systemd service manager and
+// reports whether it did: without NOTIFY_SOCKET, systemd isn't listening
+// and nothing is sent.
+func sdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// A leading @ names an abstract socket, as it does for net.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("connect to NOTIFY_SOCKET: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("notify systemd: %w", err)
+	}
+	return true, nil
+}
+
+// sdWatchdogInterval returns how often systemd expects WATCHDOG=1, half
+// the WatchdogSec it passed in WATCHDOG_USEC, or 0 if it expects none from
+// this process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// notifySystemd tells systemd the tailer is ready, once ping succeeds, and
+// then keeps its watchdog fed while healthy reports the tails reading,
+// until ctx is done. ping is retried with backoff from retryBase; a nil
+// ping skips the check. Without NOTIFY_SOCKET it does nothing.
+func notifySystemd(ctx context.Context, ping func(context.Context) error, retryBase time.Duration, healthy func() error) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	for attempt := 1; ping != nil; attempt++ {
+		err := ping(ctx)
+		if err == nil {
+			break
+		}
+		wait := backoff(retryBase, attempt)
+		slog.Warn("Endpoint check failed; not telling systemd the tailer is ready yet", "err", err, "retry_in", wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if _, err := sdNotify("READY=1"); err != nil {
+		slog.Error("Failed to notify systemd", "state", "READY=1", "err", err)
+		return
+	}
+	slog.Info("Told systemd the tailer is ready")
+
+	interval := sdWatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := healthy(); err != nil {
+			slog.Warn("Not feeding the systemd watchdog", "err", err)
+			continue
+		}
+		if _, err := sdNotify("WATCHDOG=1"); err != nil {
+			slog.Error("Failed to notify systemd", "state", "WATCHDOG=1", "err", err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listenNotify listens as systemd does on NOTIFY_SOCKET, and returns the
+// states sent to it.
+func listenNotify(t *testing.T) <-chan string {
+	t.Helper()
+	// Socket paths are short; t.TempDir's can be too long.
+	dir, err := os.MkdirTemp("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("can't listen on a datagram socket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	states := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			states <- string(buf[:n])
+		}
+	}()
+	return states
+}
+
+func nextState(t *testing.T, states <-chan string) string {
+	t.Helper()
+	select {
+	case s := <-states:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing sent to NOTIFY_SOCKET")
+		return ""
+	}
+}
+
+func TestSdNotifyWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := sdNotify("READY=1"); sent || err != nil {
+		t.Errorf("sdNotify = %v, %v; want nothing sent", sent, err)
+	}
+}
+
+// TestNotifySystemd checks that ready waits for the endpoint to answer and
+// that the watchdog is only fed while healthy.
+func TestNotifySystemd(t *testing.T) {
+	states := listenNotify(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	var pings atomic.Int32
+	ping := func(context.Context) error {
+		if pings.Add(1) < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	var sick atomic.Bool
+	healthy := func() error {
+		if sick.Load() {
+			return errors.New("tails: stopped reading access.log")
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		notifySystemd(ctx, ping, time.Millisecond, healthy)
+	}()
+
+	if s := nextState(t, states); s != "READY=1" {
+		t.Fatalf("sent %q first, want READY=1", s)
+	}
+	if n := pings.Load(); n != 3 {
+		t.Errorf("ready after %d pings, want 3", n)
+	}
+	if s := nextState(t, states); s != "WATCHDOG=1" {
+		t.Fatalf("sent %q, want WATCHDOG=1", s)
+	}
+
+	sick.Store(true)
+	time.Sleep(10 * time.Millisecond) // a ping may have been on its way
+	for len(states) > 0 {
+		<-states
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(states) > 0 {
+		t.Errorf("sent %q while unhealthy", <-states)
+	}
+	sick.Store(false)
+	if s := nextState(t, states); s != "WATCHDOG=1" {
+		t.Errorf("sent %q once healthy again, want WATCHDOG=1", s)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"30000000", "", 15 * time.Second},
+		{"30000000", strconv.Itoa(os.Getpid()), 15 * time.Second},
+		{"30000000", "1", 0}, // for another process
+		{"junk", "", 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := sdWatchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: interval %s, want %s", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
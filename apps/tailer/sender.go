@@ -0,0 +1,499 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// abortGrace is how long Close waits for workers to spool what they hold
+// once the shutdown timeout has passed and requests are cancelled.
+const abortGrace = time.Second
+
+// errShutdown is returned for sends abandoned because the shutdown
+// timeout passed while they waited.
+var errShutdown = errors.New("sender shutting down")
+
+// Sender accumulates parsed events and delivers them to the ingest API.
+// A batch is flushed when it reaches BatchSize events or when the oldest
+// event in it is older than BatchInterval, whichever comes first.
+//
+// Batching and delivery run in separate goroutines joined by a bounded
+// queue of about QueueSize events, so retries against a slow endpoint
+// never stall the tail loop. cfg.Workers goroutines deliver from the queue
+// concurrently, so batches may reach the API out of order. When the queue
+// is full, Backpressure decides what gives way.
+type Sender struct {
+	api     client.Sender
+	key     func(*CrawlEvent) string // nil when any events can share a batch
+	m       *Metrics
+	log     *slog.Logger
+	cfg     Config
+	events  chan *CrawlEvent
+	batches chan []*CrawlEvent
+	spool   Backlog
+	queue   persistentBacklog           // spool, if it holds every event
+	limiter atomic.Pointer[TokenBucket] // nil when unlimited
+	paused  Throttle
+	breaker *Breaker
+	ctx     context.Context // cancelled with the parent or when the shutdown timeout passes
+	cancel  context.CancelFunc
+	done    chan struct{}
+	workers sync.WaitGroup
+
+	// closeMu makes Enqueue safe against a concurrent Close: producers
+	// hold it shared while sending on events, Close exclusively.
+	closeMu sync.RWMutex
+	closed  bool
+
+	overflowLog throttledLog
+	retryLog    throttledLog
+	failLog     throttledLog
+}
+
+// batchKeyer is implemented by transports that can't send events with
+// different keys in one request, such as a Router. The Sender then
+// batches per key.
+type batchKeyer interface {
+	BatchKey(*CrawlEvent) string
+}
+
+// NewSender starts a sender. If spool is non-nil, events that cannot be
+// delivered are written to it instead of being dropped, and replayed from
+// it in the background. A spool that is a Queue also holds events while
+// they are being delivered.
+//
+// Cancelling ctx aborts delivery as the shutdown timeout does: requests
+// and retry waits are cut short, and whatever is queued or still arrives
+// is spooled or dropped at once. Close must still be called.
+func NewSender(ctx context.Context, api client.Sender, cfg Config, spool Backlog) *Sender {
+	return newSender(ctx, api, cfg, spool, metrics, slog.Default())
+}
+
+// newSender is NewSender recording into m rather than the process-wide
+// metrics and logging to log, for a mirror endpoint.
+func newSender(ctx context.Context, api client.Sender, cfg Config, spool Backlog, m *Metrics, log *slog.Logger) *Sender {
+	s := &Sender{
+		api:     api,
+		m:       m,
+		log:     log,
+		cfg:     cfg,
+		events:  make(chan *CrawlEvent, cfg.BatchSize),
+		batches: make(chan []*CrawlEvent, max(cfg.QueueSize/cfg.BatchSize, 1)),
+		spool:   spool,
+		breaker: NewBreaker(cfg.BreakerAfter, cfg.BreakerCooldown, m, log),
+		done:    make(chan struct{}),
+
+		overflowLog: throttledLog{interval: 10 * time.Second, logger: log},
+		retryLog:    throttledLog{interval: 10 * time.Second, logger: log},
+		failLog:     throttledLog{interval: 10 * time.Second, logger: log},
+	}
+	s.paused.throttled, s.paused.log = &m.Throttled, log
+	if k, ok := api.(batchKeyer); ok {
+		s.key = k.BatchKey
+	}
+	if q, ok := spool.(persistentBacklog); ok {
+		s.queue = q
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.SetRateLimit(cfg.MaxRPS, cfg.Burst)
+	go s.batch()
+	for i := range max(cfg.Workers, 1) {
+		s.workers.Add(1)
+		go s.deliver(i)
+	}
+	go func() {
+		s.workers.Wait()
+		close(s.done)
+	}()
+	if spool != nil {
+		go spool.Drain(s.send, cfg.BatchSize)
+	}
+	return s
+}
+
+// Enqueue hands an event to the sender. Events enqueued after Close are
+// dropped.
+func (s *Sender) Enqueue(event *CrawlEvent) {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		s.m.EventsDropped.Inc()
+		return
+	}
+	if s.queue != nil {
+		if err := s.queue.Add(event); err != nil {
+			s.failLog.Log(slog.LevelError, "Failed to queue event", "err", err)
+		}
+	}
+	s.m.QueueDepth.Add(1)
+	select {
+	case s.events <- event:
+	case <-s.ctx.Done():
+		s.m.QueueDepth.Add(-1)
+		s.fail([]*CrawlEvent{event}, "shutdown timeout")
+	}
+}
+
+// Close flushes any partial batch and waits up to timeout for queued
+// batches to be delivered. Past the timeout, retries stop and whatever is
+// still queued is spooled (or dropped); Close reports whether everything
+// was delivered in time.
+func (s *Sender) Close(timeout time.Duration) bool {
+	s.closeMu.Lock()
+	s.closed = true
+	close(s.events)
+	s.closeMu.Unlock()
+
+	drained := true
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+		drained = false
+		s.log.Warn("Shutdown timeout reached", "undelivered", s.m.QueueDepth.Load())
+		s.cancel()
+		select {
+		case <-s.done:
+		case <-time.After(abortGrace):
+		}
+	}
+
+	if s.spool != nil {
+		s.spool.Close()
+	}
+	s.cancel()
+	s.log.Info("Sender stopped", "sent", s.m.EventsSent.Load(), "retried", s.m.EventsRetried.Load(), "dropped", s.m.EventsDropped.Load())
+	return drained
+}
+
+// SetRateLimit replaces the -max-rps limit; 0 removes it.
+func (s *Sender) SetRateLimit(rps float64, burst int) {
+	if rps <= 0 {
+		s.limiter.Store(nil)
+		return
+	}
+	s.limiter.Store(NewTokenBucket(rps, burst))
+}
+
+func (s *Sender) aborted() bool {
+	return s.ctx.Err() != nil
+}
+
+// openBatch is a batch being filled, with the time its first event came.
+type openBatch struct {
+	events  []*CrawlEvent
+	started time.Time
+}
+
+func (s *Sender) batch() {
+	defer close(s.batches)
+
+	// Without a batch key there is only ever the one, under "".
+	open := map[string]*openBatch{}
+	var timer *time.Timer
+	var expired <-chan time.Time
+
+	// arm sets the timer for the oldest open batch.
+	arm := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		var oldest time.Time
+		for _, b := range open {
+			if oldest.IsZero() || b.started.Before(oldest) {
+				oldest = b.started
+			}
+		}
+		if !oldest.IsZero() {
+			timer = time.NewTimer(time.Until(oldest.Add(s.cfg.BatchInterval)))
+			expired = timer.C
+		}
+	}
+	flush := func(key string) {
+		s.push(open[key].events)
+		delete(open, key)
+	}
+
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				if timer != nil {
+					timer.Stop()
+				}
+				for key := range open {
+					flush(key)
+				}
+				return
+			}
+			var key string
+			if s.key != nil {
+				key = s.key(event)
+			}
+			b := open[key]
+			if b == nil {
+				b = &openBatch{events: make([]*CrawlEvent, 0, s.cfg.BatchSize), started: time.Now()}
+				open[key] = b
+				if timer == nil {
+					arm()
+				}
+			}
+			b.events = append(b.events, event)
+			if len(b.events) >= s.cfg.BatchSize {
+				flush(key)
+				arm()
+			}
+		case <-expired:
+			timer, expired = nil, nil
+			now := time.Now()
+			for key, b := range open {
+				if !now.Before(b.started.Add(s.cfg.BatchInterval)) {
+					flush(key)
+				}
+			}
+			arm()
+		}
+	}
+}
+
+// push queues batch for delivery, applying the backpressure policy if the
+// queue is full.
+func (s *Sender) push(batch []*CrawlEvent) {
+	select {
+	case s.batches <- batch:
+		return
+	default:
+	}
+
+	switch s.cfg.Backpressure {
+	case "block":
+		// Stalls the batcher, which in turn stalls Enqueue and the tail
+		// readers until a worker frees a slot.
+		select {
+		case s.batches <- batch:
+		case <-s.ctx.Done():
+			s.fail(batch, "shutdown timeout")
+			s.m.QueueDepth.Add(-int64(len(batch)))
+		}
+	case "drop-oldest":
+		for {
+			select {
+			case s.batches <- batch:
+				return
+			default:
+			}
+			select {
+			case oldest := <-s.batches:
+				s.overflow(oldest)
+				s.m.QueueDepth.Add(-int64(len(oldest)))
+			default:
+			}
+		}
+	default:
+		s.overflow(batch)
+		s.m.QueueDepth.Add(-int64(len(batch)))
+	}
+}
+
+// deliver is one worker draining the queue.
+func (s *Sender) deliver(worker int) {
+	defer s.workers.Done()
+
+	for batch := range s.batches {
+		s.deliverOne(worker, batch)
+		s.m.QueueDepth.Add(-int64(len(batch)))
+	}
+}
+
+// deliverOne delivers a single batch. A panic is confined to the batch
+// being sent so the worker keeps serving the queue.
+func (s *Sender) deliverOne(worker int, batch []*CrawlEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.log.Error("Sender worker panicked", "worker", worker, "panic", r)
+			s.fail(batch, "worker panic")
+		}
+	}()
+
+	if s.aborted() {
+		s.fail(batch, "shutdown timeout")
+		return
+	}
+	s.sendWithRetry(batch)
+}
+
+// sendWithRetry makes up to 1+MaxRetries attempts to deliver batch. A
+// 429 doesn't count as an attempt: sending pauses for the Retry-After
+// period and the batch is tried again. Nor does an open circuit: the
+// batch is spooled, or without a spool it waits for the probe.
+func (s *Sender) sendWithRetry(batch []*CrawlEvent) {
+	for attempt := 0; ; attempt++ {
+		err := s.send(batch)
+		if err == nil {
+			if s.queue != nil {
+				s.queue.Ack(batch)
+			}
+			if s.spool != nil {
+				s.spool.Kick()
+			}
+			return
+		}
+		if errors.Is(err, errShutdown) || s.aborted() {
+			s.fail(batch, "shutdown timeout")
+			return
+		}
+		if errors.Is(err, errCircuitOpen) {
+			if s.spool != nil {
+				s.fail(batch, "circuit open")
+				return
+			}
+			if !s.breaker.Wait(s.ctx.Done()) {
+				s.fail(batch, "shutdown timeout")
+				return
+			}
+			attempt--
+			continue
+		}
+		var se *client.StatusError
+		if errors.As(err, &se) && se.StatusCode == http.StatusTooManyRequests {
+			d := se.RetryAfter
+			if d <= 0 {
+				d = backoff(s.cfg.RetryBase, s.paused.Hits()+1)
+			}
+			s.paused.Pause(d)
+			attempt--
+			continue
+		}
+		if !retryable(err) {
+			if s.queue != nil {
+				s.queue.Ack(batch)
+			}
+			s.m.EventsDropped.Add(int64(len(batch)))
+			s.failLog.Log(slog.LevelWarn, "Dropping events", "events", len(batch), "err", err, "dropped_total", s.m.EventsDropped.Load())
+			return
+		}
+		if attempt >= s.cfg.MaxRetries {
+			s.fail(batch, fmt.Sprintf("%v after %d attempts", err, attempt+1))
+			return
+		}
+		delay := backoff(s.cfg.RetryBase, attempt+1)
+		s.m.EventsRetried.Add(int64(len(batch)))
+		s.retryLog.Log(slog.LevelWarn, "Send failed, retrying", "err", err, "events", len(batch), "delay", delay.Round(time.Millisecond), "retried_total", s.m.EventsRetried.Load())
+		select {
+		case <-time.After(delay):
+		case <-s.ctx.Done():
+			s.fail(batch, "shutdown timeout")
+			return
+		}
+	}
+}
+
+// overflow handles a batch for which the delivery queue had no room. It
+// logs periodically rather than per batch, since it tends to happen many
+// times in a row.
+func (s *Sender) overflow(batch []*CrawlEvent) {
+	s.m.QueueOverflow.Add(int64(len(batch)))
+	if s.spool != nil {
+		if err := s.spool.Append(batch); err == nil {
+			s.overflowLog.Log(slog.LevelWarn, "Send queue full, spooling events", "overflowed_total", s.m.QueueOverflow.Load())
+			return
+		}
+	}
+	s.m.EventsDropped.Add(int64(len(batch)))
+	s.overflowLog.Log(slog.LevelWarn, "Send queue full, dropping events", "dropped_total", s.m.EventsDropped.Load())
+}
+
+// fail spools a batch that could not be delivered for a transient reason,
+// or drops it if there is no spool (or the spool itself fails).
+func (s *Sender) fail(batch []*CrawlEvent, reason string) {
+	if s.spool != nil {
+		err := s.spool.Append(batch)
+		if err == nil {
+			s.failLog.Log(slog.LevelWarn, "Spooled events", "events", len(batch), "reason", reason)
+			return
+		}
+		s.log.Error("Failed to spool events", "err", err)
+	}
+	s.m.EventsDropped.Add(int64(len(batch)))
+	s.failLog.Log(slog.LevelWarn, "Dropping events", "events", len(batch), "reason", reason, "dropped_total", s.m.EventsDropped.Load())
+}
+
+// wait blocks until neither API throttling nor the rate limit hold back
+// another request.
+func (s *Sender) wait() error {
+	if !s.paused.Wait(s.ctx.Done()) {
+		return errShutdown
+	}
+	if limiter := s.limiter.Load(); limiter != nil && !limiter.Wait(s.ctx.Done()) {
+		return errShutdown
+	}
+	return nil
+}
+
+// send makes one delivery attempt for batch and records the outcome. The
+// rate limit applies per HTTP request, so a batch costs a single token.
+func (s *Sender) send(batch []*CrawlEvent) error {
+	if s.cfg.BatchSize <= 1 {
+		for i := range batch {
+			if err := s.request(batch[i : i+1]); err != nil {
+				s.sent(batch[:i])
+				return err
+			}
+		}
+		s.sent(batch)
+		s.paused.Clear()
+		return nil
+	}
+	if err := s.request(batch); err != nil {
+		return err
+	}
+	s.sent(batch)
+	s.paused.Clear()
+	return nil
+}
+
+// request makes one request for events, if the circuit breaker, API
+// throttling and the rate limit let it.
+func (s *Sender) request(events []*CrawlEvent) error {
+	if err := s.breaker.Allow(); err != nil {
+		return err
+	}
+	if err := s.wait(); err != nil {
+		s.breaker.Record(err, true)
+		return err
+	}
+	start := time.Now()
+	err := s.api.Send(s.ctx, events)
+	telemetry.RecordSend(start, len(events), err)
+	statsd.RecordSend(time.Since(start), err)
+	s.breaker.Record(err, s.aborted())
+	if err != nil {
+		s.m.SendError(err)
+	}
+	switch class := errorClass(err); {
+	case s.aborted():
+	case err != nil && (class == "network" || class == "5xx"):
+		if s.m.FailingSince.Load() == 0 {
+			s.m.FailingSince.Set(time.Now().UnixMilli())
+		}
+	default:
+		s.m.FailingSince.Set(0)
+	}
+	return err
+}
+
+// sent records the delivery of events.
+func (s *Sender) sent(events []*CrawlEvent) {
+	s.m.EventsSent.Add(int64(len(events)))
+	for _, event := range events {
+		s.m.SentByFamily.Inc(event.CrawlerFamily)
+	}
+}
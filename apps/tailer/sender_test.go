@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// fakeAPI is a client.Sender answering with a scripted list of errors,
+// then success.
+type fakeAPI struct {
+	mu       sync.Mutex
+	errs     []error
+	calls    int
+	received []*CrawlEvent
+}
+
+func (f *fakeAPI) Send(ctx context.Context, events []*CrawlEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return err
+	}
+	f.received = append(f.received, events...)
+	return nil
+}
+
+func testConfig() Config {
+	return Config{
+		BatchSize:     10,
+		BatchInterval: 10 * time.Millisecond,
+		MaxRetries:    2,
+		RetryBase:     time.Millisecond,
+		QueueSize:     100,
+		Workers:       1,
+	}
+}
+
+func TestSenderDelivery(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantSent  int
+	}{
+		{name: "success", wantCalls: 1, wantSent: 3},
+		{name: "retried after 5xx", errs: []error{&client.StatusError{StatusCode: 503}}, wantCalls: 2, wantSent: 3},
+		{name: "429 does not use up retries", errs: []error{
+			&client.StatusError{StatusCode: 429, RetryAfter: time.Millisecond},
+			&client.StatusError{StatusCode: 429, RetryAfter: time.Millisecond},
+			&client.StatusError{StatusCode: 429, RetryAfter: time.Millisecond},
+		}, wantCalls: 4, wantSent: 3},
+		{name: "4xx dropped", errs: []error{&client.StatusError{StatusCode: 400}}, wantCalls: 1},
+		{name: "temporary 4xx retried", errs: []error{&client.StatusError{StatusCode: 401, Code: "timestamp_skew"}}, wantCalls: 2, wantSent: 3},
+		{name: "permanent 5xx dropped", errs: []error{&client.StatusError{StatusCode: 501, Code: "not_implemented"}}, wantCalls: 1},
+		{name: "gives up after retries", errs: []error{
+			&client.StatusError{StatusCode: 500},
+			&client.StatusError{StatusCode: 500},
+			&client.StatusError{StatusCode: 500},
+		}, wantCalls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeAPI{errs: tt.errs}
+			s := NewSender(context.Background(), api, testConfig(), nil)
+			for range 3 {
+				s.Enqueue(&CrawlEvent{Path: "/"})
+			}
+			if !s.Close(5 * time.Second) {
+				t.Fatal("Close timed out")
+			}
+			if api.calls != tt.wantCalls || len(api.received) != tt.wantSent {
+				t.Errorf("got %d calls, %d events sent; want %d, %d", api.calls, len(api.received), tt.wantCalls, tt.wantSent)
+			}
+		})
+	}
+}
+
+// keyedAPI is a fakeAPI that wants events batched by host.
+type keyedAPI struct {
+	fakeAPI
+	batches [][]*CrawlEvent
+}
+
+func (k *keyedAPI) BatchKey(event *CrawlEvent) string { return event.Host }
+
+func (k *keyedAPI) Send(ctx context.Context, events []*CrawlEvent) error {
+	k.mu.Lock()
+	k.batches = append(k.batches, events)
+	k.mu.Unlock()
+	return k.fakeAPI.Send(ctx, events)
+}
+
+func TestSenderBatchesPerKey(t *testing.T) {
+	api := &keyedAPI{}
+	cfg := testConfig()
+	cfg.BatchSize = 2
+	s := NewSender(context.Background(), api, cfg, nil)
+	for _, host := range []string{"a", "b", "a", "b", "b"} {
+		s.Enqueue(&CrawlEvent{Host: host})
+	}
+	if !s.Close(5 * time.Second) {
+		t.Fatal("Close timed out")
+	}
+	if len(api.received) != 5 || len(api.batches) != 3 {
+		t.Fatalf("got %d events in %d batches, want 5 in 3", len(api.received), len(api.batches))
+	}
+	for _, batch := range api.batches {
+		for _, e := range batch {
+			if e.Host != batch[0].Host {
+				t.Errorf("batch mixes hosts %q and %q", batch[0].Host, e.Host)
+			}
+		}
+	}
+}
+
+// hangingAPI is a client.Sender whose requests only end when cancelled.
+type hangingAPI struct{}
+
+func (hangingAPI) Send(ctx context.Context, events []*CrawlEvent) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSenderStopsOnCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		api  client.Sender
+	}{
+		{name: "request in flight", api: hangingAPI{}},
+		{name: "waiting to retry", api: &fakeAPI{errs: []error{&client.StatusError{StatusCode: 503}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.RetryBase = time.Hour
+			cfg.QueueSize = cfg.BatchSize
+			cfg.Backpressure = "block"
+			ctx, cancel := context.WithCancel(context.Background())
+			s := NewSender(ctx, tt.api, cfg, nil)
+			for range 3 * cfg.QueueSize {
+				go s.Enqueue(&CrawlEvent{Path: "/"})
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			start := time.Now()
+			cancel()
+			s.Close(time.Hour)
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("Close took %v after cancel", d)
+			}
+			s.Enqueue(&CrawlEvent{Path: "/"}) // dropped, must not block
+		})
+	}
+}
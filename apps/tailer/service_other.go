@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"io"
+	"log/slog"
+)
+
+// runServiceCommand is the service subcommand, which is for Windows; a
+// systemd unit does the same elsewhere.
+func runServiceCommand(args []string, out io.Writer) int {
+	slog.Error("The service subcommand is only available on Windows; run the tailer with systemd instead")
+	return 1
+}
+
+// runAsService reports false: only Windows has services of this kind.
+func runAsService(args []string) bool {
+	return false
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	// serviceName is the name the tailer is installed as, and the source
+	// of its event log entries.
+	serviceName = "trace-tailer"
+
+	// serviceStopWait is how long service stop waits for the tailer to
+	// deliver what it read and exit.
+	serviceStopWait = 2 * time.Minute
+)
+
+// runServiceCommand is the service subcommand: install, uninstall, start
+// or stop the Windows service. Flags after install are those the service
+// runs the tailer with. It returns the exit status.
+func runServiceCommand(args []string, out io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(out, "Usage: trace-tailer service install [flags] | uninstall | start | stop")
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "install":
+		err = installService(args[1:])
+	case "uninstall":
+		err = uninstallService()
+	case "start":
+		err = controlService(func(s *mgr.Service) error { return s.Start() })
+	case "stop":
+		err = controlService(stopService)
+	default:
+		err = fmt.Errorf("unknown service command %q (want install, uninstall, start or stop)", args[0])
+	}
+	if err != nil {
+		slog.Error("Service command failed", "command", args[0], "err", err)
+		return 1
+	}
+	fmt.Fprintf(out, "Service %s: %s done\n", serviceName, args[0])
+	return 0
+}
+
+// installService installs the tailer as a service starting with Windows
+// and restarted when it fails, running with args.
+func installService(args []string) error {
+	if _, _, err := parseConfig(args); err != nil {
+		return fmt.Errorf("check the flags: %w", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find the executable: %w", err)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return errors.New("already installed; uninstall it first")
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Originary Trace Tailer",
+		Description: "Sends the crawler requests in web server logs to Originary Trace.",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("create service: %w", err)
+	}
+	defer s.Close()
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: 5 * time.Second}
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, uint32((24 * time.Hour).Seconds())); err != nil {
+		s.Delete()
+		return fmt.Errorf("set recovery actions: %w", err)
+	}
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("add event log source: %w", err)
+	}
+	return nil
+}
+
+// uninstallService removes the service and its event log source.
+func uninstallService() error {
+	err := controlService(func(s *mgr.Service) error { return s.Delete() })
+	if err != nil {
+		return err
+	}
+	if err := eventlog.Remove(serviceName); err != nil {
+		return fmt.Errorf("remove event log source: %w", err)
+	}
+	return nil
+}
+
+// controlService calls do with the installed service.
+func controlService(do func(*mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("open service: %w", err)
+	}
+	defer s.Close()
+	return do(s)
+}
+
+// stopService asks s to stop and waits, up to serviceStopWait, until it
+// has.
+func stopService(s *mgr.Service) error {
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("stop service: %w", err)
+	}
+	deadline := time.Now().Add(serviceStopWait)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("still stopping after %s", serviceStopWait)
+		}
+		time.Sleep(time.Second)
+		if status, err = s.Query(); err != nil {
+			return fmt.Errorf("query service: %w", err)
+		}
+	}
+	return nil
+}
+
+// runAsService runs the tailer with args as the Windows service, if the
+// service manager started it, and reports whether it did. Unless
+// -log-file is set, it logs to the event log.
+func runAsService(args []string) bool {
+	if ok, err := svc.IsWindowsService(); err != nil || !ok {
+		return false
+	}
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		// There's nowhere else to say so.
+		os.Exit(1)
+	}
+	defer elog.Close()
+	out := &eventLogWriter{log: elog}
+	serviceLog = func(handler func(io.Writer) slog.Handler) slog.Handler {
+		return eventLogHandler{Handler: handler(out), out: out}
+	}
+	// Until run sets up logging, for a configuration it rejects.
+	slog.SetDefault(slog.New(serviceLog(func(w io.Writer) slog.Handler { return slog.NewTextHandler(w, nil) })))
+	if err := svc.Run(serviceName, &service{args: args}); err != nil {
+		elog.Error(1, fmt.Sprintf("Service failed: %v", err))
+	}
+	return true
+}
+
+// service is the svc.Handler running the tailer.
+type service struct {
+	args []string
+}
+
+// Execute runs the tailer until it stops by itself or the service manager
+// stops it, which stops it as SIGTERM would.
+func (s *service) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	stop := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(s.args, stop)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				select {
+				case stop <- syscall.SIGTERM:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// eventLogHandler is a slog.Handler writing each record to the event log,
+// as an entry of the record's level.
+type eventLogHandler struct {
+	slog.Handler // writing to out
+	out          *eventLogWriter
+}
+
+func (h eventLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.out.level = r.Level
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return eventLogHandler{Handler: h.Handler.WithAttrs(attrs), out: h.out}
+}
+
+func (h eventLogHandler) WithGroup(name string) slog.Handler {
+	return eventLogHandler{Handler: h.Handler.WithGroup(name), out: h.out}
+}
+
+// eventLogWriter writes each formatted record to the event log, at the
+// level of the record being handled.
+type eventLogWriter struct {
+	log   *eventlog.Log
+	mu    sync.Mutex // held while a record is handled
+	level slog.Level
+}
+
+func (w *eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	var err error
+	switch {
+	case w.level >= slog.LevelError:
+		err = w.log.Error(1, msg)
+	case w.level >= slog.LevelWarn:
+		err = w.log.Warning(1, msg)
+	default:
+		err = w.log.Info(1, msg)
+	}
+	return len(p), err
+}
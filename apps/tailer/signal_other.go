@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// diagnosticsSignal makes a running tailer log its diagnostics.
+var diagnosticsSignal os.Signal = syscall.SIGUSR1
+
+// notifySignals relays to c the signals the tailer acts on: SIGINT and
+// SIGTERM to stop, SIGHUP to reload and diagnosticsSignal.
+func notifySignals(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, diagnosticsSignal)
+}
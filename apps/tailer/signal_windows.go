@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// diagnosticsSignal is nil: Windows has nothing like SIGUSR1.
+var diagnosticsSignal os.Signal
+
+// notifySignals relays to c the signals the tailer acts on. Windows only
+// has those that stop it, for Ctrl+C and closing the console; there is no
+// SIGHUP to reload with.
+func notifySignals(c chan<- os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// Signatures keeps the crawler table up to date with the signature list
+// the API serves, so that new crawlers are classified without a tailer
+// release. The last list that applied is kept in -signatures-cache, when
+// set, for starting while the API can't be reached.
+type Signatures struct {
+	api   *client.Client
+	cache string
+	apply func([]CrawlerRule) error
+	etag  string // of the list applied
+	log   throttledLog
+}
+
+// signaturesCache is what -signatures-cache holds.
+type signaturesCache struct {
+	ETag       string          `json:"etag,omitempty"`
+	FetchedAt  time.Time       `json:"fetched_at"`
+	Signatures json.RawMessage `json:"signatures"`
+}
+
+// NewSignatures returns a refresher fetching from api and handing each new
+// list to apply.
+func NewSignatures(api *client.Client, cache string, apply func([]CrawlerRule) error) *Signatures {
+	return &Signatures{api: api, cache: cache, apply: apply, log: throttledLog{interval: time.Minute}}
+}
+
+// LoadCache applies the list in the cache file, if there is one. A cache
+// that can't be used is logged and the built-in table stays in use.
+func (s *Signatures) LoadCache() {
+	if s.cache == "" {
+		return
+	}
+	data, err := os.ReadFile(s.cache)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var cached signaturesCache
+	if err == nil {
+		err = json.Unmarshal(data, &cached)
+	}
+	var rules []CrawlerRule
+	if err == nil {
+		rules, err = parseSignatures(cached.Signatures)
+	}
+	if err == nil {
+		err = s.apply(rules)
+	}
+	if err != nil {
+		slog.Warn("Ignoring cached crawler signatures", "file", s.cache, "err", err)
+		return
+	}
+	s.etag = cached.ETag
+	slog.Info("Loaded cached crawler signatures", "signatures", len(rules), "fetched", cached.FetchedAt.Format(time.RFC3339))
+}
+
+// Run refreshes the list now and then every interval until ctx is done.
+func (s *Signatures) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	missing := false
+	for {
+		err := s.refresh(ctx)
+		var se *client.StatusError
+		switch {
+		case err == nil || ctx.Err() != nil:
+		case errors.As(err, &se) && se.StatusCode == http.StatusNotFound:
+			// An API without the list isn't worth a warning every time.
+			if !missing {
+				slog.Info("The API serves no crawler signatures; keeping the current table", "err", err)
+				missing = true
+			}
+		default:
+			s.log.Log(slog.LevelWarn, "Failed to refresh crawler signatures, keeping the current table", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Signatures) refresh(ctx context.Context) error {
+	data, etag, err := s.api.FetchSignatures(ctx, s.etag)
+	if errors.Is(err, client.ErrNotModified) {
+		slog.Debug("Crawler signatures unchanged", "etag", etag)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rules, err := parseSignatures(data)
+	if err != nil {
+		return err
+	}
+	if err := s.apply(rules); err != nil {
+		return fmt.Errorf("apply crawler signatures: %w", err)
+	}
+	s.etag = etag
+	slog.Info("Updated crawler signatures", "signatures", len(rules), "etag", etag)
+
+	if s.cache != "" {
+		cached, err := json.Marshal(signaturesCache{ETag: etag, FetchedAt: time.Now().UTC(), Signatures: data})
+		if err == nil {
+			err = writeFileSync(s.cache, cached)
+		}
+		if err != nil {
+			slog.Error("Failed to save crawler signatures", "file", s.cache, "err", err)
+		}
+	}
+	return nil
+}
+
+// parseSignatures parses a signature list: a JSON array of rules, as in
+// the -crawlers file.
+func parseSignatures(data []byte) ([]CrawlerRule, error) {
+	var rules []CrawlerRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse crawler signatures: %w", err)
+	}
+	return rules, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+func TestSignatures(t *testing.T) {
+	var list atomic.Value
+	list.Store(`[{"family":"newbot","match":"newbot"}]`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + list.Load().(string) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		io.WriteString(w, list.Load().(string))
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.Format = "nginx"
+	newPipeline := func() *Pipeline {
+		p, err := NewPipeline(cfg, NewFanout(NewSender(context.Background(), &fakeAPI{}, cfg, nil), nil, 0), nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	classify := func(p *Pipeline) string {
+		return p.rules.Load().classifier.Classify("Mozilla/5.0 (compatible; NewBot/1.0)")
+	}
+	cache := filepath.Join(t.TempDir(), "signatures.json")
+
+	p := newPipeline()
+	s := NewSignatures(client.New(srv.URL, "pk_test", "sk_test"), cache, p.SetSignatures)
+	if err := s.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := classify(p); got != "newbot" {
+		t.Errorf("after refresh classified as %s, want newbot", got)
+	}
+	if err := s.refresh(context.Background()); err != nil {
+		t.Errorf("refresh of an unchanged list: %v", err)
+	}
+	if err := p.Reload(cfg); err != nil || classify(p) != "newbot" {
+		t.Errorf("signatures lost on reload (err %v)", err)
+	}
+
+	// A list that doesn't parse, or makes no valid table, keeps the one in
+	// use.
+	for _, bad := range []string{`[{"family":"badbot","regex":"("}]`, `{"not": "a list"}`} {
+		list.Store(bad)
+		if err := s.refresh(context.Background()); err == nil {
+			t.Errorf("refresh accepted %s", bad)
+		}
+		if got := classify(p); got != "newbot" {
+			t.Errorf("after %s classified as %s, want newbot", bad, got)
+		}
+	}
+
+	// A cold start with the API down classifies with the cached list.
+	srv.Close()
+	cold := newPipeline()
+	if got := classify(cold); got != "unknown" {
+		t.Fatalf("without signatures classified as %s, want unknown", got)
+	}
+	s = NewSignatures(client.New(srv.URL, "pk_test", "sk_test"), cache, cold.SetSignatures)
+	s.LoadCache()
+	if err := s.refresh(context.Background()); err == nil {
+		t.Error("refresh succeeded with the API down")
+	}
+	if got := classify(cold); got != "newbot" {
+		t.Errorf("from the cache classified as %s, want newbot", got)
+	}
+}
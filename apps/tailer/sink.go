@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+// sinkNames are the destinations -sink accepts.
+var sinkNames = []string{"http", "kafka", "file"}
+
+// sink is a destination for events selected with -sink: the ingest API,
+// or one of the alternatives to it.
+type sink struct {
+	name  string
+	send  client.Sender
+	close func() error // called once the sink's sender has stopped; may be nil
+}
+
+// newSinks returns the sinks of cfg.Sinks in order, with api as http.
+func newSinks(cfg Config, api client.Sender) ([]sink, error) {
+	var sinks []sink
+	for _, name := range cfg.Sinks {
+		switch name {
+		case "http":
+			sinks = append(sinks, sink{name: name, send: api})
+		case "kafka":
+			k, err := NewKafkaSink(cfg)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink{name: name, send: k, close: k.Close})
+		case "file":
+			f, err := NewFileSink(cfg)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink{name: name, send: f, close: f.Close})
+		}
+	}
+	return sinks, nil
+}
+
+// closeSinks releases the sinks, logging any that fail to close.
+func closeSinks(sinks []sink) {
+	for _, s := range sinks {
+		if s.close == nil {
+			continue
+		}
+		if err := s.close(); err != nil {
+			slog.Error("Failed to close sink", "sink", s.name, "err", err)
+		}
+	}
+}
+
+func validateSinks(cfg Config) error {
+	for i, name := range cfg.Sinks {
+		if !slices.Contains(sinkNames, name) {
+			return fmt.Errorf("unknown -sink %q (want http, kafka or file)", name)
+		}
+		if slices.Contains(cfg.Sinks[:i], name) {
+			return fmt.Errorf("-sink %s is given twice", name)
+		}
+	}
+	if slices.Contains(cfg.Sinks, "kafka") {
+		if err := validateKafka(cfg); err != nil {
+			return err
+		}
+	}
+	if slices.Contains(cfg.Sinks, "file") {
+		return validateFileSink(cfg)
+	}
+	return nil
+}
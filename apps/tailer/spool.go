@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// spoolSegments is how many files the spool cap is split across.
+	// Eviction drops a whole segment, so this sets its granularity.
+	spoolSegments = 8
+
+	// spoolRetryInterval is how often the drainer retries while the
+	// endpoint keeps failing. A successful live send also wakes it up.
+	spoolRetryInterval = 30 * time.Second
+
+	spoolPrefix = "spool-"
+	spoolSuffix = ".ndjson"
+)
+
+// Spool is a disk-backed overflow for events that could not be delivered.
+// Events are appended as NDJSON to numbered segment files in dir; the
+// drainer replays segments oldest-first and deletes each one once it has
+// been delivered completely. When the total size would exceed maxBytes the
+// oldest segment is dropped.
+//
+// Delivery from the spool is at-least-once: if the process stops while a
+// segment is partly drained, that segment is sent again from its start.
+type Spool struct {
+	dir        string
+	maxBytes   int64
+	segmentMax int64
+
+	mu       sync.Mutex
+	segments []*spoolSegment // oldest first; the last one is being appended to
+	active   *os.File
+	nextSeq  uint64
+	drained  int // events of segments[0] already delivered
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+type spoolSegment struct {
+	path   string
+	size   int64
+	events int
+}
+
+// OpenSpool opens (creating if needed) the spool in dir and picks up any
+// segments left by a previous run.
+func OpenSpool(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create spool directory: %w", err)
+	}
+
+	sp := &Spool{
+		dir:        dir,
+		maxBytes:   maxBytes,
+		segmentMax: max(maxBytes/spoolSegments, 1),
+		kick:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool directory: %w", err)
+	}
+	var seqs []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, spoolPrefix) || !strings.HasSuffix(name, spoolSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, spoolPrefix), spoolSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	for _, seq := range seqs {
+		seg, err := scanSegment(sp.segmentPath(seq))
+		if err != nil {
+			return nil, err
+		}
+		sp.nextSeq = seq + 1
+		if seg.events == 0 {
+			os.Remove(seg.path)
+			continue
+		}
+		sp.segments = append(sp.segments, seg)
+	}
+	if n := sp.pending(); n > 0 {
+		slog.Info("Spool holds undelivered events from a previous run", "dir", dir, "events", n)
+	}
+
+	return sp, nil
+}
+
+func scanSegment(path string) (*spoolSegment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	seg := &spoolSegment{path: path}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		seg.size += int64(len(sc.Bytes())) + 1
+		if len(sc.Bytes()) > 0 {
+			seg.events++
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read spool segment %s: %w", path, err)
+	}
+	return seg, nil
+}
+
+func (sp *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(sp.dir, fmt.Sprintf("%s%020d%s", spoolPrefix, seq, spoolSuffix))
+}
+
+func (sp *Spool) pending() int {
+	n := -sp.drained
+	for _, seg := range sp.segments {
+		n += seg.events
+	}
+	return n
+}
+
+// spoolBuffers holds the buffers Append encodes into, which while the API
+// is down is every batch.
+var spoolBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// Append writes events to the spool, evicting the oldest segments if the
+// cap would be exceeded.
+func (sp *Spool) Append(events []*CrawlEvent) error {
+	buf := spoolBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer spoolBuffers.Put(buf)
+	enc := json.NewEncoder(buf)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			return fmt.Errorf("marshal event: %w", err)
+		}
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.active == nil || sp.segments[len(sp.segments)-1].size+int64(buf.Len()) > sp.segmentMax {
+		if err := sp.rotate(); err != nil {
+			return err
+		}
+	}
+	sp.evict(int64(buf.Len()))
+
+	if _, err := sp.active.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("write spool: %w", err)
+	}
+	seg := sp.segments[len(sp.segments)-1]
+	seg.size += int64(buf.Len())
+	seg.events += len(events)
+	return nil
+}
+
+// rotate closes the active segment and starts a new one. Callers hold mu.
+func (sp *Spool) rotate() error {
+	if sp.active != nil {
+		sp.active.Sync()
+		sp.active.Close()
+		sp.active = nil
+	}
+
+	path := sp.segmentPath(sp.nextSeq)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("create spool segment: %w", err)
+	}
+	sp.nextSeq++
+	sp.active = f
+	sp.segments = append(sp.segments, &spoolSegment{path: path})
+	return nil
+}
+
+// evict drops the oldest segments until incoming more bytes fit under the
+// cap. The active segment is never dropped. Callers hold mu.
+func (sp *Spool) evict(incoming int64) {
+	var total int64
+	for _, seg := range sp.segments {
+		total += seg.size
+	}
+	for total+incoming > sp.maxBytes && len(sp.segments) > 1 {
+		oldest := sp.segments[0]
+		lost := oldest.events - sp.drained
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to remove spool segment", "err", err)
+		}
+		sp.segments = sp.segments[1:]
+		sp.drained = 0
+		total -= oldest.size
+		slog.Warn("Spool full, dropped oldest events", "events", lost)
+	}
+}
+
+// Drain replays spooled events through send in chunks of batchSize until
+// Close is called. It runs in its own goroutine.
+func (sp *Spool) Drain(send func([]*CrawlEvent) error, batchSize int) {
+	defer close(sp.done)
+
+	ticker := time.NewTicker(spoolRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		for sp.drainOne(send, batchSize) {
+			select {
+			case <-sp.stop:
+				return
+			default:
+			}
+		}
+
+		select {
+		case <-sp.stop:
+			return
+		case <-sp.kick:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Kick asks the drainer to retry now, e.g. after the endpoint recovered.
+func (sp *Spool) Kick() {
+	select {
+	case sp.kick <- struct{}{}:
+	default:
+	}
+}
+
+// drainOne delivers what remains of the oldest segment. It returns true if
+// the segment was fully delivered and another one may be waiting.
+func (sp *Spool) drainOne(send func([]*CrawlEvent) error, batchSize int) bool {
+	sp.mu.Lock()
+	if len(sp.segments) == 0 || sp.segments[0].events == 0 {
+		sp.mu.Unlock()
+		return false
+	}
+	// Never read the file being appended to; start a new one instead.
+	if len(sp.segments) == 1 && sp.active != nil {
+		if err := sp.rotate(); err != nil {
+			sp.mu.Unlock()
+			slog.Error("Failed to rotate spool", "err", err)
+			return false
+		}
+	}
+	seg := sp.segments[0]
+	skip := sp.drained
+	sp.mu.Unlock()
+
+	events, err := readSegment(seg.path)
+	if err != nil {
+		slog.Error("Failed to read spool", "err", err)
+		return false
+	}
+	if skip > len(events) {
+		skip = len(events)
+	}
+	events = events[skip:]
+
+	for len(events) > 0 {
+		n := min(batchSize, len(events))
+		if err := send(events[:n]); err != nil {
+			slog.Warn("Spool drain paused", "err", err)
+			return false
+		}
+		events = events[n:]
+
+		select {
+		case <-sp.stop:
+			sp.mu.Lock()
+			if len(sp.segments) > 0 && sp.segments[0] == seg {
+				sp.drained += n
+			}
+			sp.mu.Unlock()
+			return false
+		default:
+		}
+
+		sp.mu.Lock()
+		if len(sp.segments) == 0 || sp.segments[0] != seg {
+			// Evicted while we were sending it.
+			sp.mu.Unlock()
+			return true
+		}
+		sp.drained += n
+		sp.mu.Unlock()
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if len(sp.segments) > 0 && sp.segments[0] == seg {
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to remove spool segment", "err", err)
+		}
+		sp.segments = sp.segments[1:]
+		sp.drained = 0
+	}
+	slog.Info("Delivered spooled segment", "segment", filepath.Base(seg.path), "still_spooled", sp.pending())
+	return true
+}
+
+func readSegment(path string) ([]*CrawlEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	var events []*CrawlEvent
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		event := &CrawlEvent{}
+		if err := json.Unmarshal(sc.Bytes(), event); err != nil {
+			// A torn write from a crash; skip the line rather than
+			// wedging the whole spool.
+			slog.Warn("Skipping corrupt spool entry", "file", path, "err", err)
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read spool segment %s: %w", path, err)
+	}
+	return events, nil
+}
+
+// Close stops the drainer and closes the active segment. Undelivered
+// events stay on disk for the next run.
+func (sp *Spool) Close() {
+	close(sp.stop)
+	<-sp.done
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.active != nil {
+		sp.active.Sync()
+		sp.active.Close()
+		sp.active = nil
+	}
+}
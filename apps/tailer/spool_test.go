@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// spoolSink collects what a spool drains; fail, if set, picks the
+// attempts that fail.
+type spoolSink struct {
+	mu       sync.Mutex
+	attempts int
+	received []*CrawlEvent
+	fail     func(attempt int) bool
+}
+
+func (s *spoolSink) send(events []*CrawlEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.fail != nil && s.fail(s.attempts) {
+		return errors.New("503 Service Unavailable")
+	}
+	s.received = append(s.received, events...)
+	return nil
+}
+
+// wait returns what s received once it holds n events, or after 5s.
+func (s *spoolSink) wait(n int) []*CrawlEvent {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		got := append([]*CrawlEvent(nil), s.received...)
+		s.mu.Unlock()
+		if len(got) >= n || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func spoolFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, spoolPrefix+"*"+spoolSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSpoolEvictsOldest(t *testing.T) {
+	dir := t.TempDir()
+	// Events of one size, a segment each.
+	events := queueEvents(20)[10:]
+	line, _ := json.Marshal(events[0])
+	sp, err := OpenSpool(dir, spoolSegments*int64(len(line)+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if err := sp.Append([]*CrawlEvent{e}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := spoolFiles(t, dir); len(files) != spoolSegments {
+		t.Errorf("%d segments on disk, want %d", len(files), spoolSegments)
+	}
+
+	sink := &spoolSink{}
+	go sp.Drain(sink.send, 100)
+	got := sink.wait(spoolSegments)
+	sp.Close()
+	if len(got) != spoolSegments {
+		t.Fatalf("drained %d events, want %d", len(got), spoolSegments)
+	}
+	for i, e := range got {
+		if want := events[len(events)-spoolSegments+i]; e.Path != want.Path {
+			t.Errorf("event %d is %s, want %s: the oldest should have gone", i, e.Path, want.Path)
+		}
+	}
+}
+
+func TestSpoolReopenDrainsInOrder(t *testing.T) {
+	dir := t.TempDir()
+	events := queueEvents(25)
+	for i, e := range events {
+		e.Timestamp = 1_700_000_000_123 + int64(i)
+	}
+	sp, err := OpenSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The API is down until the restart.
+	go sp.Drain((&spoolSink{fail: func(int) bool { return true }}).send, 10)
+	for i := 0; i < len(events); i += 10 {
+		if err := sp.Append(events[i:min(i+10, len(events))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sp.Close()
+
+	sp, err = OpenSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &spoolSink{}
+	go sp.Drain(sink.send, 10)
+	got := sink.wait(len(events))
+	sp.Close()
+	if len(got) != len(events) {
+		t.Fatalf("drained %d events after reopening, want %d", len(got), len(events))
+	}
+	for i, e := range got {
+		if e.Path != events[i].Path || e.Timestamp != events[i].Timestamp || e.EventID != events[i].EventID {
+			t.Errorf("event %d is %+v, want %+v", i, *e, *events[i])
+		}
+	}
+	if files := spoolFiles(t, dir); len(files) > 1 {
+		t.Errorf("%d segments left after draining, want at most the empty active one", len(files))
+	}
+}
+
+func TestSpoolKeepsSegmentOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := OpenSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := queueEvents(5)
+	if err := sp.Append(events); err != nil {
+		t.Fatal(err)
+	}
+	spooled := spoolFiles(t, dir)[0]
+
+	sink := &spoolSink{fail: func(attempt int) bool { return attempt == 1 }}
+	go sp.Drain(sink.send, 100)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sink.mu.Lock()
+		attempts := sink.attempts
+		sink.mu.Unlock()
+		if attempts > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(spooled); err != nil {
+		t.Fatalf("segment gone after a failed send: %v", err)
+	}
+
+	sp.Kick()
+	got := sink.wait(len(events))
+	sp.Close()
+	if len(got) != len(events) || got[0].Path != events[0].Path {
+		t.Fatalf("drained %d events after the failure, want all %d", len(got), len(events))
+	}
+	if _, err := os.Stat(spooled); !os.IsNotExist(err) {
+		t.Errorf("segment still on disk once delivered: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/nxadm/tail"
+)
+
+// watchdog reopens tails that have stopped reading a file that is still
+// being written, every half -stall-timeout until Stop. The tail library
+// can miss a copytruncate rotation and keep waiting at an offset past the
+// new end of the file, or keep following a renamed file; either way no
+// error is reported and nothing is sent.
+func (w *Watcher) watchdog() {
+	ticker := time.NewTicker(w.stallAfter / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.checkStalls(now)
+		}
+	}
+}
+
+func (w *Watcher) checkStalls(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ctx.Err() != nil {
+		return
+	}
+	for path, ft := range w.tails {
+		if ft.fifo != nil {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue // gone; the tail waits for it to come back
+		}
+		reason, fromStart := stallReason(ft, fi, now, w.stallAfter)
+		if reason == "" {
+			continue
+		}
+		metrics.TailReopens.Inc()
+		idle := now.Sub(time.Unix(0, ft.lastLine.Load())).Round(time.Second)
+		slog.Warn("Tail stalled, reopening", "file", path, "reason", reason, "idle", idle, "offset", ft.offset.Load(), "size", fi.Size())
+
+		var location *tail.SeekInfo
+		if !fromStart {
+			location = &tail.SeekInfo{Offset: ft.offset.Load(), Whence: io.SeekStart}
+		}
+		ft.t.Stop()
+		delete(w.tails, path)
+		if err := w.startAt(path, ft.globbed, location); err != nil {
+			slog.Error("Failed to reopen log file", "file", path, "err", err)
+		}
+	}
+}
+
+// stallReason says why ft, which last read a line more than stallAfter
+// ago, should be reopened given the file as it is now (fi), or "" if
+// nothing is wrong. fromStart is whether the file should then be read
+// from the beginning rather than from where ft got to.
+func stallReason(ft *fileTail, fi os.FileInfo, now time.Time, stallAfter time.Duration) (reason string, fromStart bool) {
+	last := time.Unix(0, ft.lastLine.Load())
+	if now.Sub(last) < stallAfter {
+		return "", false
+	}
+	if inode := fileInode(ft.path, fi); inode != 0 && ft.inode.Load() != 0 && inode != ft.inode.Load() {
+		return "replaced", true // renamed away and recreated
+	}
+	offset := ft.offset.Load()
+	if fi.Size() < offset {
+		return "truncated", true // copytruncate
+	}
+	if fi.Size() > offset && fi.ModTime().After(last) {
+		// A file truncated and written past the old offset again between
+		// two checks looks the same, except that the offset no
+		// longer falls just after a newline.
+		if offset > 0 && !afterNewline(ft.path, offset) {
+			return "truncated", true
+		}
+		return "unread data", false
+	}
+	return "", false
+}
+
+// afterNewline reports whether the byte before offset in file is '\n'.
+func afterNewline(file string, offset int64) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset-1); err != nil {
+		return false
+	}
+	return b[0] == '\n'
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStallReason(t *testing.T) {
+	const stallAfter = time.Minute
+	const content = "line one\nline two\n"
+
+	tests := []struct {
+		name      string
+		idle      time.Duration
+		rotate    func(t *testing.T, path string)
+		want      string
+		fromStart bool
+	}{
+		{"quiet file", 2 * stallAfter, func(*testing.T, string) {}, "", false},
+		{"reading", time.Second, func(t *testing.T, path string) { appendFile(t, path, "line three\n") }, "", false},
+		{"unread data", 2 * stallAfter, func(t *testing.T, path string) { appendFile(t, path, "line three\n") }, "unread data", false},
+		{"copytruncate", 2 * stallAfter, func(t *testing.T, path string) {
+			copyFile(t, path, path+".1")
+			if err := os.Truncate(path, 0); err != nil {
+				t.Fatal(err)
+			}
+			appendFile(t, path, "new\n")
+		}, "truncated", true},
+		{"copytruncate, written past the offset", 2 * stallAfter, func(t *testing.T, path string) {
+			copyFile(t, path, path+".1")
+			if err := os.Truncate(path, 0); err != nil {
+				t.Fatal(err)
+			}
+			appendFile(t, path, "a much longer first line\n")
+		}, "truncated", true},
+		{"rename", 2 * stallAfter, func(t *testing.T, path string) {
+			if err := os.Rename(path, path+".1"); err != nil {
+				t.Fatal(err)
+			}
+			appendFile(t, path, content)
+		}, "replaced", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			ft := &fileTail{path: path}
+			ft.offset.Store(int64(len(content)))
+			ft.inode.Store(statInode(path))
+			ft.lastLine.Store(now.Add(-tt.idle).UnixNano())
+			// The file was last written when the last line was read.
+			last := now.Add(-tt.idle)
+			os.Chtimes(path, last, last)
+
+			tt.rotate(t, path)
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reason, fromStart := stallReason(ft, fi, now, stallAfter)
+			if reason != tt.want || fromStart != tt.fromStart {
+				t.Errorf("stallReason = %q, %v; want %q, %v", reason, fromStart, tt.want, tt.fromStart)
+			}
+		})
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyFile(t *testing.T, from, to string) {
+	t.Helper()
+	data, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(to, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
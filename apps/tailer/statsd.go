@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// statsdMaxPacket keeps datagrams within a 1500-byte Ethernet MTU,
+	// less IP and UDP headers.
+	statsdMaxPacket = 1432
+
+	// statsdMaxTimings is how many request timings are kept between
+	// flushes; later ones are dropped.
+	statsdMaxTimings = 10000
+
+	statsdPrefix = "trace.tailer."
+)
+
+// statsd emits to -statsd-addr; nil unless it is set. It is set before
+// any sender starts.
+var statsd *StatsdEmitter
+
+// StatsdEmitter sends the tailer's counters, as the increase since the
+// previous flush, and the duration of each request to the ingest API to
+// a DogStatsD agent over UDP. Nothing it does waits on the agent, so a
+// missing or slow one can't hold up delivery.
+type StatsdEmitter struct {
+	m    *Metrics
+	conn net.Conn
+
+	mu      sync.Mutex
+	timings []statsdTiming
+	dropped int
+	last    statsdCounts // as of the previous flush
+
+	stop     chan struct{}
+	done     chan struct{}
+	errorLog throttledLog
+}
+
+type statsdTiming struct {
+	ms          float64
+	statusClass string
+}
+
+type statsdCounts struct {
+	lines, parseErrors int64
+	sent               map[string]int64 // by crawler family
+	sendErrors         map[string]int64 // by status class
+}
+
+// StartStatsd starts flushing m to addr every interval.
+func StartStatsd(addr string, interval time.Duration, m *Metrics) (*StatsdEmitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", addr, err)
+	}
+	s := &StatsdEmitter{
+		m:        m,
+		conn:     conn,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		errorLog: throttledLog{interval: time.Minute},
+	}
+	s.last = s.counts()
+	go s.run(interval)
+	slog.Info("Emitting statsd metrics", "addr", addr)
+	return s, nil
+}
+
+func (s *StatsdEmitter) run(interval time.Duration) {
+	defer close(s.done)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			s.Flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close flushes what is left and disconnects.
+func (s *StatsdEmitter) Close() {
+	close(s.stop)
+	<-s.done
+	s.Flush()
+	s.conn.Close()
+}
+
+// RecordSend records the duration of a request to the ingest API. It does
+// nothing on a nil emitter.
+func (s *StatsdEmitter) RecordSend(d time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	class := "2xx"
+	if err != nil {
+		class = errorClass(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.timings) >= statsdMaxTimings {
+		s.dropped++
+		return
+	}
+	s.timings = append(s.timings, statsdTiming{ms: float64(d.Microseconds()) / 1000, statusClass: class})
+}
+
+func (s *StatsdEmitter) counts() statsdCounts {
+	c := statsdCounts{
+		lines:       s.m.LinesRead.Load(),
+		parseErrors: s.m.ParseErrors.Load(),
+		sent:        s.m.SentByFamily.Snapshot(),
+		sendErrors:  map[string]int64{},
+	}
+	for class, counter := range s.m.SendErrors {
+		c.sendErrors[class] = counter.Load()
+	}
+	return c
+}
+
+// Flush sends the counters' increase since the previous flush and the
+// timings recorded since.
+func (s *StatsdEmitter) Flush() {
+	s.mu.Lock()
+	cur, prev := s.counts(), s.last
+	s.last = cur
+	timings, dropped := s.timings, s.dropped
+	s.timings, s.dropped = nil, 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		s.errorLog.Log(slog.LevelWarn, "Dropped statsd timings between flushes", "timings", dropped)
+	}
+
+	var lines []string
+	counter := func(name string, n int64, tags string) {
+		if n > 0 {
+			lines = append(lines, statsdPrefix+name+":"+strconv.FormatInt(n, 10)+"|c"+tags)
+		}
+	}
+	counter("lines_read", cur.lines-prev.lines, "")
+	counter("parse_errors", cur.parseErrors-prev.parseErrors, "")
+	for _, family := range sortedKeys(cur.sent) {
+		counter("events_sent", cur.sent[family]-prev.sent[family], "|#crawler_family:"+statsdTag(family))
+	}
+	for _, class := range sortedKeys(cur.sendErrors) {
+		counter("send_errors", cur.sendErrors[class]-prev.sendErrors[class], "|#status_class:"+class)
+	}
+	for _, t := range timings {
+		lines = append(lines, statsdPrefix+"send_latency:"+strconv.FormatFloat(t.ms, 'f', -1, 64)+"|ms|#status_class:"+t.statusClass)
+	}
+
+	for _, packet := range statsdPackets(lines) {
+		if _, err := s.conn.Write(packet); err != nil {
+			s.errorLog.Log(slog.LevelWarn, "Failed to send statsd metrics", "err", err)
+			return
+		}
+	}
+}
+
+// statsdPackets joins lines, one metric each, into datagrams of at most
+// statsdMaxPacket bytes.
+func statsdPackets(lines []string) [][]byte {
+	var packets [][]byte
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacket {
+			packets = append(packets, append([]byte(nil), buf.Bytes()...))
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+	return packets
+}
+
+// statsdTag makes v safe as a tag value: no separators of the format.
+func statsdTag(v string) string {
+	if v == "" {
+		return "unknown"
+	}
+	b := []byte(v)
+	for i, c := range b {
+		switch c {
+		case '|', ',', '#', ':', '\n', ' ':
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
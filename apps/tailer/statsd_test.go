@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+)
+
+func TestStatsd(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+
+	m := NewMetrics()
+	m.LinesRead.Add(3) // before the start, so not sent
+	s, err := StartStatsd(agent.LocalAddr().String(), time.Hour, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.LinesRead.Add(5)
+	m.SentByFamily.Inc("gptbot")
+	m.SentByFamily.Inc("gptbot")
+	m.SendError(&client.StatusError{StatusCode: 503})
+	s.RecordSend(12500*time.Microsecond, nil)
+	s.RecordSend(time.Second, &client.StatusError{StatusCode: 503})
+	s.Flush()
+	m.ParseErrors.Inc()
+	s.Close()
+
+	var got []string
+	buf := make([]byte, statsdMaxPacket)
+	for {
+		agent.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := agent.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		got = append(got, strings.Split(string(buf[:n]), "\n")...)
+	}
+	want := []string{
+		"trace.tailer.lines_read:5|c",
+		"trace.tailer.events_sent:2|c|#crawler_family:gptbot",
+		"trace.tailer.send_errors:1|c|#status_class:5xx",
+		"trace.tailer.send_latency:12.5|ms|#status_class:2xx",
+		"trace.tailer.send_latency:1000|ms|#status_class:5xx",
+		"trace.tailer.parse_errors:1|c",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Nothing is sent, or fails, on a nil emitter.
+	var none *StatsdEmitter
+	none.RecordSend(time.Second, errors.New("failed"))
+}
+
+func TestStatsdPackets(t *testing.T) {
+	lines := make([]string, 100)
+	for i := range lines {
+		lines[i] = "trace.tailer.send_latency:" + strings.Repeat("9", 40) + "|ms|#status_class:2xx"
+	}
+	packets := statsdPackets(lines)
+	var n int
+	for _, p := range packets {
+		if len(p) > statsdMaxPacket {
+			t.Errorf("packet of %d bytes, want at most %d", len(p), statsdMaxPacket)
+		}
+		n += len(strings.Split(string(p), "\n"))
+	}
+	if n != len(lines) || len(packets) < 2 {
+		t.Errorf("%d lines in %d packets, want %d in several", n, len(packets), len(lines))
+	}
+}
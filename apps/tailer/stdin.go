@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// readLines feeds newline-delimited log lines from r, named name in log
+// messages, to the pipeline until EOF or until ctx is done, which is
+// returned as an error. A read error (such as the writing
+// end of a pipe going away) ends the input and is returned once, rather
+// than surfacing on every line. If progressEvery is positive, progress is
+// logged every that many lines. Reading stops early, without an error,
+// at a line logged well after -until. If consumed is not nil, it is told
+// the bytes of every line once the line is handled.
+func readLines(ctx context.Context, r io.Reader, name string, pipeline *Pipeline, progressEvery int, consumed func(n int)) (lines, parseErrors int, err error) {
+	br := bufio.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return lines, parseErrors, err
+		}
+		line, err := br.ReadString('\n')
+		n := len(line)
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			lines++
+			switch perr := pipeline.Process(name, line); {
+			case errors.Is(perr, errPastUntil):
+				slog.Info("Stopped reading past -until", "source", name, "lines", lines)
+				return lines, parseErrors, nil
+			case perr != nil:
+				parseErrors++
+			}
+			if progressEvery > 0 && lines%progressEvery == 0 {
+				slog.Info("Progress", "source", name, "lines", lines, "parse_errors", parseErrors)
+			}
+		}
+		if consumed != nil && n > 0 {
+			consumed(n)
+		}
+		if errors.Is(err, io.EOF) {
+			return lines, parseErrors, nil
+		}
+		if err != nil {
+			return lines, parseErrors, err
+		}
+	}
+}
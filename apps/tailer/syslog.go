@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSyslogMessage bounds a single message, over UDP (where it is also the
+// largest datagram) and over TCP.
+const maxSyslogMessage = 64 << 10
+
+var errSyslogMalformed = errors.New("malformed syslog message")
+
+// SyslogListener receives log lines as syslog messages (RFC 3164 or RFC
+// 5424) over UDP and TCP, as nginx's access_log syslog: and most syslog
+// daemons' forwarding send them, and feeds each message's MSG part to the
+// pipeline.
+type SyslogListener struct {
+	process func(source, line string) error
+	unwatch func() bool // stops closing the listener when ctx is done
+
+	mu        sync.Mutex
+	closing   bool
+	listeners []io.Closer
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// ListenSyslog starts listening on each address, a udp:// or tcp:// URL
+// such as udp://0.0.0.0:5514. Messages are handed to process with the
+// address as the source. Cancelling ctx closes the listener.
+func ListenSyslog(ctx context.Context, addrs []string, process func(source, line string) error) (*SyslogListener, error) {
+	l := &SyslogListener{process: process, conns: map[net.Conn]struct{}{}}
+	for _, addr := range addrs {
+		network, hostport, err := parseSyslogAddr(addr)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		if network == "udp" {
+			pc, err := net.ListenPacket("udp", hostport)
+			if err != nil {
+				l.Close()
+				return nil, fmt.Errorf("listen on %s: %w", addr, err)
+			}
+			l.listeners = append(l.listeners, pc)
+			l.wg.Add(1)
+			go l.serveUDP(addr, pc)
+		} else {
+			ln, err := net.Listen("tcp", hostport)
+			if err != nil {
+				l.Close()
+				return nil, fmt.Errorf("listen on %s: %w", addr, err)
+			}
+			l.listeners = append(l.listeners, ln)
+			l.wg.Add(1)
+			go l.serveTCP(addr, ln)
+		}
+		slog.Info("Listening for syslog", "addr", addr)
+	}
+	l.unwatch = context.AfterFunc(ctx, l.Close)
+	return l, nil
+}
+
+// parseSyslogAddr splits a -listen-syslog URL into a network and address.
+func parseSyslogAddr(addr string) (network, hostport string, err error) {
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return "", "", fmt.Errorf("invalid -listen-syslog %q, want udp://host:port or tcp://host:port", addr)
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return "", "", fmt.Errorf("unknown -listen-syslog scheme %q (want udp or tcp)", u.Scheme)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return "", "", fmt.Errorf("invalid -listen-syslog %q: %w", addr, err)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// Close stops listening, closes open TCP connections and waits for the
+// messages being handled to reach the pipeline.
+func (l *SyslogListener) Close() {
+	if l.unwatch != nil {
+		l.unwatch()
+	}
+	l.mu.Lock()
+	l.closing = true
+	for _, c := range l.listeners {
+		c.Close()
+	}
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+}
+
+func (l *SyslogListener) isClosing() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closing
+}
+
+func (l *SyslogListener) serveUDP(source string, pc net.PacketConn) {
+	defer l.wg.Done()
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if !l.isClosing() {
+				slog.Error("Stopped reading syslog", "addr", source, "err", err)
+			}
+			return
+		}
+		l.handle(source, buf[:n])
+	}
+}
+
+func (l *SyslogListener) serveTCP(source string, ln net.Listener) {
+	defer l.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if l.isClosing() {
+				return
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			slog.Error("Stopped accepting syslog connections", "addr", source, "err", err)
+			return
+		}
+
+		l.mu.Lock()
+		if l.closing {
+			l.mu.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.mu.Unlock()
+		go l.serveConn(source, conn)
+	}
+}
+
+// serveConn reads messages from one TCP connection until it closes. Each
+// message is framed either by octet counting ("57 <34>1 ...", RFC 6587)
+// or by a trailing newline; senders may not mix the two on a connection,
+// but the framing is worked out per message anyway.
+func (l *SyslogListener) serveConn(source string, conn net.Conn) {
+	defer l.wg.Done()
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+		conn.Close()
+	}()
+
+	br := bufio.NewReaderSize(conn, 4096)
+	for {
+		msg, err := readFrame(br)
+		if len(msg) > 0 {
+			l.handle(source, msg)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !l.isClosing() {
+				metrics.SyslogErrors.Inc()
+				slog.Warn("Dropped syslog connection", "addr", source, "remote", conn.RemoteAddr().String(), "err", err)
+			}
+			return
+		}
+	}
+}
+
+// readFrame reads the next message from a TCP syslog stream.
+func readFrame(br *bufio.Reader) ([]byte, error) {
+	first, err := br.Peek(1)
+	for err == nil && (first[0] == '\n' || first[0] == '\r') {
+		br.Discard(1)
+		first, err = br.Peek(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		line, err := br.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// Keep reading a long line rather than splitting it.
+			buf := append([]byte(nil), line...)
+			for errors.Is(err, bufio.ErrBufferFull) && len(buf) <= maxSyslogMessage {
+				line, err = br.ReadSlice('\n')
+				buf = append(buf, line...)
+			}
+			if len(buf) > maxSyslogMessage {
+				return nil, fmt.Errorf("message longer than %d bytes", maxSyslogMessage)
+			}
+			line = buf
+		}
+		return line, err
+	}
+
+	prefix, err := br.ReadSlice(' ')
+	if err != nil {
+		return nil, fmt.Errorf("read octet count: %w", err)
+	}
+	n, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+	if err != nil || n > maxSyslogMessage {
+		return nil, fmt.Errorf("invalid octet count %q", prefix[:len(prefix)-1])
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(br, msg); err != nil {
+		return nil, fmt.Errorf("read %d octet message: %w", n, err)
+	}
+	return msg, nil
+}
+
+func (l *SyslogListener) handle(source string, raw []byte) {
+	line, err := parseSyslog(raw)
+	if err != nil {
+		metrics.SyslogErrors.Inc()
+		slog.Debug("Skipped syslog message", "addr", source, "err", err, "message", truncateLine(string(raw)))
+		return
+	}
+	if line != "" {
+		l.process(source, line)
+	}
+}
+
+// parseSyslog returns the MSG part of an RFC 5424 or RFC 3164 message.
+func parseSyslog(raw []byte) (string, error) {
+	msg := strings.TrimRight(string(raw), "\r\n\x00")
+	if !strings.HasPrefix(msg, "<") {
+		return "", fmt.Errorf("%w: no <PRI>", errSyslogMalformed)
+	}
+	end := strings.IndexByte(msg, '>')
+	if end < 2 || end > 4 {
+		return "", fmt.Errorf("%w: invalid <PRI>", errSyslogMalformed)
+	}
+	if pri, err := strconv.Atoi(msg[1:end]); err != nil || pri > 191 {
+		return "", fmt.Errorf("%w: invalid <PRI> %q", errSyslogMalformed, msg[1:end])
+	}
+	msg = msg[end+1:]
+
+	// RFC 5424 has a version ("1") straight after the PRI, where RFC 3164
+	// has a month name.
+	if version, _, ok := strings.Cut(msg, " "); ok && version != "" && len(version) <= 3 && strings.Trim(version, "0123456789") == "" {
+		return parse5424(msg)
+	}
+	return parse3164(msg), nil
+}
+
+// parse5424 parses what follows the PRI in an RFC 5424 message:
+// "VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]".
+func parse5424(msg string) (string, error) {
+	for range 6 {
+		var ok bool
+		if _, msg, ok = strings.Cut(msg, " "); !ok {
+			return "", fmt.Errorf("%w: truncated RFC 5424 header", errSyslogMalformed)
+		}
+	}
+
+	if strings.HasPrefix(msg, "-") {
+		msg = msg[1:]
+	} else {
+		for strings.HasPrefix(msg, "[") {
+			end := sdElementEnd(msg)
+			if end < 0 {
+				return "", fmt.Errorf("%w: unterminated structured data", errSyslogMalformed)
+			}
+			msg = msg[end+1:]
+		}
+	}
+	if msg == "" {
+		return "", nil
+	}
+	if msg[0] != ' ' {
+		return "", fmt.Errorf("%w: invalid structured data", errSyslogMalformed)
+	}
+	return strings.TrimPrefix(msg[1:], "\ufeff"), nil
+}
+
+// sdElementEnd returns the index of the ']' closing the structured data
+// element msg starts with, skipping escaped characters in quoted values,
+// or -1.
+func sdElementEnd(msg string) int {
+	quoted := false
+	for i := 1; i < len(msg); i++ {
+		switch msg[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ']':
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// rfc3164Time is the "Mmm dd hh:mm:ss" timestamp of an RFC 3164 header.
+const rfc3164Time = "Jan _2 15:04:05"
+
+// parse3164 parses what follows the PRI in an RFC 3164 message:
+// "TIMESTAMP HOSTNAME TAG: MSG". Senders vary: some leave out the
+// hostname, some the whole header, so each part is skipped only when it
+// is there. The tag is a word ending in ':', such as "nginx:" or
+// "haproxy[812]:".
+func parse3164(msg string) string {
+	n := len(rfc3164Time)
+	if len(msg) <= n || msg[n] != ' ' {
+		return msg
+	}
+	if _, err := time.Parse(rfc3164Time, msg[:n]); err != nil {
+		return msg
+	}
+	msg = msg[n+1:]
+
+	if word, rest, ok := strings.Cut(msg, " "); ok && !strings.HasSuffix(word, ":") {
+		msg = rest // the hostname
+	}
+	if word, rest, ok := strings.Cut(msg, " "); ok && strings.HasSuffix(word, ":") {
+		msg = rest
+	}
+	return msg
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	const line = `1700000000.123 "GET / HTTP/1.1" 200 "Googlebot" 66.249.66.1`
+	tests := []struct {
+		name, msg, want string
+	}{
+		{"nginx", "<190>Nov 14 22:13:20 web1 nginx: " + line, line},
+		{"pid tag", "<190>Nov  4 22:13:20 web1 nginx[812]: " + line, line},
+		{"no hostname", "<190>Nov 14 22:13:20 nginx: " + line, line},
+		{"no header", "<190>" + line, line},
+		{"trailing newline", "<190>Nov 14 22:13:20 web1 nginx: " + line + "\n", line},
+		{"rfc5424", "<165>1 2023-11-14T22:13:20.123Z web1 nginx 812 - - " + line, line},
+		{"rfc5424 sd", `<165>1 2023-11-14T22:13:20Z web1 nginx - ID47 [a@1 k="v\]x"][b@1 n="2"] ` + line, line},
+		{"rfc5424 bom", "<165>1 2023-11-14T22:13:20Z web1 nginx - - - \ufeff" + line, line},
+		{"rfc5424 no msg", "<165>1 2023-11-14T22:13:20Z web1 nginx - - -", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSyslog([]byte(tt.msg))
+			if err != nil {
+				t.Fatalf("parseSyslog: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSyslogRejects(t *testing.T) {
+	for _, msg := range []string{
+		"",
+		"Nov 14 22:13:20 web1 nginx: no pri",
+		"<>x",
+		"<999>x",
+		"<165>1 2023-11-14T22:13:20Z web1",
+		`<165>1 2023-11-14T22:13:20Z web1 nginx - - [a@1 k="v"`,
+		`<165>1 2023-11-14T22:13:20Z web1 nginx - - [a@1]junk`,
+	} {
+		if _, err := parseSyslog([]byte(msg)); !errors.Is(err, errSyslogMalformed) {
+			t.Errorf("parseSyslog(%q) = %v, want a malformed message error", msg, err)
+		}
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	counted := "<13>1 - - - counted"
+	// Some senders follow an octet-counted frame with a newline anyway.
+	stream := fmt.Sprintf("%d %s\n%d %s", len(counted), counted, len(counted), counted) + "<13>plain line\n" + "<13>last, unterminated"
+	br := bufio.NewReader(strings.NewReader(stream))
+	var got []string
+	for {
+		msg, err := readFrame(br)
+		if len(msg) > 0 {
+			got = append(got, string(msg))
+		}
+		if err != nil {
+			break
+		}
+	}
+	want := []string{counted, counted, "<13>plain line\n", "<13>last, unterminated"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := readFrame(bufio.NewReader(strings.NewReader("99999999 <13>x"))); err == nil {
+		t.Error("no error for an oversized octet count")
+	}
+}
+
+func TestSyslogListener(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	process := func(source, line string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, source+" "+line)
+		return nil
+	}
+	l, err := ListenSyslog(context.Background(), []string{"udp://127.0.0.1:0", "tcp://127.0.0.1:0"}, process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpAddr := l.listeners[0].(net.PacketConn).LocalAddr().String()
+	tcpAddr := l.listeners[1].(net.Listener).Addr().String()
+
+	udp, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(udp, "<190>Nov 14 22:13:20 web1 nginx: over udp")
+	udp.Close()
+
+	tcp, err := net.Dial("tcp", tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "<190>1 2023-11-14T22:13:20Z web1 nginx - - - over tcp"
+	fmt.Fprintf(tcp, "%d %s", len(msg), msg)
+	// Left open: Close must not wait for the sender to hang up.
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	l.Close()
+	tcp.Close()
+
+	slices.Sort(got)
+	want := []string{"tcp://127.0.0.1:0 over tcp", "udp://127.0.0.1:0 over udp"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
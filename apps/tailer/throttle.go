@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// maxThrottle caps how long a single Retry-After can pause sending.
+const maxThrottle = 10 * time.Minute
+
+// Throttle pauses all requests to the API after it answered 429. Tailing
+// continues meanwhile; events wait in the sender's queue (and spill to
+// the spool or are dropped once it is full).
+type Throttle struct {
+	mu    sync.Mutex
+	until time.Time
+	since time.Time // start of the current throttled period, zero if none
+	hits  int       // 429s in the current period
+
+	throttled *Counter // counts throttled periods
+	log       *slog.Logger
+}
+
+// Pause stops requests for d, logging when a throttled period begins.
+func (t *Throttle) Pause(d time.Duration) {
+	d = min(d, maxThrottle)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if t.since.IsZero() {
+		t.since = now
+		t.throttled.Inc()
+		t.log.Info("API is throttling requests, pausing sends", "pause", d.Round(time.Millisecond))
+	}
+	t.hits++
+	if until := now.Add(d); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// Hits returns the number of 429s seen in the current throttled period.
+func (t *Throttle) Hits() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.hits
+}
+
+// Clear ends a throttled period after a request succeeded.
+func (t *Throttle) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.since.IsZero() {
+		return
+	}
+	t.log.Info("API throttling ended", "after", time.Since(t.since).Round(time.Second), "rejected", t.hits)
+	t.since = time.Time{}
+	t.hits = 0
+}
+
+// Wait blocks until the pause is over or cancel is closed, and reports
+// whether sending may proceed.
+func (t *Throttle) Wait(cancel <-chan struct{}) bool {
+	t.mu.Lock()
+	d := time.Until(t.until)
+	t.mu.Unlock()
+	if d <= 0 {
+		return true
+	}
+
+	select {
+	case <-time.After(d):
+		return true
+	case <-cancel:
+		return false
+	}
+}
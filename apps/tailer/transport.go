@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/originaryx/trace/tailer/pkg/client"
+	"github.com/originaryx/trace/tailer/pkg/grpcclient"
+)
+
+// newHTTPClient returns the HTTP client for requests to the ingest API,
+// with the TLS and proxy settings of cfg applied. Without -proxy, the
+// usual HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables are honoured.
+func newHTTPClient(cfg Config) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	transport.DisableKeepAlives = cfg.DisableKeepAlive
+	if cfg.Proxy != "" {
+		u, err := parseProxy(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// parseHeaders parses the -header values, "Name: value" with ${NAME}
+// references in the value expanded from the environment. Headers the
+// client sets itself are refused rather than silently overridden.
+func parseHeaders(values []string) (http.Header, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	h := make(http.Header, len(values))
+	for _, v := range values {
+		// The value may be a secret, so it is never quoted back.
+		name, value, ok := strings.Cut(v, ":")
+		if !ok {
+			return nil, errors.New("-header wants \"Name: value\"")
+		}
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, " \t\"(),/;<=>?@[\\]{}") {
+			return nil, fmt.Errorf("-header %q: invalid header name", name)
+		}
+		if client.ReservedHeader(name) {
+			return nil, fmt.Errorf("-header %s: the tailer sets this header itself", name)
+		}
+		value, err := expandEnv(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("-header %s: %w", name, err)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("-header %s: value contains a line break", name)
+		}
+		h.Add(name, value)
+	}
+	return h, nil
+}
+
+// newGRPCClient returns the -transport grpc client for the -endpoint
+// target, over TLS with the settings of cfg.
+func newGRPCClient(cfg Config, key, secret string) (*grpcclient.Client, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c, err := grpcclient.Dial(cfg.Endpoint, tlsConfig, key, secret)
+	if err != nil {
+		return nil, err
+	}
+	c.Timeout = cfg.HTTPTimeout
+	c.Compression = cfg.Compress
+	c.Auth = grpcclient.HMAC{SigVersion: cfg.SigVersion}
+	if cfg.GRPCAuth == "bearer" {
+		c.Auth = grpcclient.Bearer{}
+	}
+	c.OnRequest = func(d time.Duration) { metrics.RequestDuration.Observe(d.Seconds()) }
+	return c, nil
+}
+
+// parseProxy parses the -proxy URL. net/http speaks SOCKS5 itself, so
+// socks5:// needs nothing beyond http.ProxyURL.
+func parseProxy(v string) (*url.URL, error) {
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("parse -proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("-proxy %s: unsupported scheme %q (want http, https or socks5)", u.Redacted(), u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("-proxy %s: missing host", u.Redacted())
+	}
+	return u, nil
+}
+
+// newTLSConfig builds the client TLS configuration from the -tls-* flags.
+// The certificate files are loaded now so that a bad one fails at startup.
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.TLSCA != "" {
+		pem, err := os.ReadFile(cfg.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("read -tls-ca: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("-tls-ca %s: no PEM certificates found", cfg.TLSCA)
+		}
+		tc.RootCAs = pool
+	}
+
+	if cfg.TLSCert != "" {
+		kp := &keyPair{certFile: cfg.TLSCert, keyFile: cfg.TLSKey}
+		if err := kp.load(); err != nil {
+			return nil, err
+		}
+		tc.GetClientCertificate = kp.get
+	}
+
+	if cfg.TLSInsecure {
+		slog.Warn("-tls-insecure-skip-verify is set: the endpoint's certificate is NOT verified and anyone on the network path can read and alter requests. Never use this in production.")
+		tc.InsecureSkipVerify = true
+	}
+	return tc, nil
+}
+
+// keyPair is a client certificate that is reloaded when its files change
+// on disk, so certificates can be rotated without a restart. The files are
+// checked at each TLS handshake, which with keep-alive connections is
+// rare.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+// load reads both files and replaces the certificate.
+func (kp *keyPair) load() error {
+	certPEM, certTime, err := readWithTime(kp.certFile)
+	if err != nil {
+		return fmt.Errorf("read -tls-cert: %w", err)
+	}
+	keyPEM, keyTime, err := readWithTime(kp.keyFile)
+	if err != nil {
+		return fmt.Errorf("read -tls-key: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("load -tls-cert %s and -tls-key %s: %w", kp.certFile, kp.keyFile, err)
+	}
+	kp.cert, kp.certTime, kp.keyTime = &cert, certTime, keyTime
+	return nil
+}
+
+// get is the tls.Config GetClientCertificate hook. A certificate that fails
+// to reload is logged and the previous one stays in use until the files
+// change again; they may have been caught halfway through being replaced.
+func (kp *keyPair) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if kp.changed() {
+		if err := kp.load(); err != nil {
+			slog.Error("Keeping the current client certificate", "err", err)
+			kp.certTime, kp.keyTime = modTime(kp.certFile), modTime(kp.keyFile)
+		} else {
+			slog.Info("Reloaded client certificate", "file", kp.certFile)
+		}
+	}
+	return kp.cert, nil
+}
+
+func (kp *keyPair) changed() bool {
+	return !modTime(kp.certFile).Equal(kp.certTime) || !modTime(kp.keyFile).Equal(kp.keyTime)
+}
+
+// modTime returns the modification time of path, or the zero time if it
+// cannot be read.
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+func readWithTime(path string) ([]byte, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, fi.ModTime(), nil
+}
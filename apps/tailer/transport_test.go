@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	t.Setenv("GW_TOKEN", "s3cret")
+	tests := []struct {
+		name    string
+		values  []string
+		want    http.Header
+		wantErr bool
+	}{
+		{name: "none"},
+		{
+			name:   "headers",
+			values: []string{"X-Org-Id: 42", "authorization:Bearer ${GW_TOKEN}", "X-Tag: a", "X-Tag: b"},
+			want:   http.Header{"X-Org-Id": {"42"}, "Authorization": {"Bearer s3cret"}, "X-Tag": {"a", "b"}},
+		},
+		{name: "empty value", values: []string{"X-Empty:"}, want: http.Header{"X-Empty": {""}}},
+		{name: "no colon", values: []string{"Bearer s3cret"}, wantErr: true},
+		{name: "bad name", values: []string{"X Org: 42"}, wantErr: true},
+		{name: "content type", values: []string{"content-type: text/plain"}, wantErr: true},
+		{name: "signature", values: []string{"X-Peac-Signature: x"}, wantErr: true},
+		{name: "unset variable", values: []string{"Authorization: Bearer ${NO_SUCH_TOKEN}"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaders(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHeaders = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHeaders = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
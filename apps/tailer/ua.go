@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// uaAnonymizer applies -ua-mode to events once everything that looks at
+// the user agent, the classifier and filters included, has seen it.
+type uaAnonymizer struct {
+	familyOnly bool
+	salt       []byte // -ua-salt
+	props      []propertySalt
+}
+
+// propertySalt is the ua_salt of a property in the config file, nil if
+// it has none.
+type propertySalt struct {
+	host string
+	salt []byte
+}
+
+// newUAAnonymizer returns the anonymizer for cfg, or nil for -ua-mode
+// raw.
+func newUAAnonymizer(cfg Config) (*uaAnonymizer, error) {
+	switch cfg.UAMode {
+	case "raw", "":
+		return nil, nil
+	case "family-only":
+		return &uaAnonymizer{familyOnly: true}, nil
+	case "hash":
+	default:
+		return nil, fmt.Errorf("unknown -ua-mode %q (want raw, hash or family-only)", cfg.UAMode)
+	}
+	salt, err := resolveCredential("User-Agent salt", "ua-salt", cfg.UASalt, cfg.UASaltFile, "TRACE_UA_SALT")
+	if err != nil {
+		return nil, err
+	}
+	a := &uaAnonymizer{salt: []byte(salt)}
+	for _, p := range cfg.Properties {
+		ps := propertySalt{host: p.Host}
+		if p.UASalt != "" {
+			ps.salt = []byte(p.UASalt)
+		}
+		a.props = append(a.props, ps)
+	}
+	return a, nil
+}
+
+// apply replaces event's user agent with a keyed hash of it, the same for
+// the same user agent and salt, or removes it. With hash a user agent
+// that already is one, as in events replayed with -format ndjson, is
+// kept.
+func (a *uaAnonymizer) apply(event *CrawlEvent) {
+	if a.familyOnly {
+		event.UserAgent = ""
+		return
+	}
+	if event.UserAgent == "" || isUAHash(event.UserAgent) {
+		return
+	}
+	mac := hmac.New(sha256.New, a.saltFor(event.Host))
+	mac.Write([]byte(event.UserAgent))
+	event.UserAgent = hex.EncodeToString(mac.Sum(nil))
+}
+
+// saltFor is the salt of the property host belongs to, the first one
+// matching it as with credentials, or -ua-salt.
+func (a *uaAnonymizer) saltFor(host string) []byte {
+	for _, p := range a.props {
+		if ok, _ := matchHost(p.host, host); ok {
+			if p.salt != nil {
+				return p.salt
+			}
+			break
+		}
+	}
+	return a.salt
+}
+
+// isUAHash reports whether ua has the form apply hashes to.
+func isUAHash(ua string) bool {
+	if len(ua) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(ua)
+	return err == nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUAMode(t *testing.T) {
+	const line = `1700000000.123 "GET /a HTTP/1.1" 200 512 "Mozilla/5.0 (compatible; GPTBot/1.0)" 203.0.113.7 en 0.010 %s -`
+	tests := []struct {
+		name    string
+		mode    string
+		hosts   []string // one line for each
+		wantUA  []string // "hash" for a hash, "" for none
+		wantErr bool
+	}{
+		{name: "raw", mode: "raw", hosts: []string{"example.com"}, wantUA: []string{"Mozilla/5.0 (compatible; GPTBot/1.0)"}},
+		{name: "hash", mode: "hash", hosts: []string{"example.com", "example.com"}, wantUA: []string{"hash", "hash"}},
+		{name: "family only", mode: "family-only", hosts: []string{"example.com"}, wantUA: []string{""}},
+		{name: "property salt", mode: "hash", hosts: []string{"example.com", "shop.example.org"}, wantUA: []string{"hash", "hash"}},
+		{name: "no salt", mode: "hash", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Format = "nginx"
+			cfg.UAMode = tt.mode
+			if !tt.wantErr {
+				cfg.UASalt = "s3cret"
+			}
+			cfg.Properties = []Property{{Host: "*.example.org", UASalt: "other"}}
+			api := &fakeAPI{}
+			sender := NewSender(context.Background(), api, cfg, nil)
+			p, err := NewPipeline(cfg, NewFanout(sender, nil, 0), nil, nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewPipeline: %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for _, host := range tt.hosts {
+				if err := p.Process("test", strings.Replace(line, "%s", host, 1)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !sender.Close(5 * time.Second) {
+				t.Fatal("sender did not drain")
+			}
+
+			if len(api.received) != len(tt.wantUA) {
+				t.Fatalf("sent %d events, want %d", len(api.received), len(tt.wantUA))
+			}
+			for i, e := range api.received {
+				if e.CrawlerFamily != "gptbot" {
+					t.Errorf("crawler_family = %q, want gptbot", e.CrawlerFamily)
+				}
+				switch want := tt.wantUA[i]; want {
+				case "hash":
+					if !isUAHash(e.UserAgent) {
+						t.Errorf("ua = %q, want a hash", e.UserAgent)
+					}
+				case "":
+					data, _ := json.Marshal(e)
+					if strings.Contains(string(data), `"ua"`) {
+						t.Errorf("event %s has a ua field", data)
+					}
+				default:
+					if e.UserAgent != want {
+						t.Errorf("ua = %q, want %q", e.UserAgent, want)
+					}
+				}
+			}
+			if len(api.received) == 2 {
+				a, b := api.received[0].UserAgent, api.received[1].UserAgent
+				if same := tt.hosts[0] == tt.hosts[1]; (a == b) != same {
+					t.Errorf("hashes %s and %s for hosts %v, want same %v", a, b, tt.hosts, same)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	verifyTTL         = time.Hour
+	verifyTimeout     = 2 * time.Second
+	verifyConcurrency = 8
+	verifyCacheMax    = 10000
+)
+
+// BotVerifier checks that a client address really belongs to the crawler
+// operator its user agent claims: the address must reverse-resolve to a
+// host under one of the operator's domains, and that host must resolve
+// back to the same address. Results are cached per address, concurrent
+// checks of the same address share one lookup, and at most
+// verifyConcurrency lookups run at once.
+type BotVerifier struct {
+	resolver *net.Resolver
+	sem      chan struct{}
+
+	mu    sync.Mutex
+	cache map[string]*verifyEntry
+}
+
+type verifyEntry struct {
+	done    chan struct{}
+	ok      bool
+	expires time.Time
+}
+
+func NewBotVerifier() *BotVerifier {
+	return &BotVerifier{
+		resolver: net.DefaultResolver,
+		sem:      make(chan struct{}, verifyConcurrency),
+		cache:    map[string]*verifyEntry{},
+	}
+}
+
+// Verify reports whether ip is a genuine crawler address for domains. Any
+// lookup failure counts as unverified.
+func (v *BotVerifier) Verify(ip string, domains []string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	key := addr.String() + "|" + strings.Join(domains, ",")
+
+	v.mu.Lock()
+	e, ok := v.cache[key]
+	if ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+		v.mu.Unlock()
+		<-e.done
+		return e.ok
+	}
+	if len(v.cache) >= verifyCacheMax {
+		v.evictLocked()
+	}
+	e = &verifyEntry{done: make(chan struct{})}
+	v.cache[key] = e
+	v.mu.Unlock()
+
+	v.sem <- struct{}{}
+	e.ok = v.lookup(addr, domains)
+	<-v.sem
+
+	v.mu.Lock()
+	e.expires = time.Now().Add(verifyTTL)
+	v.mu.Unlock()
+	close(e.done)
+	return e.ok
+}
+
+// evictLocked drops expired entries, or everything settled if none have
+// expired, to keep the cache bounded. Callers hold mu.
+func (v *BotVerifier) evictLocked() {
+	now := time.Now()
+	for k, e := range v.cache {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(v.cache, k)
+		}
+	}
+	if len(v.cache) < verifyCacheMax {
+		return
+	}
+	for k, e := range v.cache {
+		if !e.expires.IsZero() {
+			delete(v.cache, k)
+		}
+	}
+}
+
+func (v *BotVerifier) lookup(addr netip.Addr, domains []string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+	defer cancel()
+
+	names, err := v.resolver.LookupAddr(ctx, addr.String())
+	if err != nil {
+		return false
+	}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !hasDomainSuffix(name, domains) {
+			continue
+		}
+		ips, err := v.resolver.LookupNetIP(ctx, "ip", name)
+		if err != nil {
+			continue
+		}
+		for _, resolved := range ips {
+			if resolved.Unmap() == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasDomainSuffix(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/nxadm/tail"
+	"github.com/nxadm/tail/watch"
+)
+
+// rescanInterval is how often -file globs are re-expanded to pick up new
+// files and retire removed ones.
+const rescanInterval = 10 * time.Second
+
+// Watcher tails every file named by the -file patterns, one goroutine per
+// file, and feeds their lines to a shared pipeline.
+//
+// Plain paths are followed like `tail -F`: if the file disappears the tail
+// waits for it to be recreated. Files found through a glob are retired
+// once they have been missing for two consecutive rescans. A plain path
+// that is a named pipe is read as one instead (see fifoTail).
+type Watcher struct {
+	patterns   []string
+	pipeline   *Pipeline
+	positions  *PositionStore
+	fromStart  bool
+	stallAfter time.Duration // see watchdog
+	watchMode  string        // -watch-mode, until Start settles it
+	poll       bool
+
+	ctx      context.Context // cancelled to stop
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+
+	mu    sync.Mutex
+	tails map[string]*fileTail
+	wg    sync.WaitGroup
+}
+
+type fileTail struct {
+	path    string
+	globbed bool
+	t       *tail.Tail
+	fifo    *fifoTail // instead of t for a named pipe
+	missing int       // consecutive rescans the file was not found
+
+	lines       atomic.Int64
+	parseErrors atomic.Int64
+	queued      atomic.Int64
+
+	// What the watchdog checks the file against: where reading got to,
+	// in which file, and when.
+	offset   atomic.Int64
+	inode    atomic.Uint64
+	lastLine atomic.Int64 // Unix ns; when tailing started until a line is read
+
+	ended atomic.Bool // the goroutine reading the file returned
+}
+
+// NewWatcher returns a watcher for the -file patterns in cfg. Cancelling
+// ctx stops it as Stop does, without waiting.
+func NewWatcher(ctx context.Context, cfg Config, pipeline *Pipeline, positions *PositionStore) *Watcher {
+	// The poll interval is a package variable of the tail library, so
+	// it is set once, before any file is tailed, and only if it changes:
+	// the library's goroutines read it.
+	if watch.POLL_DURATION != cfg.PollInterval {
+		watch.POLL_DURATION = cfg.PollInterval
+	}
+	w := &Watcher{
+		patterns:   cfg.LogFiles,
+		pipeline:   pipeline,
+		positions:  positions,
+		fromStart:  cfg.FromBeginning,
+		stallAfter: cfg.StallTimeout,
+		watchMode:  cfg.WatchMode,
+		tails:      map[string]*fileTail{},
+	}
+	w.ctx, w.cancel = context.WithCancel(ctx)
+	context.AfterFunc(w.ctx, w.stopTails)
+	return w
+}
+
+// Start tails the files that currently match and begins rescanning.
+func (w *Watcher) Start() error {
+	w.poll = w.watchMode != "inotify"
+	if !w.poll {
+		if err := probeInotify(w.patterns); err != nil {
+			slog.Warn("Can't watch log files with inotify, polling instead", "err", err)
+			w.poll = true
+		}
+	}
+	if w.poll {
+		slog.Info("Watching log files", "mode", "poll", "interval", watch.POLL_DURATION)
+	} else {
+		slog.Info("Watching log files", "mode", "inotify")
+	}
+
+	if err := w.scan(true); err != nil {
+		return err
+	}
+
+	if w.stallAfter > 0 {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.watchdog()
+		}()
+	}
+	if w.hasGlobs() {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			ticker := time.NewTicker(rescanInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-w.ctx.Done():
+					return
+				case <-ticker.C:
+					if err := w.scan(false); err != nil {
+						slog.Error("Failed to rescan log files", "err", err)
+					}
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// StartAfterCatchUp reads, in the background, what rotations of the plain
+// -file paths hold beyond their saved positions (see catchUp), and then
+// starts tailing as Start does. Stop interrupts the catch-up.
+func (w *Watcher) StartAfterCatchUp() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for _, path := range w.patterns {
+			if isGlob(path) {
+				continue
+			}
+			lines, skipped, err := catchUp(path, w.positions, w.pipeline.ProcessAfter, w.ctx.Done())
+			if err != nil {
+				slog.Error("Failed to catch up on rotated files", "file", path, "err", err)
+			}
+			if lines > 0 {
+				slog.Info("Caught up", "file", path, "lines", lines, "already_read", skipped)
+			}
+		}
+		if w.ctx.Err() != nil {
+			return
+		}
+		if err := w.Start(); err != nil {
+			slog.Error("Failed to tail files", "err", err)
+		}
+	}()
+}
+
+// probeInotify checks that the directories of the -file patterns can be
+// watched with inotify. The tail library only finds out once it is
+// tailing, and then gives up on the file.
+func probeInotify(patterns []string) error {
+	iw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer iw.Close()
+	for _, p := range patterns {
+		dir := filepath.Dir(p)
+		if isGlob(dir) {
+			continue
+		}
+		if err := iw.Add(dir); err != nil {
+			return fmt.Errorf("watch %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// Stop stops all tails and waits for their goroutines to finish.
+func (w *Watcher) Stop() {
+	w.cancel()
+	w.stopTails()
+	w.wg.Wait()
+}
+
+// stopTails stops every tail, once the watcher's context is done.
+func (w *Watcher) stopTails() {
+	w.stopOnce.Do(func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for _, ft := range w.tails {
+			ft.stop()
+		}
+	})
+}
+
+// Files returns the paths currently being tailed.
+func (w *Watcher) Files() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	files := make([]string, 0, len(w.tails))
+	for path := range w.tails {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Ended returns the files whose tail stopped reading while the watcher
+// runs, as when the tail library gave up on one.
+func (w *Watcher) Ended() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var ended []string
+	if w.ctx.Err() != nil {
+		return nil
+	}
+	for path, ft := range w.tails {
+		if ft.ended.Load() {
+			ended = append(ended, path)
+		}
+	}
+	sort.Strings(ended)
+	return ended
+}
+
+func (w *Watcher) hasGlobs() bool {
+	for _, p := range w.patterns {
+		if isGlob(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// scan expands the patterns, starts tails for new files, and retires
+// globbed files that have gone away. Files discovered after startup are
+// new, so they are always read from the beginning.
+func (w *Watcher) scan(initial bool) error {
+	found := map[string]bool{}
+	for _, pattern := range w.patterns {
+		if !isGlob(pattern) {
+			found[pattern] = false
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() {
+				if _, plain := found[m]; !plain {
+					found[m] = true
+				}
+			}
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ctx.Err() != nil {
+		return nil // stopped while catching up
+	}
+
+	for path, ft := range w.tails {
+		if !ft.globbed {
+			continue
+		}
+		if _, ok := found[path]; ok {
+			ft.missing = 0
+			continue
+		}
+		if ft.missing++; ft.missing >= 2 {
+			slog.Info("Log file no longer present, stopping", "file", path)
+			ft.stop()
+			delete(w.tails, path)
+		}
+	}
+
+	for path, globbed := range found {
+		if _, ok := w.tails[path]; ok {
+			continue
+		}
+		if err := w.start(path, globbed, initial); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start begins tailing path. Callers hold mu.
+func (w *Watcher) start(path string, globbed, initial bool) error {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeNamedPipe != 0 {
+		ft := &fileTail{path: path, globbed: globbed, fifo: newFIFOTail(path)}
+		w.tails[path] = ft
+		slog.Info("Reading named pipe", "file", path)
+
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			ft.fifo.run(func(line string) {
+				ft.lines.Add(1)
+				w.process(ft, line)
+			})
+			ft.ended.Store(true)
+			slog.Info("Stopped reading named pipe", "file", ft.path, "lines", ft.lines.Load(), "parse_errors", ft.parseErrors.Load(), "queued", ft.queued.Load())
+		}()
+		return nil
+	}
+
+	var location *tail.SeekInfo
+	if initial && w.positions != nil && !w.fromStart {
+		location = w.positions.Resume(path)
+	}
+	return w.startAt(path, globbed, location)
+}
+
+// startAt begins tailing path at location, or from the start if that is
+// nil. Callers hold mu.
+func (w *Watcher) startAt(path string, globbed bool, location *tail.SeekInfo) error {
+	t, err := tail.TailFile(path, tail.Config{
+		Location:  location,
+		Follow:    true,
+		ReOpen:    true,
+		MustExist: false,
+		Poll:      w.poll,
+		Logger:    slog.NewLogLogger(slog.Default().Handler(), slog.LevelInfo),
+	})
+	if err != nil {
+		return err
+	}
+
+	ft := &fileTail{path: path, globbed: globbed, t: t}
+	if location != nil {
+		ft.offset.Store(location.Offset)
+	}
+	ft.inode.Store(statInode(path))
+	ft.lastLine.Store(time.Now().UnixNano())
+	w.tails[path] = ft
+	slog.Info("Watching", "file", path)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.follow(ft)
+	}()
+	return nil
+}
+
+func (w *Watcher) follow(ft *fileTail) {
+	var inode uint64
+	for line := range ft.t.Lines {
+		if line.Err != nil {
+			slog.Warn("Error reading line", "file", ft.path, "err", line.Err)
+			continue
+		}
+		ft.lines.Add(1)
+		ft.lastLine.Store(time.Now().UnixNano())
+
+		// Line numbers restart at 1 whenever the tail (re)opens the file,
+		// which is the moment the inode may have changed.
+		if line.Num == 1 || inode == 0 {
+			inode = statInode(ft.path)
+			ft.inode.Store(inode)
+		}
+		ft.offset.Store(line.SeekInfo.Offset)
+		w.process(ft, line.Text)
+		// Only once the line's event is queued, so that a position saved
+		// never covers a line whose event could still be lost.
+		if w.positions != nil {
+			w.positions.Update(ft.path, inode, line.SeekInfo.Offset)
+		}
+	}
+
+	ft.ended.Store(true)
+	slog.Info("Stopped tailing", "file", ft.path, "lines", ft.lines.Load(), "parse_errors", ft.parseErrors.Load(), "queued", ft.queued.Load())
+}
+
+func (w *Watcher) process(ft *fileTail, line string) {
+	if err := w.pipeline.Process(ft.path, line); err != nil {
+		ft.parseErrors.Add(1)
+		return
+	}
+	ft.queued.Add(1)
+}
+
+func (ft *fileTail) stop() {
+	if ft.fifo != nil {
+		ft.fifo.Stop()
+		return
+	}
+	ft.t.Stop()
+}
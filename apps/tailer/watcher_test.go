@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCancelStopsPipeline tails files with a sender whose requests hang,
+// and checks that cancelling the root context stops the watcher and the
+// sender within a bounded time.
+func TestCancelStopsPipeline(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "access.log")
+	line := `1700000000.123 "GET /a HTTP/1.1" 200 512 "GPTBot/1.0" 203.0.113.7 en 0.010 example.com gptbot` + "\n"
+	if err := os.WriteFile(file, []byte(line), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.Format = "nginx"
+	cfg.LogFiles = []string{file, filepath.Join(dir, "*.log")}
+	cfg.FromBeginning = true
+	cfg.WatchMode = "poll"
+	cfg.PollInterval = 10 * time.Millisecond
+	cfg.StallTimeout = time.Second
+	cfg.RetryBase = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sender := NewSender(ctx, hangingAPI{}, cfg, nil)
+	p, err := NewPipeline(cfg, NewFanout(sender, nil, 0), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(ctx, cfg, p, nil)
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var appended atomic.Bool
+	go func() {
+		f, _ := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+		defer f.Close()
+		for ctx.Err() == nil {
+			f.WriteString(line)
+			appended.Store(true)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if !appended.Load() {
+		t.Fatal("nothing written to the log")
+	}
+
+	start := time.Now()
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		sender.Close(time.Hour)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("pipeline still running 2s after cancel")
+	}
+	t.Logf("stopped %v after cancel", time.Since(start))
+	w.Stop() // already stopped; must not block or panic
+}
+
+// addHook is a persistent backlog that calls add with each event queued.
+type addHook struct {
+	add  func(*CrawlEvent)
+	stop chan struct{}
+}
+
+func (h *addHook) Add(e *CrawlEvent) error              { h.add(e); return nil }
+func (h *addHook) Ack([]*CrawlEvent)                    {}
+func (h *addHook) Append([]*CrawlEvent) error           { return nil }
+func (h *addHook) Drain(func([]*CrawlEvent) error, int) { <-h.stop }
+func (h *addHook) Kick()                                {}
+func (h *addHook) Close()                               { close(h.stop) }
+
+// TestPositionAfterQueued saves positions as each event is being queued,
+// as the periodic sync may, and checks that the offset saved never covers
+// the line being queued.
+func TestPositionAfterQueued(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "access.log")
+	var log string
+	var ends []int64
+	for i := 0; i < 3; i++ {
+		log += fmt.Sprintf(`1700000000.123 "GET /%d HTTP/1.1" 200 512 "GPTBot/1.0" 203.0.113.7 en 0.010 example.com gptbot`+"\n", i)
+		ends = append(ends, int64(len(log)))
+	}
+	if err := os.WriteFile(file, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	positionFile := filepath.Join(dir, "positions.json")
+	positions, err := LoadPositions(positionFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.Format = "nginx"
+	cfg.LogFiles = []string{file}
+	cfg.FromBeginning = true
+	cfg.WatchMode = "poll"
+	cfg.PollInterval = 10 * time.Millisecond
+	cfg.MaxEventAge = 0
+	var mu sync.Mutex
+	var saved []int64
+	backlog := &addHook{stop: make(chan struct{}), add: func(*CrawlEvent) {
+		if err := positions.Sync(); err != nil {
+			t.Error(err)
+		}
+		onDisk, err := LoadPositions(positionFile)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		pos, _ := onDisk.Get(file)
+		mu.Lock()
+		saved = append(saved, pos.Offset)
+		mu.Unlock()
+	}}
+	sender := NewSender(context.Background(), &fakeAPI{}, cfg, backlog)
+	p, err := NewPipeline(cfg, NewFanout(sender, nil, 0), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(context.Background(), cfg, p, positions)
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(saved)
+		mu.Unlock()
+		if n >= len(ends) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Stop()
+	sender.Close(time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(saved) != len(ends) {
+		t.Fatalf("%d events queued, want %d", len(saved), len(ends))
+	}
+	for i, offset := range saved {
+		if offset >= ends[i] {
+			t.Errorf("line %d: offset %d saved while its event was queued covers the line, which ends at %d", i, offset, ends[i])
+		}
+	}
+}